@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"on-air/server/services/fakeipg"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// fakeIPGCmd represents the fake-ipg command
+var fakeIPGCmd = &cobra.Command{
+	Use:   "fake-ipg",
+	Short: "run a fake Pasargad payment gateway",
+	Long:  "this command runs a local Pasargad payment gateway for end to end tests, point gatepay.base_url at it. The outcomes of the next transactions can be scripted with POST /fake/script",
+	Run: func(cmd *cobra.Command, args []string) {
+		port, _ := cmd.Flags().GetString("port")
+		outcome, _ := cmd.Flags().GetString("outcome")
+		delay, _ := cmd.Flags().GetDuration("delay")
+		startFakeIPG(port, outcome, delay)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fakeIPGCmd)
+	fakeIPGCmd.Flags().String("port", "8081", "Port number")
+	fakeIPGCmd.Flags().String("outcome", string(fakeipg.OutcomeSuccess), "default outcome, success, amount_mismatch, timeout or declined")
+	fakeIPGCmd.Flags().Duration("delay", time.Minute, "how long the timeout outcome holds the calls")
+}
+
+func startFakeIPG(port string, outcome string, delay time.Duration) {
+	defaultOutcome, err := fakeipg.ParseOutcome(outcome)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fake := fakeipg.New(defaultOutcome, delay)
+
+	log.Printf("fake ipg listening on :%s with %s outcome", port, defaultOutcome)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), fake.Handler()))
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"on-air/config"
+	"on-air/databases"
+	"on-air/repository"
+	"on-air/server/services/gateway"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// reconcileCmd represents the reconcile command
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "reconcile payments with the payment gateway",
+	Long:  "this command checks the payments stuck in Requested or Paid status against the payment gateway and verifies, refunds or expires them",
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		output, _ := cmd.Flags().GetString("output")
+		olderThan, _ := cmd.Flags().GetDuration("older-than")
+		expireAfter, _ := cmd.Flags().GetDuration("expire-after")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if !cmd.Flags().Changed("older-than") {
+			olderThan = 0
+		}
+		if !cmd.Flags().Changed("expire-after") {
+			expireAfter = 0
+		}
+		reconcile(configFlag, format, output, olderThan, expireAfter, dryRun)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reconcileCmd)
+	reconcileCmd.Flags().String("format", "json", "report format, json or csv")
+	reconcileCmd.Flags().String("output", "", "report file, stdout when empty")
+	reconcileCmd.Flags().Duration("older-than", config.DefaultReconcileOlderThan, "only reconcile payments older than this, reconcile.older_than when not set")
+	reconcileCmd.Flags().Duration("expire-after", config.DefaultReconcileExpireAfter, "expire payments unknown to the gateway after this, reconcile.expire_after when not set")
+	reconcileCmd.Flags().Bool("dry-run", false, "report the actions without applying them")
+}
+
+func reconcile(configPath string, format string, output string, olderThan time.Duration, expireAfter time.Duration, dryRun bool) {
+	if format != "json" && format != "csv" {
+		log.Fatalf("unknown report format %q", format)
+	}
+
+	cfg, err := config.InitConfig(configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if olderThan <= 0 {
+		olderThan = cfg.Reconcile.OlderThanOrDefault()
+	}
+	if expireAfter <= 0 {
+		expireAfter = cfg.Reconcile.ExpireAfterOrDefault()
+	}
+
+	db := databases.InitPostgres(cfg)
+
+	gateways, err := gateway.NewRegistryFromConfig(&cfg.IPG)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	results, err := repository.ReconcilePayments(context.Background(), db, gateways, olderThan, expireAfter, dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	writer := io.Writer(os.Stdout)
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+
+		writer = file
+	}
+
+	if format == "csv" {
+		err = writeReconcileCSV(writer, results)
+	} else {
+		err = writeReconcileJSON(writer, results)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func writeReconcileJSON(writer io.Writer, results []repository.ReconcileResult) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(results)
+}
+
+func writeReconcileCSV(writer io.Writer, results []repository.ReconcileResult) error {
+	csvWriter := csv.NewWriter(writer)
+
+	err := csvWriter.Write([]string{"payment_id", "ticket_id", "gateway", "amount", "previous_status", "status", "action", "error"})
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		err := csvWriter.Write([]string{
+			fmt.Sprint(result.PaymentID),
+			fmt.Sprint(result.TicketID),
+			result.Gateway,
+			strconv.Itoa(result.Amount),
+			result.PreviousStatus,
+			result.Status,
+			string(result.Action),
+			result.Error,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+
+	return csvWriter.Error()
+}
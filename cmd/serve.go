@@ -1,10 +1,17 @@
 package cmd
 
 import (
+	"context"
 	"log"
 	"on-air/config"
 	"on-air/databases"
+	"on-air/logging"
 	"on-air/server"
+	"on-air/tracing"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -30,6 +37,11 @@ func startServer(port string, configPath string) {
 		panic(err)
 	}
 
+	err = logging.Init(&cfg.Log)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	db := databases.InitPostgres(cfg)
 	redis := databases.InitRedis(cfg)
 
@@ -37,5 +49,30 @@ func startServer(port string, configPath string) {
 		port = cfg.Server.Port
 	}
 
-	log.Fatal(server.SetupServer(cfg, db, redis, port))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Init(ctx, &cfg.Tracing)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer flushTracing(shutdownTracing)
+
+	err = server.SetupServer(ctx, cfg, db, redis, port)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("server has stopped")
+}
+
+// flushTracing exports the spans still buffered when the process stops.
+func flushTracing(shutdown func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := shutdown(ctx)
+	if err != nil {
+		log.Println("failed to flush the spans:", err)
+	}
 }
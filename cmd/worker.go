@@ -6,9 +6,14 @@ import (
 	"net/http"
 	"on-air/config"
 	"on-air/databases"
+	"on-air/jobs"
+	"on-air/logging"
+	"on-air/metrics"
 	"on-air/models"
 	"on-air/repository"
 	"on-air/server/services"
+	"on-air/server/services/gateway"
+	"on-air/tracing"
 	"os"
 	"os/signal"
 	"sync"
@@ -22,6 +27,19 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// defaultWorkerLimit is the batch size of the worker when worker.limit is not set.
+const defaultWorkerLimit = 100
+
+// defaultJobsLockKey is the advisory lock of the job scheduler when
+// jobs.lock_key is not set.
+const defaultJobsLockKey = 0x6f6e616972
+
+const (
+	expireTicketsJob     = "expire_tickets"
+	syncCitiesJob        = "sync_cities"
+	reconcilePaymentsJob = "reconcile_payments"
+)
+
 // workerCmd represents the worker command
 var workerCmd = &cobra.Command{
 	Use:   "worker",
@@ -38,6 +56,11 @@ func SetupWorker(configPath string) {
 		panic(err)
 	}
 
+	err = logging.Init(&cfg.Log)
+	if err != nil {
+		panic(err)
+	}
+
 	db := databases.InitPostgres(cfg)
 
 	if !cfg.Worker.Enabled {
@@ -45,84 +68,273 @@ func SetupWorker(configPath string) {
 		return
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Init(ctx, &cfg.Tracing)
+	if err != nil {
+		panic(err)
+	}
+	defer flushTracing(shutdownTracing)
+
+	apiMock := &services.APIMockClient{
+		Client:  &http.Client{Transport: tracing.Transport(nil)},
+		Breaker: &breaker.Breaker{},
+		BaseURL: cfg.Services.ApiMock.BaseURL,
+		Timeout: cfg.Services.ApiMock.Timeout,
+	}
+
+	gateways, err := gateway.NewRegistryFromConfig(&cfg.IPG)
+	if err != nil {
+		panic(err)
+	}
+
+	outbox := &repository.Outbox{
+		DB:            db,
+		APIMockClient: apiMock,
+		Gateways:      gateways,
+		MaxAttempts:   cfg.Outbox.MaxAttemptsOrDefault(),
+		Backoff:       cfg.Outbox.BackoffOrDefault(),
+	}
+
+	runner, err := newJobRunner(cfg, db, apiMock, gateways)
+	if err != nil {
+		panic(err)
+	}
 
 	var wg sync.WaitGroup
-	wg.Add(1)
-	go Run(cfg, ctx, db, &wg)
+	wg.Add(2)
+	go Run(cfg, ctx, runner, &wg)
+	go RunOutboxDispatcher(cfg, ctx, outbox, &wg)
 
-	waitForShutdownSignal()
-	cancel() // Signal the worker to stop
+	if cfg.Worker.MetricsPort != "" {
+		wg.Add(1)
+		go RunMetricsServer(ctx, cfg.Worker.MetricsPort, &wg)
+	}
+
+	<-ctx.Done()
+	log.Info("worker: Received termination signal")
 
 	wg.Wait() // Wait for the worker to finish processing
 
 	log.Info("Worker has stopped")
 }
 
-func Run(cfg *config.Config, ctx context.Context, db *gorm.DB, wg *sync.WaitGroup) {
+func Run(cfg *config.Config, ctx context.Context, runner *jobs.Runner, wg *sync.WaitGroup) {
 	defer wg.Done()
-	apiMock := &services.APIMockClient{
-		Client:  &http.Client{},
-		Breaker: &breaker.Breaker{},
-		BaseURL: cfg.Services.ApiMock.BaseURL,
-		Timeout: cfg.Services.ApiMock.Timeout,
+
+	runner.Run(ctx, cfg.Worker.Iteration)
+}
+
+// newJobRunner registers the jobs of the worker with their default schedules,
+// jobs.schedules in the config overrides them.
+func newJobRunner(cfg *config.Config, db *gorm.DB, apiMock *services.APIMockClient, gateways *gateway.Registry) (*jobs.Runner, error) {
+	runner := jobs.NewRunner(db, &jobs.AdvisoryLock{DB: db, Key: defaultJobsLockKey})
+	if cfg.Jobs.PollInterval > 0 {
+		runner.PollInterval = cfg.Jobs.PollInterval
+	}
+	if cfg.Jobs.Concurrency > 0 {
+		runner.Concurrency = cfg.Jobs.Concurrency
+	}
+	if cfg.Jobs.BatchSize > 0 {
+		runner.BatchSize = cfg.Jobs.BatchSize
+	}
+	if cfg.Jobs.MaxAttempts > 0 {
+		runner.MaxAttempts = cfg.Jobs.MaxAttempts
+	}
+	if cfg.Jobs.Backoff > 0 {
+		runner.Backoff = cfg.Jobs.Backoff
+	}
+	if cfg.Jobs.Lease > 0 {
+		runner.Lease = cfg.Jobs.Lease
+	}
+	if cfg.Jobs.LockKey != 0 {
+		runner.Locker = &jobs.AdvisoryLock{DB: db, Key: cfg.Jobs.LockKey}
 	}
 
-	ticker := time.NewTicker(cfg.Worker.Interval)
-	counter := 0
-	for {
-		select {
-		case <-ticker.C:
-			var tickets, err = repository.GetExpiredTickets(db)
-			if err != nil {
-				log.Errorf("worker: Failed to get expired tickets: %v", err)
-				continue
+	cityRepo := &repository.City{
+		APIMockClient: apiMock,
+		DB:            db,
+	}
+
+	runner.Register(jobs.NotifyJob, jobs.NotifyHandler(jobs.LogNotifier{}))
+	runner.Register(expireTicketsJob, func(ctx context.Context, payload []byte) error {
+		expired := expireTickets(ctx, db, &cfg.Worker, cfg.Outbox.BackoffOrDefault())
+		metrics.TicketsExpired.Add(float64(expired))
+		if expired > 0 {
+			log.Infof("worker: Expired %d tickets", expired)
+		}
+
+		return nil
+	})
+	runner.Register(syncCitiesJob, func(ctx context.Context, payload []byte) error {
+		return cityRepo.SyncOnce(ctx)
+	})
+	runner.Register(reconcilePaymentsJob, func(ctx context.Context, payload []byte) error {
+		results, err := repository.ReconcilePayments(ctx, db, gateways, cfg.Reconcile.OlderThanOrDefault(), cfg.Reconcile.ExpireAfterOrDefault(), false)
+		if err != nil {
+			return err
+		}
+
+		for _, result := range results {
+			if result.Action == repository.ReconcileRefunded && result.Error != "" {
+				metrics.RefundsFailed.WithLabelValues(metrics.RefundGateway).Inc()
 			}
 
-			for _, ticket := range tickets {
-				err := processTicket(db, apiMock, ticket)
+			if result.Action != repository.ReconcileSkipped || result.Error != "" {
+				log.Infof("worker: Reconciled payment %d: %s %s", result.PaymentID, result.Action, result.Error)
+			}
+		}
+
+		return nil
+	})
+
+	schedules := map[string]string{
+		expireTicketsJob:     everySchedule(cfg.Worker.Interval, time.Minute),
+		syncCitiesJob:        everySchedule(cfg.Services.ApiMock.CitiesSyncPeriod, time.Hour),
+		reconcilePaymentsJob: "*/15 * * * *",
+	}
+	for name, spec := range cfg.Jobs.Schedules {
+		schedules[name] = spec
+	}
+
+	for name, spec := range schedules {
+		err := runner.Schedule(name, spec)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return runner, nil
+}
+
+func everySchedule(interval time.Duration, fallback time.Duration) string {
+	if interval <= 0 {
+		interval = fallback
+	}
+
+	return "@every " + interval.String()
+}
+
+// expireTickets runs Concurrency goroutines that claim batches of Limit expired
+// tickets until none is left and returns how many tickets were expired. A
+// ticket that fails is not claimed again before the next tick, so failing
+// tickets can not keep the ones after them from expiring.
+func expireTickets(ctx context.Context, db *gorm.DB, cfg *config.Worker, dispatchDelay time.Duration) int {
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	limit := cfg.Limit
+	if limit <= 0 {
+		limit = defaultWorkerLimit
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		expired int
+		failed  []uint
+	)
+
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				mu.Lock()
+				skip := append([]uint(nil), failed...)
+				mu.Unlock()
+
+				claimed, batchFailed, err := expireBatch(ctx, db, limit, dispatchDelay, skip)
 				if err != nil {
-					log.Errorf("worker: Failed to process ticket: %v", err)
+					log.Errorf("worker: Failed to get expired tickets: %v", err)
+					return
 				}
-			}
 
-			if cfg.Worker.Iteration > 0 {
-				counter++
-				if counter >= cfg.Worker.Iteration {
+				mu.Lock()
+				expired += claimed - len(batchFailed)
+				failed = append(failed, batchFailed...)
+				mu.Unlock()
+
+				// A short batch means no expired ticket is left.
+				if claimed < limit {
 					return
 				}
 			}
+		}()
+	}
 
-		case <-ctx.Done():
-			log.Info("worker: Done signal received")
-			return
+	wg.Wait()
+
+	return expired
+}
+
+// expireBatch claims up to limit expired tickets but the skip ones and expires
+// them in one transaction, the claimed tickets stay locked until it commits.
+// A ticket that fails is rolled back on its own and returned in failed.
+func expireBatch(ctx context.Context, db *gorm.DB, limit int, dispatchDelay time.Duration, skip []uint) (int, []uint, error) {
+	var (
+		claimed int
+		failed  []uint
+	)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		tickets, err := repository.GetExpiredTickets(ctx, tx, limit, skip...)
+		if err != nil {
+			return err
+		}
+
+		claimed = len(tickets)
+		for _, ticket := range tickets {
+			err := processTicket(ctx, tx, dispatchDelay, ticket)
+			if err != nil {
+				log.Errorf("worker: Failed to process ticket %d: %v", ticket.ID, err)
+				failed = append(failed, ticket.ID)
+			}
 		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
 	}
+
+	return claimed, failed, nil
 }
 
-func processTicket(db *gorm.DB, apiMock *services.APIMockClient, ticket models.Ticket) error {
+func processTicket(ctx context.Context, db *gorm.DB, dispatchDelay time.Duration, ticket models.Ticket) error {
 	err := db.Transaction(func(tx *gorm.DB) error {
-		flight, err := repository.FindFlightById(tx, int(ticket.FlightID))
+		flight, err := repository.FindFlightById(ctx, tx, int(ticket.FlightID))
 		if err != nil {
 			return fmt.Errorf("worker: failed to find flight: %w", err)
 		}
 
-		refundResult, err := apiMock.Refund(flight.Number, ticket.Count)
+		err = repository.ChangeTicketStatus(ctx, tx, ticket.ID, string(models.TicketExpired), models.ActorWorker, "reservation hold expired")
 		if err != nil {
-			return fmt.Errorf("worker: failed to refund ticket: %w", err)
+			return fmt.Errorf("worker: failed to change ticket status: %w", err)
 		}
 
-		if refundResult {
-			err = repository.ChangeTicketStatus(tx, ticket.ID, string(models.TicketExpired))
-			if err != nil {
-				return fmt.Errorf("worker: failed to change ticket status: %w", err)
-			}
+		err = repository.ChangePaymentStatus(ctx, tx, ticket.ID, string(models.PaymentExpired), models.ActorWorker, "reservation hold expired")
+		if err != nil {
+			return fmt.Errorf("worker: failed to change payment status: %w", err)
+		}
 
-			err = repository.ChangePaymentStatus(tx, ticket.ID, string(models.PaymentExpired))
-			if err != nil {
-				return fmt.Errorf("worker: failed to change payment status: %w", err)
-			}
+		_, err = repository.EnqueueOutbox(ctx, tx, models.OutboxRefund, ticket.ID, flight.Number, ticket.Count, dispatchDelay)
+		if err != nil {
+			return fmt.Errorf("worker: failed to enqueue refund: %w", err)
+		}
+
+		_, err = repository.EnqueueJob(ctx, tx, jobs.NotifyJob, jobs.Notification{
+			UserID:   ticket.UserID,
+			TicketID: ticket.ID,
+			Event:    "ticket_expired",
+			Message:  fmt.Sprintf("Your reservation on flight %s expired before it was paid", flight.Number),
+		}, time.Now())
+		if err != nil {
+			return fmt.Errorf("worker: failed to enqueue notification: %w", err)
 		}
 
 		return nil
@@ -131,9 +343,62 @@ func processTicket(db *gorm.DB, apiMock *services.APIMockClient, ticket models.T
 	return err
 }
 
-func waitForShutdownSignal() {
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGTERM)
-	<-quit
-	log.Info("worker: Received termination signal")
+// RunOutboxDispatcher delivers pending outbox messages to the flight provider
+// and the payment gateways until ctx is done.
+func RunOutboxDispatcher(cfg *config.Config, ctx context.Context, outbox *repository.Outbox, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(cfg.Outbox.IntervalOrDefault())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			dispatched, err := outbox.DispatchPending(ctx, cfg.Outbox.BatchSizeOrDefault())
+			if err != nil {
+				log.Errorf("worker: Failed to dispatch outbox messages: %v", err)
+				continue
+			}
+
+			if dispatched > 0 {
+				log.Infof("worker: Dispatched %d outbox messages", dispatched)
+			}
+
+		case <-ctx.Done():
+			log.Info("worker: outbox dispatcher done signal received")
+			return
+		}
+	}
+}
+
+// RunMetricsServer serves /metrics on port until ctx is done, so the worker
+// counters can be scraped like the ones of the API server.
+func RunMetricsServer(ctx context.Context, port string, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%s", port),
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Errorf("worker: Failed to shut down the metrics server: %v", err)
+		}
+	}()
+
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Errorf("worker: Metrics server failed: %v", err)
+	}
 }
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"log"
+	"on-air/config"
+	"on-air/models"
+	"testing"
+	"time"
+
+	"bou.ke/monkey"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const expiredTicketsQuery = `SELECT (.+) FROM "tickets" WHERE \(status = (.+) AND expires_at < (.+)\)`
+
+type WorkerTestSuite struct {
+	suite.Suite
+	sqlMock sqlmock.Sqlmock
+	dbMock  *gorm.DB
+	failing map[uint]bool
+	expired []uint
+}
+
+func (suite *WorkerTestSuite) SetupTest() {
+	mockDB, sqlMock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.dbMock, err = gorm.Open(postgres.New(postgres.Config{
+		Conn: mockDB,
+	}))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.sqlMock = sqlMock
+	suite.failing = map[uint]bool{}
+	suite.expired = nil
+
+	monkey.Patch(processTicket, func(_ context.Context, _ *gorm.DB, _ time.Duration, ticket models.Ticket) error {
+		if suite.failing[ticket.ID] {
+			return errors.New("provider down")
+		}
+
+		suite.expired = append(suite.expired, ticket.ID)
+		return nil
+	})
+}
+
+func (suite *WorkerTestSuite) TearDownTest() {
+	monkey.Unpatch(processTicket)
+}
+
+func (suite *WorkerTestSuite) expectBatch(skip []uint, ids ...uint) {
+	rows := sqlmock.NewRows([]string{"id", "status"})
+	for _, id := range ids {
+		rows.AddRow(id, string(models.Reserved))
+	}
+
+	args := []driver.Value{string(models.Reserved), sqlmock.AnyArg()}
+	query := expiredTicketsQuery
+	if len(skip) > 0 {
+		query += ` AND id NOT IN \(.+\)`
+		for _, id := range skip {
+			args = append(args, id)
+		}
+	}
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(query + ` (.+) ORDER BY expires_at LIMIT (.+) FOR UPDATE SKIP LOCKED`).
+		WithArgs(args...).
+		WillReturnRows(rows)
+	suite.sqlMock.ExpectCommit()
+}
+
+func (suite *WorkerTestSuite) TestWorker_ExpireBatch_Failed() {
+	require := suite.Require()
+
+	suite.failing[2] = true
+	suite.expectBatch([]uint{9}, 1, 2, 3)
+
+	claimed, failed, err := expireBatch(context.Background(), suite.dbMock, 3, time.Second, []uint{9})
+	require.NoError(err)
+	require.Equal(3, claimed)
+	require.Equal([]uint{2}, failed)
+	require.Equal([]uint{1, 3}, suite.expired)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *WorkerTestSuite) TestWorker_ExpireBatch_QueryFailed() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(expiredTicketsQuery).WillReturnError(errors.New("connection reset"))
+	suite.sqlMock.ExpectRollback()
+
+	_, _, err := expireBatch(context.Background(), suite.dbMock, 3, time.Second, nil)
+	require.Error(err)
+	require.Empty(suite.expired)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *WorkerTestSuite) TestWorker_ExpireTickets_UntilShortBatch() {
+	require := suite.Require()
+
+	suite.expectBatch(nil, 1, 2)
+	suite.expectBatch(nil, 3)
+
+	expired := expireTickets(context.Background(), suite.dbMock, &config.Worker{Concurrency: 1, Limit: 2}, time.Second)
+	require.Equal(3, expired)
+	require.Equal([]uint{1, 2, 3}, suite.expired)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *WorkerTestSuite) TestWorker_ExpireTickets_SkipsFailed() {
+	require := suite.Require()
+
+	// A whole batch failing does not keep the tickets after it from expiring.
+	suite.failing[1] = true
+	suite.failing[2] = true
+	suite.expectBatch(nil, 1, 2)
+	suite.expectBatch([]uint{1, 2}, 3, 4)
+	suite.expectBatch([]uint{1, 2}, 5)
+
+	expired := expireTickets(context.Background(), suite.dbMock, &config.Worker{Concurrency: 1, Limit: 2}, time.Second)
+	require.Equal(3, expired)
+	require.Equal([]uint{3, 4, 5}, suite.expired)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *WorkerTestSuite) TestWorker_ExpireTickets_QueryFailed() {
+	require := suite.Require()
+
+	suite.expectBatch(nil, 1, 2)
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(expiredTicketsQuery).WillReturnError(errors.New("connection reset"))
+	suite.sqlMock.ExpectRollback()
+
+	expired := expireTickets(context.Background(), suite.dbMock, &config.Worker{Concurrency: 1, Limit: 2}, time.Second)
+	require.Equal(2, expired)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func TestWorker(t *testing.T) {
+	suite.Run(t, new(WorkerTestSuite))
+}
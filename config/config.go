@@ -2,19 +2,32 @@ package config
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Database Database
-	Server   Server
-	Redis    Redis
-	JWT      JWT
-	IPG      IPG
-	Worker   Worker
-	Services Services
+	Database    Database
+	Server      Server
+	Redis       Redis
+	JWT         JWT
+	IPG         IPG
+	Worker      Worker
+	Services    Services
+	Idempotency Idempotency
+	Outbox      Outbox
+	Reservation Reservation
+	Jobs        Jobs
+	Reconcile   Reconcile
+	Tracing     Tracing
+	Log         Log
+	Account     Account
+	Mail        Mail
+	SMS         SMS
+	RateLimit   RateLimit
 }
 
 type Database struct {
@@ -32,20 +45,63 @@ type Redis struct {
 	TTL      time.Duration
 }
 
+// Server is the HTTP server. TrustedProxies are the CIDR ranges of the proxies
+// whose X-Forwarded-For is believed, with none the client IP is the address
+// of the connection.
 type Server struct {
-	Port string
+	Port            string
+	ShutdownTimeout time.Duration
+	TrustedProxies  []string
 }
 
+// JWT signs the access tokens, which live for ExpiresIn and are renewed
+// with a refresh token living for RefreshExpiresIn. The tokens are signed
+// with Keys when any is set and with SecretKey (HS256) otherwise.
 type JWT struct {
-	SecretKey string
-	ExpiresIn time.Duration
+	SecretKey        string
+	ExpiresIn        time.Duration
+	RefreshExpiresIn time.Duration
+	Keys             []SigningKey
+}
+
+// SigningKey is an RS256 or EdDSA key identified by ID, the kid of the
+// tokens. The newest key past its ActiveFrom signs the tokens and every key
+// before its RetireAt verifies them, so a key is rotated by adding its
+// successor with a later ActiveFrom and retiring it once its last tokens
+// expired. Zero times mean always active and never retired.
+type SigningKey struct {
+	ID             string    `mapstructure:"id"`
+	Algorithm      string    `mapstructure:"algorithm"`
+	PrivateKeyFile string    `mapstructure:"private_key_file"`
+	ActiveFrom     time.Time `mapstructure:"active_from"`
+	RetireAt       time.Time `mapstructure:"retire_at"`
 }
 
 type IPG struct {
+	Gateways     []string
+	BaseURL      string
+	Timeout      time.Duration
 	MerchantCode int
 	TerminalId   int
 	RedirectUrl  string
 	CertFile     string
+	Zarinpal     Zarinpal
+	Callback     Callback
+}
+
+// Callback is where the payer lands after the gateway callback is handled,
+// the callback urls are signed with the secret when it is set.
+type Callback struct {
+	Secret     string
+	SuccessURL string
+	FailureURL string
+}
+
+type Zarinpal struct {
+	MerchantID  string
+	BaseURL     string
+	GatewayURL  string
+	CallbackURL string
 }
 
 type Worker struct {
@@ -54,6 +110,7 @@ type Worker struct {
 	Iteration   int
 	Concurrency int
 	Limit       int
+	MetricsPort string
 }
 type Service struct {
 	BaseURL          string
@@ -65,6 +122,227 @@ type Services struct {
 	ApiMock Service
 }
 
+type Idempotency struct {
+	TTL time.Duration
+}
+
+// Defaults of the outbox dispatcher when outbox.* are not set.
+const (
+	DefaultOutboxInterval    = 5 * time.Second
+	DefaultOutboxBatchSize   = 50
+	DefaultOutboxMaxAttempts = 8
+	DefaultOutboxBackoff     = 10 * time.Second
+)
+
+type Outbox struct {
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
+// IntervalOrDefault returns Interval, or DefaultOutboxInterval when it is not
+// set.
+func (o *Outbox) IntervalOrDefault() time.Duration {
+	if o.Interval > 0 {
+		return o.Interval
+	}
+
+	return DefaultOutboxInterval
+}
+
+// BatchSizeOrDefault returns BatchSize, or DefaultOutboxBatchSize when it is
+// not set.
+func (o *Outbox) BatchSizeOrDefault() int {
+	if o.BatchSize > 0 {
+		return o.BatchSize
+	}
+
+	return DefaultOutboxBatchSize
+}
+
+// MaxAttemptsOrDefault returns MaxAttempts, or DefaultOutboxMaxAttempts when
+// it is not set.
+func (o *Outbox) MaxAttemptsOrDefault() int {
+	if o.MaxAttempts > 0 {
+		return o.MaxAttempts
+	}
+
+	return DefaultOutboxMaxAttempts
+}
+
+// BackoffOrDefault returns Backoff, or DefaultOutboxBackoff when it is not set.
+func (o *Outbox) BackoffOrDefault() time.Duration {
+	if o.Backoff > 0 {
+		return o.Backoff
+	}
+
+	return DefaultOutboxBackoff
+}
+
+// DefaultHold is how long seats are held for payment when no hold is configured.
+const DefaultHold = 15 * time.Minute
+
+// DefaultExtension is how long a ticket is extended by when no extension is
+// configured.
+const DefaultExtension = 10 * time.Minute
+
+// Reservation controls how long reserved seats are held for payment. Hold can
+// be overridden per airline, keyed by the lower cased name since viper lower
+// cases map keys, and a ticket can be extended once by Extension.
+type Reservation struct {
+	Hold         time.Duration
+	AirlineHolds map[string]time.Duration
+	Extension    time.Duration
+}
+
+// HoldFor returns the hold duration of a ticket on the airline.
+func (r *Reservation) HoldFor(airline string) time.Duration {
+	if hold, ok := r.AirlineHolds[strings.ToLower(airline)]; ok && hold > 0 {
+		return hold
+	}
+
+	if r.Hold > 0 {
+		return r.Hold
+	}
+
+	return DefaultHold
+}
+
+// ExtensionOrDefault returns Extension, or DefaultExtension when it is not set.
+func (r *Reservation) ExtensionOrDefault() time.Duration {
+	if r.Extension > 0 {
+		return r.Extension
+	}
+
+	return DefaultExtension
+}
+
+// Defaults of the reconciliation when reconcile.* are not set.
+const (
+	DefaultReconcileOlderThan   = 15 * time.Minute
+	DefaultReconcileExpireAfter = time.Hour
+)
+
+// Reconcile settles the payments stuck waiting for the gateway callback: they
+// are checked with the gateway once OlderThan old, and expire when the gateway
+// does not know them after ExpireAfter.
+type Reconcile struct {
+	OlderThan   time.Duration
+	ExpireAfter time.Duration
+}
+
+// OlderThanOrDefault returns OlderThan, or DefaultReconcileOlderThan when it is
+// not set.
+func (r *Reconcile) OlderThanOrDefault() time.Duration {
+	if r.OlderThan > 0 {
+		return r.OlderThan
+	}
+
+	return DefaultReconcileOlderThan
+}
+
+// ExpireAfterOrDefault returns ExpireAfter, or DefaultReconcileExpireAfter when
+// it is not set.
+func (r *Reconcile) ExpireAfterOrDefault() time.Duration {
+	if r.ExpireAfter > 0 {
+		return r.ExpireAfter
+	}
+
+	return DefaultReconcileExpireAfter
+}
+
+// Jobs configures the job runner of the worker. Schedules maps a job name to
+// its cron spec and overrides the default schedule of the job.
+type Jobs struct {
+	PollInterval time.Duration
+	Concurrency  int
+	BatchSize    int
+	MaxAttempts  int
+	Backoff      time.Duration
+	Lease        time.Duration
+	LockKey      int64
+	Schedules    map[string]string
+}
+
+// Tracing exports the OpenTelemetry spans, Exporter is "otlp" to send them
+// to Endpoint over gRPC or "stdout" to print them on local runs.
+type Tracing struct {
+	Enabled     bool
+	Exporter    string
+	Endpoint    string
+	Insecure    bool
+	ServiceName string
+	SampleRatio float64
+}
+
+// Log configures the output of the logs, Format is "text" or "json" and
+// Level is a logrus level, e.g. "debug".
+type Log struct {
+	Level  string
+	Format string
+}
+
+// Account configures the tokens mailed to verify an email, to reset a
+// password and to change an email. The tokens are signed with TokenSecret and
+// the links of the emails are VerifyEmailURL, ResetPasswordURL and
+// ChangeEmailURL with the token as the token query parameter. The one-time
+// codes of the mobile login have OTPLength digits, expire after OTPExpiresIn
+// and allow OTPMaxAttempts wrong guesses, a new code can be asked for every
+// OTPResendInterval.
+type Account struct {
+	TokenSecret            string
+	VerifyEmailExpiresIn   time.Duration
+	ResetPasswordExpiresIn time.Duration
+	VerifyEmailURL         string
+	ResetPasswordURL       string
+	ChangeEmailURL         string
+	OTPLength              int
+	OTPExpiresIn           time.Duration
+	OTPMaxAttempts         int
+	OTPResendInterval      time.Duration
+}
+
+// Mail sends the emails, Driver is "smtp", "file" to write every email to a
+// file in Dir or "log" to print them on local runs.
+type Mail struct {
+	Driver   string
+	From     string
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Timeout  time.Duration
+	Dir      string
+}
+
+// SMS sends the text messages, Driver is "console" to print them on local
+// runs.
+type SMS struct {
+	Driver string
+}
+
+// RateLimit caps the requests of the routes. Rules maps the name of a rule,
+// e.g. login_ip, to the requests a key may make in a sliding window, routes
+// sharing a rule share its counts and a rule without a limit does not limit.
+// MaxLoginFailures failed logins of an email within LoginFailureWindow lock
+// its logins for LockoutDuration, no MaxLoginFailures never locks. The wrong
+// one-time codes of a mobile number lock its codes the same way.
+type RateLimit struct {
+	Rules              map[string]RateLimitRule
+	MaxLoginFailures   int
+	LoginFailureWindow time.Duration
+	LockoutDuration    time.Duration
+	MaxOTPFailures     int
+	OTPFailureWindow   time.Duration
+	OTPLockoutDuration time.Duration
+}
+
+type RateLimitRule struct {
+	Limit  int           `mapstructure:"limit"`
+	Window time.Duration `mapstructure:"window"`
+}
+
 func InitConfig(configPath string) (*Config, error) {
 	viper.SetConfigFile(configPath)
 
@@ -73,6 +351,33 @@ func InitConfig(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %s", err)
 	}
 
+	airlineHolds := make(map[string]time.Duration)
+	for airline, value := range viper.GetStringMapString("reservation.airline_holds") {
+		hold, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hold for airline %s: %s", airline, err)
+		}
+
+		airlineHolds[strings.ToLower(airline)] = hold
+	}
+
+	var signingKeys []SigningKey
+	err = viper.UnmarshalKey("auth.keys", &signingKeys, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeHookFunc(time.RFC3339),
+		mapstructure.StringToTimeDurationHookFunc(),
+	)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing keys: %s", err)
+	}
+
+	rateLimitRules := make(map[string]RateLimitRule)
+	err = viper.UnmarshalKey("rate_limit.rules", &rateLimitRules, viper.DecodeHook(
+		mapstructure.StringToTimeDurationHookFunc(),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate limit rules: %s", err)
+	}
+
 	return &Config{
 		Database: Database{
 			Host:     viper.GetString("database.host"),
@@ -89,17 +394,35 @@ func InitConfig(configPath string) (*Config, error) {
 			TTL:      viper.GetDuration("redis.ttl"),
 		},
 		Server: Server{
-			Port: viper.GetString("server.port"),
+			Port:            viper.GetString("server.port"),
+			ShutdownTimeout: viper.GetDuration("server.shutdown_timeout"),
+			TrustedProxies:  viper.GetStringSlice("server.trusted_proxies"),
 		},
 		JWT: JWT{
-			SecretKey: viper.GetString("auth.secret_key"),
-			ExpiresIn: viper.GetDuration("auth.expires_in"),
+			SecretKey:        viper.GetString("auth.secret_key"),
+			ExpiresIn:        viper.GetDuration("auth.expires_in"),
+			RefreshExpiresIn: viper.GetDuration("auth.refresh_expires_in"),
+			Keys:             signingKeys,
 		},
 		IPG: IPG{
+			Gateways:     viper.GetStringSlice("gatepay.gateways"),
+			BaseURL:      viper.GetString("gatepay.base_url"),
+			Timeout:      viper.GetDuration("gatepay.timeout"),
 			MerchantCode: viper.GetInt("gatepay.merchant_code"),
 			TerminalId:   viper.GetInt("gatepay.terminal_id"),
 			RedirectUrl:  viper.GetString("gatepay.redirect_url"),
 			CertFile:     viper.GetString("gatepay.cert_file"),
+			Callback: Callback{
+				Secret:     viper.GetString("gatepay.callback.secret"),
+				SuccessURL: viper.GetString("gatepay.callback.success_url"),
+				FailureURL: viper.GetString("gatepay.callback.failure_url"),
+			},
+			Zarinpal: Zarinpal{
+				MerchantID:  viper.GetString("gatepay.zarinpal.merchant_id"),
+				BaseURL:     viper.GetString("gatepay.zarinpal.base_url"),
+				GatewayURL:  viper.GetString("gatepay.zarinpal.gateway_url"),
+				CallbackURL: viper.GetString("gatepay.zarinpal.callback_url"),
+			},
 		},
 		Worker: Worker{
 			Enabled:     viper.GetBool("worker.enabled"),
@@ -107,6 +430,7 @@ func InitConfig(configPath string) (*Config, error) {
 			Iteration:   viper.GetInt("worker.iteration"),
 			Concurrency: viper.GetInt("worker.concurency"),
 			Limit:       viper.GetInt("worker.limit"),
+			MetricsPort: viper.GetString("worker.metrics_port"),
 		},
 		Services: Services{
 			ApiMock: Service{
@@ -115,5 +439,79 @@ func InitConfig(configPath string) (*Config, error) {
 				CitiesSyncPeriod: viper.GetDuration("services.cities.sync_period"),
 			},
 		},
+		Idempotency: Idempotency{
+			TTL: viper.GetDuration("idempotency.ttl"),
+		},
+		Outbox: Outbox{
+			Interval:    viper.GetDuration("outbox.interval"),
+			BatchSize:   viper.GetInt("outbox.batch_size"),
+			MaxAttempts: viper.GetInt("outbox.max_attempts"),
+			Backoff:     viper.GetDuration("outbox.backoff"),
+		},
+		Reservation: Reservation{
+			Hold:         viper.GetDuration("reservation.hold"),
+			AirlineHolds: airlineHolds,
+			Extension:    viper.GetDuration("reservation.extension"),
+		},
+		Jobs: Jobs{
+			PollInterval: viper.GetDuration("jobs.poll_interval"),
+			Concurrency:  viper.GetInt("jobs.concurrency"),
+			BatchSize:    viper.GetInt("jobs.batch_size"),
+			MaxAttempts:  viper.GetInt("jobs.max_attempts"),
+			Backoff:      viper.GetDuration("jobs.backoff"),
+			Lease:        viper.GetDuration("jobs.lease"),
+			LockKey:      viper.GetInt64("jobs.lock_key"),
+			Schedules:    viper.GetStringMapString("jobs.schedules"),
+		},
+		Reconcile: Reconcile{
+			OlderThan:   viper.GetDuration("reconcile.older_than"),
+			ExpireAfter: viper.GetDuration("reconcile.expire_after"),
+		},
+		Tracing: Tracing{
+			Enabled:     viper.GetBool("tracing.enabled"),
+			Exporter:    viper.GetString("tracing.exporter"),
+			Endpoint:    viper.GetString("tracing.endpoint"),
+			Insecure:    viper.GetBool("tracing.insecure"),
+			ServiceName: viper.GetString("tracing.service_name"),
+			SampleRatio: viper.GetFloat64("tracing.sample_ratio"),
+		},
+		Log: Log{
+			Level:  viper.GetString("log.level"),
+			Format: viper.GetString("log.format"),
+		},
+		Account: Account{
+			TokenSecret:            viper.GetString("account.token_secret"),
+			VerifyEmailExpiresIn:   viper.GetDuration("account.verify_email_expires_in"),
+			ResetPasswordExpiresIn: viper.GetDuration("account.reset_password_expires_in"),
+			VerifyEmailURL:         viper.GetString("account.verify_email_url"),
+			ResetPasswordURL:       viper.GetString("account.reset_password_url"),
+			ChangeEmailURL:         viper.GetString("account.change_email_url"),
+			OTPLength:              viper.GetInt("account.otp_length"),
+			OTPExpiresIn:           viper.GetDuration("account.otp_expires_in"),
+			OTPMaxAttempts:         viper.GetInt("account.otp_max_attempts"),
+			OTPResendInterval:      viper.GetDuration("account.otp_resend_interval"),
+		},
+		Mail: Mail{
+			Driver:   viper.GetString("mail.driver"),
+			From:     viper.GetString("mail.from"),
+			Host:     viper.GetString("mail.host"),
+			Port:     viper.GetInt("mail.port"),
+			Username: viper.GetString("mail.username"),
+			Password: viper.GetString("mail.password"),
+			Timeout:  viper.GetDuration("mail.timeout"),
+			Dir:      viper.GetString("mail.dir"),
+		},
+		SMS: SMS{
+			Driver: viper.GetString("sms.driver"),
+		},
+		RateLimit: RateLimit{
+			Rules:              rateLimitRules,
+			MaxLoginFailures:   viper.GetInt("rate_limit.max_login_failures"),
+			LoginFailureWindow: viper.GetDuration("rate_limit.login_failure_window"),
+			LockoutDuration:    viper.GetDuration("rate_limit.lockout_duration"),
+			MaxOTPFailures:     viper.GetInt("rate_limit.max_otp_failures"),
+			OTPFailureWindow:   viper.GetDuration("rate_limit.otp_failure_window"),
+			OTPLockoutDuration: viper.GetDuration("rate_limit.otp_lockout_duration"),
+		},
 	}, nil
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"on-air/config"
+	"on-air/tracing"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -25,5 +26,11 @@ func InitPostgres(cfg *config.Config) *gorm.DB {
 		return nil
 	}
 
+	err = db.Use(&tracing.GormPlugin{})
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
 	return db
 }
@@ -0,0 +1,56 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CronTestSuite struct {
+	suite.Suite
+}
+
+func (suite *CronTestSuite) next(spec string, from time.Time) time.Time {
+	schedule, err := ParseSchedule(spec)
+	suite.Require().NoError(err)
+
+	return schedule.Next(from)
+}
+
+func (suite *CronTestSuite) TestNext() {
+	require := suite.Require()
+	from := time.Date(2023, 7, 1, 10, 7, 30, 0, time.UTC) // a saturday
+
+	require.Equal(time.Date(2023, 7, 1, 10, 8, 0, 0, time.UTC), suite.next("* * * * *", from))
+	require.Equal(time.Date(2023, 7, 1, 10, 15, 0, 0, time.UTC), suite.next("*/15 * * * *", from))
+	require.Equal(time.Date(2023, 7, 1, 11, 0, 0, 0, time.UTC), suite.next("@hourly", from))
+	require.Equal(time.Date(2023, 7, 2, 0, 0, 0, 0, time.UTC), suite.next("@daily", from))
+	require.Equal(time.Date(2023, 7, 3, 9, 30, 0, 0, time.UTC), suite.next("30 9 * * 1-5", from))
+	require.Equal(time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC), suite.next("0 0 1 * *", from))
+	require.Equal(time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC), suite.next("0 8,12,16 * * *", from))
+	require.Equal(time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), suite.next("0 0 29 2 *", from))
+	require.Equal(time.Date(2104, 2, 29, 0, 0, 0, 0, time.UTC), suite.next("0 0 29 2 *", time.Date(2096, 3, 1, 0, 0, 0, 0, time.UTC)))
+	require.Equal(from.Add(10*time.Second), suite.next("@every 10s", from))
+}
+
+func (suite *CronTestSuite) TestNext_DayOfMonthOrWeek() {
+	require := suite.Require()
+	from := time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC)
+
+	// the 15th or any monday, whichever comes first
+	require.Equal(time.Date(2023, 7, 3, 0, 0, 0, 0, time.UTC), suite.next("0 0 15 * 1", from))
+}
+
+func (suite *CronTestSuite) TestParseSchedule_Invalid() {
+	require := suite.Require()
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@every", "@every -1s", "@sometimes", "0 0 31 2 *", "0 0 30,31 2 *", "0 0 31 4,6,9,11 *"} {
+		_, err := ParseSchedule(spec)
+		require.Error(err, spec)
+	}
+}
+
+func TestCron(t *testing.T) {
+	suite.Run(t, new(CronTestSuite))
+}
@@ -0,0 +1,72 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+
+	"gorm.io/gorm"
+)
+
+// Locker elects the worker that enqueues the scheduled jobs, the queued jobs
+// themselves are run by every worker.
+type Locker interface {
+	// TryLock reports whether this worker holds the lock, acquiring it when
+	// it is free. It is called on every poll, so a lost lock is noticed.
+	TryLock(ctx context.Context) (bool, error)
+	Unlock(ctx context.Context) error
+}
+
+// AdvisoryLock is a Postgres session advisory lock. The session is kept on a
+// dedicated connection, the lock is released when the connection closes, so a
+// crashed leader hands over to another worker.
+type AdvisoryLock struct {
+	DB  *gorm.DB
+	Key int64
+
+	conn *sql.Conn
+}
+
+func (l *AdvisoryLock) TryLock(ctx context.Context) (bool, error) {
+	if l.conn != nil {
+		err := l.conn.PingContext(ctx)
+		if err == nil {
+			return true, nil
+		}
+
+		l.conn.Close()
+		l.conn = nil
+	}
+
+	sqlDB, err := l.DB.DB()
+	if err != nil {
+		return false, err
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.Key).Scan(&locked)
+	if err != nil || !locked {
+		conn.Close()
+		return false, err
+	}
+
+	l.conn = conn
+
+	return true, nil
+}
+
+func (l *AdvisoryLock) Unlock(ctx context.Context) error {
+	if l.conn == nil {
+		return nil
+	}
+
+	_, err := l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.Key)
+	l.conn.Close()
+	l.conn = nil
+
+	return err
+}
@@ -0,0 +1,49 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+)
+
+const NotifyJob = "notify"
+
+// Notification tells a user about a change of one of their tickets.
+type Notification struct {
+	UserID   uint   `json:"user_id"`
+	TicketID uint   `json:"ticket_id"`
+	Event    string `json:"event"`
+	Message  string `json:"message"`
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// LogNotifier writes the notifications to the log, it stands in until a real
+// channel is configured.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, notification Notification) error {
+	logrus.WithFields(logrus.Fields{
+		"user_id":   notification.UserID,
+		"ticket_id": notification.TicketID,
+		"event":     notification.Event,
+	}).Info(notification.Message)
+
+	return nil
+}
+
+// NotifyHandler delivers the Notification payload of a notify job.
+func NotifyHandler(notifier Notifier) Handler {
+	return func(ctx context.Context, payload []byte) error {
+		var notification Notification
+		err := json.Unmarshal(payload, &notification)
+		if err != nil {
+			return err
+		}
+
+		return notifier.Notify(ctx, notification)
+	}
+}
@@ -0,0 +1,302 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"on-air/metrics"
+	"on-air/models"
+	"on-air/repository"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxBackoffShift = 10
+
+// Handler runs a job with its JSON payload, a returned error retries the job
+// with backoff until it runs out of attempts.
+type Handler func(ctx context.Context, payload []byte) error
+
+type scheduledJob struct {
+	name     string
+	schedule Schedule
+	next     time.Time
+}
+
+// Runner runs the jobs queued in the jobs table. Jobs are claimed with
+// SKIP LOCKED, so any number of workers can run side by side, while only the
+// worker holding the Locker enqueues the scheduled jobs. A claimed job is
+// leased to its worker for Lease.
+type Runner struct {
+	DB           *gorm.DB
+	Locker       Locker
+	PollInterval time.Duration
+	Concurrency  int
+	BatchSize    int
+	MaxAttempts  int
+	Backoff      time.Duration
+	Lease        time.Duration
+
+	handlers  map[string]Handler
+	schedules []*scheduledJob
+	leader    bool
+}
+
+func NewRunner(db *gorm.DB, locker Locker) *Runner {
+	return &Runner{
+		DB:           db,
+		Locker:       locker,
+		PollInterval: time.Second,
+		Concurrency:  1,
+		BatchSize:    10,
+		MaxAttempts:  5,
+		Backoff:      10 * time.Second,
+		Lease:        5 * time.Minute,
+		handlers:     make(map[string]Handler),
+	}
+}
+
+func (r *Runner) Register(name string, handler Handler) {
+	r.handlers[name] = handler
+}
+
+// Schedule enqueues the registered job name on the cron spec, see
+// ParseSchedule for the supported specs.
+func (r *Runner) Schedule(name string, spec string) error {
+	if _, ok := r.handlers[name]; !ok {
+		return fmt.Errorf("schedule %s: job is not registered", name)
+	}
+
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("schedule %s: %w", name, err)
+	}
+
+	r.schedules = append(r.schedules, &scheduledJob{name: name, schedule: schedule})
+
+	return nil
+}
+
+// Run polls the queue every PollInterval until ctx is done. When iterations
+// is positive it returns after that many polls.
+func (r *Runner) Run(ctx context.Context, iterations int) {
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+
+	defer func() {
+		if r.leader {
+			err := r.Locker.Unlock(context.Background())
+			if err != nil {
+				logrus.Error("jobs: failed to release the leader lock, error:", err)
+			}
+		}
+	}()
+
+	counter := 0
+	for {
+		select {
+		case <-ticker.C:
+			r.Poll(ctx, time.Now())
+
+			if iterations > 0 {
+				counter++
+				if counter >= iterations {
+					return
+				}
+			}
+
+		case <-ctx.Done():
+			logrus.Info("jobs: done signal received")
+			return
+		}
+	}
+}
+
+// Poll enqueues the scheduled jobs that are due when this worker is the
+// leader and runs the pending jobs across Concurrency goroutines.
+func (r *Runner) Poll(ctx context.Context, now time.Time) {
+	err := r.enqueueScheduled(ctx, now)
+	if err != nil {
+		logrus.Error("jobs: failed to enqueue scheduled jobs, error:", err)
+	}
+
+	ids, err := repository.GetPendingJobIDs(ctx, r.DB, r.BatchSize)
+	if err != nil {
+		logrus.Error("jobs: failed to get pending jobs, error:", err)
+		return
+	}
+
+	concurrency := r.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	queue := make(chan uint)
+	var wg sync.WaitGroup
+
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+
+			for id := range queue {
+				_, err := r.Work(ctx, id)
+				if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+					logrus.Errorf("jobs: failed to run job %d, error: %v", id, err)
+				}
+			}
+		}()
+	}
+
+	for _, id := range ids {
+		queue <- id
+	}
+	close(queue)
+
+	wg.Wait()
+}
+
+// enqueueScheduled enqueues the scheduled jobs that are due. A schedule starts
+// counting when its worker becomes the leader, and a run is skipped while the
+// previous one is still pending.
+func (r *Runner) enqueueScheduled(ctx context.Context, now time.Time) error {
+	if len(r.schedules) == 0 {
+		return nil
+	}
+
+	leader, err := r.Locker.TryLock(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !leader {
+		r.leader = false
+		return nil
+	}
+
+	if !r.leader {
+		logrus.Info("jobs: acquired the leader lock")
+		for _, scheduled := range r.schedules {
+			scheduled.next = scheduled.schedule.Next(now)
+		}
+		r.leader = true
+	}
+
+	for _, scheduled := range r.schedules {
+		if now.Before(scheduled.next) {
+			continue
+		}
+
+		scheduled.next = scheduled.schedule.Next(now)
+
+		pending, err := repository.HasPendingJob(ctx, r.DB, scheduled.name)
+		if err != nil {
+			return err
+		}
+
+		if pending {
+			continue
+		}
+
+		_, err = repository.EnqueueJob(ctx, r.DB, scheduled.name, struct{}{}, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Work runs a pending job. The job is claimed in a short transaction of its
+// own, which counts the attempt and pushes its next attempt Lease ahead, and
+// the handler runs outside of it with Lease to finish. The job of a worker
+// that dies while running it is claimed again once the lease is over. A job
+// that is not due or not pending anymore, or is locked by another worker,
+// returns gorm.ErrRecordNotFound.
+func (r *Runner) Work(ctx context.Context, id uint) (*models.Job, error) {
+	job, err := r.claim(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.run(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+func (r *Runner) claim(ctx context.Context, id uint) (*models.Job, error) {
+	var job models.Job
+
+	now := time.Now()
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("id = ? AND status = ? AND next_attempt_at <= ?", id, string(models.JobPending), now).
+			First(&job).Error
+		if err != nil {
+			return err
+		}
+
+		metrics.WorkerLag.Set(now.Sub(job.NextAttemptAt).Seconds())
+
+		job.Attempts++
+		job.NextAttemptAt = now.Add(r.Lease)
+
+		return tx.Model(&job).Updates(map[string]interface{}{
+			"attempts":        job.Attempts,
+			"next_attempt_at": job.NextAttemptAt,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (r *Runner) run(ctx context.Context, job *models.Job) error {
+	var err error
+
+	handler, ok := r.handlers[job.Type]
+	if ok {
+		runCtx, cancel := context.WithTimeout(ctx, r.Lease)
+		err = handler(runCtx, job.Payload)
+		cancel()
+	} else {
+		err = fmt.Errorf("unknown job type %q", job.Type)
+	}
+
+	if err != nil {
+		return r.retry(job, err)
+	}
+
+	job.Status = string(models.JobDone)
+	job.LastError = ""
+	metrics.JobsProcessed.WithLabelValues(job.Type, job.Status).Inc()
+
+	return r.DB.Save(job).Error
+}
+
+func (r *Runner) retry(job *models.Job, runErr error) error {
+	shift := job.Attempts - 1
+	if shift > maxBackoffShift {
+		shift = maxBackoffShift
+	}
+
+	job.LastError = runErr.Error()
+	job.NextAttemptAt = time.Now().Add(r.Backoff * time.Duration(1<<shift))
+
+	if job.Attempts >= r.MaxAttempts {
+		job.Status = string(models.JobDead)
+		logrus.Errorf("jobs: job %d of type %s is dead after %d attempts, error: %v", job.ID, job.Type, job.Attempts, runErr)
+	}
+	metrics.JobsProcessed.WithLabelValues(job.Type, job.Status).Inc()
+
+	return r.DB.Save(job).Error
+}
@@ -0,0 +1,239 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"on-air/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type fakeLocker struct {
+	locked bool
+}
+
+func (l *fakeLocker) TryLock(ctx context.Context) (bool, error) {
+	return l.locked, nil
+}
+
+func (l *fakeLocker) Unlock(ctx context.Context) error {
+	l.locked = false
+	return nil
+}
+
+type RunnerTestSuite struct {
+	suite.Suite
+	sqlMock sqlmock.Sqlmock
+	dbMock  *gorm.DB
+	locker  *fakeLocker
+	runner  *Runner
+	runs    int
+	err     error
+}
+
+func (suite *RunnerTestSuite) SetupTest() {
+	mockDB, sqlMock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.dbMock, err = gorm.Open(postgres.New(postgres.Config{
+		Conn: mockDB,
+	}))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.sqlMock = sqlMock
+	suite.locker = &fakeLocker{}
+	suite.runner = NewRunner(suite.dbMock, suite.locker)
+	suite.runner.MaxAttempts = 2
+	suite.runner.Backoff = time.Minute
+	suite.runs = 0
+	suite.err = nil
+	suite.runner.Register("sync", func(ctx context.Context, payload []byte) error {
+		suite.runs++
+		return suite.err
+	})
+}
+
+func (suite *RunnerTestSuite) expectClaim(jobType string, attempts int) {
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "jobs" WHERE \(id = (.+) AND status = (.+) AND next_attempt_at <= (.+)\) (.+) FOR UPDATE SKIP LOCKED`).
+		WithArgs(3, string(models.JobPending), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type", "payload", "status", "attempts"}).
+			AddRow(3, jobType, []byte(`{}`), string(models.JobPending), attempts))
+	suite.sqlMock.ExpectExec(`UPDATE "jobs" SET "attempts"=(.+),"next_attempt_at"=(.+),"updated_at"=(.+) WHERE`).
+		WithArgs(attempts+1, sqlmock.AnyArg(), sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+}
+
+func (suite *RunnerTestSuite) expectSave() {
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "jobs"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+}
+
+func (suite *RunnerTestSuite) expectJob(jobType string, attempts int) {
+	suite.expectClaim(jobType, attempts)
+	suite.expectSave()
+}
+
+func (suite *RunnerTestSuite) TestWork_Done() {
+	require := suite.Require()
+
+	suite.expectJob("sync", 0)
+
+	job, err := suite.runner.Work(context.Background(), 3)
+	require.NoError(err)
+	require.Equal(1, suite.runs)
+	require.Equal(string(models.JobDone), job.Status)
+	require.Equal(1, job.Attempts)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *RunnerTestSuite) TestWork_ClaimedBeforeRun() {
+	require := suite.Require()
+
+	suite.expectClaim("sync", 0)
+	suite.runner.Register("sync", func(ctx context.Context, payload []byte) error {
+		// The claim is committed before the handler runs, and the handler
+		// has the lease to finish.
+		require.NoError(suite.sqlMock.ExpectationsWereMet())
+		deadline, ok := ctx.Deadline()
+		require.True(ok)
+		require.WithinDuration(time.Now().Add(suite.runner.Lease), deadline, time.Second)
+
+		suite.expectSave()
+		return nil
+	})
+
+	job, err := suite.runner.Work(context.Background(), 3)
+	require.NoError(err)
+	require.Equal(string(models.JobDone), job.Status)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *RunnerTestSuite) TestWork_NotClaimed() {
+	require := suite.Require()
+
+	// Not due, not pending anymore or locked by another worker.
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "jobs" (.+) FOR UPDATE SKIP LOCKED`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	suite.sqlMock.ExpectRollback()
+
+	_, err := suite.runner.Work(context.Background(), 3)
+	require.ErrorIs(err, gorm.ErrRecordNotFound)
+	require.Equal(0, suite.runs)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *RunnerTestSuite) TestWork_Retry() {
+	require := suite.Require()
+	suite.err = errors.New("provider unavailable")
+
+	suite.expectJob("sync", 0)
+
+	job, err := suite.runner.Work(context.Background(), 3)
+	require.NoError(err)
+	require.Equal(string(models.JobPending), job.Status)
+	require.Equal("provider unavailable", job.LastError)
+	require.WithinDuration(time.Now().Add(time.Minute), job.NextAttemptAt, time.Second)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *RunnerTestSuite) TestWork_Dead() {
+	require := suite.Require()
+	suite.err = errors.New("provider unavailable")
+
+	suite.expectJob("sync", 1)
+
+	job, err := suite.runner.Work(context.Background(), 3)
+	require.NoError(err)
+	require.Equal(string(models.JobDead), job.Status)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *RunnerTestSuite) TestWork_UnknownType() {
+	require := suite.Require()
+
+	suite.expectJob("unknown", 0)
+
+	job, err := suite.runner.Work(context.Background(), 3)
+	require.NoError(err)
+	require.Equal(0, suite.runs)
+	require.Contains(job.LastError, "unknown job type")
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *RunnerTestSuite) TestSchedule_Unregistered() {
+	require := suite.Require()
+
+	require.Error(suite.runner.Schedule("reconcile", "@hourly"))
+	require.Error(suite.runner.Schedule("sync", "every hour"))
+	require.NoError(suite.runner.Schedule("sync", "@hourly"))
+}
+
+func (suite *RunnerTestSuite) TestEnqueueScheduled_Leader() {
+	require := suite.Require()
+	require.NoError(suite.runner.Schedule("sync", "@every 1m"))
+	suite.locker.locked = true
+	now := time.Now()
+
+	// becoming the leader starts the schedule
+	require.NoError(suite.runner.enqueueScheduled(context.Background(), now))
+
+	suite.sqlMock.ExpectQuery(`SELECT count\(\*\) FROM "jobs" WHERE \(type = (.+) AND status = (.+)\)`).
+		WithArgs("sync", string(models.JobPending)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(`INSERT INTO "jobs"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, "sync", sqlmock.AnyArg(), string(models.JobPending), 0, sqlmock.AnyArg(), "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	suite.sqlMock.ExpectCommit()
+
+	require.NoError(suite.runner.enqueueScheduled(context.Background(), now.Add(time.Minute)))
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+
+	// not due yet
+	require.NoError(suite.runner.enqueueScheduled(context.Background(), now.Add(90*time.Second)))
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *RunnerTestSuite) TestEnqueueScheduled_PreviousRunPending() {
+	require := suite.Require()
+	require.NoError(suite.runner.Schedule("sync", "@every 1m"))
+	suite.locker.locked = true
+	now := time.Now()
+
+	require.NoError(suite.runner.enqueueScheduled(context.Background(), now))
+
+	suite.sqlMock.ExpectQuery(`SELECT count\(\*\) FROM "jobs"`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	require.NoError(suite.runner.enqueueScheduled(context.Background(), now.Add(time.Minute)))
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *RunnerTestSuite) TestEnqueueScheduled_Follower() {
+	require := suite.Require()
+	require.NoError(suite.runner.Schedule("sync", "@every 1m"))
+
+	require.NoError(suite.runner.enqueueScheduled(context.Background(), time.Now().Add(time.Hour)))
+	require.False(suite.runner.leader)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func TestRunner(t *testing.T) {
+	suite.Run(t, new(RunnerTestSuite))
+}
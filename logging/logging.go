@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"on-air/config"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RequestIDHeader carries the id a request is logged with, it is accepted
+// from the client and forwarded on the outbound requests.
+const RequestIDHeader = "X-Request-ID"
+
+// Formats of the log output.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	loggerKey
+)
+
+// Init configures the format and the level of the standard logger, the
+// defaults are the text format and the info level.
+func Init(cfg *config.Log) error {
+	switch strings.ToLower(cfg.Format) {
+	case FormatText, "":
+		logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	case FormatJSON:
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+
+	level := logrus.InfoLevel
+	if cfg.Level != "" {
+		var err error
+		level, err = logrus.ParseLevel(cfg.Level)
+		if err != nil {
+			return err
+		}
+	}
+	logrus.SetLevel(level)
+
+	return nil
+}
+
+// WithRequestID returns a copy of ctx carrying the id of the request.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestID returns the id of the request ctx belongs to, it is empty
+// outside of a request, e.g. in the worker.
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// SetRequestID forwards the id of the request ctx belongs to on an outbound
+// request, so the logs of both sides can be correlated.
+func SetRequestID(ctx context.Context, header http.Header) {
+	if requestID := RequestID(ctx); requestID != "" {
+		header.Set(RequestIDHeader, requestID)
+	}
+}
+
+// WithLogger returns a copy of ctx carrying the request-scoped entry.
+func WithLogger(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey, entry)
+}
+
+// FromContext returns the request-scoped entry of ctx, or an entry of the
+// standard logger when ctx does not belong to a request.
+func FromContext(ctx context.Context) *logrus.Entry {
+	if entry, ok := ctx.Value(loggerKey).(*logrus.Entry); ok {
+		return entry
+	}
+
+	return logrus.NewEntry(logrus.StandardLogger())
+}
@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"on-air/config"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type LoggingTestSuite struct {
+	suite.Suite
+}
+
+func (suite *LoggingTestSuite) TearDownTest() {
+	logrus.SetFormatter(&logrus.TextFormatter{})
+	logrus.SetLevel(logrus.InfoLevel)
+}
+
+func (suite *LoggingTestSuite) TestInit_JSON() {
+	require := suite.Require()
+
+	err := Init(&config.Log{Format: "json", Level: "debug"})
+	require.NoError(err)
+	require.IsType(&logrus.JSONFormatter{}, logrus.StandardLogger().Formatter)
+	require.Equal(logrus.DebugLevel, logrus.GetLevel())
+}
+
+func (suite *LoggingTestSuite) TestInit_Defaults() {
+	require := suite.Require()
+
+	err := Init(&config.Log{})
+	require.NoError(err)
+	require.IsType(&logrus.TextFormatter{}, logrus.StandardLogger().Formatter)
+	require.Equal(logrus.InfoLevel, logrus.GetLevel())
+}
+
+func (suite *LoggingTestSuite) TestInit_Invalid() {
+	require := suite.Require()
+
+	require.Error(Init(&config.Log{Format: "xml"}))
+	require.Error(Init(&config.Log{Level: "loud"}))
+}
+
+func (suite *LoggingTestSuite) TestRequestID() {
+	require := suite.Require()
+
+	header := http.Header{}
+	SetRequestID(context.Background(), header)
+	require.Empty(header.Get(RequestIDHeader))
+
+	ctx := WithRequestID(context.Background(), "request-1")
+	SetRequestID(ctx, header)
+	require.Equal("request-1", RequestID(ctx))
+	require.Equal("request-1", header.Get(RequestIDHeader))
+}
+
+func (suite *LoggingTestSuite) TestFromContext() {
+	require := suite.Require()
+
+	require.Empty(FromContext(context.Background()).Data)
+
+	entry := logrus.WithField("request_id", "request-1")
+	require.Same(entry, FromContext(WithLogger(context.Background(), entry)))
+}
+
+func TestLogging(t *testing.T) {
+	suite.Run(t, new(LoggingTestSuite))
+}
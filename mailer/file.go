@@ -0,0 +1,47 @@
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"on-air/logging"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FileMailer writes every email to its own .eml file in Dir, so the emails of
+// local runs and tests can be opened or read back.
+type FileMailer struct {
+	From string
+	Dir  string
+}
+
+func (f *FileMailer) Send(ctx context.Context, message Message) error {
+	err := os.MkdirAll(f.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+
+	return os.WriteFile(filepath.Join(f.Dir, name), message.Bytes(f.From, now), 0o600)
+}
+
+// LogMailer prints the emails to the log instead of sending them.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, message Message) error {
+	logging.FromContext(ctx).WithFields(logrus.Fields{
+		"to":      message.To,
+		"subject": message.Subject,
+	}).Info(message.Body)
+
+	return nil
+}
@@ -0,0 +1,70 @@
+// Package mailer sends the emails of the server, through SMTP or, on local
+// runs, to files or the log.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"on-air/config"
+	"strings"
+	"time"
+)
+
+// Drivers of the mailer.
+const (
+	DriverSMTP = "smtp"
+	DriverFile = "file"
+	DriverLog  = "log"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// New returns the mailer of the configured driver, the log mailer when none
+// is configured.
+func New(cfg *config.Mail) (Mailer, error) {
+	switch strings.ToLower(cfg.Driver) {
+	case DriverSMTP:
+		if cfg.Host == "" || cfg.From == "" {
+			return nil, fmt.Errorf("smtp mailer needs a host and a from address")
+		}
+
+		return &SMTPMailer{cfg: cfg}, nil
+	case DriverFile:
+		if cfg.Dir == "" {
+			return nil, fmt.Errorf("file mailer needs a dir")
+		}
+
+		return &FileMailer{From: cfg.From, Dir: cfg.Dir}, nil
+	case DriverLog, "":
+		return &LogMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// Bytes returns the message in the Internet Message Format, as sent by from.
+func (m Message) Bytes(from string, date time.Time) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+
+	return buf.Bytes()
+}
@@ -0,0 +1,128 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"on-air/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MailerTestSuite struct {
+	suite.Suite
+}
+
+// serveSMTP answers one SMTP session on listener and sends the commands and
+// the data it received on received.
+func serveSMTP(listener net.Listener, received chan<- []string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var lines []string
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	inData := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		line = strings.TrimRight(line, "\r\n")
+		lines = append(lines, line)
+
+		switch {
+		case inData && line == ".":
+			inData = false
+			reply("250 queued")
+		case inData:
+		case strings.HasPrefix(line, "EHLO"):
+			reply("250 localhost")
+		case strings.HasPrefix(line, "DATA"):
+			inData = true
+			reply("354 go ahead")
+		case strings.HasPrefix(line, "QUIT"):
+			reply("221 bye")
+			received <- lines
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+
+	received <- lines
+}
+
+func (suite *MailerTestSuite) TestSMTPMailer() {
+	require := suite.Require()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	defer listener.Close()
+
+	received := make(chan []string, 1)
+	go serveSMTP(listener, received)
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	mailer, err := New(&config.Mail{Driver: DriverSMTP, From: "on-air <no-reply@example.com>", Host: "127.0.0.1", Port: port})
+	require.NoError(err)
+
+	err = mailer.Send(context.Background(), Message{To: "user@example.com", Subject: "Verify your email", Body: "line 1\nline 2"})
+	require.NoError(err)
+
+	session := strings.Join(<-received, "\n")
+	require.Contains(session, "MAIL FROM:<no-reply@example.com>")
+	require.Contains(session, "RCPT TO:<user@example.com>")
+	require.Contains(session, "Subject: Verify your email")
+	require.Contains(session, "line 1\nline 2")
+}
+
+func (suite *MailerTestSuite) TestFileMailer() {
+	require := suite.Require()
+	dir := filepath.Join(suite.T().TempDir(), "mail")
+
+	mailer, err := New(&config.Mail{Driver: DriverFile, From: "no-reply@example.com", Dir: dir})
+	require.NoError(err)
+
+	err = mailer.Send(context.Background(), Message{To: "user@example.com", Subject: "Reset your password", Body: "token"})
+	require.NoError(err)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(err)
+	require.Len(files, 1)
+
+	content, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	require.NoError(err)
+	require.Contains(string(content), "To: user@example.com\r\n")
+	require.True(strings.HasSuffix(string(content), "\r\n\r\ntoken"))
+}
+
+func (suite *MailerTestSuite) TestNew() {
+	require := suite.Require()
+
+	mailer, err := New(&config.Mail{})
+	require.NoError(err)
+	require.IsType(&LogMailer{}, mailer)
+
+	_, err = New(&config.Mail{Driver: "carrier-pigeon"})
+	require.Error(err)
+
+	_, err = New(&config.Mail{Driver: DriverSMTP})
+	require.Error(err)
+
+	_, err = New(&config.Mail{Driver: DriverFile})
+	require.Error(err)
+}
+
+func TestMailer(t *testing.T) {
+	suite.Run(t, new(MailerTestSuite))
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "on_air"
+
+// Cache results of the flights cache.
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+// Targets of a failed refund, the flight provider releases the seats and the
+// payment gateway returns the money.
+const (
+	RefundProvider = "provider"
+	RefundGateway  = "gateway"
+)
+
+var (
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of the HTTP requests per route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	ProviderRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "provider",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of the flight provider calls per operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	ProviderErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "provider",
+		Name:      "errors_total",
+		Help:      "Failed flight provider calls per operation, including the ones turned down by the circuit breaker.",
+	}, []string{"operation"})
+
+	ProviderBreakerOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "provider",
+		Name:      "breaker_open",
+		Help:      "1 when the circuit breaker of the flight provider is open, 0 otherwise.",
+	})
+
+	FlightsCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "flights_cache",
+		Name:      "requests_total",
+		Help:      "Lookups of the flights cache per result, hit or miss.",
+	}, []string{"result"})
+
+	TicketsExpired = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "tickets_expired_total",
+		Help:      "Reserved tickets expired by the worker.",
+	})
+
+	RefundsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "refunds_failed_total",
+		Help:      "Refunds the worker failed to deliver per target, provider or gateway.",
+	}, []string{"target"})
+
+	JobsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "jobs_processed_total",
+		Help:      "Jobs run by the worker per type and resulting status.",
+	}, []string{"type", "status"})
+
+	WorkerLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "lag_seconds",
+		Help:      "How long after its due time the last job was picked up.",
+	})
+)
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1 @@
+ALTER TABLE payments ADD COLUMN refunded_amount int NOT NULL DEFAULT 0;
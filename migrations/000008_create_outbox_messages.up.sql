@@ -0,0 +1,16 @@
+CREATE TABLE outbox_messages (
+  id serial PRIMARY KEY,
+  kind varchar(20),
+  ticket_id int,
+  flight_number varchar(20),
+  count int,
+  status varchar(20),
+  attempts int NOT NULL DEFAULT 0,
+  next_attempt_at timestamp with time zone,
+  last_error text,
+  created_at timestamp with time zone,
+  updated_at timestamp with time zone,
+  deleted_at timestamp with time zone
+);
+ALTER TABLE outbox_messages ADD FOREIGN KEY (ticket_id) REFERENCES tickets (id);
+CREATE INDEX idx_outbox_messages_status_next_attempt_at ON outbox_messages (status, next_attempt_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS payments_gateway_reference_idx;
+ALTER TABLE payments DROP COLUMN reference;
+ALTER TABLE payments DROP COLUMN gateway;
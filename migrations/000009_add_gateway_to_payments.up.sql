@@ -0,0 +1,3 @@
+ALTER TABLE payments ADD COLUMN gateway varchar(20) NOT NULL DEFAULT 'pasargad';
+ALTER TABLE payments ADD COLUMN reference varchar(64) NOT NULL DEFAULT '';
+CREATE INDEX payments_gateway_reference_idx ON payments (gateway, reference);
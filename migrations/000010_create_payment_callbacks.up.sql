@@ -0,0 +1,14 @@
+CREATE TABLE payment_callbacks (
+  id serial PRIMARY KEY,
+  gateway varchar(20),
+  payment_id int,
+  query text,
+  remote_addr varchar(64),
+  result varchar(20),
+  reason varchar(40),
+  created_at timestamp with time zone,
+  updated_at timestamp with time zone,
+  deleted_at timestamp with time zone
+);
+ALTER TABLE payment_callbacks ADD FOREIGN KEY (payment_id) REFERENCES payments (id);
+CREATE INDEX idx_payment_callbacks_payment_id ON payment_callbacks (payment_id);
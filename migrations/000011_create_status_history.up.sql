@@ -0,0 +1,15 @@
+CREATE TABLE status_history (
+  id serial PRIMARY KEY,
+  entity varchar(20),
+  entity_id int,
+  ticket_id int,
+  from_status varchar(20),
+  to_status varchar(20),
+  actor varchar(40),
+  reason text,
+  created_at timestamp with time zone,
+  updated_at timestamp with time zone,
+  deleted_at timestamp with time zone
+);
+ALTER TABLE status_history ADD FOREIGN KEY (ticket_id) REFERENCES tickets (id);
+CREATE INDEX idx_status_history_ticket_id ON status_history (ticket_id);
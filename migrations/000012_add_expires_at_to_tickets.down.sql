@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS tickets_status_expires_at_idx;
+ALTER TABLE tickets DROP COLUMN extended;
+ALTER TABLE tickets DROP COLUMN expires_at;
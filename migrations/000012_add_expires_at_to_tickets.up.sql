@@ -0,0 +1,4 @@
+ALTER TABLE tickets ADD COLUMN expires_at timestamp with time zone;
+ALTER TABLE tickets ADD COLUMN extended boolean NOT NULL DEFAULT false;
+UPDATE tickets SET expires_at = created_at + interval '15 minutes';
+CREATE INDEX tickets_status_expires_at_idx ON tickets (status, expires_at);
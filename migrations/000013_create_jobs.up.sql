@@ -0,0 +1,14 @@
+CREATE TABLE jobs (
+  id serial PRIMARY KEY,
+  type varchar(50),
+  payload jsonb,
+  status varchar(20),
+  attempts int NOT NULL DEFAULT 0,
+  next_attempt_at timestamp with time zone,
+  last_error text,
+  created_at timestamp with time zone,
+  updated_at timestamp with time zone,
+  deleted_at timestamp with time zone
+);
+CREATE INDEX idx_jobs_status_next_attempt_at ON jobs (status, next_attempt_at);
+CREATE INDEX idx_jobs_type_status ON jobs (type, status);
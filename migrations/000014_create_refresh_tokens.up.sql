@@ -0,0 +1,15 @@
+CREATE TABLE refresh_tokens (
+  id serial PRIMARY KEY,
+  user_id int NOT NULL,
+  token_hash varchar(64) NOT NULL,
+  expires_at timestamp with time zone NOT NULL,
+  revoked_at timestamp with time zone,
+  replaced_by_id int,
+  created_at timestamp with time zone,
+  updated_at timestamp with time zone,
+  deleted_at timestamp with time zone
+);
+ALTER TABLE refresh_tokens ADD FOREIGN KEY (user_id) REFERENCES users (id);
+ALTER TABLE refresh_tokens ADD FOREIGN KEY (replaced_by_id) REFERENCES refresh_tokens (id);
+CREATE UNIQUE INDEX idx_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens (user_id);
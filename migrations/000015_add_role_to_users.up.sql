@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN role varchar(20) NOT NULL DEFAULT 'customer';
+CREATE INDEX idx_users_role ON users (role);
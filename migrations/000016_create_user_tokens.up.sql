@@ -0,0 +1,15 @@
+ALTER TABLE users ADD COLUMN email_verified_at timestamp with time zone;
+CREATE TABLE user_tokens (
+  id serial PRIMARY KEY,
+  user_id int NOT NULL,
+  purpose varchar(20) NOT NULL,
+  token_hash varchar(64) NOT NULL,
+  expires_at timestamp with time zone NOT NULL,
+  used_at timestamp with time zone,
+  created_at timestamp with time zone,
+  updated_at timestamp with time zone,
+  deleted_at timestamp with time zone
+);
+ALTER TABLE user_tokens ADD FOREIGN KEY (user_id) REFERENCES users (id);
+CREATE UNIQUE INDEX idx_user_tokens_token_hash ON user_tokens (token_hash);
+CREATE INDEX idx_user_tokens_user_id_purpose ON user_tokens (user_id, purpose);
@@ -0,0 +1 @@
+CREATE INDEX idx_users_phone_number ON users (phone_number);
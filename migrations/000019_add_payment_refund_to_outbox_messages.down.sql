@@ -0,0 +1,2 @@
+ALTER TABLE outbox_messages DROP COLUMN amount;
+ALTER TABLE outbox_messages DROP COLUMN payment_id;
@@ -0,0 +1,2 @@
+ALTER TABLE outbox_messages ADD COLUMN payment_id int;
+ALTER TABLE outbox_messages ADD COLUMN amount int NOT NULL DEFAULT 0;
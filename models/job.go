@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+type Job struct {
+	gorm.Model
+	Type          string `gorm:"type:varchar(50)"`
+	Payload       datatypes.JSON
+	Status        string `gorm:"type:varchar(20)"`
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+}
+
+type JobStatus string
+
+const (
+	JobPending JobStatus = "Pending"
+	JobDone    JobStatus = "Done"
+	JobDead    JobStatus = "Dead"
+)
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type OutboxMessage struct {
+	gorm.Model
+	Kind          string `gorm:"type:varchar(20)"`
+	TicketID      uint
+	FlightNumber  string `gorm:"type:varchar(20)"`
+	Count         int
+	PaymentID     uint
+	Amount        int
+	Status        string `gorm:"type:varchar(20)"`
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+}
+
+type OutboxKind string
+
+const (
+	OutboxReserve OutboxKind = "Reserve"
+	OutboxRefund  OutboxKind = "Refund"
+	// OutboxPaymentRefund refunds Amount of the payment PaymentID through
+	// its gateway.
+	OutboxPaymentRefund OutboxKind = "PaymentRefund"
+)
+
+type OutboxStatus string
+
+const (
+	OutboxPending   OutboxStatus = "Pending"
+	OutboxDelivered OutboxStatus = "Delivered"
+	OutboxRejected  OutboxStatus = "Rejected"
+	OutboxDead      OutboxStatus = "Dead"
+)
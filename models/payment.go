@@ -8,18 +8,46 @@ import (
 
 type Payment struct {
 	gorm.Model
-	Amount   int
-	Status   string `gorm:"type:varchar(20)"`
-	TicketID uint
-	PayedAt  time.Time
-	Ticket   Ticket
+	Amount         int
+	RefundedAmount int
+	Status         string `gorm:"type:varchar(20)"`
+	Gateway        string `gorm:"type:varchar(20)"`
+	Reference      string `gorm:"type:varchar(64)"`
+	TicketID       uint
+	PayedAt        time.Time
+	Ticket         Ticket
 }
 
 type PaymentStatus string
 
 const (
-	Requested      PaymentStatus = "Requested"
-	PaymentPaid    PaymentStatus = "Paid"
-	Verified       PaymentStatus = "Verified"
-	PaymentExpired PaymentStatus = "Expired"
+	Requested        PaymentStatus = "Requested"
+	PaymentPaid      PaymentStatus = "Paid"
+	Verified         PaymentStatus = "Verified"
+	PaymentExpired   PaymentStatus = "Expired"
+	PaymentCancelled PaymentStatus = "Cancelled"
+	PaymentRefunded  PaymentStatus = "Refunded"
 )
+
+// paymentTransitions lists the statuses a payment can move to from each
+// status, statuses without an entry are final. A payment is Paid once the
+// gateway callback claims it and until it is verified.
+var paymentTransitions = map[PaymentStatus][]PaymentStatus{
+	Requested:   {PaymentPaid, Verified, PaymentExpired, PaymentCancelled, PaymentRefunded},
+	PaymentPaid: {Verified, PaymentExpired, PaymentCancelled, PaymentRefunded},
+	Verified:    {PaymentCancelled, PaymentRefunded},
+}
+
+func (s PaymentStatus) CanTransitionTo(to PaymentStatus) bool {
+	for _, next := range paymentTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s PaymentStatus) IsFinal() bool {
+	return len(paymentTransitions[s]) == 0
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// PaymentCallback is the audit record of a callback received from a payment
+// gateway, PaymentID is nil when the callback did not match a payment.
+type PaymentCallback struct {
+	gorm.Model
+	Gateway    string `gorm:"type:varchar(20)"`
+	PaymentID  *uint
+	Query      string
+	RemoteAddr string `gorm:"type:varchar(64)"`
+	Result     string `gorm:"type:varchar(20)"`
+	Reason     string `gorm:"type:varchar(40)"`
+}
+
+type PaymentCallbackResult string
+
+const (
+	CallbackAccepted PaymentCallbackResult = "Accepted"
+	CallbackRejected PaymentCallbackResult = "Rejected"
+)
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RefreshToken is a refresh token issued to a user, only the sha256 hash of
+// the token is stored. A rotated token is revoked and points to the token
+// that replaced it.
+type RefreshToken struct {
+	gorm.Model
+	UserID       uint
+	TokenHash    string `gorm:"type:varchar(64);unique"`
+	ExpiresAt    time.Time
+	RevokedAt    *time.Time
+	ReplacedByID *uint
+}
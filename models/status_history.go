@@ -0,0 +1,50 @@
+package models
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// StatusHistory records a status transition of a ticket or one of its
+// payments, FromStatus is empty when the entity was created.
+type StatusHistory struct {
+	gorm.Model
+	Entity     string `gorm:"type:varchar(20)"`
+	EntityID   uint
+	TicketID   uint
+	FromStatus string `gorm:"type:varchar(20)"`
+	ToStatus   string `gorm:"type:varchar(20)"`
+	Actor      string `gorm:"type:varchar(40)"`
+	Reason     string
+}
+
+func (StatusHistory) TableName() string {
+	return "status_history"
+}
+
+type StatusEntity string
+
+const (
+	TicketEntity  StatusEntity = "ticket"
+	PaymentEntity StatusEntity = "payment"
+)
+
+// The actors of the transitions that are not made on behalf of a user.
+const (
+	ActorWorker    = "worker"
+	ActorOutbox    = "outbox"
+	ActorReconcile = "reconcile"
+)
+
+func UserActor(userID int) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+func StaffActor(userID int) string {
+	return fmt.Sprintf("staff:%d", userID)
+}
+
+func GatewayActor(gateway string) string {
+	return "gateway:" + gateway
+}
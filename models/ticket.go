@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -10,7 +12,9 @@ type Ticket struct {
 	UnitPrice  int
 	Count      int
 	FlightID   uint
-	Status     string      `gorm:"type:varchar(10)"`
+	Status     string `gorm:"type:varchar(10)"`
+	ExpiresAt  time.Time
+	Extended   bool
 	User       User        `gorm:"foreignkey:UserID"`
 	Flight     Flight      `gorm:"foreignkey:FlightID"`
 	Passengers []Passenger `gorm:"many2many:ticket_passengers;"`
@@ -19,7 +23,32 @@ type Ticket struct {
 type TicketStatus string
 
 const (
-	Reserved      TicketStatus = "Reserved"
-	TicketPaid    TicketStatus = "Paid"
-	TicketExpired TicketStatus = "Expired"
+	TicketPending   TicketStatus = "Pending"
+	TicketFailed    TicketStatus = "Failed"
+	Reserved        TicketStatus = "Reserved"
+	TicketPaid      TicketStatus = "Paid"
+	TicketExpired   TicketStatus = "Expired"
+	TicketCancelled TicketStatus = "Cancelled"
 )
+
+// ticketTransitions lists the statuses a ticket can move to from each status,
+// statuses without an entry are final.
+var ticketTransitions = map[TicketStatus][]TicketStatus{
+	TicketPending: {Reserved, TicketFailed},
+	Reserved:      {TicketPaid, TicketExpired, TicketCancelled},
+	TicketPaid:    {TicketCancelled},
+}
+
+func (s TicketStatus) CanTransitionTo(to TicketStatus) bool {
+	for _, next := range ticketTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s TicketStatus) IsFinal() bool {
+	return len(ticketTransitions[s]) == 0
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -12,5 +14,17 @@ type User struct {
 	// NationalCode string `gorm:"type:varchar(50);unique"`
 	PhoneNumber string `gorm:"type:varchar(15)"`
 	Password    string `gorm:"type:varchar(128)"`
-	Tickets     []Ticket
+	Role        string `gorm:"type:varchar(20);default:customer"`
+	// EmailVerifiedAt is nil until the user follows the link mailed to them.
+	EmailVerifiedAt *time.Time
+	Tickets         []Ticket
 }
+
+type Role string
+
+const (
+	RoleCustomer Role = "customer"
+	RoleSupport  Role = "support"
+	RoleFinance  Role = "finance"
+	RoleAdmin    Role = "admin"
+)
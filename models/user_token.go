@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserToken is a single-use token mailed to a user for Purpose, only the
+// sha256 hash of the token is stored. It is used up once UsedAt is set.
+// Email is the new email of the user for a change_email token.
+type UserToken struct {
+	gorm.Model
+	UserID    uint
+	Purpose   string `gorm:"type:varchar(20)"`
+	TokenHash string `gorm:"type:varchar(64);unique"`
+	Email     string `gorm:"type:varchar(50)"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+type UserTokenPurpose string
+
+const (
+	VerifyEmailPurpose   UserTokenPurpose = "verify_email"
+	ResetPasswordPurpose UserTokenPurpose = "reset_password"
+	ChangeEmailPurpose   UserTokenPurpose = "change_email"
+)
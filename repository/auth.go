@@ -1,61 +1,230 @@
 package repository
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"on-air/config"
+	"on-air/models"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-type payload struct {
-	UserID    int       `json:"id"`
-	ExpiredAt time.Time `json:"expired_at"`
+// DefaultRefreshExpiresIn is how long a refresh token is valid when
+// auth.refresh_expires_in is not set.
+const DefaultRefreshExpiresIn = 30 * 24 * time.Hour
+
+const revokedTokenRedisKeyPrefix = "revoked_token"
+
+var (
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// Claims are the claims of an access token, the user is the subject and the
+// id of the token is used to revoke it. The role is carried in the token, so
+// a changed role applies from the next refresh.
+type Claims struct {
+	jwt.RegisteredClaims
+	UserID int         `json:"-"`
+	Role   models.Role `json:"role,omitempty"`
 }
 
-func newPayload(userID int, duration time.Duration) *payload {
-	payload := &payload{
-		UserID:    userID,
-		ExpiredAt: time.Now().Add(duration),
+func newClaims(userID int, role models.Role, duration time.Duration) *Claims {
+	now := time.Now()
+
+	return &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(userID),
+			ID:        newTokenID(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
+		},
+		UserID: userID,
+		Role:   role,
 	}
+}
 
-	return payload
+func CreateToken(keys *TokenKeys, userID int, role models.Role) (string, error) {
+	claims := newClaims(userID, role, keys.cfg.ExpiresIn)
+
+	return keys.sign(claims)
 }
 
-func (payload *payload) Valid() error {
-	if time.Now().After(payload.ExpiredAt) {
-		return errors.New("token expired")
+func VerifyToken(keys *TokenKeys, token string) (*Claims, error) {
+	jwtToken, err := jwt.ParseWithClaims(token, &Claims{}, keys.verificationKey)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	claims, ok := jwtToken.Claims.(*Claims)
+	if !ok || claims.ID == "" || claims.ExpiresAt == nil {
+		return nil, ErrInvalidToken
+	}
+
+	claims.UserID, err = strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, ErrInvalidToken
 	}
 
-	return nil
+	// Tokens issued before the roles were introduced belong to customers.
+	if claims.Role == "" {
+		claims.Role = models.RoleCustomer
+	}
+
+	return claims, nil
 }
 
-func CreateToken(cfg *config.JWT, userID int) (string, error) {
-	payload := newPayload(userID, cfg.ExpiresIn)
+// RevokeToken puts the access token on the denylist until it expires.
+func RevokeToken(ctx context.Context, redisClient *redis.Client, claims *Claims) error {
+	ttl := time.Until(claims.ExpiresAt.Time)
+	if ttl <= 0 {
+		return nil
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, payload)
-	return token.SignedString([]byte(cfg.SecretKey))
+	return redisClient.Set(ctx, revokedTokenKey(claims.ID), 1, ttl).Err()
 }
 
-var ErrInvalidToken = errors.New("invalid token")
+// IsTokenRevoked reports whether the access token is on the denylist.
+func IsTokenRevoked(ctx context.Context, redisClient *redis.Client, claims *Claims) (bool, error) {
+	count, err := redisClient.Exists(ctx, revokedTokenKey(claims.ID)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
 
-func VerifyToken(cfg *config.JWT, token string) (*payload, error) {
-	keyFunc := func(token *jwt.Token) (interface{}, error) {
-		_, ok := token.Method.(*jwt.SigningMethodHMAC)
-		if !ok {
-			return nil, errors.New("")
-		}
-		return []byte(cfg.SecretKey), nil
+func revokedTokenKey(tokenID string) string {
+	return fmt.Sprintf("%s_%s", revokedTokenRedisKeyPrefix, tokenID)
+}
+
+// CreateRefreshToken issues a refresh token of the user. Only the hash of the
+// token is stored, the token itself is returned once.
+func CreateRefreshToken(ctx context.Context, db *gorm.DB, cfg *config.JWT, userID int) (string, error) {
+	db = db.WithContext(ctx)
+
+	token, refreshToken := newRefreshToken(cfg, userID)
+	err := db.Create(refreshToken).Error
+	if err != nil {
+		return "", err
 	}
 
-	jwtToken, err := jwt.ParseWithClaims(token, &payload{}, keyFunc)
+	return token, nil
+}
+
+// RotateRefreshToken revokes the refresh token and issues the one replacing
+// it. A revoked token that is used again has likely been stolen, so every
+// refresh token of its user is revoked and the user has to log in again.
+func RotateRefreshToken(ctx context.Context, db *gorm.DB, cfg *config.JWT, token string) (int, string, error) {
+	db = db.WithContext(ctx)
+
+	var userID int
+	var rotated string
+	var reused bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var current models.RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&current, "token_hash = ?", hashToken(token)).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+
+		userID = int(current.UserID)
+
+		if current.RevokedAt != nil {
+			reused = true
+			return nil
+		}
+
+		if time.Now().After(current.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		var replacement *models.RefreshToken
+		rotated, replacement = newRefreshToken(cfg, userID)
+		err = tx.Create(replacement).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&current).Updates(map[string]interface{}{
+			"revoked_at":     time.Now(),
+			"replaced_by_id": replacement.ID,
+		}).Error
+	})
 	if err != nil {
-		return nil, ErrInvalidToken
+		return 0, "", err
 	}
 
-	payload, ok := jwtToken.Claims.(*payload)
-	if !ok {
-		return nil, ErrInvalidToken
+	if reused {
+		err = RevokeRefreshTokens(ctx, db, userID)
+		if err != nil {
+			return 0, "", err
+		}
+
+		return 0, "", ErrRefreshTokenReused
 	}
-	return payload, nil
+
+	return userID, rotated, nil
+}
+
+// RevokeRefreshToken revokes the refresh token of the user, revoking an
+// unknown or already revoked token is not an error so logout can be retried.
+func RevokeRefreshToken(ctx context.Context, db *gorm.DB, userID int, token string) error {
+	db = db.WithContext(ctx)
+
+	return db.Model(&models.RefreshToken{}).
+		Where("token_hash = ? AND user_id = ? AND revoked_at IS NULL", hashToken(token), userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeRefreshTokens revokes every refresh token of the user.
+func RevokeRefreshTokens(ctx context.Context, db *gorm.DB, userID int) error {
+	db = db.WithContext(ctx)
+
+	return db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func newRefreshToken(cfg *config.JWT, userID int) (string, *models.RefreshToken) {
+	expiresIn := cfg.RefreshExpiresIn
+	if expiresIn <= 0 {
+		expiresIn = DefaultRefreshExpiresIn
+	}
+
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	return token, &models.RefreshToken{
+		UserID:    uint(userID),
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(expiresIn),
+	}
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func newTokenID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
 }
@@ -1,12 +1,15 @@
 package repository
 
 import (
+	"context"
 	"log"
 	"on-air/config"
+	"on-air/models"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
 	"github.com/stretchr/testify/suite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -17,6 +20,7 @@ type AuthTestSuite struct {
 	sqlMock sqlmock.Sqlmock
 	dbMock  *gorm.DB
 	jwt     *config.JWT
+	keys    *TokenKeys
 }
 
 func (suite *AuthTestSuite) SetupSuite() {
@@ -38,29 +42,113 @@ func (suite *AuthTestSuite) SetupSuite() {
 		SecretKey: "superSafeSecretKey",
 		ExpiresIn: 60 * time.Second,
 	}
+	suite.keys, _ = NewTokenKeys(suite.jwt)
 }
 
 func (suite *AuthTestSuite) TestAuth_CreateToken_Success() {
 	require := suite.Require()
 
-	_, err := CreateToken(suite.jwt, 3)
+	_, err := CreateToken(suite.keys, 3, models.RoleCustomer)
 	require.NoError(err)
 }
 
 func (suite *AuthTestSuite) TestAuth_VerifyToken_Success() {
 	require := suite.Require()
 
-	accessToken, _ := CreateToken(suite.jwt, 1)
+	accessToken, _ := CreateToken(suite.keys, 1, models.RoleCustomer)
 
-	payload, err := VerifyToken(suite.jwt, accessToken)
+	payload, err := VerifyToken(suite.keys, accessToken)
 	require.NoError(err)
 	require.Equal(payload.UserID, 1)
 }
 
+func (suite *AuthTestSuite) TestAuth_VerifyToken_RegisteredClaims() {
+	require := suite.Require()
+
+	accessToken, _ := CreateToken(suite.keys, 4, models.RoleCustomer)
+
+	claims, err := VerifyToken(suite.keys, accessToken)
+	require.NoError(err)
+	require.Equal("4", claims.Subject)
+	require.Len(claims.ID, 32)
+	require.WithinDuration(time.Now(), claims.IssuedAt.Time, time.Second)
+	require.WithinDuration(time.Now().Add(suite.jwt.ExpiresIn), claims.ExpiresAt.Time, time.Second)
+}
+
+func (suite *AuthTestSuite) TestAuth_VerifyToken_Role() {
+	require := suite.Require()
+
+	accessToken, _ := CreateToken(suite.keys, 2, models.RoleFinance)
+	claims, err := VerifyToken(suite.keys, accessToken)
+	require.NoError(err)
+	require.Equal(models.RoleFinance, claims.Role)
+
+	accessToken, _ = CreateToken(suite.keys, 2, "")
+	claims, err = VerifyToken(suite.keys, accessToken)
+	require.NoError(err)
+	require.Equal(models.RoleCustomer, claims.Role)
+}
+
+func (suite *AuthTestSuite) TestAuth_VerifyToken_Expired() {
+	require := suite.Require()
+
+	expiredKeys, _ := NewTokenKeys(&config.JWT{SecretKey: suite.jwt.SecretKey, ExpiresIn: -time.Second})
+	accessToken, _ := CreateToken(expiredKeys, 1, models.RoleCustomer)
+
+	claims, err := VerifyToken(suite.keys, accessToken)
+	require.ErrorIs(err, ErrInvalidToken)
+	require.Nil(claims)
+}
+
+func (suite *AuthTestSuite) TestAuth_RevokeToken() {
+	require := suite.Require()
+
+	accessToken, _ := CreateToken(suite.keys, 1, models.RoleCustomer)
+	claims, _ := VerifyToken(suite.keys, accessToken)
+
+	redisClient, mockRedis := redismock.NewClientMock()
+	mockRedis.ExpectExists("revoked_token_" + claims.ID).SetVal(0)
+	mockRedis.CustomMatch(func(expected, actual []interface{}) error {
+		require.Equal("revoked_token_"+claims.ID, actual[1])
+		return nil
+	}).ExpectSet("revoked_token_"+claims.ID, 1, suite.jwt.ExpiresIn).SetVal("OK")
+	mockRedis.ExpectExists("revoked_token_" + claims.ID).SetVal(1)
+
+	revoked, err := IsTokenRevoked(context.Background(), redisClient, claims)
+	require.NoError(err)
+	require.False(revoked)
+
+	require.NoError(RevokeToken(context.Background(), redisClient, claims))
+
+	revoked, err = IsTokenRevoked(context.Background(), redisClient, claims)
+	require.NoError(err)
+	require.True(revoked)
+	require.NoError(mockRedis.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_RotateRefreshToken_Expired() {
+	require := suite.Require()
+
+	mockToken := sqlmock.NewRows([]string{"id", "user_id", "token_hash", "expires_at"}).
+		AddRow(1, 3, hashToken("refresh"), time.Now().Add(-time.Minute))
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "refresh_tokens" WHERE token_hash = \$1 .* FOR UPDATE`).
+		WithArgs(hashToken("refresh")).
+		WillReturnRows(mockToken)
+	suite.sqlMock.ExpectRollback()
+
+	userID, rotated, err := RotateRefreshToken(context.Background(), suite.dbMock, suite.jwt, "refresh")
+	require.ErrorIs(err, ErrInvalidRefreshToken)
+	require.Zero(userID)
+	require.Empty(rotated)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
 func (suite *AuthTestSuite) TestAuth_VerifyToken_Failure() {
 	require := suite.Require()
 
-	payload, err := VerifyToken(suite.jwt, "supermozakhraftoken")
+	payload, err := VerifyToken(suite.keys, "supermozakhraftoken")
 	require.Error(err)
 	require.Nil(payload)
 }
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"errors"
 	"on-air/models"
 	"on-air/server/services"
@@ -16,44 +17,48 @@ type City struct {
 	SyncPeriod    time.Duration
 }
 
-func (c *City) SyncCities() {
+// SyncCities syncs the cities every SyncPeriod until ctx is done.
+func (c *City) SyncCities(ctx context.Context) {
 	ticker := time.NewTicker(c.SyncPeriod)
-	done := make(chan bool)
 
 	go func() {
-		cities, err := c.APIMockClient.GetCities()
+		defer ticker.Stop()
+
+		err := c.SyncOnce(ctx)
 		if err != nil {
 			logrus.Error("city_repository_sync_cities:", err)
-		} else {
-			err := c.StoreCities(cities)
-			if err != nil {
-				logrus.Error("city_repository_sync_cities:", err)
-			}
 		}
 
 		for {
 			select {
-			case <-done:
+			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				cities, err := c.APIMockClient.GetCities()
+				err := c.SyncOnce(ctx)
 				if err != nil {
 					logrus.Error("city_repository_sync_cities:", err)
-				} else {
-					err := c.StoreCities(cities)
-					if err != nil {
-						logrus.Error("city_repository_sync_cities:", err)
-					}
 				}
 			}
 		}
 	}()
 }
 
-func (c *City) StoreCities(cities []string) error {
+// SyncOnce stores the cities the provider knows about.
+func (c *City) SyncOnce(ctx context.Context) error {
+	cities, err := c.APIMockClient.GetCities(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.StoreCities(ctx, cities)
+}
+
+func (c *City) StoreCities(ctx context.Context, cities []string) error {
+	db := c.DB.WithContext(ctx)
+
 	for _, cityName := range cities {
 		city := models.City{Name: cityName, CountryID: 1}
-		err := c.DB.FirstOrCreate(&city, models.City{Name: cityName}).Error
+		err := db.FirstOrCreate(&city, models.City{Name: cityName}).Error
 		if err != nil {
 			logrus.Error("city_repository_store_cities:", err)
 			return errors.New("failed to store cities")
@@ -63,7 +68,9 @@ func (c *City) StoreCities(cities []string) error {
 	return nil
 }
 
-func FindCityByName(db *gorm.DB, Name string) (*models.City, error) {
+func FindCityByName(ctx context.Context, db *gorm.DB, Name string) (*models.City, error) {
+	db = db.WithContext(ctx)
+
 	var city models.City
 	err := db.Where("name = ?", Name).First(&city).Error
 	if err != nil {
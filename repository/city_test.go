@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net/http"
@@ -70,7 +71,7 @@ func (suite *CityTestSuite) Test_GetCityByName_Success() {
 		WithArgs("Shiraz").
 		WillReturnRows(mockCity)
 
-	city, err := FindCityByName(suite.dbMock, "Shiraz")
+	city, err := FindCityByName(context.Background(), suite.dbMock, "Shiraz")
 
 	require.NoError(err)
 	require.Equal(expectedCity, *city)
@@ -82,7 +83,7 @@ func (suite *CityTestSuite) Test_GetCityByName_Failure() {
 		WithArgs("Shiraz").
 		WillReturnError(errors.New("Internal server error"))
 
-	_, err := FindCityByName(suite.dbMock, "Shiraz")
+	_, err := FindCityByName(context.Background(), suite.dbMock, "Shiraz")
 
 	require.Equal(err.Error(), "Internal server error")
 }
@@ -94,7 +95,7 @@ func (suite *CityTestSuite) Test_SyncCities_Success() {
 	getCitiesPatch := monkey.PatchInstanceMethod(
 		reflect.TypeOf(suite.city.APIMockClient),
 		"GetCities",
-		func(_ *services.APIMockClient) ([]string, error) {
+		func(_ *services.APIMockClient, ctx context.Context) ([]string, error) {
 			getCitiesPatchCalledCount++
 			return []string{"Tehran", "Tabriz", "Shiraz", "Kish", "Esfahan", "Qeshm", "Mashhad"}, nil
 		},
@@ -103,13 +104,13 @@ func (suite *CityTestSuite) Test_SyncCities_Success() {
 
 	storeCitiesCalled := false
 	storeCitiesPatch := monkey.PatchInstanceMethod(reflect.TypeOf(suite.city), "StoreCities",
-		func(c *City, cities []string) error {
+		func(c *City, ctx context.Context, cities []string) error {
 			storeCitiesCalled = true
 			return nil
 		})
 	defer storeCitiesPatch.Unpatch()
 
-	go suite.city.SyncCities()
+	go suite.city.SyncCities(context.Background())
 	time.Sleep(5 * time.Second)
 	require.Equal(3, getCitiesPatchCalledCount)
 	require.Equal(true, storeCitiesCalled)
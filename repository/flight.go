@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"on-air/models"
 	"time"
 
@@ -9,6 +10,7 @@ import (
 )
 
 func AddFlight(
+	ctx context.Context,
 	db *gorm.DB,
 	flightNumber string,
 	origin string,
@@ -18,13 +20,15 @@ func AddFlight(
 	penalties datatypes.JSON,
 	start time.Time,
 	finish time.Time) (*models.Flight, error) {
-	fromCity, err := FindCityByName(db, origin)
+	db = db.WithContext(ctx)
+
+	fromCity, err := FindCityByName(ctx, db, origin)
 
 	if err != nil {
 		return nil, err
 	}
 
-	toCity, err := FindCityByName(db, destination)
+	toCity, err := FindCityByName(ctx, db, destination)
 	if err != nil {
 		return nil, err
 	}
@@ -48,7 +52,9 @@ func AddFlight(
 	return &flight, nil
 }
 
-func FindFlight(db *gorm.DB, flightNumber string) (*models.Flight, error) {
+func FindFlight(ctx context.Context, db *gorm.DB, flightNumber string) (*models.Flight, error) {
+	db = db.WithContext(ctx)
+
 	var flight models.Flight
 
 	err := db.Where("Number = ?", flightNumber).First(&flight).Error
@@ -59,7 +65,9 @@ func FindFlight(db *gorm.DB, flightNumber string) (*models.Flight, error) {
 	return &flight, nil
 }
 
-func FindFlightById(db *gorm.DB, id int) (*models.Flight, error) {
+func FindFlightById(ctx context.Context, db *gorm.DB, id int) (*models.Flight, error) {
+	db = db.WithContext(ctx)
+
 	var flight models.Flight
 
 	err := db.Where("ID = ?", id).First(&flight).Error
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"errors"
 	"log"
 	"on-air/models"
@@ -43,7 +44,7 @@ func (suite *FlightTestSuite) TestTickets_AddFlight_Success() {
 	require := suite.Require()
 
 	city := getCity()
-	monkey.Patch(FindCityByName, func(db *gorm.DB, Name string) (*models.City, error) {
+	monkey.Patch(FindCityByName, func(ctx context.Context, db *gorm.DB, Name string) (*models.City, error) {
 		return city(), nil
 	})
 	defer monkey.Unpatch(FindCityByName)
@@ -54,26 +55,26 @@ func (suite *FlightTestSuite) TestTickets_AddFlight_Success() {
 		WillReturnRows(sqlmock.NewRows([]string{"id"}))
 	suite.sqlMock.ExpectCommit()
 
-	_, err := AddFlight(suite.dbMock, "F101", "Shiraz", "Tehran", "Homa", "Airbus_360", datatypes.JSON([]byte(`{"type": "fine", "amount": 100}`)), time.Now(), time.Now())
+	_, err := AddFlight(context.Background(), suite.dbMock, "F101", "Shiraz", "Tehran", "Homa", "Airbus_360", datatypes.JSON([]byte(`{"type": "fine", "amount": 100}`)), time.Now(), time.Now())
 	require.NoError(err)
 }
 
 func (suite *FlightTestSuite) TestTickets_AddFlightWhenGetFromCity_Failure() {
 	require := suite.Require()
 
-	monkey.Patch(FindCityByName, func(db *gorm.DB, Name string) (*models.City, error) {
+	monkey.Patch(FindCityByName, func(ctx context.Context, db *gorm.DB, Name string) (*models.City, error) {
 		return nil, errors.New("Internal database error")
 	})
 	defer monkey.Unpatch(FindCityByName)
 
-	_, err := AddFlight(suite.dbMock, "F101", "Shiraz", "Tehran", "Homa", "Airbus_360", datatypes.JSON([]byte(`{"type": "fine", "amount": 100}`)), time.Now(), time.Now())
+	_, err := AddFlight(context.Background(), suite.dbMock, "F101", "Shiraz", "Tehran", "Homa", "Airbus_360", datatypes.JSON([]byte(`{"type": "fine", "amount": 100}`)), time.Now(), time.Now())
 	require.Equal(err.Error(), "Internal database error")
 }
 
 func (suite *FlightTestSuite) TestTickets_AddFlightWhenCreateFlight_Failure() {
 	require := suite.Require()
 	city := getCity()
-	monkey.Patch(FindCityByName, func(db *gorm.DB, Name string) (*models.City, error) {
+	monkey.Patch(FindCityByName, func(ctx context.Context, db *gorm.DB, Name string) (*models.City, error) {
 		return city(), nil
 	})
 	defer monkey.Unpatch(FindCityByName)
@@ -84,7 +85,7 @@ func (suite *FlightTestSuite) TestTickets_AddFlightWhenCreateFlight_Failure() {
 		WillReturnError(errors.New("Internal database error"))
 	suite.sqlMock.ExpectRollback()
 
-	_, err := AddFlight(suite.dbMock, "F101", "Shiraz", "Tehran", "Homa", "Airbus_360", datatypes.JSON([]byte(`{"type": "fine", "amount": 100}`)), time.Now(), time.Now())
+	_, err := AddFlight(context.Background(), suite.dbMock, "F101", "Shiraz", "Tehran", "Homa", "Airbus_360", datatypes.JSON([]byte(`{"type": "fine", "amount": 100}`)), time.Now(), time.Now())
 	require.Equal(err.Error(), "Internal database error")
 }
 
@@ -127,7 +128,7 @@ func (suite *FlightTestSuite) TestTickets_FindFlight_Success() {
 		WithArgs("F101").
 		WillReturnRows(mockFlight)
 
-	flight, err := FindFlight(suite.dbMock, "F101")
+	flight, err := FindFlight(context.Background(), suite.dbMock, "F101")
 	require.NoError(err)
 	require.Equal(expectedFlight, *flight)
 }
@@ -138,7 +139,7 @@ func (suite *FlightTestSuite) TestTickets_FindFlight_Failure() {
 		WithArgs("F101").
 		WillReturnError(errors.New("internal error"))
 
-	_, err := FindFlight(suite.dbMock, "F101")
+	_, err := FindFlight(context.Background(), suite.dbMock, "F101")
 	require.Equal(err.Error(), "internal error")
 }
 
@@ -163,7 +164,7 @@ func (suite *FlightTestSuite) TestTickets_FindFlightById_Success() {
 		WithArgs(1).
 		WillReturnRows(mockFlight)
 
-	flight, err := FindFlightById(suite.dbMock, 1)
+	flight, err := FindFlightById(context.Background(), suite.dbMock, 1)
 	require.NoError(err)
 	require.Equal(expectedFlight, *flight)
 }
@@ -174,7 +175,7 @@ func (suite *FlightTestSuite) TestTickets_FindFlightById_Failure() {
 		WithArgs(1).
 		WillReturnError(errors.New("internal error"))
 
-	_, err := FindFlightById(suite.dbMock, 1)
+	_, err := FindFlightById(context.Background(), suite.dbMock, 1)
 	require.Equal(err.Error(), "internal error")
 }
 
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"on-air/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EnqueueJob records a job of the given type to run at runAt, payload is
+// stored as JSON. Like EnqueueOutbox it can be called in the transaction of
+// the change the job belongs to.
+func EnqueueJob(ctx context.Context, db *gorm.DB, jobType string, payload interface{}, runAt time.Time) (*models.Job, error) {
+	db = db.WithContext(ctx)
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	job := models.Job{
+		Type:          jobType,
+		Payload:       data,
+		Status:        string(models.JobPending),
+		NextAttemptAt: runAt,
+	}
+
+	err = db.Create(&job).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func GetPendingJobIDs(ctx context.Context, db *gorm.DB, limit int) ([]uint, error) {
+	db = db.WithContext(ctx)
+
+	var ids []uint
+
+	err := db.Model(&models.Job{}).
+		Where("status = ? AND next_attempt_at <= ?", string(models.JobPending), time.Now()).
+		Order("next_attempt_at").
+		Limit(limit).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// HasPendingJob reports whether a job of the type is waiting to run, so a
+// schedule does not pile up runs while the workers are behind.
+func HasPendingJob(ctx context.Context, db *gorm.DB, jobType string) (bool, error) {
+	db = db.WithContext(ctx)
+
+	var count int64
+
+	err := db.Model(&models.Job{}).
+		Where("type = ? AND status = ?", jobType, string(models.JobPending)).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,212 @@
+package repository
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"on-air/config"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Algorithms of the signing keys.
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var ErrNoSigningKey = errors.New("no active signing key")
+
+type signingKey struct {
+	config.SigningKey
+	method     jwt.SigningMethod
+	privateKey crypto.Signer
+}
+
+// TokenKeys are the keys the access tokens are signed and verified with,
+// they are loaded once from the configured key files.
+type TokenKeys struct {
+	cfg  *config.JWT
+	keys []*signingKey
+	now  func() time.Time
+}
+
+func NewTokenKeys(cfg *config.JWT) (*TokenKeys, error) {
+	tokenKeys := &TokenKeys{
+		cfg: cfg,
+		now: time.Now,
+	}
+
+	ids := make(map[string]bool, len(cfg.Keys))
+	for _, key := range cfg.Keys {
+		if key.ID == "" {
+			return nil, errors.New("signing key without an id")
+		}
+
+		if ids[key.ID] {
+			return nil, fmt.Errorf("duplicate signing key %q", key.ID)
+		}
+		ids[key.ID] = true
+
+		loaded, err := loadSigningKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %q: %w", key.ID, err)
+		}
+
+		tokenKeys.keys = append(tokenKeys.keys, loaded)
+	}
+
+	if len(tokenKeys.keys) > 0 && tokenKeys.signingKey() == nil {
+		return nil, ErrNoSigningKey
+	}
+
+	return tokenKeys, nil
+}
+
+func loadSigningKey(key config.SigningKey) (*signingKey, error) {
+	pem, err := os.ReadFile(key.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.Algorithm {
+	case AlgorithmRS256:
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+
+		return &signingKey{SigningKey: key, method: jwt.SigningMethodRS256, privateKey: privateKey}, nil
+	case AlgorithmEdDSA:
+		privateKey, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+
+		return &signingKey{SigningKey: key, method: jwt.SigningMethodEdDSA, privateKey: privateKey.(crypto.Signer)}, nil
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", key.Algorithm)
+	}
+}
+
+func (k *signingKey) active(now time.Time) bool {
+	return !now.Before(k.ActiveFrom) && !k.retired(now)
+}
+
+func (k *signingKey) retired(now time.Time) bool {
+	return !k.RetireAt.IsZero() && !now.Before(k.RetireAt)
+}
+
+// signingKey returns the newest active key, nil when none is active.
+func (t *TokenKeys) signingKey() *signingKey {
+	now := t.now()
+
+	var newest *signingKey
+	for _, key := range t.keys {
+		if key.active(now) && (newest == nil || !key.ActiveFrom.Before(newest.ActiveFrom)) {
+			newest = key
+		}
+	}
+
+	return newest
+}
+
+// sign signs the claims with the current key, or with the secret key when
+// no key is configured.
+func (t *TokenKeys) sign(claims jwt.Claims) (string, error) {
+	if len(t.keys) == 0 {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(t.cfg.SecretKey))
+	}
+
+	key := t.signingKey()
+	if key == nil {
+		return "", ErrNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.privateKey)
+}
+
+// verificationKey returns the key a token is verified with. Any key that is
+// not retired is accepted, so the tokens of the previous key stay valid
+// during a rotation.
+func (t *TokenKeys) verificationKey(token *jwt.Token) (interface{}, error) {
+	if len(t.keys) == 0 {
+		_, ok := token.Method.(*jwt.SigningMethodHMAC)
+		if !ok {
+			return nil, ErrInvalidToken
+		}
+		return []byte(t.cfg.SecretKey), nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	for _, key := range t.keys {
+		if key.ID != kid {
+			continue
+		}
+
+		if key.retired(t.now()) || token.Method.Alg() != key.method.Alg() {
+			return nil, ErrInvalidToken
+		}
+
+		return key.privateKey.Public(), nil
+	}
+
+	return nil, ErrInvalidToken
+}
+
+// JWK is the public part of a signing key, as published in the JWKS.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the keys that are not retired, including
+// the ones that are not active yet so they are known before they sign.
+func (t *TokenKeys) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+
+	for _, key := range t.keys {
+		if key.retired(t.now()) {
+			continue
+		}
+
+		jwk := JWK{
+			Use:       "sig",
+			Algorithm: key.method.Alg(),
+			KeyID:     key.ID,
+		}
+
+		switch publicKey := key.privateKey.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	return jwks
+}
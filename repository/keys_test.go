@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"on-air/config"
+	"on-air/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/suite"
+)
+
+type TokenKeysTestSuite struct {
+	suite.Suite
+	rsaKeyFile     string
+	ed25519KeyFile string
+	rsaKey         *rsa.PrivateKey
+	ed25519Key     ed25519.PrivateKey
+}
+
+func (suite *TokenKeysTestSuite) SetupSuite() {
+	require := suite.Require()
+	dir := suite.T().TempDir()
+
+	var err error
+	suite.rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(err)
+	suite.rsaKeyFile = suite.writeKey(dir, "rsa.pem", suite.rsaKey)
+
+	_, suite.ed25519Key, err = ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+	suite.ed25519KeyFile = suite.writeKey(dir, "ed25519.pem", suite.ed25519Key)
+}
+
+func (suite *TokenKeysTestSuite) writeKey(dir string, name string, key crypto.PrivateKey) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	suite.Require().NoError(err)
+
+	path := filepath.Join(dir, name)
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	suite.Require().NoError(err)
+
+	return path
+}
+
+func (suite *TokenKeysTestSuite) newKeys(keys ...config.SigningKey) *TokenKeys {
+	tokenKeys, err := NewTokenKeys(&config.JWT{ExpiresIn: time.Minute, Keys: keys})
+	suite.Require().NoError(err)
+
+	return tokenKeys
+}
+
+func (suite *TokenKeysTestSuite) TestTokenKeys_RS256() {
+	require := suite.Require()
+
+	keys := suite.newKeys(config.SigningKey{ID: "rsa-1", Algorithm: AlgorithmRS256, PrivateKeyFile: suite.rsaKeyFile})
+
+	accessToken, err := CreateToken(keys, 5, models.RoleCustomer)
+	require.NoError(err)
+
+	// Other services verify the token with the public key alone.
+	token, err := jwt.Parse(accessToken, func(token *jwt.Token) (interface{}, error) {
+		require.Equal("rsa-1", token.Header["kid"])
+		return &suite.rsaKey.PublicKey, nil
+	})
+	require.NoError(err)
+	require.Equal("RS256", token.Method.Alg())
+
+	claims, err := VerifyToken(keys, accessToken)
+	require.NoError(err)
+	require.Equal(5, claims.UserID)
+}
+
+func (suite *TokenKeysTestSuite) TestTokenKeys_Rotation() {
+	require := suite.Require()
+	now := time.Now()
+
+	keys := suite.newKeys(
+		config.SigningKey{ID: "rsa-1", Algorithm: AlgorithmRS256, PrivateKeyFile: suite.rsaKeyFile, RetireAt: now.Add(time.Hour)},
+		config.SigningKey{ID: "ed-1", Algorithm: AlgorithmEdDSA, PrivateKeyFile: suite.ed25519KeyFile, ActiveFrom: now.Add(time.Minute)},
+	)
+
+	oldToken, err := CreateToken(keys, 1, models.RoleCustomer)
+	require.NoError(err)
+
+	keys.now = func() time.Time { return now.Add(2 * time.Minute) }
+	newToken, err := CreateToken(keys, 1, models.RoleCustomer)
+	require.NoError(err)
+
+	token, _, err := new(jwt.Parser).ParseUnverified(newToken, &Claims{})
+	require.NoError(err)
+	require.Equal("ed-1", token.Header["kid"])
+	require.Equal("EdDSA", token.Method.Alg())
+
+	// Both keys verify during the rotation window, the old key stops once
+	// it is retired.
+	_, err = VerifyToken(keys, oldToken)
+	require.NoError(err)
+	_, err = VerifyToken(keys, newToken)
+	require.NoError(err)
+
+	keys.now = func() time.Time { return now.Add(time.Hour) }
+	_, err = VerifyToken(keys, oldToken)
+	require.ErrorIs(err, ErrInvalidToken)
+	require.Len(keys.JWKS().Keys, 1)
+}
+
+func (suite *TokenKeysTestSuite) TestTokenKeys_JWKS() {
+	require := suite.Require()
+
+	keys := suite.newKeys(
+		config.SigningKey{ID: "rsa-1", Algorithm: AlgorithmRS256, PrivateKeyFile: suite.rsaKeyFile},
+		config.SigningKey{ID: "ed-1", Algorithm: AlgorithmEdDSA, PrivateKeyFile: suite.ed25519KeyFile, ActiveFrom: time.Now().Add(time.Hour)},
+	)
+
+	jwks := keys.JWKS()
+	require.Len(jwks.Keys, 2)
+	require.Equal(JWK{KeyType: "RSA", Use: "sig", Algorithm: "RS256", KeyID: "rsa-1", N: jwks.Keys[0].N, E: "AQAB"}, jwks.Keys[0])
+	require.NotEmpty(jwks.Keys[0].N)
+	require.Equal("OKP", jwks.Keys[1].KeyType)
+	require.Equal("Ed25519", jwks.Keys[1].Curve)
+	require.Equal("EdDSA", jwks.Keys[1].Algorithm)
+	require.NotEmpty(jwks.Keys[1].X)
+}
+
+func (suite *TokenKeysTestSuite) TestTokenKeys_RejectsOtherAlgorithms() {
+	require := suite.Require()
+
+	keys := suite.newKeys(config.SigningKey{ID: "rsa-1", Algorithm: AlgorithmRS256, PrivateKeyFile: suite.rsaKeyFile})
+	claims := newClaims(1, models.RoleCustomer, time.Minute)
+
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hmacToken.Header["kid"] = "rsa-1"
+	accessToken, err := hmacToken.SignedString([]byte("secret"))
+	require.NoError(err)
+	_, err = VerifyToken(keys, accessToken)
+	require.ErrorIs(err, ErrInvalidToken)
+
+	unknownToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	unknownToken.Header["kid"] = "rsa-2"
+	accessToken, err = unknownToken.SignedString(suite.rsaKey)
+	require.NoError(err)
+	_, err = VerifyToken(keys, accessToken)
+	require.ErrorIs(err, ErrInvalidToken)
+}
+
+func (suite *TokenKeysTestSuite) TestNewTokenKeys_Failure() {
+	require := suite.Require()
+
+	tests := []config.JWT{
+		{Keys: []config.SigningKey{{ID: "rsa-1", Algorithm: "HS512", PrivateKeyFile: suite.rsaKeyFile}}},
+		{Keys: []config.SigningKey{{ID: "rsa-1", Algorithm: AlgorithmEdDSA, PrivateKeyFile: suite.rsaKeyFile}}},
+		{Keys: []config.SigningKey{{ID: "rsa-1", Algorithm: AlgorithmRS256, PrivateKeyFile: "missing.pem"}}},
+		{Keys: []config.SigningKey{{Algorithm: AlgorithmRS256, PrivateKeyFile: suite.rsaKeyFile}}},
+		{Keys: []config.SigningKey{
+			{ID: "rsa-1", Algorithm: AlgorithmRS256, PrivateKeyFile: suite.rsaKeyFile},
+			{ID: "rsa-1", Algorithm: AlgorithmRS256, PrivateKeyFile: suite.rsaKeyFile},
+		}},
+		{Keys: []config.SigningKey{{ID: "rsa-1", Algorithm: AlgorithmRS256, PrivateKeyFile: suite.rsaKeyFile, ActiveFrom: time.Now().Add(time.Hour)}}},
+	}
+
+	for _, test := range tests {
+		cfg := test
+		_, err := NewTokenKeys(&cfg)
+		require.Error(err)
+	}
+}
+
+func TestTokenKeys(t *testing.T) {
+	suite.Run(t, new(TokenKeysTestSuite))
+}
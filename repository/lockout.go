@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"on-air/config"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	loginFailuresRedisKeyPrefix = "login_failures"
+	loginLockRedisKeyPrefix     = "login_lock"
+	otpFailuresRedisKeyPrefix   = "otp_failures"
+	otpLockRedisKeyPrefix       = "otp_lock"
+)
+
+// LoginLockedFor returns how long the logins of the email are still locked,
+// zero when they are not.
+func LoginLockedFor(ctx context.Context, redisClient *redis.Client, email string) (time.Duration, error) {
+	return lockedFor(ctx, redisClient, loginLockKey(email))
+}
+
+// RecordLoginFailure counts a failed login of the email and locks its logins
+// for cfg.LockoutDuration once cfg.MaxLoginFailures failed within
+// cfg.LoginFailureWindow of each other. It reports whether the logins got
+// locked.
+func RecordLoginFailure(ctx context.Context, redisClient *redis.Client, cfg *config.RateLimit, email string) (bool, error) {
+	return recordFailure(ctx, redisClient, loginFailuresKey(email), loginLockKey(email),
+		cfg.MaxLoginFailures, cfg.LoginFailureWindow, cfg.LockoutDuration)
+}
+
+// ResetLoginFailures forgets the failed logins of the email after it logged
+// in.
+func ResetLoginFailures(ctx context.Context, redisClient *redis.Client, email string) error {
+	return redisClient.Del(ctx, loginFailuresKey(email)).Err()
+}
+
+// OTPLockedFor returns how long the codes of the mobile number are still
+// locked, zero when they are not.
+func OTPLockedFor(ctx context.Context, redisClient *redis.Client, phoneNumber string) (time.Duration, error) {
+	return lockedFor(ctx, redisClient, otpLockKey(phoneNumber))
+}
+
+// RecordOTPFailure counts a wrong code of the mobile number and locks its
+// codes for cfg.OTPLockoutDuration once cfg.MaxOTPFailures were wrong within
+// cfg.OTPFailureWindow of each other, whichever codes they were for. A new
+// code resets the attempts of the code but not these. It reports whether the
+// codes got locked.
+func RecordOTPFailure(ctx context.Context, redisClient *redis.Client, cfg *config.RateLimit, phoneNumber string) (bool, error) {
+	return recordFailure(ctx, redisClient, otpFailuresKey(phoneNumber), otpLockKey(phoneNumber),
+		cfg.MaxOTPFailures, cfg.OTPFailureWindow, cfg.OTPLockoutDuration)
+}
+
+// ResetOTPFailures forgets the wrong codes of the mobile number after a right
+// one.
+func ResetOTPFailures(ctx context.Context, redisClient *redis.Client, phoneNumber string) error {
+	return redisClient.Del(ctx, otpFailuresKey(phoneNumber)).Err()
+}
+
+func lockedFor(ctx context.Context, redisClient *redis.Client, lockKey string) (time.Duration, error) {
+	ttl, err := redisClient.PTTL(ctx, lockKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// PTTL is negative when the key does not exist.
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
+
+// recordFailure counts a failure in failuresKey and sets lockKey for lockout
+// once maxFailures failed within window of each other, no maxFailures never
+// locks.
+func recordFailure(ctx context.Context, redisClient *redis.Client, failuresKey string, lockKey string, maxFailures int, window time.Duration, lockout time.Duration) (bool, error) {
+	if maxFailures <= 0 {
+		return false, nil
+	}
+
+	var failures *redis.IntCmd
+	_, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		failures = pipe.Incr(ctx, failuresKey)
+		pipe.PExpire(ctx, failuresKey, window)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if failures.Val() < int64(maxFailures) {
+		return false, nil
+	}
+
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, lockKey, 1, lockout)
+		pipe.Del(ctx, failuresKey)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func loginFailuresKey(email string) string {
+	return fmt.Sprintf("%s_%s", loginFailuresRedisKeyPrefix, strings.ToLower(strings.TrimSpace(email)))
+}
+
+func loginLockKey(email string) string {
+	return fmt.Sprintf("%s_%s", loginLockRedisKeyPrefix, strings.ToLower(strings.TrimSpace(email)))
+}
+
+func otpFailuresKey(phoneNumber string) string {
+	return fmt.Sprintf("%s_%s", otpFailuresRedisKeyPrefix, phoneNumber)
+}
+
+func otpLockKey(phoneNumber string) string {
+	return fmt.Sprintf("%s_%s", otpLockRedisKeyPrefix, phoneNumber)
+}
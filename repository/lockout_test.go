@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"context"
+	"on-air/config"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+)
+
+type LockoutTestSuite struct {
+	suite.Suite
+	redis     *redis.Client
+	mockRedis redismock.ClientMock
+	rateLimit *config.RateLimit
+}
+
+func (suite *LockoutTestSuite) SetupTest() {
+	suite.redis, suite.mockRedis = redismock.NewClientMock()
+	suite.rateLimit = &config.RateLimit{
+		MaxLoginFailures:   3,
+		LoginFailureWindow: 15 * time.Minute,
+		LockoutDuration:    time.Hour,
+		MaxOTPFailures:     10,
+		OTPFailureWindow:   time.Hour,
+		OTPLockoutDuration: 2 * time.Hour,
+	}
+}
+
+func (suite *LockoutTestSuite) TestRecordLoginFailure_BelowMax() {
+	require := suite.Require()
+
+	suite.mockRedis.ExpectTxPipeline()
+	suite.mockRedis.ExpectIncr("login_failures_admin@gmail.com").SetVal(2)
+	suite.mockRedis.ExpectPExpire("login_failures_admin@gmail.com", 15*time.Minute).SetVal(true)
+	suite.mockRedis.ExpectTxPipelineExec()
+
+	locked, err := RecordLoginFailure(context.Background(), suite.redis, suite.rateLimit, "Admin@gmail.com")
+	require.NoError(err)
+	require.False(locked)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *LockoutTestSuite) TestRecordLoginFailure_Locks() {
+	require := suite.Require()
+
+	suite.mockRedis.ExpectTxPipeline()
+	suite.mockRedis.ExpectIncr("login_failures_admin@gmail.com").SetVal(3)
+	suite.mockRedis.ExpectPExpire("login_failures_admin@gmail.com", 15*time.Minute).SetVal(true)
+	suite.mockRedis.ExpectTxPipelineExec()
+	suite.mockRedis.ExpectTxPipeline()
+	suite.mockRedis.ExpectSet("login_lock_admin@gmail.com", 1, time.Hour).SetVal("OK")
+	suite.mockRedis.ExpectDel("login_failures_admin@gmail.com").SetVal(1)
+	suite.mockRedis.ExpectTxPipelineExec()
+
+	locked, err := RecordLoginFailure(context.Background(), suite.redis, suite.rateLimit, "admin@gmail.com")
+	require.NoError(err)
+	require.True(locked)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *LockoutTestSuite) TestRecordLoginFailure_Disabled() {
+	require := suite.Require()
+
+	locked, err := RecordLoginFailure(context.Background(), suite.redis, &config.RateLimit{}, "admin@gmail.com")
+	require.NoError(err)
+	require.False(locked)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *LockoutTestSuite) TestLoginLockedFor() {
+	require := suite.Require()
+
+	suite.mockRedis.ExpectPTTL("login_lock_admin@gmail.com").SetVal(-2 * time.Nanosecond)
+	suite.mockRedis.ExpectPTTL("login_lock_admin@gmail.com").SetVal(10 * time.Minute)
+
+	lockedFor, err := LoginLockedFor(context.Background(), suite.redis, "admin@gmail.com")
+	require.NoError(err)
+	require.Zero(lockedFor)
+
+	lockedFor, err = LoginLockedFor(context.Background(), suite.redis, "admin@gmail.com")
+	require.NoError(err)
+	require.Equal(10*time.Minute, lockedFor)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *LockoutTestSuite) TestResetLoginFailures() {
+	require := suite.Require()
+
+	suite.mockRedis.ExpectDel("login_failures_admin@gmail.com").SetVal(1)
+
+	require.NoError(ResetLoginFailures(context.Background(), suite.redis, "admin@gmail.com"))
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *LockoutTestSuite) TestRecordOTPFailure_Locks() {
+	require := suite.Require()
+
+	suite.mockRedis.ExpectTxPipeline()
+	suite.mockRedis.ExpectIncr("otp_failures_09121234567").SetVal(10)
+	suite.mockRedis.ExpectPExpire("otp_failures_09121234567", time.Hour).SetVal(true)
+	suite.mockRedis.ExpectTxPipelineExec()
+	suite.mockRedis.ExpectTxPipeline()
+	suite.mockRedis.ExpectSet("otp_lock_09121234567", 1, 2*time.Hour).SetVal("OK")
+	suite.mockRedis.ExpectDel("otp_failures_09121234567").SetVal(1)
+	suite.mockRedis.ExpectTxPipelineExec()
+
+	locked, err := RecordOTPFailure(context.Background(), suite.redis, suite.rateLimit, "09121234567")
+	require.NoError(err)
+	require.True(locked)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *LockoutTestSuite) TestOTPLockedFor() {
+	require := suite.Require()
+
+	suite.mockRedis.ExpectPTTL("otp_lock_09121234567").SetVal(time.Hour)
+
+	lockedFor, err := OTPLockedFor(context.Background(), suite.redis, "09121234567")
+	require.NoError(err)
+	require.Equal(time.Hour, lockedFor)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *LockoutTestSuite) TestResetOTPFailures() {
+	require := suite.Require()
+
+	suite.mockRedis.ExpectDel("otp_failures_09121234567").SetVal(1)
+
+	require.NoError(ResetOTPFailures(context.Background(), suite.redis, "09121234567"))
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func TestLockout(t *testing.T) {
+	suite.Run(t, new(LockoutTestSuite))
+}
@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"on-air/config"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	otpRedisKeyPrefix       = "otp"
+	otpResendRedisKeyPrefix = "otp_resend"
+)
+
+// Defaults of the one-time codes when account.otp_* are not set.
+const (
+	DefaultOTPLength         = 6
+	DefaultOTPExpiresIn      = 2 * time.Minute
+	DefaultOTPMaxAttempts    = 5
+	DefaultOTPResendInterval = time.Minute
+)
+
+var (
+	ErrInvalidOTP          = errors.New("invalid otp")
+	ErrOTPTooSoon          = errors.New("otp requested too soon")
+	ErrOTPAttemptsExceeded = errors.New("too many wrong otp attempts")
+)
+
+// verifyOTPScript counts an attempt at the code of KEYS[1] and compares its
+// hash with ARGV[1] in one step, so parallel guesses can not get past the
+// ARGV[2] attempts. The code is deleted once it is right or out of attempts.
+// It returns 1 for the right code, 0 for a wrong one, -1 when there is no
+// code and -2 when the attempts ran out.
+var verifyOTPScript = redis.NewScript(`
+local hash = redis.call("HGET", KEYS[1], "hash")
+if not hash then
+	return -1
+end
+
+local attempts = redis.call("HINCRBY", KEYS[1], "attempts", 1)
+if hash == ARGV[1] then
+	redis.call("DEL", KEYS[1])
+	return 1
+end
+
+if attempts >= tonumber(ARGV[2]) then
+	redis.call("DEL", KEYS[1])
+	return -2
+end
+
+return 0
+`)
+
+// RequestOTP issues a new numeric code for the mobile number, replacing the
+// one before it. Only the HMAC of the code is kept, until it expires. A code
+// can be asked for once every account.otp_resend_interval.
+func RequestOTP(ctx context.Context, redisClient *redis.Client, cfg *config.Account, phoneNumber string) (string, error) {
+	if cfg.TokenSecret == "" {
+		return "", ErrNoTokenSecret
+	}
+
+	allowed, err := redisClient.SetNX(ctx, otpResendKey(phoneNumber), 1, otpResendInterval(cfg)).Result()
+	if err != nil {
+		return "", err
+	}
+
+	if !allowed {
+		return "", ErrOTPTooSoon
+	}
+
+	code, err := newOTP(otpLength(cfg))
+	if err != nil {
+		return "", err
+	}
+
+	key := otpKey(phoneNumber)
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "hash", hashOTP(cfg, phoneNumber, code), "attempts", 0)
+		pipe.Expire(ctx, key, otpExpiresIn(cfg))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// VerifyOTP uses up the code of the mobile number. ErrInvalidOTP is returned
+// for a wrong, expired or used code and ErrOTPAttemptsExceeded when the code
+// is dropped after too many wrong ones.
+func VerifyOTP(ctx context.Context, redisClient *redis.Client, cfg *config.Account, phoneNumber string, code string) error {
+	if cfg.TokenSecret == "" {
+		return ErrNoTokenSecret
+	}
+
+	result, err := verifyOTPScript.Run(ctx, redisClient,
+		[]string{otpKey(phoneNumber)},
+		hashOTP(cfg, phoneNumber, code), otpMaxAttempts(cfg),
+	).Int()
+	if err != nil {
+		return err
+	}
+
+	switch result {
+	case 1:
+		return nil
+	case -2:
+		return ErrOTPAttemptsExceeded
+	default:
+		return ErrInvalidOTP
+	}
+}
+
+// newOTP returns a random code of length digits, leading zeros included.
+func newOTP(length int) (string, error) {
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(length)), nil)
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", length, n), nil
+}
+
+// hashOTP keys the hash with the token secret, a plain hash of a six digit
+// code is reversed by trying them all.
+func hashOTP(cfg *config.Account, phoneNumber string, code string) string {
+	mac := hmac.New(sha256.New, []byte(cfg.TokenSecret))
+	mac.Write([]byte(phoneNumber + "." + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func otpKey(phoneNumber string) string {
+	return fmt.Sprintf("%s_%s", otpRedisKeyPrefix, phoneNumber)
+}
+
+func otpResendKey(phoneNumber string) string {
+	return fmt.Sprintf("%s_%s", otpResendRedisKeyPrefix, phoneNumber)
+}
+
+func otpLength(cfg *config.Account) int {
+	if cfg.OTPLength > 0 {
+		return cfg.OTPLength
+	}
+	return DefaultOTPLength
+}
+
+func otpExpiresIn(cfg *config.Account) time.Duration {
+	if cfg.OTPExpiresIn > 0 {
+		return cfg.OTPExpiresIn
+	}
+	return DefaultOTPExpiresIn
+}
+
+func otpMaxAttempts(cfg *config.Account) int {
+	if cfg.OTPMaxAttempts > 0 {
+		return cfg.OTPMaxAttempts
+	}
+	return DefaultOTPMaxAttempts
+}
+
+func otpResendInterval(cfg *config.Account) time.Duration {
+	if cfg.OTPResendInterval > 0 {
+		return cfg.OTPResendInterval
+	}
+	return DefaultOTPResendInterval
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"on-air/config"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+)
+
+type OTPTestSuite struct {
+	suite.Suite
+	redis     *redis.Client
+	mockRedis redismock.ClientMock
+	account   *config.Account
+}
+
+func (suite *OTPTestSuite) SetupTest() {
+	suite.redis, suite.mockRedis = redismock.NewClientMock()
+	suite.account = &config.Account{
+		TokenSecret:       "tokenSecret",
+		OTPLength:         5,
+		OTPExpiresIn:      3 * time.Minute,
+		OTPMaxAttempts:    3,
+		OTPResendInterval: 30 * time.Second,
+	}
+}
+
+func (suite *OTPTestSuite) TestRequestOTP_Success() {
+	require := suite.Require()
+
+	suite.mockRedis.ExpectSetNX("otp_resend_09121234567", 1, 30*time.Second).SetVal(true)
+	suite.mockRedis.ExpectTxPipeline()
+	suite.mockRedis.CustomMatch(func(expected, actual []interface{}) error {
+		require.Equal("hset", actual[0])
+		require.Equal("otp_09121234567", actual[1])
+		require.Equal("hash", actual[2])
+		require.Len(actual[3], 64)
+		require.Equal("attempts", actual[4])
+		return nil
+	}).ExpectHSet("otp_09121234567", "hash", "", "attempts", 0).SetVal(2)
+	suite.mockRedis.ExpectExpire("otp_09121234567", 3*time.Minute).SetVal(true)
+	suite.mockRedis.ExpectTxPipelineExec()
+
+	code, err := RequestOTP(context.Background(), suite.redis, suite.account, "09121234567")
+	require.NoError(err)
+	require.Regexp(regexp.MustCompile(`^\d{5}$`), code)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *OTPTestSuite) TestRequestOTP_TooSoon() {
+	require := suite.Require()
+
+	suite.mockRedis.ExpectSetNX("otp_resend_09121234567", 1, 30*time.Second).SetVal(false)
+
+	_, err := RequestOTP(context.Background(), suite.redis, suite.account, "09121234567")
+	require.ErrorIs(err, ErrOTPTooSoon)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *OTPTestSuite) TestVerifyOTP() {
+	require := suite.Require()
+
+	hash := hashOTP(suite.account, "09121234567", "01234")
+	testCases := []struct {
+		desc        string
+		result      int64
+		expectedErr error
+	}{
+		{"Right code", 1, nil},
+		{"Wrong code", 0, ErrInvalidOTP},
+		{"No code", -1, ErrInvalidOTP},
+		{"Attempts ran out", -2, ErrOTPAttemptsExceeded},
+	}
+
+	for _, t := range testCases {
+		suite.mockRedis.ExpectEvalSha(verifyOTPScript.Hash(), []string{"otp_09121234567"}, hash, 3).SetVal(t.result)
+
+		err := VerifyOTP(context.Background(), suite.redis, suite.account, "09121234567", "01234")
+		if t.expectedErr == nil {
+			require.NoError(err, t.desc)
+		} else {
+			require.ErrorIs(err, t.expectedErr, t.desc)
+		}
+	}
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *OTPTestSuite) TestNewOTP() {
+	require := suite.Require()
+
+	for i := 0; i < 20; i++ {
+		code, err := newOTP(DefaultOTPLength)
+		require.NoError(err)
+		require.Regexp(regexp.MustCompile(`^\d{6}$`), code)
+	}
+}
+
+func (suite *OTPTestSuite) TestOTP_NoSecret() {
+	require := suite.Require()
+
+	_, err := RequestOTP(context.Background(), suite.redis, &config.Account{}, "09121234567")
+	require.ErrorIs(err, ErrNoTokenSecret)
+
+	err = VerifyOTP(context.Background(), suite.redis, &config.Account{}, "09121234567", "01234")
+	require.ErrorIs(err, ErrNoTokenSecret)
+}
+
+func TestOTP(t *testing.T) {
+	suite.Run(t, new(OTPTestSuite))
+}
@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"on-air/metrics"
+	"on-air/models"
+	"on-air/server/services"
+	"on-air/server/services/gateway"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxBackoffShift = 10
+
+// Outbox delivers the reserve and refund calls recorded in outbox_messages to
+// the flight provider, and the payment refunds to their gateways. Messages are
+// delivered at least once: a call that times out on our side is retried even
+// if the provider or the gateway has handled it, so provider calls carry the
+// message as their idempotency key. A reserve that goes dead may still have
+// been made by the provider, so its seats are refunded.
+type Outbox struct {
+	DB            *gorm.DB
+	APIMockClient *services.APIMockClient
+	Gateways      *gateway.Registry
+	MaxAttempts   int
+	Backoff       time.Duration
+}
+
+// EnqueueOutbox records a provider call, it is meant to be called in the same
+// transaction as the ticket change it belongs to. The dispatcher leaves the
+// message to the caller for delay before picking it up.
+func EnqueueOutbox(ctx context.Context, db *gorm.DB, kind models.OutboxKind, ticketID uint, flightNumber string, count int, delay time.Duration) (*models.OutboxMessage, error) {
+	return enqueueOutbox(ctx, db, models.OutboxMessage{
+		Kind:         string(kind),
+		TicketID:     ticketID,
+		FlightNumber: flightNumber,
+		Count:        count,
+	}, delay)
+}
+
+// EnqueuePaymentRefund records the refund of amount of the payment through its
+// gateway, in the same transaction as the change of the payment that records
+// the refund. The refund is then made however the request that asked for it
+// ends.
+func EnqueuePaymentRefund(ctx context.Context, db *gorm.DB, payment *models.Payment, amount int, delay time.Duration) (*models.OutboxMessage, error) {
+	return enqueueOutbox(ctx, db, models.OutboxMessage{
+		Kind:      string(models.OutboxPaymentRefund),
+		TicketID:  payment.TicketID,
+		PaymentID: payment.ID,
+		Amount:    amount,
+	}, delay)
+}
+
+func enqueueOutbox(ctx context.Context, db *gorm.DB, message models.OutboxMessage, delay time.Duration) (*models.OutboxMessage, error) {
+	db = db.WithContext(ctx)
+
+	message.Status = string(models.OutboxPending)
+	message.NextAttemptAt = time.Now().Add(delay)
+
+	err := db.Create(&message).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+func GetPendingOutboxMessageIDs(ctx context.Context, db *gorm.DB, limit int) ([]uint, error) {
+	db = db.WithContext(ctx)
+
+	var ids []uint
+
+	err := db.Model(&models.OutboxMessage{}).
+		Where("status = ? AND next_attempt_at <= ?", string(models.OutboxPending), time.Now()).
+		Order("next_attempt_at").
+		Limit(limit).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Dispatch delivers a pending message. The message row stays locked during the
+// provider call, so concurrent dispatchers skip it. A message that is not
+// pending anymore or is locked by another dispatcher returns gorm.ErrRecordNotFound.
+func (o *Outbox) Dispatch(ctx context.Context, id uint) (*models.OutboxMessage, error) {
+	var message models.OutboxMessage
+
+	err := o.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("id = ? AND status = ?", id, string(models.OutboxPending)).
+			First(&message).Error
+		if err != nil {
+			return err
+		}
+
+		return o.deliver(ctx, tx, &message)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// DispatchPending delivers up to limit due messages and returns how many of
+// them were handled.
+func (o *Outbox) DispatchPending(ctx context.Context, limit int) (int, error) {
+	ids, err := GetPendingOutboxMessageIDs(ctx, o.DB, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	dispatched := 0
+	for _, id := range ids {
+		_, err := o.Dispatch(ctx, id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return dispatched, err
+		}
+
+		dispatched++
+	}
+
+	return dispatched, nil
+}
+
+func (o *Outbox) deliver(ctx context.Context, tx *gorm.DB, message *models.OutboxMessage) error {
+	var accepted bool
+	var err error
+
+	switch models.OutboxKind(message.Kind) {
+	case models.OutboxReserve:
+		accepted, err = o.APIMockClient.Reserve(ctx, message.FlightNumber, message.Count, outboxIdempotencyKey(message))
+	case models.OutboxRefund:
+		accepted, err = o.APIMockClient.Refund(ctx, message.FlightNumber, message.Count, outboxIdempotencyKey(message))
+		if err == nil && !accepted {
+			err = errors.New("refund rejected by provider")
+		}
+	case models.OutboxPaymentRefund:
+		accepted, err = true, o.refundPayment(ctx, tx, message)
+	default:
+		err = fmt.Errorf("unknown outbox message kind %q", message.Kind)
+	}
+
+	message.Attempts++
+	if err != nil {
+		switch models.OutboxKind(message.Kind) {
+		case models.OutboxRefund:
+			metrics.RefundsFailed.WithLabelValues(metrics.RefundProvider).Inc()
+		case models.OutboxPaymentRefund:
+			metrics.RefundsFailed.WithLabelValues(metrics.RefundGateway).Inc()
+		}
+
+		return o.retry(ctx, tx, message, err)
+	}
+
+	ticketStatus := models.Reserved
+	reason := "seats reserved by provider"
+	message.Status = string(models.OutboxDelivered)
+	message.LastError = ""
+	if !accepted {
+		ticketStatus = models.TicketFailed
+		reason = "seats rejected by provider"
+		message.Status = string(models.OutboxRejected)
+	}
+
+	err = tx.Save(message).Error
+	if err != nil {
+		return err
+	}
+
+	if models.OutboxKind(message.Kind) != models.OutboxReserve {
+		return nil
+	}
+
+	return ChangeTicketStatus(ctx, tx, message.TicketID, string(ticketStatus), models.ActorOutbox, reason)
+}
+
+func (o *Outbox) refundPayment(ctx context.Context, tx *gorm.DB, message *models.OutboxMessage) error {
+	var payment models.Payment
+
+	err := tx.First(&payment, "id = ?", message.PaymentID).Error
+	if err != nil {
+		return err
+	}
+
+	return RefundPayment(ctx, o.Gateways, &payment, message.Amount)
+}
+
+func (o *Outbox) retry(ctx context.Context, tx *gorm.DB, message *models.OutboxMessage, deliveryErr error) error {
+	shift := message.Attempts - 1
+	if shift > maxBackoffShift {
+		shift = maxBackoffShift
+	}
+
+	message.LastError = deliveryErr.Error()
+	message.NextAttemptAt = time.Now().Add(o.Backoff * time.Duration(1<<shift))
+
+	if message.Attempts >= o.MaxAttempts {
+		message.Status = string(models.OutboxDead)
+	}
+
+	err := tx.Save(message).Error
+	if err != nil {
+		return err
+	}
+
+	if message.Status != string(models.OutboxDead) || models.OutboxKind(message.Kind) != models.OutboxReserve {
+		return nil
+	}
+
+	err = ChangeTicketStatus(ctx, tx, message.TicketID, string(models.TicketFailed), models.ActorOutbox, message.LastError)
+	if err != nil {
+		return err
+	}
+
+	_, err = EnqueueOutbox(ctx, tx, models.OutboxRefund, message.TicketID, message.FlightNumber, message.Count, 0)
+	return err
+}
+
+func outboxIdempotencyKey(message *models.OutboxMessage) string {
+	return fmt.Sprintf("outbox_%d", message.ID)
+}
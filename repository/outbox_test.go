@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"on-air/models"
+	"on-air/server/services"
+	"on-air/server/services/gateway"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/eapache/go-resiliency/breaker"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type OutboxTestSuite struct {
+	suite.Suite
+	sqlMock sqlmock.Sqlmock
+	dbMock  *gorm.DB
+	outbox  *Outbox
+}
+
+func (suite *OutboxTestSuite) SetupSuite() {
+	mockDB, sqlMock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.dbMock, err = gorm.Open(postgres.New(postgres.Config{
+		Conn: mockDB,
+	}))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.sqlMock = sqlMock
+	suite.outbox = &Outbox{
+		DB: suite.dbMock,
+		APIMockClient: &services.APIMockClient{
+			Client:  &http.Client{},
+			Breaker: &breaker.Breaker{},
+			Timeout: time.Second,
+		},
+		MaxAttempts: 2,
+		Backoff:     time.Minute,
+	}
+}
+
+func (suite *OutboxTestSuite) provider(status int, body string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Equal("outbox_5", r.Header.Get(services.IdempotencyKeyHeader))
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	suite.outbox.APIMockClient.BaseURL = server.URL
+
+	return server
+}
+
+func (suite *OutboxTestSuite) expectMessage(kind models.OutboxKind, attempts int) {
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "outbox_messages" (.+) FOR UPDATE SKIP LOCKED`).
+		WithArgs(5, string(models.OutboxPending)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "ticket_id", "flight_number", "count", "status", "attempts"}).
+			AddRow(5, string(kind), 9, "FL001", 2, string(models.OutboxPending), attempts))
+}
+
+func (suite *OutboxTestSuite) expectTicketStatus(status models.TicketStatus) {
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "tickets"`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(9, string(models.TicketPending)))
+	suite.sqlMock.ExpectExec(`UPDATE "tickets" SET "status"=(.+) WHERE \(id = (.+) AND status = (.+)\)`).
+		WithArgs(string(status), sqlmock.AnyArg(), 9, string(models.TicketPending)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(suite.sqlMock, models.TicketEntity, 9, string(models.TicketPending), string(status), models.ActorOutbox)
+}
+
+func (suite *OutboxTestSuite) TestOutbox_Dispatch_Reserve_Delivered() {
+	require := suite.Require()
+	server := suite.provider(http.StatusOK, `{"Status": true}`)
+	defer server.Close()
+
+	suite.expectMessage(models.OutboxReserve, 0)
+	suite.sqlMock.ExpectExec(`UPDATE "outbox_messages"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.expectTicketStatus(models.Reserved)
+	suite.sqlMock.ExpectCommit()
+
+	message, err := suite.outbox.Dispatch(context.Background(), 5)
+	require.NoError(err)
+	require.Equal(string(models.OutboxDelivered), message.Status)
+	require.Equal(1, message.Attempts)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *OutboxTestSuite) TestOutbox_Dispatch_Reserve_SoldOut() {
+	require := suite.Require()
+	server := suite.provider(http.StatusOK, `{"Status": false}`)
+	defer server.Close()
+
+	suite.expectMessage(models.OutboxReserve, 0)
+	suite.sqlMock.ExpectExec(`UPDATE "outbox_messages"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.expectTicketStatus(models.TicketFailed)
+	suite.sqlMock.ExpectCommit()
+
+	message, err := suite.outbox.Dispatch(context.Background(), 5)
+	require.NoError(err)
+	require.Equal(string(models.OutboxRejected), message.Status)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *OutboxTestSuite) TestOutbox_Dispatch_Refund_Retry() {
+	require := suite.Require()
+	server := suite.provider(http.StatusInternalServerError, `{}`)
+	defer server.Close()
+
+	suite.expectMessage(models.OutboxRefund, 0)
+	suite.sqlMock.ExpectExec(`UPDATE "outbox_messages"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	before := time.Now()
+	message, err := suite.outbox.Dispatch(context.Background(), 5)
+	require.NoError(err)
+	require.Equal(string(models.OutboxPending), message.Status)
+	require.Equal(1, message.Attempts)
+	require.NotEmpty(message.LastError)
+	require.True(message.NextAttemptAt.After(before.Add(time.Minute - time.Second)))
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *OutboxTestSuite) TestOutbox_Dispatch_Reserve_DeadLetter() {
+	require := suite.Require()
+	server := suite.provider(http.StatusInternalServerError, `{}`)
+	defer server.Close()
+
+	suite.expectMessage(models.OutboxReserve, 1)
+	suite.sqlMock.ExpectExec(`UPDATE "outbox_messages"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.expectTicketStatus(models.TicketFailed)
+	suite.sqlMock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, string(models.OutboxRefund), 9, "FL001", 2, 0, 0, string(models.OutboxPending), 0, sqlmock.AnyArg(), "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6))
+	suite.sqlMock.ExpectCommit()
+
+	message, err := suite.outbox.Dispatch(context.Background(), 5)
+	require.NoError(err)
+	require.Equal(string(models.OutboxDead), message.Status)
+	require.Equal(2, message.Attempts)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *OutboxTestSuite) expectPaymentRefund() {
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "outbox_messages" (.+) FOR UPDATE SKIP LOCKED`).
+		WithArgs(5, string(models.OutboxPending)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "ticket_id", "payment_id", "amount", "status", "attempts"}).
+			AddRow(5, string(models.OutboxPaymentRefund), 9, 3, 1500, string(models.OutboxPending), 0))
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "payments" WHERE id = (.+)`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ticket_id", "amount", "gateway"}).AddRow(3, 9, 2000, gateway.Pasargad))
+}
+
+func (suite *OutboxTestSuite) TestOutbox_Dispatch_PaymentRefund_Delivered() {
+	require := suite.Require()
+	paymentGateway := &stubGateway{}
+	suite.outbox.Gateways = gateway.NewRegistry(paymentGateway)
+
+	suite.expectPaymentRefund()
+	suite.sqlMock.ExpectExec(`UPDATE "outbox_messages"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	message, err := suite.outbox.Dispatch(context.Background(), 5)
+	require.NoError(err)
+	require.Equal(string(models.OutboxDelivered), message.Status)
+	require.Equal(int64(1500), paymentGateway.refunded)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *OutboxTestSuite) TestOutbox_Dispatch_PaymentRefund_Retry() {
+	require := suite.Require()
+	paymentGateway := &stubGateway{refundErr: errors.New("gateway unavailable")}
+	suite.outbox.Gateways = gateway.NewRegistry(paymentGateway)
+
+	suite.expectPaymentRefund()
+	suite.sqlMock.ExpectExec(`UPDATE "outbox_messages"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	message, err := suite.outbox.Dispatch(context.Background(), 5)
+	require.NoError(err)
+	require.Equal(string(models.OutboxPending), message.Status)
+	require.Equal("gateway unavailable", message.LastError)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *OutboxTestSuite) TestOutbox_Dispatch_AlreadyTaken() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "outbox_messages" (.+) FOR UPDATE SKIP LOCKED`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	suite.sqlMock.ExpectRollback()
+
+	_, err := suite.outbox.Dispatch(context.Background(), 5)
+	require.ErrorIs(err, gorm.ErrRecordNotFound)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func TestOutbox(t *testing.T) {
+	suite.Run(t, new(OutboxTestSuite))
+}
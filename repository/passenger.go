@@ -1,12 +1,15 @@
 package repository
 
 import (
+	"context"
 	"on-air/models"
 
 	"gorm.io/gorm"
 )
 
-func CreatePassenger(db *gorm.DB, userID int, nationalCode, firstName, lastName, gender string) (*models.Passenger, error) {
+func CreatePassenger(ctx context.Context, db *gorm.DB, userID int, nationalCode, firstName, lastName, gender string) (*models.Passenger, error) {
+	db = db.WithContext(ctx)
+
 	passenger := models.Passenger{
 		UserID:       uint(userID),
 		NationalCode: nationalCode,
@@ -23,7 +26,9 @@ func CreatePassenger(db *gorm.DB, userID int, nationalCode, firstName, lastName,
 	return &passenger, nil
 }
 
-func GetPassengersByUserID(db *gorm.DB, userID int) (*[]models.Passenger, error) {
+func GetPassengersByUserID(ctx context.Context, db *gorm.DB, userID int) (*[]models.Passenger, error) {
+	db = db.WithContext(ctx)
+
 	var passengers []models.Passenger
 	if err := db.Find(&passengers, "user_id = ?", userID).Error; err != nil {
 		return nil, err
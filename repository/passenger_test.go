@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"errors"
 	"log"
 	"regexp"
@@ -45,7 +46,7 @@ func (suite *PassengerTestSuite) TestPassenger_CreatePassenger_Success() {
 		regexp.QuoteMeta(`INSERT INTO "passengers"`)).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}))
 	suite.sqlMock.ExpectCommit()
-	_, err := CreatePassenger(suite.dbMock, suite.UserID, "0123456789", "fname", "lname", "f")
+	_, err := CreatePassenger(context.Background(), suite.dbMock, suite.UserID, "0123456789", "fname", "lname", "f")
 	require.NoError(err)
 }
 
@@ -58,7 +59,7 @@ func (suite *PassengerTestSuite) TestPassenger_CreatePassenger_Failure() {
 		regexp.QuoteMeta(`INSERT INTO "passengers"`)).
 		WillReturnError(errors.New("internal error"))
 	suite.sqlMock.ExpectRollback()
-	res, err := CreatePassenger(suite.dbMock, suite.UserID, "0123456789", "fname", "lname", "f")
+	res, err := CreatePassenger(context.Background(), suite.dbMock, suite.UserID, "0123456789", "fname", "lname", "f")
 	require.Equal(expectedError, string(err.Error()))
 	require.Empty(res)
 }
@@ -74,7 +75,7 @@ func (suite *PassengerTestSuite) TestGetPassenger_Success() {
 	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "passengers" WHERE user_id = (.+)`).
 		WillReturnRows(mockPassenger)
 
-	_, err := GetPassengersByUserID(suite.dbMock, suite.UserID)
+	_, err := GetPassengersByUserID(context.Background(), suite.dbMock, suite.UserID)
 	require.NoError(err)
 }
 
@@ -84,7 +85,7 @@ func (suite *PassengerTestSuite) TestGetPassenger_Failure() {
 	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "passengers" WHERE user_id = (.+)`).
 		WillReturnError(errors.New("internal error"))
 
-	_, err := GetPassengersByUserID(suite.dbMock, suite.UserID)
+	_, err := GetPassengersByUserID(context.Background(), suite.dbMock, suite.UserID)
 	require.Equal(err.Error(), "internal error")
 }
 
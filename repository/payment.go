@@ -1,142 +1,321 @@
 package repository
 
 import (
+	"context"
 	"errors"
-	"log"
-	"on-air/config"
+	"fmt"
 	"on-air/models"
-	"on-air/server/services/pasargad"
+	"on-air/server/services/gateway"
 	"strconv"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
-func PayTicket(db *gorm.DB, ipg *config.IPG, ticketID uint) (string, error) {
+// PayTicket creates a payment of the reserved ticket of the user and returns
+// the address of the gateway to pay it at. A ticket of another user is not
+// found, and a ticket that is not held for payment returns
+// ErrTicketNotReserved before anything is charged.
+func PayTicket(ctx context.Context, db *gorm.DB, gateways *gateway.Registry, userID int, ticketID uint) (string, error) {
+	db = db.WithContext(ctx)
+
 	var dbticket models.Ticket
 
-	err := db.First(&dbticket, "ID = ?", ticketID).Error
+	err := db.First(&dbticket, "id = ? AND user_id = ?", ticketID, userID).Error
 
 	if err != nil {
 		return "", err
 	}
 
+	if dbticket.Status != string(models.Reserved) || !dbticket.ExpiresAt.After(time.Now()) {
+		return "", ErrTicketNotReserved
+	}
+
 	payment := models.Payment{
 		TicketID: ticketID,
 		Amount:   dbticket.UnitPrice * dbticket.Count,
 		Status:   string(models.Requested),
 	}
 
-	err = db.Create(&payment).Error
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&payment).Error
+		if err != nil {
+			return err
+		}
+
+		return recordStatus(tx, models.PaymentEntity, payment.ID, ticketID, "", payment.Status, models.UserActor(int(dbticket.UserID)), "")
+	})
 
 	if err != nil {
 		return "", err
 	}
 
-	pasargadApi := pasargadApi(ipg)
-	request := pasargad.CreatePaymentRequest{
-		Amount:        int64(payment.Amount),
-		InvoiceNumber: strconv.Itoa(int(payment.ID)),
-		InvoiceDate:   payment.CreatedAt.Format("2006/01/02"),
+	paymentGateway, response, err := gateways.Redirect(ctx, gateway.RedirectRequest{
+		Invoice: paymentInvoice(&payment),
+		Amount:  int64(payment.Amount),
+	})
+
+	if err != nil {
+		return "", err
 	}
 
-	response, err := pasargadApi.Redirect(request)
+	err = db.Model(&payment).Updates(map[string]interface{}{
+		"gateway":   paymentGateway.Name(),
+		"reference": response.Reference,
+	}).Error
 
 	if err != nil {
 		return "", err
 	}
 
-	return response, nil
+	return response.URL, nil
 }
 
-func VerifyPayment(db *gorm.DB, ipg *config.IPG, paymentID int, paymentDate time.Time, transactionReferenceID int) (string, error) {
-	var dbPayment models.Payment
+var (
+	ErrPaymentAlreadyVerified = errors.New("payment already verified")
+	ErrPaymentNotPayable      = errors.New("payment is not payable")
+	ErrCallbackReplayed       = errors.New("payment callback replayed")
+	ErrTicketNotReserved      = errors.New("ticket is not reserved")
+	ErrAmountMismatch         = errors.New("transaction amount does not match the payment")
+)
 
-	err := db.First(&dbPayment, "ID = ?", uint(paymentID)).Error
+// GetCallbackPayment finds the payment a gateway callback belongs to, by the
+// invoice number when the gateway sends it and by its reference otherwise.
+func GetCallbackPayment(ctx context.Context, db *gorm.DB, gatewayName string, callback *gateway.Callback) (*models.Payment, error) {
+	db = db.WithContext(ctx)
+
+	var payment models.Payment
+
+	query := db.Preload("Ticket").Where("gateway = ?", gatewayName)
+	if callback.Number != "" {
+		query = query.Where("id = ?", callback.Number)
+	} else {
+		query = query.Where("reference = ?", callback.Reference)
+	}
+
+	err := query.First(&payment).Error
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	pasargadApi := pasargadApi(ipg)
+	return &payment, nil
+}
 
-	checkRequest := pasargad.CreateCheckTransactionRequest{
-		InvoiceNumber:          strconv.Itoa(paymentID),
-		InvoiceDate:            paymentDate.Format("2006/01/02"),
-		TransactionReferenceID: strconv.Itoa(transactionReferenceID),
+// VerifyPayment settles the payment a gateway callback belongs to. The payment
+// is claimed by moving it from Requested to Paid, so a replayed callback can
+// not verify or refund it twice. The transaction is checked with the gateway
+// instead of trusting the callback: a declined one cancels the payment and a
+// paid one is refunded when it does not match the payment or the ticket is not
+// reserved anymore. A payment left in Paid by a gateway error is settled by
+// the reconcile command.
+func VerifyPayment(ctx context.Context, db *gorm.DB, paymentGateway gateway.PaymentGateway, callback *gateway.Callback) (*models.Payment, error) {
+	db = db.WithContext(ctx)
+
+	payment, err := GetCallbackPayment(ctx, db, paymentGateway.Name(), callback)
+	if err != nil {
+		return nil, err
 	}
 
-	checkResponse, err := pasargadApi.CheckTransaction(checkRequest)
-	if err != nil {
-		return "", err
+	switch models.PaymentStatus(payment.Status) {
+	case models.Requested:
+	case models.Verified:
+		return payment, ErrPaymentAlreadyVerified
+	case models.PaymentPaid:
+		return payment, ErrCallbackReplayed
+	default:
+		return payment, ErrPaymentNotPayable
+	}
+
+	if payment.Reference != "" && callback.Reference != "" && payment.Reference != callback.Reference {
+		return payment, fmt.Errorf("%w: reference does not match the payment", gateway.ErrInvalidCallback)
 	}
 
-	if checkResponse.IsSuccess != true && checkResponse.Amount != int64(dbPayment.Amount) {
-		RefundPayment(ipg, paymentID, paymentDate)
-		return "", errors.New("Transaction not correct!")
+	if payment.Reference == "" {
+		payment.Reference = callback.Reference
 	}
 
-	verifyRequest := pasargad.CreateVerifyPaymentRequest{
-		Amount:        int64(dbPayment.Amount),
-		InvoiceNumber: strconv.Itoa(paymentID),
-		InvoiceDate:   paymentDate.Format("2006/01/02"),
+	err = claimPayment(db, payment, paymentGateway.Name())
+	if errors.Is(err, ErrStatusChanged) {
+		return payment, ErrCallbackReplayed
 	}
 
-	verifyResponse, err := pasargadApi.VerifyPayment(verifyRequest)
 	if err != nil {
-		return "", err
+		return payment, err
+	}
+
+	checkResponse, err := paymentGateway.Check(ctx, gateway.CheckRequest{
+		Invoice: paymentInvoice(payment),
+		Amount:  int64(payment.Amount),
+	})
+
+	var declined gateway.DeclinedError
+	if errors.As(err, &declined) {
+		return payment, rejectPayment(db, payment, models.PaymentCancelled, err)
+	}
+
+	if err != nil {
+		return payment, err
 	}
 
-	if verifyResponse.IsSuccess {
-		dbPayment.Status = string(models.Verified)
-		err = db.Save(dbPayment).Error
+	if checkResponse.Amount != int64(payment.Amount) {
+		return payment, refundRejectedPayment(ctx, db, paymentGateway, payment, ErrAmountMismatch)
+	}
+
+	if payment.Ticket.Status != string(models.Reserved) {
+		return payment, refundRejectedPayment(ctx, db, paymentGateway, payment, ErrTicketNotReserved)
+	}
+
+	_, err = paymentGateway.Verify(ctx, gateway.VerifyRequest{
+		Invoice: paymentInvoice(payment),
+		Amount:  int64(payment.Amount),
+	})
+
+	if errors.As(err, &declined) {
+		return payment, refundRejectedPayment(ctx, db, paymentGateway, payment, err)
+	}
+
+	if err != nil {
+		return payment, err
+	}
 
+	actor := models.GatewayActor(paymentGateway.Name())
+	payedAt := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := transitionPayment(tx, payment, models.Verified, actor, "", map[string]interface{}{
+			"payed_at": payedAt,
+		})
 		if err != nil {
-			RefundPayment(ipg, paymentID, paymentDate)
-			return "", err
+			return err
 		}
 
-	} else {
-		RefundPayment(ipg, paymentID, paymentDate)
+		return transitionTicket(tx, &payment.Ticket, models.TicketPaid, actor, "payment verified")
+	})
+	if err != nil {
+		payment.Status = string(models.PaymentPaid)
+		return payment, refundRejectedPayment(ctx, db, paymentGateway, payment, err)
 	}
 
-	ChangeTicketStatus(db, dbPayment.TicketID, string(models.PaymentPaid))
+	payment.PayedAt = payedAt
 
-	return dbPayment.Status, nil
+	return payment, nil
 }
 
-func RefundPayment(ipg *config.IPG, paymentID int, paymentDate time.Time) {
+func claimPayment(db *gorm.DB, payment *models.Payment, gatewayName string) error {
+	return transitionPayment(db, payment, models.PaymentPaid, models.GatewayActor(gatewayName), "callback received", map[string]interface{}{
+		"reference": payment.Reference,
+	})
+}
+
+// rejectPayment moves a claimed payment to status and returns reason, the
+// payment stays Paid when that fails.
+func rejectPayment(db *gorm.DB, payment *models.Payment, status models.PaymentStatus, reason error) error {
+	err := transitionPayment(db, payment, status, models.GatewayActor(payment.Gateway), reason.Error(), nil)
+	if err != nil {
+		logrus.Error("payment_repository: update payment status failed, error:", err)
+	}
 
-	pasargadApi := pasargadApi(ipg)
+	return reason
+}
 
-	request := pasargad.CreateRefundRequest{
-		InvoiceNumber: strconv.Itoa(paymentID),
-		InvoiceDate:   paymentDate.Format("2006/01/02"),
+func refundRejectedPayment(ctx context.Context, db *gorm.DB, paymentGateway gateway.PaymentGateway, payment *models.Payment, reason error) error {
+	err := paymentGateway.Refund(ctx, gateway.RefundRequest{
+		Invoice: paymentInvoice(payment),
+	})
+	if err != nil {
+		logrus.Error("payment_repository: refund failed, error:", err)
+		return reason
 	}
 
-	_, err := pasargadApi.Refund(request)
+	return rejectPayment(db, payment, models.PaymentRefunded, reason)
+}
 
+// RefundPayment refunds amount of the payment through the gateway it was paid
+// with, a zero amount refunds the whole payment.
+func RefundPayment(ctx context.Context, gateways *gateway.Registry, payment *models.Payment, amount int) error {
+	paymentGateway, err := gateways.Get(payment.Gateway)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+
+	return paymentGateway.Refund(ctx, gateway.RefundRequest{
+		Invoice: paymentInvoice(payment),
+		Amount:  int64(amount),
+	})
 }
 
-func pasargadApi(ipg *config.IPG) (pasrgad *pasargad.PasargadPaymentAPI) {
-	return pasargad.PasargadAPI(
-		int64(ipg.MerchantCode),
-		int64(ipg.TerminalId),
-		ipg.RedirectUrl,
-		ipg.CertFile,
-	)
+var ErrRefundExceedsPayment = errors.New("refund exceeds what is left of the payment")
+
+// RefundTicket records the refund of amount of the verified payment of the
+// ticket and enqueues it through its gateway, a zero amount refunds what is
+// left of it. The payment is locked like on a cancellation, so refunds made at
+// the same time never add up to more than was paid. The payment stays
+// verified, like after a partial cancellation, so a later cancellation refunds
+// only what is left.
+func RefundTicket(ctx context.Context, db *gorm.DB, ticketID uint, amount int, dispatchDelay time.Duration) (*models.Payment, int, *models.OutboxMessage, error) {
+	db = db.WithContext(ctx)
+
+	var payment *models.Payment
+	var message *models.OutboxMessage
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+
+		payment, err = lockVerifiedPayment(tx, ticketID)
+		if err != nil {
+			return err
+		}
+
+		remaining := payment.Amount - payment.RefundedAmount
+		if amount == 0 {
+			amount = remaining
+		}
+
+		if amount <= 0 || amount > remaining {
+			return fmt.Errorf("%w: %d of %d", ErrRefundExceedsPayment, amount, remaining)
+		}
+
+		message, err = refundPaymentLater(ctx, tx, payment, amount, dispatchDelay)
+		return err
+	})
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	return payment, amount, message, nil
 }
 
-func ChangePaymentStatus(db *gorm.DB, ticketID uint, status string) error {
-	var payments []models.Payment
+func paymentInvoice(payment *models.Payment) gateway.Invoice {
+	return gateway.Invoice{
+		Number:    strconv.Itoa(int(payment.ID)),
+		Date:      payment.CreatedAt,
+		Reference: payment.Reference,
+	}
+}
+
+// ChangePaymentStatus moves the payments of the ticket to status, payments
+// already there or in a final status are left alone.
+func ChangePaymentStatus(ctx context.Context, db *gorm.DB, ticketID uint, status string, actor string, reason string) error {
+	db = db.WithContext(ctx)
 
-	err := db.Model(&payments).Where("ticket_id = ?", ticketID).Update("status", status).Error
+	var payments []models.Payment
 
+	err := db.Where("ticket_id = ?", ticketID).Order("id").Find(&payments).Error
 	if err != nil {
 		return err
 	}
 
+	for i := range payments {
+		current := models.PaymentStatus(payments[i].Status)
+		if current == models.PaymentStatus(status) || current.IsFinal() {
+			continue
+		}
+
+		err = transitionPayment(db, &payments[i], models.PaymentStatus(status), actor, reason, nil)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"context"
+	"on-air/models"
+
+	"gorm.io/gorm"
+)
+
+func CreatePaymentCallback(ctx context.Context, db *gorm.DB, callback *models.PaymentCallback) error {
+	db = db.WithContext(ctx)
+
+	return db.Create(callback).Error
+}
@@ -0,0 +1,325 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"on-air/models"
+	"on-air/server/services/gateway"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type stubGateway struct {
+	checkAmount int64
+	checkErr    error
+	verifyErr   error
+	refundErr   error
+	refunds     int
+	refunded    int64
+}
+
+func (g *stubGateway) Name() string {
+	return gateway.Pasargad
+}
+
+func (g *stubGateway) Redirect(ctx context.Context, request gateway.RedirectRequest) (*gateway.RedirectResult, error) {
+	return &gateway.RedirectResult{}, nil
+}
+
+func (g *stubGateway) Check(ctx context.Context, request gateway.CheckRequest) (*gateway.CheckResult, error) {
+	if g.checkErr != nil {
+		return nil, g.checkErr
+	}
+
+	return &gateway.CheckResult{Amount: g.checkAmount}, nil
+}
+
+func (g *stubGateway) Verify(ctx context.Context, request gateway.VerifyRequest) (*gateway.VerifyResult, error) {
+	if g.verifyErr != nil {
+		return nil, g.verifyErr
+	}
+
+	return &gateway.VerifyResult{}, nil
+}
+
+func (g *stubGateway) Refund(ctx context.Context, request gateway.RefundRequest) error {
+	if g.refundErr != nil {
+		return g.refundErr
+	}
+
+	g.refunds++
+	g.refunded += request.Amount
+	return nil
+}
+
+func (g *stubGateway) ParseCallback(query url.Values) (*gateway.Callback, error) {
+	return &gateway.Callback{}, nil
+}
+
+type PaymentTestSuite struct {
+	suite.Suite
+	sqlMock  sqlmock.Sqlmock
+	dbMock   *gorm.DB
+	callback *gateway.Callback
+}
+
+func (suite *PaymentTestSuite) SetupSuite() {
+	mockDB, sqlMock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.dbMock, err = gorm.Open(postgres.New(postgres.Config{
+		Conn: mockDB,
+	}))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.sqlMock = sqlMock
+	suite.callback = &gateway.Callback{Number: "4", Reference: "1001", Succeeded: true}
+}
+
+func (suite *PaymentTestSuite) expectPayment(status models.PaymentStatus, ticketStatus models.TicketStatus) {
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "payments" WHERE gateway = (.+) AND id = (.+)`).
+		WithArgs(gateway.Pasargad, "4").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "amount", "status", "gateway", "ticket_id"}).
+			AddRow(4, 2000, string(status), gateway.Pasargad, 9))
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "tickets"`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(9, string(ticketStatus)))
+}
+
+func (suite *PaymentTestSuite) expectClaim(rows int64) {
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "payments" SET "reference"=(.+),"status"=(.+) WHERE \(id = (.+) AND status = (.+)\)`).
+		WithArgs("1001", string(models.PaymentPaid), sqlmock.AnyArg(), 4, string(models.Requested)).
+		WillReturnResult(sqlmock.NewResult(0, rows))
+	if rows == 0 {
+		suite.sqlMock.ExpectRollback()
+		return
+	}
+
+	expectHistory(suite.sqlMock, models.PaymentEntity, 4, string(models.Requested), string(models.PaymentPaid), models.GatewayActor(gateway.Pasargad))
+	suite.sqlMock.ExpectCommit()
+}
+
+func (suite *PaymentTestSuite) expectStatus(status models.PaymentStatus) {
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "payments" SET "status"=(.+) WHERE \(id = (.+) AND status = (.+)\)`).
+		WithArgs(string(status), sqlmock.AnyArg(), 4, string(models.PaymentPaid)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(suite.sqlMock, models.PaymentEntity, 4, string(models.PaymentPaid), string(status), models.GatewayActor(gateway.Pasargad))
+	suite.sqlMock.ExpectCommit()
+}
+
+func (suite *PaymentTestSuite) TestVerifyPayment_Success() {
+	require := suite.Require()
+	paymentGateway := &stubGateway{checkAmount: 2000}
+
+	suite.expectPayment(models.Requested, models.Reserved)
+	suite.expectClaim(1)
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "payments" SET "payed_at"=(.+),"status"=(.+) WHERE \(id = (.+) AND status = (.+)\)`).
+		WithArgs(sqlmock.AnyArg(), string(models.Verified), sqlmock.AnyArg(), 4, string(models.PaymentPaid)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(suite.sqlMock, models.PaymentEntity, 4, string(models.PaymentPaid), string(models.Verified), models.GatewayActor(gateway.Pasargad))
+	suite.sqlMock.ExpectExec(`UPDATE "tickets" SET "status"=(.+) WHERE \(id = (.+) AND status = (.+)\)`).
+		WithArgs(string(models.TicketPaid), sqlmock.AnyArg(), 9, string(models.Reserved)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(suite.sqlMock, models.TicketEntity, 9, string(models.Reserved), string(models.TicketPaid), models.GatewayActor(gateway.Pasargad))
+	suite.sqlMock.ExpectCommit()
+
+	payment, err := VerifyPayment(context.Background(), suite.dbMock, paymentGateway, suite.callback)
+	require.NoError(err)
+	require.Equal(string(models.Verified), payment.Status)
+	require.Equal(0, paymentGateway.refunds)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *PaymentTestSuite) TestVerifyPayment_AlreadyVerified() {
+	require := suite.Require()
+
+	suite.expectPayment(models.Verified, models.TicketPaid)
+
+	_, err := VerifyPayment(context.Background(), suite.dbMock, &stubGateway{}, suite.callback)
+	require.ErrorIs(err, ErrPaymentAlreadyVerified)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *PaymentTestSuite) TestVerifyPayment_Expired() {
+	require := suite.Require()
+
+	suite.expectPayment(models.PaymentExpired, models.TicketExpired)
+
+	_, err := VerifyPayment(context.Background(), suite.dbMock, &stubGateway{}, suite.callback)
+	require.ErrorIs(err, ErrPaymentNotPayable)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *PaymentTestSuite) TestVerifyPayment_Replayed() {
+	require := suite.Require()
+
+	suite.expectPayment(models.Requested, models.Reserved)
+	suite.expectClaim(0)
+
+	_, err := VerifyPayment(context.Background(), suite.dbMock, &stubGateway{}, suite.callback)
+	require.ErrorIs(err, ErrCallbackReplayed)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *PaymentTestSuite) TestVerifyPayment_Declined() {
+	require := suite.Require()
+	paymentGateway := &stubGateway{checkErr: gateway.DeclinedError{Gateway: gateway.Pasargad, Message: "not paid"}}
+
+	suite.expectPayment(models.Requested, models.Reserved)
+	suite.expectClaim(1)
+	suite.expectStatus(models.PaymentCancelled)
+
+	payment, err := VerifyPayment(context.Background(), suite.dbMock, paymentGateway, suite.callback)
+	require.ErrorIs(err, gateway.DeclinedError{})
+	require.Equal(string(models.PaymentCancelled), payment.Status)
+	require.Equal(0, paymentGateway.refunds)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *PaymentTestSuite) TestVerifyPayment_TicketNotReserved() {
+	require := suite.Require()
+	paymentGateway := &stubGateway{checkAmount: 2000}
+
+	suite.expectPayment(models.Requested, models.TicketExpired)
+	suite.expectClaim(1)
+	suite.expectStatus(models.PaymentRefunded)
+
+	payment, err := VerifyPayment(context.Background(), suite.dbMock, paymentGateway, suite.callback)
+	require.ErrorIs(err, ErrTicketNotReserved)
+	require.Equal(string(models.PaymentRefunded), payment.Status)
+	require.Equal(1, paymentGateway.refunds)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *PaymentTestSuite) TestVerifyPayment_AmountMismatch() {
+	require := suite.Require()
+	paymentGateway := &stubGateway{checkAmount: 1000}
+
+	suite.expectPayment(models.Requested, models.Reserved)
+	suite.expectClaim(1)
+	suite.expectStatus(models.PaymentRefunded)
+
+	_, err := VerifyPayment(context.Background(), suite.dbMock, paymentGateway, suite.callback)
+	require.ErrorIs(err, ErrAmountMismatch)
+	require.Equal(1, paymentGateway.refunds)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *PaymentTestSuite) expectPayableTicket(status models.TicketStatus, expiresAt time.Time) {
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "tickets" WHERE \(id = (.+) AND user_id = (.+)\)`).
+		WithArgs(9, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "unit_price", "count", "status", "expires_at"}).
+			AddRow(9, 1, 1000, 2, string(status), expiresAt))
+}
+
+func (suite *PaymentTestSuite) TestPayTicket_Success() {
+	require := suite.Require()
+
+	suite.expectPayableTicket(models.Reserved, time.Now().Add(time.Minute))
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(`INSERT INTO "payments"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	expectHistory(suite.sqlMock, models.PaymentEntity, 4, "", string(models.Requested), models.UserActor(1))
+	suite.sqlMock.ExpectCommit()
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "payments" SET "gateway"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	_, err := PayTicket(context.Background(), suite.dbMock, gateway.NewRegistry(&stubGateway{}), 1, 9)
+	require.NoError(err)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *PaymentTestSuite) TestPayTicket_NotReserved() {
+	require := suite.Require()
+
+	for _, status := range []models.TicketStatus{models.TicketPending, models.TicketPaid, models.TicketExpired, models.TicketCancelled} {
+		suite.expectPayableTicket(status, time.Now().Add(time.Minute))
+
+		_, err := PayTicket(context.Background(), suite.dbMock, gateway.NewRegistry(&stubGateway{}), 1, 9)
+		require.ErrorIs(err, ErrTicketNotReserved, status)
+	}
+
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *PaymentTestSuite) TestPayTicket_HoldRunOut() {
+	require := suite.Require()
+
+	suite.expectPayableTicket(models.Reserved, time.Now().Add(-time.Minute))
+
+	_, err := PayTicket(context.Background(), suite.dbMock, gateway.NewRegistry(&stubGateway{}), 1, 9)
+	require.ErrorIs(err, ErrTicketNotReserved)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *PaymentTestSuite) TestPayTicket_OtherUser() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "tickets" WHERE \(id = (.+) AND user_id = (.+)\)`).
+		WithArgs(9, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	_, err := PayTicket(context.Background(), suite.dbMock, gateway.NewRegistry(&stubGateway{}), 2, 9)
+	require.ErrorIs(err, gorm.ErrRecordNotFound)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *PaymentTestSuite) expectRefundablePayment(amount int, refunded int) {
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "payments" WHERE \(ticket_id = .* AND status = .*\) .* FOR UPDATE`).
+		WithArgs(7, string(models.Verified)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ticket_id", "amount", "refunded_amount", "status", "gateway"}).
+			AddRow(4, 7, amount, refunded, string(models.Verified), gateway.Pasargad))
+}
+
+func (suite *PaymentTestSuite) TestRefundTicket_Remaining() {
+	require := suite.Require()
+
+	suite.expectRefundablePayment(2000, 500)
+	suite.sqlMock.ExpectExec(`UPDATE "payments" SET "refunded_amount"`).
+		WithArgs(1500, sqlmock.AnyArg(), 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, string(models.OutboxPaymentRefund), 7, "", 0, 4, 1500, string(models.OutboxPending), 0, sqlmock.AnyArg(), "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(20))
+	suite.sqlMock.ExpectCommit()
+
+	payment, amount, message, err := RefundTicket(context.Background(), suite.dbMock, 7, 0, time.Second)
+	require.NoError(err)
+	require.Equal(1500, amount)
+	require.Equal(2000, payment.RefundedAmount)
+	require.Equal(string(models.Verified), payment.Status)
+	require.Equal(uint(20), message.ID)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *PaymentTestSuite) TestRefundTicket_ExceedsPayment() {
+	require := suite.Require()
+
+	suite.expectRefundablePayment(2000, 1500)
+	suite.sqlMock.ExpectRollback()
+
+	_, _, _, err := RefundTicket(context.Background(), suite.dbMock, 7, 600, time.Second)
+	require.ErrorIs(err, ErrRefundExceedsPayment)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func TestPayment(t *testing.T) {
+	suite.Run(t, new(PaymentTestSuite))
+}
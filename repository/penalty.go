@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"on-air/models"
+	"on-air/server/services"
+	"time"
+)
+
+var ErrFlightDeparted = errors.New("flight already departed")
+
+// CalculateRefund returns the refundable part of amount when a ticket of the
+// flight is cancelled at the given time. Each penalty window is bounded by
+// Start and End, which are either RFC3339 times or durations before the
+// flight's StartedAt (e.g. "48h"). An empty Start is open-ended and an empty
+// End means departure time. When several windows match, the highest penalty
+// wins.
+func CalculateRefund(flight *models.Flight, amount int, at time.Time) (int, error) {
+	if !at.Before(flight.StartedAt) {
+		return 0, ErrFlightDeparted
+	}
+
+	var penalties []services.Penalties
+	if len(flight.Penalties) > 0 {
+		err := json.Unmarshal(flight.Penalties, &penalties)
+		if err != nil {
+			return 0, fmt.Errorf("invalid flight penalties: %w", err)
+		}
+	}
+
+	percent := 0
+	for _, penalty := range penalties {
+		start, err := parsePenaltyBound(penalty.Start, flight.StartedAt)
+		if err != nil {
+			return 0, err
+		}
+
+		end, err := parsePenaltyBound(penalty.End, flight.StartedAt)
+		if err != nil {
+			return 0, err
+		}
+
+		if end.IsZero() {
+			end = flight.StartedAt
+		}
+
+		if (start.IsZero() || !at.Before(start)) && at.Before(end) && penalty.Percent > percent {
+			percent = penalty.Percent
+		}
+	}
+
+	if percent > 100 {
+		percent = 100
+	}
+
+	return amount * (100 - percent) / 100, nil
+}
+
+func parsePenaltyBound(value string, startedAt time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if duration, err := time.ParseDuration(value); err == nil {
+		return startedAt.Add(-duration), nil
+	}
+
+	bound, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid penalty bound %q: %w", value, err)
+	}
+
+	return bound, nil
+}
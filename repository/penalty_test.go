@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"on-air/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"gorm.io/datatypes"
+)
+
+type PenaltyTestSuite struct {
+	suite.Suite
+	flight models.Flight
+}
+
+func (suite *PenaltyTestSuite) SetupSuite() {
+	startedAt := time.Date(2023, 8, 10, 12, 0, 0, 0, time.UTC)
+	suite.flight = models.Flight{
+		Number:    "FL005",
+		StartedAt: startedAt,
+		Penalties: datatypes.JSON([]byte(`[
+			{"Start": "", "End": "48h", "Percent": 10},
+			{"Start": "48h", "End": "` + startedAt.Add(-3*time.Hour).Format(time.RFC3339) + `", "Percent": 30},
+			{"Start": "3h", "End": "", "Percent": 60}
+		]`)),
+	}
+}
+
+func (suite *PenaltyTestSuite) TestPenalty_CalculateRefund_Success() {
+	require := suite.Require()
+
+	cases := []struct {
+		at       time.Time
+		expected int
+	}{
+		{suite.flight.StartedAt.Add(-72 * time.Hour), 900},
+		{suite.flight.StartedAt.Add(-24 * time.Hour), 700},
+		{suite.flight.StartedAt.Add(-time.Hour), 400},
+	}
+
+	for _, c := range cases {
+		refund, err := CalculateRefund(&suite.flight, 1000, c.at)
+		require.NoError(err)
+		require.Equal(c.expected, refund)
+	}
+}
+
+func (suite *PenaltyTestSuite) TestPenalty_CalculateRefund_WithoutPenalties() {
+	require := suite.Require()
+
+	flight := models.Flight{StartedAt: suite.flight.StartedAt}
+	refund, err := CalculateRefund(&flight, 1000, flight.StartedAt.Add(-time.Hour))
+	require.NoError(err)
+	require.Equal(1000, refund)
+}
+
+func (suite *PenaltyTestSuite) TestPenalty_CalculateRefund_Departed() {
+	require := suite.Require()
+
+	_, err := CalculateRefund(&suite.flight, 1000, suite.flight.StartedAt.Add(time.Minute))
+	require.ErrorIs(err, ErrFlightDeparted)
+}
+
+func (suite *PenaltyTestSuite) TestPenalty_CalculateRefund_InvalidPenalties() {
+	require := suite.Require()
+
+	flight := models.Flight{
+		StartedAt: suite.flight.StartedAt,
+		Penalties: datatypes.JSON([]byte(`[{"Start": "yesterday", "End": "", "Percent": 10}]`)),
+	}
+	_, err := CalculateRefund(&flight, 1000, flight.StartedAt.Add(-time.Hour))
+	require.Error(err)
+}
+
+func TestPenalty(t *testing.T) {
+	suite.Run(t, new(PenaltyTestSuite))
+}
@@ -0,0 +1,235 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"on-air/models"
+	"on-air/server/services/gateway"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ReconcileAction string
+
+const (
+	ReconcileVerified ReconcileAction = "verified"
+	ReconcileRefunded ReconcileAction = "refunded"
+	ReconcileExpired  ReconcileAction = "expired"
+	ReconcileSkipped  ReconcileAction = "skipped"
+)
+
+type ReconcileResult struct {
+	PaymentID      uint            `json:"payment_id"`
+	TicketID       uint            `json:"ticket_id"`
+	Gateway        string          `json:"gateway"`
+	Amount         int             `json:"amount"`
+	PreviousStatus string          `json:"previous_status"`
+	Status         string          `json:"status"`
+	Action         ReconcileAction `json:"action"`
+	Error          string          `json:"error,omitempty"`
+}
+
+// GetUnsettledPayments returns the payments created before the given time that
+// are still waiting for the gateway callback.
+func GetUnsettledPayments(ctx context.Context, db *gorm.DB, before time.Time) ([]models.Payment, error) {
+	db = db.WithContext(ctx)
+
+	var payments []models.Payment
+
+	err := db.Preload("Ticket").
+		Where("status IN ? AND created_at < ?", []string{string(models.Requested), string(models.PaymentPaid)}, before).
+		Order("id").
+		Find(&payments).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return payments, nil
+}
+
+// UpdatePaymentStatus moves the payment to status and records who did it and
+// why, it returns ErrInvalidTransition when the payment can not move there.
+func UpdatePaymentStatus(ctx context.Context, db *gorm.DB, paymentID uint, status string, actor string, reason string) error {
+	db = db.WithContext(ctx)
+
+	var payment models.Payment
+
+	err := db.First(&payment, "id = ?", paymentID).Error
+	if err != nil {
+		return err
+	}
+
+	return transitionPayment(db, &payment, models.PaymentStatus(status), actor, reason, nil)
+}
+
+// ReconcilePayments reconciles the payments created more than olderThan ago
+// that are still waiting for the gateway callback, see ReconcilePayment.
+func ReconcilePayments(ctx context.Context, db *gorm.DB, gateways *gateway.Registry, olderThan time.Duration, expireAfter time.Duration, dryRun bool) ([]ReconcileResult, error) {
+	db = db.WithContext(ctx)
+
+	now := time.Now()
+	payments, err := GetUnsettledPayments(ctx, db, now.Add(-olderThan))
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ReconcileResult, 0, len(payments))
+	for _, payment := range payments {
+		results = append(results, ReconcilePayment(ctx, db, gateways, payment, now.Add(-expireAfter), dryRun))
+	}
+
+	return results, nil
+}
+
+// ReconcilePayment asks the gateway about an unsettled payment and settles it:
+// a successful transaction is verified, or refunded when it does not match
+// the payment or the ticket can not be paid anymore, and a payment the gateway
+// does not know about is expired once it was created before expireBefore. A
+// requested payment is claimed like a callback does before it is verified or
+// refunded, so a callback handled meanwhile can not settle it a second time. A
+// payment that never got a gateway is expired the same way.
+// With dryRun the planned action is reported without touching anything.
+func ReconcilePayment(ctx context.Context, db *gorm.DB, gateways *gateway.Registry, payment models.Payment, expireBefore time.Time, dryRun bool) ReconcileResult {
+	db = db.WithContext(ctx)
+
+	result := ReconcileResult{
+		PaymentID:      payment.ID,
+		TicketID:       payment.TicketID,
+		Gateway:        payment.Gateway,
+		Amount:         payment.Amount,
+		PreviousStatus: payment.Status,
+		Status:         payment.Status,
+		Action:         ReconcileSkipped,
+	}
+
+	if payment.Gateway == "" {
+		if payment.CreatedAt.After(expireBefore) {
+			return result
+		}
+
+		return settle(db, &payment, &result, ReconcileExpired, models.PaymentExpired, dryRun, nil)
+	}
+
+	paymentGateway, err := gateways.Get(payment.Gateway)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	invoice := paymentInvoice(&payment)
+
+	checkResponse, err := paymentGateway.Check(ctx, gateway.CheckRequest{
+		Invoice: invoice,
+		Amount:  int64(payment.Amount),
+	})
+
+	var declined gateway.DeclinedError
+	if errors.As(err, &declined) {
+		if payment.CreatedAt.After(expireBefore) {
+			result.Error = err.Error()
+			return result
+		}
+
+		return settle(db, &payment, &result, ReconcileExpired, models.PaymentExpired, dryRun, nil)
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	refund := func() error {
+		return RefundPayment(ctx, gateways, &payment, 0)
+	}
+
+	// Only a reserved ticket is still waiting for this payment. A second
+	// payment of a ticket already paid is refunded, not verified again.
+	payable := payment.Ticket.Status == string(models.Reserved)
+	mismatch := checkResponse.Amount != int64(payment.Amount) || !payable
+
+	if dryRun {
+		result.Action = ReconcileVerified
+		if mismatch {
+			result.Action = ReconcileRefunded
+		}
+
+		return result
+	}
+
+	err = claimUnsettledPayment(db, &payment)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Status = payment.Status
+
+	if mismatch {
+		return settle(db, &payment, &result, ReconcileRefunded, models.PaymentRefunded, dryRun, refund)
+	}
+
+	_, err = paymentGateway.Verify(ctx, gateway.VerifyRequest{
+		Invoice: invoice,
+		Amount:  int64(payment.Amount),
+	})
+	if err != nil {
+		result.Error = err.Error()
+		return settle(db, &payment, &result, ReconcileRefunded, models.PaymentRefunded, dryRun, refund)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := transitionPayment(tx, &payment, models.Verified, models.ActorReconcile, "transaction verified", nil)
+		if err != nil {
+			return err
+		}
+
+		return transitionTicket(tx, &payment.Ticket, models.TicketPaid, models.ActorReconcile, "payment verified")
+	})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Action = ReconcileVerified
+	result.Status = string(models.Verified)
+
+	return result
+}
+
+// claimUnsettledPayment moves a requested payment to Paid the way a callback
+// claims it. A payment already Paid was claimed by a callback that did not
+// settle it and is left as it is.
+func claimUnsettledPayment(db *gorm.DB, payment *models.Payment) error {
+	if payment.Status != string(models.Requested) {
+		return nil
+	}
+
+	return transitionPayment(db, payment, models.PaymentPaid, models.ActorReconcile, "claimed by reconcile", nil)
+}
+
+func settle(db *gorm.DB, payment *models.Payment, result *ReconcileResult, action ReconcileAction, status models.PaymentStatus, dryRun bool, gatewayCall func() error) ReconcileResult {
+	if dryRun {
+		result.Action = action
+		return *result
+	}
+
+	if gatewayCall != nil {
+		err := gatewayCall()
+		if err != nil {
+			result.Error = err.Error()
+			return *result
+		}
+	}
+
+	err := transitionPayment(db, payment, status, models.ActorReconcile, string(action), nil)
+	if err != nil {
+		result.Error = err.Error()
+		return *result
+	}
+
+	result.Action = action
+	result.Status = string(status)
+
+	return *result
+}
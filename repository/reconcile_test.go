@@ -0,0 +1,259 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"on-air/models"
+	"on-air/server/services/gateway"
+	"on-air/server/services/pasargad"
+	"reflect"
+	"testing"
+	"time"
+
+	"bou.ke/monkey"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type ReconcileTestSuite struct {
+	suite.Suite
+	sqlMock  sqlmock.Sqlmock
+	dbMock   *gorm.DB
+	gateways *gateway.Registry
+	payment  models.Payment
+	api      reflect.Type
+}
+
+func (suite *ReconcileTestSuite) SetupSuite() {
+	mockDB, sqlMock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.dbMock, err = gorm.Open(postgres.New(postgres.Config{
+		Conn: mockDB,
+	}))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.sqlMock = sqlMock
+	suite.gateways = gateway.NewRegistry(&gateway.PasargadGateway{
+		API: pasargad.PasargadAPI(0, 0, "", ""),
+	})
+	suite.api = reflect.TypeOf(&pasargad.PasargadPaymentAPI{})
+	suite.payment = models.Payment{
+		Amount:   2000,
+		Status:   string(models.Requested),
+		Gateway:  gateway.Pasargad,
+		TicketID: 9,
+		Ticket:   models.Ticket{Model: gorm.Model{ID: 9}, Status: string(models.Reserved)},
+	}
+	suite.payment.ID = 4
+	suite.payment.CreatedAt = time.Now().Add(-2 * time.Hour)
+}
+
+func (suite *ReconcileTestSuite) patchCheck(response *pasargad.CheckTransactionResponse, err error) *monkey.PatchGuard {
+	return monkey.PatchInstanceMethod(suite.api, "CheckTransaction",
+		func(_ *pasargad.PasargadPaymentAPI, _ context.Context, _ pasargad.CreateCheckTransactionRequest) (*pasargad.CheckTransactionResponse, error) {
+			return response, err
+		})
+}
+
+func (suite *ReconcileTestSuite) expectStatus(from models.PaymentStatus, status models.PaymentStatus) {
+	suite.sqlMock.ExpectExec(`UPDATE "payments" SET "status"=(.+) WHERE \(id = (.+) AND status = (.+)\)`).
+		WithArgs(string(status), sqlmock.AnyArg(), 4, string(from)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(suite.sqlMock, models.PaymentEntity, 4, string(from), string(status), models.ActorReconcile)
+}
+
+func (suite *ReconcileTestSuite) expectClaim() {
+	suite.sqlMock.ExpectBegin()
+	suite.expectStatus(models.Requested, models.PaymentPaid)
+	suite.sqlMock.ExpectCommit()
+}
+
+func (suite *ReconcileTestSuite) TestReconcile_Verified() {
+	require := suite.Require()
+
+	checkPatch := suite.patchCheck(&pasargad.CheckTransactionResponse{IsSuccess: true, Amount: 2000}, nil)
+	defer checkPatch.Unpatch()
+
+	verifyPatch := monkey.PatchInstanceMethod(suite.api, "VerifyPayment",
+		func(_ *pasargad.PasargadPaymentAPI, _ context.Context, _ pasargad.CreateVerifyPaymentRequest) (*pasargad.VerifyPaymentResponse, error) {
+			return &pasargad.VerifyPaymentResponse{IsSuccess: true}, nil
+		})
+	defer verifyPatch.Unpatch()
+
+	suite.expectClaim()
+	suite.sqlMock.ExpectBegin()
+	suite.expectStatus(models.PaymentPaid, models.Verified)
+	suite.sqlMock.ExpectExec(`UPDATE "tickets" SET "status"=(.+) WHERE \(id = (.+) AND status = (.+)\)`).
+		WithArgs(string(models.TicketPaid), sqlmock.AnyArg(), 9, string(models.Reserved)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(suite.sqlMock, models.TicketEntity, 9, string(models.Reserved), string(models.TicketPaid), models.ActorReconcile)
+	suite.sqlMock.ExpectCommit()
+
+	result := ReconcilePayment(context.Background(), suite.dbMock, suite.gateways, suite.payment, time.Now().Add(-time.Hour), false)
+	require.Equal(ReconcileVerified, result.Action)
+	require.Equal(string(models.Verified), result.Status)
+	require.Empty(result.Error)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *ReconcileTestSuite) TestReconcile_Refunded_AmountMismatch() {
+	require := suite.Require()
+
+	checkPatch := suite.patchCheck(&pasargad.CheckTransactionResponse{IsSuccess: true, Amount: 1500}, nil)
+	defer checkPatch.Unpatch()
+
+	refunded := false
+	refundPatch := monkey.PatchInstanceMethod(suite.api, "Refund",
+		func(_ *pasargad.PasargadPaymentAPI, _ context.Context, _ pasargad.CreateRefundRequest) (*pasargad.RefundResponse, error) {
+			refunded = true
+			return &pasargad.RefundResponse{IsSuccess: true}, nil
+		})
+	defer refundPatch.Unpatch()
+
+	suite.expectClaim()
+	suite.sqlMock.ExpectBegin()
+	suite.expectStatus(models.PaymentPaid, models.PaymentRefunded)
+	suite.sqlMock.ExpectCommit()
+
+	result := ReconcilePayment(context.Background(), suite.dbMock, suite.gateways, suite.payment, time.Now().Add(-time.Hour), false)
+	require.Equal(ReconcileRefunded, result.Action)
+	require.True(refunded)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *ReconcileTestSuite) TestReconcile_Refunded_TicketPaid() {
+	require := suite.Require()
+
+	checkPatch := suite.patchCheck(&pasargad.CheckTransactionResponse{IsSuccess: true, Amount: 2000}, nil)
+	defer checkPatch.Unpatch()
+
+	verified := false
+	verifyPatch := monkey.PatchInstanceMethod(suite.api, "VerifyPayment",
+		func(_ *pasargad.PasargadPaymentAPI, _ context.Context, _ pasargad.CreateVerifyPaymentRequest) (*pasargad.VerifyPaymentResponse, error) {
+			verified = true
+			return &pasargad.VerifyPaymentResponse{IsSuccess: true}, nil
+		})
+	defer verifyPatch.Unpatch()
+
+	refunded := false
+	refundPatch := monkey.PatchInstanceMethod(suite.api, "Refund",
+		func(_ *pasargad.PasargadPaymentAPI, _ context.Context, _ pasargad.CreateRefundRequest) (*pasargad.RefundResponse, error) {
+			refunded = true
+			return &pasargad.RefundResponse{IsSuccess: true}, nil
+		})
+	defer refundPatch.Unpatch()
+
+	// The ticket was paid by another payment meanwhile.
+	payment := suite.payment
+	payment.Ticket.Status = string(models.TicketPaid)
+
+	suite.expectClaim()
+	suite.sqlMock.ExpectBegin()
+	suite.expectStatus(models.PaymentPaid, models.PaymentRefunded)
+	suite.sqlMock.ExpectCommit()
+
+	result := ReconcilePayment(context.Background(), suite.dbMock, suite.gateways, payment, time.Now().Add(-time.Hour), false)
+	require.Equal(ReconcileRefunded, result.Action)
+	require.False(verified)
+	require.True(refunded)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *ReconcileTestSuite) TestReconcile_Expired() {
+	require := suite.Require()
+
+	checkPatch := suite.patchCheck(nil, pasargad.ErrorResponse{Message: "transaction not found"})
+	defer checkPatch.Unpatch()
+
+	suite.sqlMock.ExpectBegin()
+	suite.expectStatus(models.Requested, models.PaymentExpired)
+	suite.sqlMock.ExpectCommit()
+
+	result := ReconcilePayment(context.Background(), suite.dbMock, suite.gateways, suite.payment, time.Now().Add(-time.Hour), false)
+	require.Equal(ReconcileExpired, result.Action)
+	require.Equal(string(models.PaymentExpired), result.Status)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *ReconcileTestSuite) TestReconcile_Expired_NoGateway() {
+	require := suite.Require()
+
+	// The redirect to the gateway failed, so there is nothing to ask it about.
+	payment := suite.payment
+	payment.Gateway = ""
+
+	suite.sqlMock.ExpectBegin()
+	suite.expectStatus(models.Requested, models.PaymentExpired)
+	suite.sqlMock.ExpectCommit()
+
+	result := ReconcilePayment(context.Background(), suite.dbMock, suite.gateways, payment, time.Now().Add(-time.Hour), false)
+	require.Equal(ReconcileExpired, result.Action)
+	require.Equal(string(models.PaymentExpired), result.Status)
+	require.Empty(result.Error)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *ReconcileTestSuite) TestReconcile_Skipped_Claimed() {
+	require := suite.Require()
+
+	checkPatch := suite.patchCheck(&pasargad.CheckTransactionResponse{IsSuccess: true, Amount: 2000}, nil)
+	defer checkPatch.Unpatch()
+
+	verified := false
+	verifyPatch := monkey.PatchInstanceMethod(suite.api, "VerifyPayment",
+		func(_ *pasargad.PasargadPaymentAPI, _ context.Context, _ pasargad.CreateVerifyPaymentRequest) (*pasargad.VerifyPaymentResponse, error) {
+			verified = true
+			return &pasargad.VerifyPaymentResponse{IsSuccess: true}, nil
+		})
+	defer verifyPatch.Unpatch()
+
+	// A callback claimed the payment after it was read.
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "payments" SET "status"=(.+) WHERE \(id = (.+) AND status = (.+)\)`).
+		WithArgs(string(models.PaymentPaid), sqlmock.AnyArg(), 4, string(models.Requested)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.sqlMock.ExpectRollback()
+
+	result := ReconcilePayment(context.Background(), suite.dbMock, suite.gateways, suite.payment, time.Now().Add(-time.Hour), false)
+	require.Equal(ReconcileSkipped, result.Action)
+	require.Equal(string(models.Requested), result.Status)
+	require.Contains(result.Error, ErrStatusChanged.Error())
+	require.False(verified)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *ReconcileTestSuite) TestReconcile_Skipped_TooRecent() {
+	require := suite.Require()
+
+	checkPatch := suite.patchCheck(nil, pasargad.ErrorResponse{Message: "transaction not found"})
+	defer checkPatch.Unpatch()
+
+	result := ReconcilePayment(context.Background(), suite.dbMock, suite.gateways, suite.payment, time.Now().Add(-3*time.Hour), false)
+	require.Equal(ReconcileSkipped, result.Action)
+	require.Equal(string(models.Requested), result.Status)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *ReconcileTestSuite) TestReconcile_DryRun() {
+	require := suite.Require()
+
+	checkPatch := suite.patchCheck(&pasargad.CheckTransactionResponse{IsSuccess: true, Amount: 2000}, nil)
+	defer checkPatch.Unpatch()
+
+	result := ReconcilePayment(context.Background(), suite.dbMock, suite.gateways, suite.payment, time.Now().Add(-time.Hour), true)
+	require.Equal(ReconcileVerified, result.Action)
+	require.Equal(string(models.Requested), result.Status)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func TestReconcile(t *testing.T) {
+	suite.Run(t, new(ReconcileTestSuite))
+}
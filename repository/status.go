@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"on-air/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidTransition = errors.New("invalid status transition")
+	ErrStatusChanged     = errors.New("status changed concurrently")
+)
+
+// transitionTicket moves the ticket to status when the state machine allows it
+// and records the transition. The update is guarded by the current status, so
+// ErrStatusChanged is returned when someone else moved the ticket first.
+func transitionTicket(db *gorm.DB, ticket *models.Ticket, status models.TicketStatus, actor string, reason string) error {
+	from := models.TicketStatus(ticket.Status)
+	if !from.CanTransitionTo(status) {
+		return fmt.Errorf("%w: ticket %d from %s to %s", ErrInvalidTransition, ticket.ID, from, status)
+	}
+
+	err := inTransaction(db, func(tx *gorm.DB) error {
+		result := tx.Model(&models.Ticket{}).
+			Where("id = ? AND status = ?", ticket.ID, string(from)).
+			Update("status", string(status))
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: ticket %d", ErrStatusChanged, ticket.ID)
+		}
+
+		return recordStatus(tx, models.TicketEntity, ticket.ID, ticket.ID, string(from), string(status), actor, reason)
+	})
+	if err != nil {
+		return err
+	}
+
+	ticket.Status = string(status)
+
+	return nil
+}
+
+// transitionPayment is transitionTicket for payments, columns are updated
+// along with the status.
+func transitionPayment(db *gorm.DB, payment *models.Payment, status models.PaymentStatus, actor string, reason string, columns map[string]interface{}) error {
+	from := models.PaymentStatus(payment.Status)
+	if !from.CanTransitionTo(status) {
+		return fmt.Errorf("%w: payment %d from %s to %s", ErrInvalidTransition, payment.ID, from, status)
+	}
+
+	updates := map[string]interface{}{"status": string(status)}
+	for column, value := range columns {
+		updates[column] = value
+	}
+
+	err := inTransaction(db, func(tx *gorm.DB) error {
+		result := tx.Model(&models.Payment{}).
+			Where("id = ? AND status = ?", payment.ID, string(from)).
+			Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: payment %d", ErrStatusChanged, payment.ID)
+		}
+
+		return recordStatus(tx, models.PaymentEntity, payment.ID, payment.TicketID, string(from), string(status), actor, reason)
+	})
+	if err != nil {
+		return err
+	}
+
+	payment.Status = string(status)
+
+	return nil
+}
+
+func recordStatus(db *gorm.DB, entity models.StatusEntity, entityID uint, ticketID uint, from string, to string, actor string, reason string) error {
+	return db.Create(&models.StatusHistory{
+		Entity:     string(entity),
+		EntityID:   entityID,
+		TicketID:   ticketID,
+		FromStatus: from,
+		ToStatus:   to,
+		Actor:      actor,
+		Reason:     reason,
+	}).Error
+}
+
+// inTransaction runs fn in a transaction, or in the one db already is.
+func inTransaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		return fn(db)
+	}
+
+	return db.Transaction(fn)
+}
+
+// GetTicketTimeline returns the transitions of the ticket and its payments in
+// the order they happened.
+func GetTicketTimeline(ctx context.Context, db *gorm.DB, ticketID uint) ([]models.StatusHistory, error) {
+	db = db.WithContext(ctx)
+
+	var history []models.StatusHistory
+
+	err := db.Where("ticket_id = ?", ticketID).Order("created_at, id").Find(&history).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"on-air/models"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func expectHistory(sqlMock sqlmock.Sqlmock, entity models.StatusEntity, entityID uint, from string, to string, actor string) {
+	sqlMock.ExpectQuery(`INSERT INTO "status_history"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, string(entity), entityID, sqlmock.AnyArg(), from, to, actor, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
+
+type StatusTestSuite struct {
+	suite.Suite
+	sqlMock sqlmock.Sqlmock
+	dbMock  *gorm.DB
+}
+
+func (suite *StatusTestSuite) SetupSuite() {
+	mockDB, sqlMock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.dbMock, err = gorm.Open(postgres.New(postgres.Config{
+		Conn: mockDB,
+	}))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.sqlMock = sqlMock
+}
+
+func (suite *StatusTestSuite) TestTransitions() {
+	require := suite.Require()
+
+	require.True(models.TicketPending.CanTransitionTo(models.Reserved))
+	require.True(models.Reserved.CanTransitionTo(models.TicketPaid))
+	require.False(models.TicketPending.CanTransitionTo(models.TicketPaid))
+	require.False(models.TicketExpired.CanTransitionTo(models.TicketPaid))
+	require.True(models.TicketCancelled.IsFinal())
+
+	require.True(models.Requested.CanTransitionTo(models.PaymentPaid))
+	require.False(models.PaymentExpired.CanTransitionTo(models.Verified))
+	require.False(models.Verified.CanTransitionTo(models.PaymentExpired))
+	require.True(models.PaymentRefunded.IsFinal())
+}
+
+func (suite *StatusTestSuite) TestChangeTicketStatus_Success() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "tickets"`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(9, string(models.Reserved)))
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "tickets" SET "status"=(.+) WHERE \(id = (.+) AND status = (.+)\)`).
+		WithArgs(string(models.TicketExpired), sqlmock.AnyArg(), 9, string(models.Reserved)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(suite.sqlMock, models.TicketEntity, 9, string(models.Reserved), string(models.TicketExpired), models.ActorWorker)
+	suite.sqlMock.ExpectCommit()
+
+	err := ChangeTicketStatus(context.Background(), suite.dbMock, 9, string(models.TicketExpired), models.ActorWorker, "reservation hold expired")
+	require.NoError(err)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *StatusTestSuite) TestChangeTicketStatus_InvalidTransition() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "tickets"`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(9, string(models.TicketExpired)))
+
+	err := ChangeTicketStatus(context.Background(), suite.dbMock, 9, string(models.TicketPaid), models.ActorReconcile, "")
+	require.ErrorIs(err, ErrInvalidTransition)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *StatusTestSuite) TestChangeTicketStatus_Changed() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "tickets"`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(9, string(models.Reserved)))
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "tickets"`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.sqlMock.ExpectRollback()
+
+	err := ChangeTicketStatus(context.Background(), suite.dbMock, 9, string(models.TicketExpired), models.ActorWorker, "")
+	require.ErrorIs(err, ErrStatusChanged)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *StatusTestSuite) TestChangePaymentStatus_SkipsFinal() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "payments" WHERE ticket_id = (.+)`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "ticket_id"}).
+			AddRow(3, string(models.PaymentCancelled), 9).
+			AddRow(4, string(models.Requested), 9))
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "payments" SET "status"=(.+) WHERE \(id = (.+) AND status = (.+)\)`).
+		WithArgs(string(models.PaymentExpired), sqlmock.AnyArg(), 4, string(models.Requested)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(suite.sqlMock, models.PaymentEntity, 4, string(models.Requested), string(models.PaymentExpired), models.ActorWorker)
+	suite.sqlMock.ExpectCommit()
+
+	err := ChangePaymentStatus(context.Background(), suite.dbMock, 9, string(models.PaymentExpired), models.ActorWorker, "reservation hold expired")
+	require.NoError(err)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *StatusTestSuite) TestGetTicketTimeline() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "status_history" WHERE ticket_id = (.+) ORDER BY created_at, id`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "entity", "entity_id", "ticket_id", "from_status", "to_status"}).
+			AddRow(1, "ticket", 9, 9, "", string(models.TicketPending)).
+			AddRow(2, "ticket", 9, 9, string(models.TicketPending), string(models.Reserved)))
+
+	history, err := GetTicketTimeline(context.Background(), suite.dbMock, 9)
+	require.NoError(err)
+	require.Len(history, 2)
+	require.Equal(string(models.Reserved), history[1].ToStatus)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func TestStatus(t *testing.T) {
+	suite.Run(t, new(StatusTestSuite))
+}
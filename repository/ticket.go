@@ -1,18 +1,28 @@
 package repository
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"on-air/models"
+	"strconv"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-func ReserveTicket(db *gorm.DB, userID int, flightID int, unitPrice int, passengerIDs []int) (*models.Ticket, error) {
+// ReserveTicket creates a pending ticket held for hold together with the outbox
+// message that reserves its seats on the provider.
+func ReserveTicket(ctx context.Context, db *gorm.DB, userID int, flightID int, flightNumber string, unitPrice int, passengerIDs []int, hold time.Duration, dispatchDelay time.Duration) (*models.Ticket, *models.OutboxMessage, error) {
+	db = db.WithContext(ctx)
+
 	var passengers []models.Passenger
 
 	err := db.Where("id IN ?", passengerIDs).Find(&passengers).Error
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	ticket := models.Ticket{
@@ -21,18 +31,37 @@ func ReserveTicket(db *gorm.DB, userID int, flightID int, unitPrice int, passeng
 		FlightID:   uint(flightID),
 		Count:      len(passengerIDs),
 		Passengers: passengers,
-		Status:     string(models.Reserved),
+		Status:     string(models.TicketPending),
+		ExpiresAt:  time.Now().Add(hold),
 	}
 
-	err = db.Create(&ticket).Error
+	var message *models.OutboxMessage
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&ticket).Error
+		if err != nil {
+			return err
+		}
+
+		err = recordStatus(tx, models.TicketEntity, ticket.ID, ticket.ID, "", ticket.Status, models.UserActor(userID), "")
+		if err != nil {
+			return err
+		}
+
+		message, err = EnqueueOutbox(ctx, tx, models.OutboxReserve, ticket.ID, flightNumber, ticket.Count, dispatchDelay)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return &ticket, nil
+	return &ticket, message, nil
 }
 
-func ChangeTicketStatus(db *gorm.DB, id uint, status string) error {
+// ChangeTicketStatus moves the ticket to status and records who did it and
+// why, it returns ErrInvalidTransition when the ticket can not move there.
+func ChangeTicketStatus(ctx context.Context, db *gorm.DB, id uint, status string, actor string, reason string) error {
+	db = db.WithContext(ctx)
+
 	var ticket models.Ticket
 
 	err := db.First(&ticket, "id = ?", id).Error
@@ -40,21 +69,350 @@ func ChangeTicketStatus(db *gorm.DB, id uint, status string) error {
 		return err
 	}
 
-	ticket.Status = status
+	return transitionTicket(db, &ticket, models.TicketStatus(status), actor, reason)
+}
+
+var ErrTicketNotCancellable = errors.New("ticket can not be cancelled")
+
+// CancelTicket cancels a reserved or paid ticket and its payments. The ticket
+// and its verified payment are locked first, so a ticket cancelled at the same
+// time by its user and the staff is cancelled and refunded once, and never
+// together with another refund of the payment. What the payment is owed after
+// the penalties of the flight is recorded on it and its refund is enqueued
+// along with the release of the seats, so both are made once the cancellation
+// is committed. It returns the refunded amount and the enqueued messages.
+func CancelTicket(ctx context.Context, db *gorm.DB, ticket *models.Ticket, dispatchDelay time.Duration, actor string, reason string) (int, []*models.OutboxMessage, error) {
+	db = db.WithContext(ctx)
+
+	refundAmount := 0
+	var messages []*models.OutboxMessage
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := lockCancellableTicket(tx, ticket)
+		if err != nil {
+			return err
+		}
+
+		var payment *models.Payment
+		if ticket.Status == string(models.TicketPaid) {
+			payment, err = lockVerifiedPayment(tx, ticket.ID)
+			if err != nil {
+				return err
+			}
+
+			refundAmount, err = CalculateRefund(&ticket.Flight, payment.Amount-payment.RefundedAmount, time.Now())
+			if err != nil {
+				return err
+			}
+		}
+
+		err = transitionTicket(tx, ticket, models.TicketCancelled, actor, reason)
+		if err != nil {
+			return err
+		}
+
+		err = ChangePaymentStatus(ctx, tx, ticket.ID, string(models.PaymentCancelled), actor, "ticket cancelled")
+		if err != nil {
+			return err
+		}
+
+		if refundAmount > 0 {
+			message, err := refundPaymentLater(ctx, tx, payment, refundAmount, dispatchDelay)
+			if err != nil {
+				return err
+			}
+
+			messages = append(messages, message)
+		}
+
+		message, err := EnqueueOutbox(ctx, tx, models.OutboxRefund, ticket.ID, ticket.Flight.Number, ticket.Count, dispatchDelay)
+		if err != nil {
+			return err
+		}
+
+		messages = append(messages, message)
+
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return refundAmount, messages, nil
+}
+
+// lockCancellableTicket locks the ticket and refreshes its status and count,
+// it returns ErrTicketNotCancellable when the ticket is not reserved or paid
+// anymore.
+func lockCancellableTicket(tx *gorm.DB, ticket *models.Ticket) error {
+	var locked models.Ticket
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, "id = ?", ticket.ID).Error
+	if err != nil {
+		return err
+	}
+
+	ticket.Status = locked.Status
+	ticket.Count = locked.Count
+
+	if ticket.Status != string(models.Reserved) && ticket.Status != string(models.TicketPaid) {
+		return fmt.Errorf("%w: ticket %d is %s", ErrTicketNotCancellable, ticket.ID, ticket.Status)
+	}
+
+	return nil
+}
+
+// lockVerifiedPayment locks the verified payment of the ticket. Every refund of
+// a payment locks it before working out what is left of it.
+func lockVerifiedPayment(tx *gorm.DB, ticketID uint) (*models.Payment, error) {
+	var payment models.Payment
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("ticket_id = ? AND status = ?", ticketID, string(models.Verified)).
+		First(&payment).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &payment, nil
+}
+
+// refundPaymentLater records refundAmount on the locked payment and enqueues
+// its refund through the gateway.
+func refundPaymentLater(ctx context.Context, tx *gorm.DB, payment *models.Payment, refundAmount int, dispatchDelay time.Duration) (*models.OutboxMessage, error) {
+	err := addRefundedAmount(tx, payment, refundAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	payment.RefundedAmount += refundAmount
+
+	return EnqueuePaymentRefund(ctx, tx, payment, refundAmount, dispatchDelay)
+}
+
+// ExpireTicket expires a reserved ticket before its hold runs out, the same
+// way the worker does once it has, and enqueues the release of its seats.
+func ExpireTicket(ctx context.Context, db *gorm.DB, ticket *models.Ticket, dispatchDelay time.Duration, actor string, reason string) (*models.OutboxMessage, error) {
+	db = db.WithContext(ctx)
+
+	var message *models.OutboxMessage
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := ChangeTicketStatus(ctx, tx, ticket.ID, string(models.TicketExpired), actor, reason)
+		if err != nil {
+			return err
+		}
+
+		err = ChangePaymentStatus(ctx, tx, ticket.ID, string(models.PaymentExpired), actor, reason)
+		if err != nil {
+			return err
+		}
+
+		message, err = EnqueueOutbox(ctx, tx, models.OutboxRefund, ticket.ID, ticket.Flight.Number, ticket.Count, dispatchDelay)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ticket.Status = string(models.TicketExpired)
+
+	return message, nil
+}
+
+var (
+	ErrPassengerNotOnTicket = errors.New("passenger is not on the ticket")
+	ErrTicketAllPassengers  = errors.New("every passenger of the ticket is cancelled")
+)
+
+// RemoveTicketPassengers drops the passengers from a reserved or paid ticket
+// and enqueues the release of their seats. The ticket is locked and the
+// passengers checked against the ones still on it, so concurrent calls never
+// drop a passenger or refund their seat twice. A paid ticket gets back the
+// seats after the penalties of the flight, never more than what is left of its
+// payment. The open payments of a reserved ticket are cancelled since their
+// amount is for the old count, the user pays the new amount with a new
+// payment. It returns the refunded amount and the enqueued messages.
+func RemoveTicketPassengers(ctx context.Context, db *gorm.DB, ticket *models.Ticket, passengers []models.Passenger, dispatchDelay time.Duration, actor string) (int, []*models.OutboxMessage, error) {
+	db = db.WithContext(ctx)
+
+	refundAmount := 0
+	var messages []*models.OutboxMessage
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := lockCancellableTicket(tx, ticket)
+		if err != nil {
+			return err
+		}
+
+		err = checkTicketPassengers(tx, ticket, passengers)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&models.Ticket{Model: gorm.Model{ID: ticket.ID}}).Association("Passengers").Delete(passengers)
+		if err != nil {
+			return err
+		}
+
+		count := ticket.Count - len(passengers)
+		result := tx.Model(&models.Ticket{}).
+			Where("id = ? AND count = ?", ticket.ID, ticket.Count).
+			Update("count", count)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: ticket %d", ErrStatusChanged, ticket.ID)
+		}
+
+		ids := make([]string, 0, len(passengers))
+		for _, passenger := range passengers {
+			ids = append(ids, strconv.Itoa(int(passenger.ID)))
+		}
+
+		reason := "passengers " + strings.Join(ids, ", ") + " cancelled"
+		err = recordStatus(tx, models.TicketEntity, ticket.ID, ticket.ID, ticket.Status, ticket.Status, actor, reason)
+		if err != nil {
+			return err
+		}
+
+		if ticket.Status == string(models.Reserved) {
+			err = ChangePaymentStatus(ctx, tx, ticket.ID, string(models.PaymentCancelled), actor, reason)
+			if err != nil {
+				return err
+			}
+		} else {
+			payment, err := lockVerifiedPayment(tx, ticket.ID)
+			if err != nil {
+				return err
+			}
+
+			amount := ticket.UnitPrice * len(passengers)
+			if remaining := payment.Amount - payment.RefundedAmount; amount > remaining {
+				amount = remaining
+			}
+
+			refundAmount, err = CalculateRefund(&ticket.Flight, amount, time.Now())
+			if err != nil {
+				return err
+			}
+
+			if refundAmount > 0 {
+				message, err := refundPaymentLater(ctx, tx, payment, refundAmount, dispatchDelay)
+				if err != nil {
+					return err
+				}
+
+				messages = append(messages, message)
+			}
+		}
+
+		message, err := EnqueueOutbox(ctx, tx, models.OutboxRefund, ticket.ID, ticket.Flight.Number, len(passengers), dispatchDelay)
+		if err != nil {
+			return err
+		}
+
+		messages = append(messages, message)
+		ticket.Count = count
+
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return refundAmount, messages, nil
+}
+
+// checkTicketPassengers checks the passengers are still on the locked ticket
+// and are not all of them, cancelling all of them cancels the ticket.
+func checkTicketPassengers(tx *gorm.DB, ticket *models.Ticket, passengers []models.Passenger) error {
+	var ids []uint
 
-	err = db.Save(ticket).Error
+	err := tx.Table("ticket_passengers").Where("ticket_id = ?", ticket.ID).Pluck("passenger_id", &ids).Error
 	if err != nil {
 		return err
 	}
 
+	onTicket := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		onTicket[id] = true
+	}
+
+	for _, passenger := range passengers {
+		if !onTicket[passenger.ID] {
+			return fmt.Errorf("%w: passenger %d of ticket %d", ErrPassengerNotOnTicket, passenger.ID, ticket.ID)
+		}
+
+		delete(onTicket, passenger.ID)
+	}
+
+	if len(onTicket) == 0 {
+		return fmt.Errorf("%w: ticket %d", ErrTicketAllPassengers, ticket.ID)
+	}
+
 	return nil
 }
 
-func GetExpiredTickets(db *gorm.DB) ([]models.Ticket, error) {
+func addRefundedAmount(db *gorm.DB, payment *models.Payment, refundAmount int) error {
+	if payment == nil || refundAmount == 0 {
+		return nil
+	}
+
+	return db.Model(&models.Payment{}).
+		Where("id = ?", payment.ID).
+		Update("refunded_amount", gorm.Expr("refunded_amount + ?", refundAmount)).Error
+}
+
+var ErrTicketNotExtendable = errors.New("ticket can not be extended")
+
+// ExtendTicket pushes the expiry of a reserved ticket by extension. A ticket
+// can be extended once and only while it is still held.
+func ExtendTicket(ctx context.Context, db *gorm.DB, ticket *models.Ticket, extension time.Duration) error {
+	db = db.WithContext(ctx)
+
+	expiresAt := ticket.ExpiresAt.Add(extension)
+
+	result := db.Model(&models.Ticket{}).
+		Where("id = ? AND status = ? AND extended = ? AND expires_at > ?", ticket.ID, string(models.Reserved), false, time.Now()).
+		Updates(map[string]interface{}{
+			"expires_at": expiresAt,
+			"extended":   true,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrTicketNotExtendable
+	}
+
+	ticket.ExpiresAt = expiresAt
+	ticket.Extended = true
+
+	return nil
+}
+
+// GetExpiredTickets locks up to limit reserved tickets whose hold expired,
+// leaving out the skip tickets. Locked tickets are skipped, so workers running
+// concurrently in their own transactions never get the same ticket.
+func GetExpiredTickets(ctx context.Context, db *gorm.DB, limit int, skip ...uint) ([]models.Ticket, error) {
+	db = db.WithContext(ctx)
+
 	var tickets []models.Ticket
 
-	err := db.Model(&tickets).
-		Where("status = ? AND created_at < ?", string(models.Reserved), time.Now().Add(-15*time.Minute)).Find(&tickets).Error
+	query := db.Model(&tickets).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ? AND expires_at < ?", string(models.Reserved), time.Now())
+	if len(skip) > 0 {
+		query = query.Where("id NOT IN ?", skip)
+	}
+
+	err := query.Order("expires_at").
+		Limit(limit).
+		Find(&tickets).Error
 	if err != nil {
 		return tickets, err
 	}
@@ -62,7 +420,9 @@ func GetExpiredTickets(db *gorm.DB) ([]models.Ticket, error) {
 	return tickets, nil
 }
 
-func GetTicket(db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+func GetTicket(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+	db = db.WithContext(ctx)
+
 	var ticket models.Ticket
 	err := db.Model(&models.Ticket{}).
 		Where("user_id = ? and id = ?", userID, ticketID).
@@ -78,7 +438,30 @@ func GetTicket(db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
 	return ticket, nil
 }
 
-func GetUserTickets(db *gorm.DB, userID uint) ([]models.Ticket, error) {
+// GetTicketByID is GetTicket for staff, it returns the ticket whoever it
+// belongs to.
+func GetTicketByID(ctx context.Context, db *gorm.DB, ticketID int) (models.Ticket, error) {
+	db = db.WithContext(ctx)
+
+	var ticket models.Ticket
+	err := db.Model(&models.Ticket{}).
+		Where("id = ?", ticketID).
+		Preload("User").
+		Preload("Passengers").
+		Preload("Flight").
+		Preload("Flight.FromCity.Country").
+		Preload("Flight.ToCity.Country").
+		Find(&ticket).Error
+	if err != nil {
+		return models.Ticket{}, err
+	}
+
+	return ticket, nil
+}
+
+func GetUserTickets(ctx context.Context, db *gorm.DB, userID uint) ([]models.Ticket, error) {
+	db = db.WithContext(ctx)
+
 	var tickets []models.Ticket
 	err := db.Model(&models.Ticket{}).
 		Where("user_id = ?", userID).
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"log"
 	"on-air/models"
 	"testing"
@@ -57,7 +58,7 @@ func (suite *TicketTestSuite) SetupSuite() {
 // 	// 	WillReturnResult(sqlmock.NewResult(1, 1))
 
 // 	suite.sqlMock.ExpectCommit()
-// 	_, err := ReserveTicket(suite.dbMock, int(suite.UserID), 1, 10000, []int{1, 2, 3})
+// 	_, err := ReserveTicket(context.Background(), suite.dbMock, int(suite.UserID), 1, 10000, []int{1, 2, 3})
 // 	require.NoError(err)
 // }
 
@@ -70,15 +71,270 @@ func (suite *TicketTestSuite) TestTicket_GetExpiredTickets_Success() {
 		}).
 		AddRow(10, 1, "1000000", 9, 5, "Reserved", time.Now().Add(-20*time.Minute))
 
-	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "tickets"`).
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "tickets" WHERE \(status = (.+) AND expires_at < (.+)\) (.+) ORDER BY expires_at LIMIT 10 FOR UPDATE SKIP LOCKED`).
 		WillReturnRows(mockPassenger)
 
-	data, err := GetExpiredTickets(suite.dbMock)
+	data, err := GetExpiredTickets(context.Background(), suite.dbMock, 10)
 
 	require.NoError(err)
 	require.Len(data, 1)
 }
 
+func (suite *TicketTestSuite) TestTicket_GetExpiredTickets_Skip() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "tickets" WHERE \(status = (.+) AND expires_at < (.+)\) AND id NOT IN \((.+)\) (.+) ORDER BY expires_at LIMIT 10 FOR UPDATE SKIP LOCKED`).
+		WithArgs(string(models.Reserved), sqlmock.AnyArg(), 3, 4).
+		WillReturnRows(suite.sqlMock.NewRows([]string{"id"}).AddRow(5))
+
+	data, err := GetExpiredTickets(context.Background(), suite.dbMock, 10, 3, 4)
+	require.NoError(err)
+	require.Len(data, 1)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *TicketTestSuite) TestTicket_ExtendTicket_Success() {
+	require := suite.Require()
+	ticket := models.Ticket{Status: string(models.Reserved), ExpiresAt: time.Now().Add(5 * time.Minute)}
+	ticket.ID = 7
+	expiresAt := ticket.ExpiresAt.Add(10 * time.Minute)
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "tickets" SET "expires_at"=(.+),"extended"=(.+) WHERE \(id = (.+) AND status = (.+) AND extended = (.+) AND expires_at > (.+)\)`).
+		WithArgs(expiresAt, true, sqlmock.AnyArg(), 7, string(models.Reserved), false, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	err := ExtendTicket(context.Background(), suite.dbMock, &ticket, 10*time.Minute)
+	require.NoError(err)
+	require.True(ticket.Extended)
+	require.Equal(expiresAt, ticket.ExpiresAt)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *TicketTestSuite) TestTicket_ExtendTicket_AlreadyExtended() {
+	require := suite.Require()
+	ticket := models.Ticket{Status: string(models.Reserved), ExpiresAt: time.Now().Add(5 * time.Minute)}
+	ticket.ID = 7
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "tickets"`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.sqlMock.ExpectCommit()
+
+	err := ExtendTicket(context.Background(), suite.dbMock, &ticket, 10*time.Minute)
+	require.ErrorIs(err, ErrTicketNotExtendable)
+	require.False(ticket.Extended)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *TicketTestSuite) paidTicket() models.Ticket {
+	ticket := models.Ticket{
+		UnitPrice: 1000,
+		Count:     2,
+		Status:    string(models.TicketPaid),
+		Flight: models.Flight{
+			Number:    "FL005",
+			StartedAt: time.Now().Add(24 * time.Hour),
+		},
+	}
+	ticket.ID = 7
+
+	return ticket
+}
+
+func (suite *TicketTestSuite) expectLockedTicket(status models.TicketStatus, count int) {
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "tickets" WHERE id = (.+) FOR UPDATE`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "count"}).AddRow(7, string(status), count))
+}
+
+func (suite *TicketTestSuite) expectLockedPayment(amount int, refundedAmount int) {
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "payments" WHERE \(ticket_id = (.+) AND status = (.+)\) (.+) FOR UPDATE`).
+		WithArgs(7, string(models.Verified)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ticket_id", "amount", "refunded_amount", "status"}).
+			AddRow(3, 7, amount, refundedAmount, string(models.Verified)))
+}
+
+func (suite *TicketTestSuite) TestTicket_CancelTicket_Paid() {
+	require := suite.Require()
+	ticket := suite.paidTicket()
+
+	suite.sqlMock.ExpectBegin()
+	suite.expectLockedTicket(models.TicketPaid, 2)
+	suite.expectLockedPayment(2000, 500)
+	suite.sqlMock.ExpectExec(`UPDATE "tickets" SET "status"`).
+		WithArgs(string(models.TicketCancelled), sqlmock.AnyArg(), 7, string(models.TicketPaid)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(suite.sqlMock, models.TicketEntity, 7, string(models.TicketPaid), string(models.TicketCancelled), models.UserActor(1))
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "payments" WHERE ticket_id = (.+)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "ticket_id"}).AddRow(3, string(models.Verified), 7))
+	suite.sqlMock.ExpectExec(`UPDATE "payments" SET "status"`).
+		WithArgs(string(models.PaymentCancelled), sqlmock.AnyArg(), 3, string(models.Verified)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(suite.sqlMock, models.PaymentEntity, 3, string(models.Verified), string(models.PaymentCancelled), models.UserActor(1))
+	suite.sqlMock.ExpectExec(`UPDATE "payments" SET "refunded_amount"`).
+		WithArgs(1500, sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, string(models.OutboxPaymentRefund), 7, "", 0, 3, 1500, string(models.OutboxPending), 0, sqlmock.AnyArg(), "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(20))
+	suite.sqlMock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, string(models.OutboxRefund), 7, "FL005", 2, 0, 0, string(models.OutboxPending), 0, sqlmock.AnyArg(), "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(21))
+	suite.sqlMock.ExpectCommit()
+
+	refundAmount, messages, err := CancelTicket(context.Background(), suite.dbMock, &ticket, time.Second, models.UserActor(1), "cancelled by user")
+	require.NoError(err)
+	require.Equal(1500, refundAmount)
+	require.Len(messages, 2)
+	require.Equal(string(models.OutboxPaymentRefund), messages[0].Kind)
+	require.Equal(string(models.OutboxRefund), messages[1].Kind)
+	require.Equal(string(models.TicketCancelled), ticket.Status)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *TicketTestSuite) TestTicket_CancelTicket_CancelledMeanwhile() {
+	require := suite.Require()
+
+	// The ticket was read as paid, but a cancellation holding the lock
+	// committed first: nothing is refunded twice.
+	ticket := suite.paidTicket()
+
+	suite.sqlMock.ExpectBegin()
+	suite.expectLockedTicket(models.TicketCancelled, 2)
+	suite.sqlMock.ExpectRollback()
+
+	_, _, err := CancelTicket(context.Background(), suite.dbMock, &ticket, time.Second, models.UserActor(1), "cancelled by user")
+	require.ErrorIs(err, ErrTicketNotCancellable)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *TicketTestSuite) TestTicket_CancelTicket_Departed() {
+	require := suite.Require()
+	ticket := suite.paidTicket()
+	ticket.Flight.StartedAt = time.Now().Add(-time.Hour)
+
+	suite.sqlMock.ExpectBegin()
+	suite.expectLockedTicket(models.TicketPaid, 2)
+	suite.expectLockedPayment(2000, 0)
+	suite.sqlMock.ExpectRollback()
+
+	_, _, err := CancelTicket(context.Background(), suite.dbMock, &ticket, time.Second, models.UserActor(1), "cancelled by user")
+	require.ErrorIs(err, ErrFlightDeparted)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *TicketTestSuite) expectTicketPassengers(ids ...int) {
+	rows := sqlmock.NewRows([]string{"passenger_id"})
+	for _, id := range ids {
+		rows.AddRow(id)
+	}
+
+	suite.sqlMock.ExpectQuery(`SELECT "passenger_id" FROM "ticket_passengers" WHERE ticket_id = `).
+		WithArgs(7).
+		WillReturnRows(rows)
+}
+
+func (suite *TicketTestSuite) expectRemovePassenger(status models.TicketStatus) {
+	suite.sqlMock.ExpectExec(`DELETE FROM "ticket_passengers"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectExec(`UPDATE "tickets" SET "count"=(.+) WHERE \(id = (.+) AND count = (.+)\)`).
+		WithArgs(1, sqlmock.AnyArg(), 7, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(suite.sqlMock, models.TicketEntity, 7, string(status), string(status), models.UserActor(1))
+}
+
+func (suite *TicketTestSuite) passenger(id uint) models.Passenger {
+	passenger := models.Passenger{}
+	passenger.ID = id
+
+	return passenger
+}
+
+func (suite *TicketTestSuite) TestTicket_RemoveTicketPassengers_Paid() {
+	require := suite.Require()
+	ticket := suite.paidTicket()
+
+	// 1500 of the 2000 payment is refunded already, the seat of 1000 gets
+	// back only the 500 left.
+	suite.sqlMock.ExpectBegin()
+	suite.expectLockedTicket(models.TicketPaid, 2)
+	suite.expectTicketPassengers(11, 12)
+	suite.expectRemovePassenger(models.TicketPaid)
+	suite.expectLockedPayment(2000, 1500)
+	suite.sqlMock.ExpectExec(`UPDATE "payments" SET "refunded_amount"`).
+		WithArgs(500, sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, string(models.OutboxPaymentRefund), 7, "", 0, 3, 500, string(models.OutboxPending), 0, sqlmock.AnyArg(), "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(20))
+	suite.sqlMock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, string(models.OutboxRefund), 7, "FL005", 1, 0, 0, string(models.OutboxPending), 0, sqlmock.AnyArg(), "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(21))
+	suite.sqlMock.ExpectCommit()
+
+	refundAmount, messages, err := RemoveTicketPassengers(context.Background(), suite.dbMock, &ticket, []models.Passenger{suite.passenger(12)}, time.Second, models.UserActor(1))
+	require.NoError(err)
+	require.Equal(500, refundAmount)
+	require.Len(messages, 2)
+	require.Equal(1, ticket.Count)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *TicketTestSuite) TestTicket_RemoveTicketPassengers_Reserved() {
+	require := suite.Require()
+	ticket := suite.paidTicket()
+	ticket.Status = string(models.Reserved)
+
+	// The requested payment is for two seats, it is cancelled so the user
+	// pays for the one left with a new payment.
+	suite.sqlMock.ExpectBegin()
+	suite.expectLockedTicket(models.Reserved, 2)
+	suite.expectTicketPassengers(11, 12)
+	suite.expectRemovePassenger(models.Reserved)
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "payments" WHERE ticket_id = (.+)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "ticket_id"}).AddRow(3, string(models.Requested), 7))
+	suite.sqlMock.ExpectExec(`UPDATE "payments" SET "status"`).
+		WithArgs(string(models.PaymentCancelled), sqlmock.AnyArg(), 3, string(models.Requested)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(suite.sqlMock, models.PaymentEntity, 3, string(models.Requested), string(models.PaymentCancelled), models.UserActor(1))
+	suite.sqlMock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, string(models.OutboxRefund), 7, "FL005", 1, 0, 0, string(models.OutboxPending), 0, sqlmock.AnyArg(), "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(21))
+	suite.sqlMock.ExpectCommit()
+
+	refundAmount, messages, err := RemoveTicketPassengers(context.Background(), suite.dbMock, &ticket, []models.Passenger{suite.passenger(12)}, time.Second, models.UserActor(1))
+	require.NoError(err)
+	require.Zero(refundAmount)
+	require.Len(messages, 1)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *TicketTestSuite) TestTicket_RemoveTicketPassengers_RemovedMeanwhile() {
+	require := suite.Require()
+	ticket := suite.paidTicket()
+
+	// Passenger 12 was removed by a request holding the lock.
+	suite.sqlMock.ExpectBegin()
+	suite.expectLockedTicket(models.TicketPaid, 1)
+	suite.expectTicketPassengers(11)
+	suite.sqlMock.ExpectRollback()
+
+	_, _, err := RemoveTicketPassengers(context.Background(), suite.dbMock, &ticket, []models.Passenger{suite.passenger(12)}, time.Second, models.UserActor(1))
+	require.ErrorIs(err, ErrPassengerNotOnTicket)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+
+	suite.sqlMock.ExpectBegin()
+	suite.expectLockedTicket(models.TicketPaid, 1)
+	suite.expectTicketPassengers(11)
+	suite.sqlMock.ExpectRollback()
+
+	_, _, err = RemoveTicketPassengers(context.Background(), suite.dbMock, &ticket, []models.Passenger{suite.passenger(11)}, time.Second, models.UserActor(1))
+	require.ErrorIs(err, ErrTicketAllPassengers)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
 func (suite *TicketTestSuite) TestTickets_GetTickets_Success() {
 	require := suite.Require()
 	data := []models.Ticket{
@@ -193,7 +449,7 @@ func (suite *TicketTestSuite) TestTickets_GetTickets_Success() {
 		WithArgs(1).
 		WillReturnRows(mockUserRows)
 
-	tickets, err := GetUserTickets(suite.dbMock, suite.UserID)
+	tickets, err := GetUserTickets(context.Background(), suite.dbMock, suite.UserID)
 	require.NoError(err)
 	require.NotNil(tickets)
 	require.Len(tickets, 1)
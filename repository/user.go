@@ -1,14 +1,22 @@
 package repository
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"on-air/models"
 	"on-air/utils"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
 
-func GetUserByEmail(db *gorm.DB, email string) (*models.User, error) {
+var ErrPhoneNumberShared = errors.New("phone number is shared by users")
+
+func GetUserByEmail(ctx context.Context, db *gorm.DB, email string) (*models.User, error) {
+	db = db.WithContext(ctx)
+
 	var dbUser models.User
 	result := db.First(&dbUser, "email = ?", email)
 	if result.RowsAffected == 0 {
@@ -18,13 +26,15 @@ func GetUserByEmail(db *gorm.DB, email string) (*models.User, error) {
 	return &dbUser, nil
 }
 
-func RegisterUser(db *gorm.DB, email string, password string) (*models.User, error) {
+func RegisterUser(ctx context.Context, db *gorm.DB, email string, password string) (*models.User, error) {
+	db = db.WithContext(ctx)
+
 	hashedPassword, err := utils.HashPassword(password)
 	if err != nil {
 		return nil, err
 	}
 
-	user := models.User{Email: email, Password: hashedPassword}
+	user := models.User{Email: email, Password: hashedPassword, Role: string(models.RoleCustomer)}
 	result := db.Create(&user)
 	if err = result.Error; err != nil {
 		return nil, err
@@ -32,3 +42,196 @@ func RegisterUser(db *gorm.DB, email string, password string) (*models.User, err
 
 	return &user, nil
 }
+
+func GetUserByID(ctx context.Context, db *gorm.DB, id int) (*models.User, error) {
+	db = db.WithContext(ctx)
+
+	var dbUser models.User
+	err := db.First(&dbUser, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &dbUser, nil
+}
+
+// GetUserByPhoneNumber returns the user of the normalized mobile number.
+// Numbers are not unique, so a number shared by users logs in none of them
+// and ErrPhoneNumberShared is returned.
+func GetUserByPhoneNumber(ctx context.Context, db *gorm.DB, phoneNumber string) (*models.User, error) {
+	db = db.WithContext(ctx)
+
+	var dbUsers []models.User
+	err := db.Where("phone_number = ?", phoneNumber).Order("id").Limit(2).Find(&dbUsers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dbUsers) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	if len(dbUsers) > 1 {
+		return nil, ErrPhoneNumberShared
+	}
+
+	return &dbUsers[0], nil
+}
+
+// UserFilter narrows the users staff search through, empty fields match every
+// user.
+type UserFilter struct {
+	Email  string
+	Role   models.Role
+	Limit  int
+	Offset int
+}
+
+// SearchUsers returns the users matching the filter, the email matches
+// partially and case insensitively.
+func SearchUsers(ctx context.Context, db *gorm.DB, filter UserFilter) ([]models.User, error) {
+	db = db.WithContext(ctx)
+
+	query := db.Model(&models.User{})
+	if filter.Email != "" {
+		query = query.Where("email ILIKE ?", "%"+escapeLike(filter.Email)+"%")
+	}
+
+	if filter.Role != "" {
+		query = query.Where("role = ?", string(filter.Role))
+	}
+
+	var users []models.User
+	err := query.Order("id").Limit(filter.Limit).Offset(filter.Offset).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// ChangeUserRole gives the user role, it applies to their tokens from the
+// next refresh.
+func ChangeUserRole(ctx context.Context, db *gorm.DB, user *models.User, role models.Role) error {
+	db = db.WithContext(ctx)
+
+	err := db.Model(&models.User{}).Where("id = ?", user.ID).Update("role", string(role)).Error
+	if err != nil {
+		return err
+	}
+
+	user.Role = string(role)
+
+	return nil
+}
+
+// UserProfile is the change of the profile of a user, nil fields are left as
+// they are.
+type UserProfile struct {
+	FirstName   *string
+	LastName    *string
+	PhoneNumber *string
+}
+
+// UpdateUserProfile applies the profile to the user.
+func UpdateUserProfile(ctx context.Context, db *gorm.DB, user *models.User, profile UserProfile) error {
+	db = db.WithContext(ctx)
+
+	updates := map[string]interface{}{}
+	if profile.FirstName != nil {
+		updates["first_name"] = *profile.FirstName
+		user.FirstName = *profile.FirstName
+	}
+
+	if profile.LastName != nil {
+		updates["last_name"] = *profile.LastName
+		user.LastName = *profile.LastName
+	}
+
+	if profile.PhoneNumber != nil {
+		updates["phone_number"] = *profile.PhoneNumber
+		user.PhoneNumber = *profile.PhoneNumber
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+
+	return db.Model(&models.User{}).Where("id = ?", user.ID).Updates(updates).Error
+}
+
+// ChangePassword sets the password of the user and revokes their refresh
+// tokens, so the other sessions have to log in with the new password.
+func ChangePassword(ctx context.Context, db *gorm.DB, userID int, password string) error {
+	db = db.WithContext(ctx)
+
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		return setPassword(ctx, tx, userID, hashedPassword)
+	})
+}
+
+func setPassword(ctx context.Context, tx *gorm.DB, userID int, hashedPassword string) error {
+	err := tx.Model(&models.User{}).Where("id = ?", userID).Update("password", hashedPassword).Error
+	if err != nil {
+		return err
+	}
+
+	return RevokeRefreshTokens(ctx, tx, userID)
+}
+
+// DeleteUser closes the account of the user. The personal data of the user and
+// their passengers is wiped and the user soft deleted, so their tickets and
+// payments stay for accounting. The email is replaced by a placeholder since
+// emails are unique, and the national codes are cleared since they are unique
+// per user. Their sessions and mailed tokens stop working.
+func DeleteUser(ctx context.Context, db *gorm.DB, userID int) error {
+	db = db.WithContext(ctx)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"first_name":        "",
+			"last_name":         "",
+			"email":             fmt.Sprintf("deleted-%d@deleted.invalid", userID),
+			"phone_number":      "",
+			"password":          "",
+			"role":              string(models.RoleCustomer),
+			"email_verified_at": nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&models.Passenger{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+			"national_code": nil,
+			"first_name":    "",
+			"last_name":     "",
+			"gender":        "",
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		err = RevokeRefreshTokens(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&models.UserToken{}).
+			Where("user_id = ? AND used_at IS NULL", userID).
+			Update("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&models.User{}, userID).Error
+	})
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
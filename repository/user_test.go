@@ -1,8 +1,10 @@
 package repository
 
 import (
+	"context"
 	"errors"
 	"log"
+	"on-air/models"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -48,7 +50,7 @@ func (suite *UserTestSuite) TestUser_RegisterUser_Success() {
 	suite.sqlMock.ExpectQuery(`INSERT`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
 	suite.sqlMock.ExpectCommit()
 
-	_, err := RegisterUser(suite.dbMock, UserEmail, UserPassword)
+	_, err := RegisterUser(context.Background(), suite.dbMock, UserEmail, UserPassword)
 	require.NoError(err)
 }
 
@@ -60,10 +62,28 @@ func (suite *UserTestSuite) TestUser_RegisterUser_Failure() {
 		WillReturnError(errors.New("user not found"))
 	suite.sqlMock.ExpectRollback()
 
-	res, _ := RegisterUser(suite.dbMock, UserEmail, UserPassword)
+	res, _ := RegisterUser(context.Background(), suite.dbMock, UserEmail, UserPassword)
 	require.Empty(res)
 }
 
+func (suite *UserTestSuite) TestUser_SearchUsers_Success() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE email ILIKE .* AND role = .* ORDER BY id LIMIT 20 OFFSET 40`).
+		WithArgs(`%ad\_min%`, string(models.RoleSupport)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).AddRow(UserId, UserEmail, string(models.RoleSupport)))
+
+	users, err := SearchUsers(context.Background(), suite.dbMock, UserFilter{
+		Email:  "ad_min",
+		Role:   models.RoleSupport,
+		Limit:  20,
+		Offset: 40,
+	})
+	require.NoError(err)
+	require.Len(users, 1)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
 func (suite *UserTestSuite) TestUser_GetUserByEmail_Success() {
 	require := suite.Require()
 
@@ -74,7 +94,7 @@ func (suite *UserTestSuite) TestUser_GetUserByEmail_Success() {
 
 	suite.sqlMock.ExpectQuery(`SELECT`).WillReturnRows(mockUser)
 
-	_, err := GetUserByEmail(suite.dbMock, UserEmail)
+	_, err := GetUserByEmail(context.Background(), suite.dbMock, UserEmail)
 	require.NoError(err)
 }
 
@@ -83,10 +103,97 @@ func (suite *UserTestSuite) TestUser_GetUserByEmail_Failure() {
 
 	suite.sqlMock.ExpectQuery("SELECT").WithArgs(UserEmail).WillReturnError(errors.New(""))
 
-	res, _ := GetUserByEmail(suite.dbMock, UserEmail)
+	res, _ := GetUserByEmail(context.Background(), suite.dbMock, UserEmail)
 	require.Empty(res)
 }
 
+func (suite *UserTestSuite) TestUser_GetUserByPhoneNumber() {
+	require := suite.Require()
+	query := `SELECT \* FROM "users" WHERE phone_number = .* ORDER BY id LIMIT 2`
+
+	suite.sqlMock.ExpectQuery(query).WithArgs("09121234567").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "phone_number"}).AddRow(UserId, "09121234567"))
+	user, err := GetUserByPhoneNumber(context.Background(), suite.dbMock, "09121234567")
+	require.NoError(err)
+	require.EqualValues(UserId, user.ID)
+
+	suite.sqlMock.ExpectQuery(query).WithArgs("09121234567").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	_, err = GetUserByPhoneNumber(context.Background(), suite.dbMock, "09121234567")
+	require.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	suite.sqlMock.ExpectQuery(query).WithArgs("09121234567").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	_, err = GetUserByPhoneNumber(context.Background(), suite.dbMock, "09121234567")
+	require.ErrorIs(err, ErrPhoneNumberShared)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *UserTestSuite) TestUser_UpdateUserProfile() {
+	require := suite.Require()
+
+	firstName, phoneNumber := "Ali", "09121234567"
+	user := &models.User{Model: gorm.Model{ID: UserId}, FirstName: "Reza", LastName: "Rezaei"}
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "users" SET "first_name"=.*,"phone_number"=.*,"updated_at"=.* WHERE id = `).
+		WithArgs("Ali", "09121234567", sqlmock.AnyArg(), UserId).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	err := UpdateUserProfile(context.Background(), suite.dbMock, user, UserProfile{FirstName: &firstName, PhoneNumber: &phoneNumber})
+	require.NoError(err)
+	require.Equal("Ali", user.FirstName)
+	require.Equal("Rezaei", user.LastName)
+	require.Equal("09121234567", user.PhoneNumber)
+
+	// Nothing to change runs no query.
+	require.NoError(UpdateUserProfile(context.Background(), suite.dbMock, user, UserProfile{}))
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *UserTestSuite) TestUser_ChangePassword() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "users" SET "password"=.*,"updated_at"=.* WHERE id = `).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), UserId).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=.* WHERE \(user_id = .* AND revoked_at IS NULL\)`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), UserId).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	suite.sqlMock.ExpectCommit()
+
+	err := ChangePassword(context.Background(), suite.dbMock, UserId, "newPassword@123")
+	require.NoError(err)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *UserTestSuite) TestUser_DeleteUser() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "users" SET "email"=.*,"email_verified_at"=.*,"first_name"=.*,"last_name"=.*,"password"=.*,"phone_number"=.*,"role"=.*,"updated_at"=.* WHERE id = `).
+		WithArgs("deleted-1@deleted.invalid", nil, "", "", "", "", "customer", sqlmock.AnyArg(), UserId).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectExec(`UPDATE "passengers" SET "first_name"=.*,"gender"=.*,"last_name"=.*,"national_code"=.*,"updated_at"=.* WHERE user_id = .* AND "passengers"."deleted_at" IS NULL`).
+		WithArgs("", "", "", nil, sqlmock.AnyArg(), UserId).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	suite.sqlMock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=.* WHERE \(user_id = .* AND revoked_at IS NULL\)`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), UserId).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectExec(`UPDATE "user_tokens" SET "used_at"=.* WHERE \(user_id = .* AND used_at IS NULL\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.sqlMock.ExpectExec(`UPDATE "users" SET "deleted_at"=.* WHERE "users"."id" = .* AND "users"."deleted_at" IS NULL`).
+		WithArgs(sqlmock.AnyArg(), UserId).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	err := DeleteUser(context.Background(), suite.dbMock, UserId)
+	require.NoError(err)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
 func TestUser(t *testing.T) {
 	suite.Run(t, new(UserTestSuite))
 }
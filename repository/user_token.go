@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"on-air/config"
+	"on-air/models"
+	"on-air/utils"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Default lifetimes of the mailed tokens when account.*_expires_in are not
+// set.
+const (
+	DefaultVerifyEmailExpiresIn   = 48 * time.Hour
+	DefaultResetPasswordExpiresIn = time.Hour
+)
+
+var (
+	ErrInvalidUserToken = errors.New("invalid user token")
+	ErrNoTokenSecret    = errors.New("account token secret is not set")
+)
+
+// CreateUserToken issues a token of the user for purpose, the earlier tokens
+// of the same purpose stop working. The token is a random secret followed by
+// its HMAC, so forged tokens are rejected without a query.
+func CreateUserToken(ctx context.Context, db *gorm.DB, cfg *config.Account, userID int, purpose models.UserTokenPurpose) (string, error) {
+	return createUserToken(ctx, db, cfg, models.UserToken{
+		UserID:  uint(userID),
+		Purpose: string(purpose),
+	})
+}
+
+// CreateEmailChangeToken issues the token that changes the email of the user
+// to email. It is mailed to the new email, so the email is verified by the
+// time it changes.
+func CreateEmailChangeToken(ctx context.Context, db *gorm.DB, cfg *config.Account, userID int, email string) (string, error) {
+	return createUserToken(ctx, db, cfg, models.UserToken{
+		UserID:  uint(userID),
+		Purpose: string(models.ChangeEmailPurpose),
+		Email:   email,
+	})
+}
+
+func createUserToken(ctx context.Context, db *gorm.DB, cfg *config.Account, userToken models.UserToken) (string, error) {
+	db = db.WithContext(ctx)
+
+	if cfg.TokenSecret == "" {
+		return "", ErrNoTokenSecret
+	}
+
+	purpose := models.UserTokenPurpose(userToken.Purpose)
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	random := base64.RawURLEncoding.EncodeToString(secret)
+	token := random + "." + signUserToken(cfg, purpose, random)
+
+	userToken.TokenHash = hashToken(token)
+	userToken.ExpiresAt = time.Now().Add(userTokenExpiresIn(cfg, purpose))
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userToken.UserID, userToken.Purpose).
+			Update("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&userToken).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// VerifyEmail uses up the token and marks the email of its user as verified.
+func VerifyEmail(ctx context.Context, db *gorm.DB, cfg *config.Account, token string) error {
+	db = db.WithContext(ctx)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		userToken, err := useUserToken(tx, cfg, token, models.VerifyEmailPurpose)
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&models.User{}).
+			Where("id = ? AND email_verified_at IS NULL", userToken.UserID).
+			Update("email_verified_at", time.Now()).Error
+	})
+}
+
+// ConfirmEmailChange uses up the token and changes the email of its user to
+// the email it was mailed to, which is verified by now.
+func ConfirmEmailChange(ctx context.Context, db *gorm.DB, cfg *config.Account, token string) error {
+	db = db.WithContext(ctx)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		userToken, err := useUserToken(tx, cfg, token, models.ChangeEmailPurpose)
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&models.User{}).
+			Where("id = ?", userToken.UserID).
+			Updates(map[string]interface{}{
+				"email":             userToken.Email,
+				"email_verified_at": time.Now(),
+			}).Error
+	})
+}
+
+// ResetPassword uses up the token, sets the password of its user and revokes
+// their refresh tokens, so every session has to log in with the new password.
+func ResetPassword(ctx context.Context, db *gorm.DB, cfg *config.Account, token string, password string) error {
+	db = db.WithContext(ctx)
+
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		userToken, err := useUserToken(tx, cfg, token, models.ResetPasswordPurpose)
+		if err != nil {
+			return err
+		}
+
+		return setPassword(ctx, tx, int(userToken.UserID), hashedPassword)
+	})
+}
+
+// useUserToken marks the token as used and returns it, it runs in the
+// transaction of the change the token allows.
+func useUserToken(tx *gorm.DB, cfg *config.Account, token string, purpose models.UserTokenPurpose) (*models.UserToken, error) {
+	random, signature, ok := strings.Cut(token, ".")
+	if !ok || cfg.TokenSecret == "" || !hmac.Equal([]byte(signature), []byte(signUserToken(cfg, purpose, random))) {
+		return nil, ErrInvalidUserToken
+	}
+
+	var userToken models.UserToken
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&userToken, "token_hash = ? AND purpose = ?", hashToken(token), string(purpose)).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidUserToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if userToken.UsedAt != nil || time.Now().After(userToken.ExpiresAt) {
+		return nil, ErrInvalidUserToken
+	}
+
+	err = tx.Model(&userToken).Update("used_at", time.Now()).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &userToken, nil
+}
+
+func signUserToken(cfg *config.Account, purpose models.UserTokenPurpose, random string) string {
+	mac := hmac.New(sha256.New, []byte(cfg.TokenSecret))
+	mac.Write([]byte(string(purpose) + "." + random))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func userTokenExpiresIn(cfg *config.Account, purpose models.UserTokenPurpose) time.Duration {
+	switch purpose {
+	case models.VerifyEmailPurpose, models.ChangeEmailPurpose:
+		if cfg.VerifyEmailExpiresIn > 0 {
+			return cfg.VerifyEmailExpiresIn
+		}
+		return DefaultVerifyEmailExpiresIn
+	default:
+		if cfg.ResetPasswordExpiresIn > 0 {
+			return cfg.ResetPasswordExpiresIn
+		}
+		return DefaultResetPasswordExpiresIn
+	}
+}
@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"on-air/config"
+	"on-air/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type UserTokenTestSuite struct {
+	suite.Suite
+	sqlMock sqlmock.Sqlmock
+	dbMock  *gorm.DB
+	account *config.Account
+}
+
+func (suite *UserTokenTestSuite) SetupTest() {
+	mockDB, sqlMock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.dbMock, err = gorm.Open(postgres.New(postgres.Config{
+		Conn: mockDB,
+	}))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.sqlMock = sqlMock
+	suite.account = &config.Account{TokenSecret: "tokenSecret"}
+}
+
+func (suite *UserTokenTestSuite) createToken(purpose models.UserTokenPurpose) string {
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "user_tokens" SET "used_at"=.* WHERE \(user_id = .* AND purpose = .* AND used_at IS NULL\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectQuery(`INSERT INTO "user_tokens"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 3, string(purpose), sqlmock.AnyArg(), "", sqlmock.AnyArg(), nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	suite.sqlMock.ExpectCommit()
+
+	token, err := CreateUserToken(context.Background(), suite.dbMock, suite.account, 3, purpose)
+	suite.Require().NoError(err)
+
+	return token
+}
+
+func (suite *UserTokenTestSuite) expectToken(token string, purpose models.UserTokenPurpose, usedAt *time.Time, expiresAt time.Time) {
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "user_tokens" WHERE \(token_hash = .* AND purpose = .*\) .* FOR UPDATE`).
+		WithArgs(hashToken(token), string(purpose)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "purpose", "token_hash", "expires_at", "used_at"}).
+			AddRow(1, 3, string(purpose), hashToken(token), expiresAt, usedAt))
+}
+
+func (suite *UserTokenTestSuite) TestVerifyEmail_Success() {
+	require := suite.Require()
+
+	token := suite.createToken(models.VerifyEmailPurpose)
+
+	suite.sqlMock.ExpectBegin()
+	suite.expectToken(token, models.VerifyEmailPurpose, nil, time.Now().Add(time.Hour))
+	suite.sqlMock.ExpectExec(`UPDATE "user_tokens" SET "used_at"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectExec(`UPDATE "users" SET "email_verified_at"=.* WHERE \(id = .* AND email_verified_at IS NULL\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	err := VerifyEmail(context.Background(), suite.dbMock, suite.account, token)
+	require.NoError(err)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *UserTokenTestSuite) TestVerifyEmail_Used() {
+	require := suite.Require()
+
+	token := suite.createToken(models.VerifyEmailPurpose)
+	usedAt := time.Now().Add(-time.Minute)
+
+	suite.sqlMock.ExpectBegin()
+	suite.expectToken(token, models.VerifyEmailPurpose, &usedAt, time.Now().Add(time.Hour))
+	suite.sqlMock.ExpectRollback()
+
+	err := VerifyEmail(context.Background(), suite.dbMock, suite.account, token)
+	require.ErrorIs(err, ErrInvalidUserToken)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *UserTokenTestSuite) TestVerifyEmail_Expired() {
+	require := suite.Require()
+
+	token := suite.createToken(models.VerifyEmailPurpose)
+
+	suite.sqlMock.ExpectBegin()
+	suite.expectToken(token, models.VerifyEmailPurpose, nil, time.Now().Add(-time.Minute))
+	suite.sqlMock.ExpectRollback()
+
+	err := VerifyEmail(context.Background(), suite.dbMock, suite.account, token)
+	require.ErrorIs(err, ErrInvalidUserToken)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *UserTokenTestSuite) TestVerifyEmail_Forged() {
+	require := suite.Require()
+
+	// A token of another purpose or with a bad signature never reaches the
+	// database.
+	token := suite.createToken(models.ResetPasswordPurpose)
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectRollback()
+	err := VerifyEmail(context.Background(), suite.dbMock, suite.account, token)
+	require.ErrorIs(err, ErrInvalidUserToken)
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectRollback()
+	err = VerifyEmail(context.Background(), suite.dbMock, suite.account, "random.signature")
+	require.ErrorIs(err, ErrInvalidUserToken)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *UserTokenTestSuite) TestResetPassword_Success() {
+	require := suite.Require()
+
+	token := suite.createToken(models.ResetPasswordPurpose)
+
+	suite.sqlMock.ExpectBegin()
+	suite.expectToken(token, models.ResetPasswordPurpose, nil, time.Now().Add(time.Hour))
+	suite.sqlMock.ExpectExec(`UPDATE "user_tokens" SET "used_at"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectExec(`UPDATE "users" SET "password"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=.* WHERE \(user_id = .* AND revoked_at IS NULL\)`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	suite.sqlMock.ExpectCommit()
+
+	err := ResetPassword(context.Background(), suite.dbMock, suite.account, token, "newPassword@123")
+	require.NoError(err)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *UserTokenTestSuite) TestConfirmEmailChange_Success() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "user_tokens" SET "used_at"=.* WHERE \(user_id = .* AND purpose = .* AND used_at IS NULL\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.sqlMock.ExpectQuery(`INSERT INTO "user_tokens"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 3, string(models.ChangeEmailPurpose), sqlmock.AnyArg(), "new@gmail.com", sqlmock.AnyArg(), nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	suite.sqlMock.ExpectCommit()
+
+	token, err := CreateEmailChangeToken(context.Background(), suite.dbMock, suite.account, 3, "new@gmail.com")
+	require.NoError(err)
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "user_tokens" WHERE \(token_hash = .* AND purpose = .*\) .* FOR UPDATE`).
+		WithArgs(hashToken(token), string(models.ChangeEmailPurpose)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "purpose", "token_hash", "email", "expires_at"}).
+			AddRow(1, 3, string(models.ChangeEmailPurpose), hashToken(token), "new@gmail.com", time.Now().Add(time.Hour)))
+	suite.sqlMock.ExpectExec(`UPDATE "user_tokens" SET "used_at"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectExec(`UPDATE "users" SET "email"=.*,"email_verified_at"=.* WHERE id = `).
+		WithArgs("new@gmail.com", sqlmock.AnyArg(), sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	err = ConfirmEmailChange(context.Background(), suite.dbMock, suite.account, token)
+	require.NoError(err)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *UserTokenTestSuite) TestConfirmEmailChange_VerifyEmailToken() {
+	require := suite.Require()
+
+	// A verification token can not change the email.
+	token := suite.createToken(models.VerifyEmailPurpose)
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectRollback()
+	err := ConfirmEmailChange(context.Background(), suite.dbMock, suite.account, token)
+	require.ErrorIs(err, ErrInvalidUserToken)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *UserTokenTestSuite) TestCreateUserToken_NoSecret() {
+	require := suite.Require()
+
+	_, err := CreateUserToken(context.Background(), suite.dbMock, &config.Account{}, 3, models.VerifyEmailPurpose)
+	require.ErrorIs(err, ErrNoTokenSecret)
+}
+
+func TestUserToken(t *testing.T) {
+	suite.Run(t, new(UserTokenTestSuite))
+}
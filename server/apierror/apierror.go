@@ -0,0 +1,165 @@
+// Package apierror is the error model of the API. Every failed request is
+// answered with the same JSON envelope carrying a stable, machine-readable
+// code clients can branch on, the message is for humans and may change.
+package apierror
+
+import (
+	"fmt"
+	"net/http"
+)
+
+type Code string
+
+const (
+	InvalidRequest          Code = "INVALID_REQUEST"
+	ValidationFailed        Code = "VALIDATION_FAILED"
+	Unauthorized            Code = "UNAUTHORIZED"
+	InvalidCredentials      Code = "INVALID_CREDENTIALS"
+	InvalidRefreshToken     Code = "INVALID_REFRESH_TOKEN"
+	InvalidToken            Code = "INVALID_TOKEN"
+	InvalidOTP              Code = "INVALID_OTP"
+	Forbidden               Code = "FORBIDDEN"
+	NotFound                Code = "NOT_FOUND"
+	UserNotFound            Code = "USER_NOT_FOUND"
+	MethodNotAllowed        Code = "METHOD_NOT_ALLOWED"
+	Conflict                Code = "CONFLICT"
+	UserDuplicate           Code = "USER_DUPLICATE"
+	PhoneNumberTaken        Code = "PHONE_NUMBER_TAKEN"
+	PassengerDuplicate      Code = "PASSENGER_DUPLICATE"
+	PassengerNotOnTicket    Code = "PASSENGER_NOT_ON_TICKET"
+	FlightSoldOut           Code = "FLIGHT_SOLD_OUT"
+	FlightDeparted          Code = "FLIGHT_DEPARTED"
+	TicketNotFound          Code = "TICKET_NOT_FOUND"
+	TicketNotCancellable    Code = "TICKET_NOT_CANCELLABLE"
+	TicketNotExtendable     Code = "TICKET_NOT_EXTENDABLE"
+	TicketNotExpirable      Code = "TICKET_NOT_EXPIRABLE"
+	TicketAlreadyExtended   Code = "TICKET_ALREADY_EXTENDED"
+	TicketReservationFailed Code = "TICKET_RESERVATION_FAILED"
+	StatusChanged           Code = "STATUS_CHANGED"
+	PaymentNotFound         Code = "PAYMENT_NOT_FOUND"
+	PaymentAlreadyVerified  Code = "PAYMENT_ALREADY_VERIFIED"
+	PaymentInProgress       Code = "PAYMENT_IN_PROGRESS"
+	PaymentNotPayable       Code = "PAYMENT_NOT_PAYABLE"
+	PaymentAmountMismatch   Code = "PAYMENT_AMOUNT_MISMATCH"
+	PaymentDeclined         Code = "PAYMENT_DECLINED"
+	RefundExceedsPayment    Code = "REFUND_EXCEEDS_PAYMENT"
+	GatewayNotFound         Code = "GATEWAY_NOT_FOUND"
+	InvalidCallback         Code = "INVALID_CALLBACK"
+	IdempotencyKeyInvalid   Code = "IDEMPOTENCY_KEY_INVALID"
+	IdempotencyKeyReused    Code = "IDEMPOTENCY_KEY_REUSED"
+	IdempotencyInProgress   Code = "IDEMPOTENCY_IN_PROGRESS"
+	ProviderError           Code = "PROVIDER_ERROR"
+	ProviderUnavailable     Code = "PROVIDER_UNAVAILABLE"
+	TooManyRequests         Code = "TOO_MANY_REQUESTS"
+	Timeout                 Code = "TIMEOUT"
+	InternalError           Code = "INTERNAL_ERROR"
+)
+
+var (
+	ErrInvalidRequest          = New(http.StatusBadRequest, InvalidRequest, "Invalid request body")
+	ErrInvalidTicketID         = New(http.StatusBadRequest, InvalidRequest, "Invalid ticket_id")
+	ErrUnauthorized            = New(http.StatusUnauthorized, Unauthorized, "Authentication required")
+	ErrInvalidCredentials      = New(http.StatusUnauthorized, InvalidCredentials, "Invalid credentials")
+	ErrWrongPassword           = New(http.StatusForbidden, InvalidCredentials, "Wrong password")
+	ErrInvalidRefreshToken     = New(http.StatusUnauthorized, InvalidRefreshToken, "Invalid refresh token")
+	ErrInvalidUserToken        = New(http.StatusBadRequest, InvalidToken, "Invalid or expired token")
+	ErrInvalidOTP              = New(http.StatusUnauthorized, InvalidOTP, "Invalid or expired code")
+	ErrOTPAttemptsExceeded     = New(http.StatusUnauthorized, InvalidOTP, "Too many wrong codes, request a new code")
+	ErrOTPTooSoon              = New(http.StatusTooManyRequests, TooManyRequests, "Wait before requesting another code")
+	ErrOTPLocked               = New(http.StatusTooManyRequests, TooManyRequests, "Too many wrong codes, try again later")
+	ErrRateLimited             = New(http.StatusTooManyRequests, TooManyRequests, "Too many requests, try again later")
+	ErrLoginLocked             = New(http.StatusTooManyRequests, TooManyRequests, "Too many failed logins, try again later")
+	ErrForbidden               = New(http.StatusForbidden, Forbidden, "Permission denied")
+	ErrNotFound                = New(http.StatusNotFound, NotFound, "Not found")
+	ErrUserNotFound            = New(http.StatusNotFound, UserNotFound, "User not found")
+	ErrUserDuplicate           = New(http.StatusConflict, UserDuplicate, "User exists")
+	ErrPhoneNumberTaken        = New(http.StatusConflict, PhoneNumberTaken, "Phone number is used by another user")
+	ErrPassengerDuplicate      = New(http.StatusConflict, PassengerDuplicate, "Passenger exists")
+	ErrPassengerNotOnTicket    = New(http.StatusBadRequest, PassengerNotOnTicket, "Invalid passengers")
+	ErrFlightSoldOut           = New(http.StatusConflict, FlightSoldOut, "Sold out")
+	ErrFlightDeparted          = New(http.StatusConflict, FlightDeparted, "Flight departed")
+	ErrTicketNotFound          = New(http.StatusNotFound, TicketNotFound, "Ticket not found")
+	ErrTicketNotCancellable    = New(http.StatusConflict, TicketNotCancellable, "Ticket can not be cancelled")
+	ErrTicketAllPassengers     = New(http.StatusConflict, TicketNotCancellable, "Cancel the ticket to remove all passengers")
+	ErrTicketNotExtendable     = New(http.StatusConflict, TicketNotExtendable, "Ticket can not be extended")
+	ErrTicketNotExpirable      = New(http.StatusConflict, TicketNotExpirable, "Ticket can not be expired")
+	ErrTicketAlreadyExtended   = New(http.StatusConflict, TicketAlreadyExtended, "Ticket already extended")
+	ErrTicketReservationFailed = New(http.StatusBadGateway, TicketReservationFailed, "Ticket could not be reserved")
+	ErrStatusChanged           = New(http.StatusConflict, StatusChanged, "Status changed, try again")
+	ErrInvalidTransition       = New(http.StatusConflict, Conflict, "Status can not be changed")
+	ErrPaymentNotFound         = New(http.StatusNotFound, PaymentNotFound, "Payment not found")
+	ErrPaymentAlreadyVerified  = New(http.StatusConflict, PaymentAlreadyVerified, "Payment already verified")
+	ErrPaymentInProgress       = New(http.StatusConflict, PaymentInProgress, "Payment is being verified")
+	ErrPaymentNotPayable       = New(http.StatusConflict, PaymentNotPayable, "Payment is not payable")
+	ErrTicketNotReserved       = New(http.StatusConflict, PaymentNotPayable, "Ticket is not reserved")
+	ErrPaymentAmountMismatch   = New(http.StatusUnprocessableEntity, PaymentAmountMismatch, "Transaction not correct")
+	ErrPaymentDeclined         = New(http.StatusUnprocessableEntity, PaymentDeclined, "Transaction declined")
+	ErrRefundExceedsPayment    = New(http.StatusConflict, RefundExceedsPayment, "Refund exceeds what is left of the payment")
+	ErrGatewayNotFound         = New(http.StatusNotFound, GatewayNotFound, "Gateway not found")
+	ErrInvalidCallback         = New(http.StatusBadRequest, InvalidCallback, "Invalid callback")
+	ErrInvalidSignature        = New(http.StatusBadRequest, InvalidCallback, "Invalid signature")
+	ErrIdempotencyKeyInvalid   = New(http.StatusBadRequest, IdempotencyKeyInvalid, "Invalid idempotency key")
+	ErrIdempotencyKeyReused    = New(http.StatusUnprocessableEntity, IdempotencyKeyReused, "Idempotency key is already used for a different request")
+	ErrIdempotencyInProgress   = New(http.StatusConflict, IdempotencyInProgress, "Request with this idempotency key is in progress")
+	ErrProvider                = New(http.StatusBadGateway, ProviderError, "Flight provider failed")
+	ErrProviderUnavailable     = New(http.StatusServiceUnavailable, ProviderUnavailable, "Flight provider is unavailable")
+	ErrTimeout                 = New(http.StatusGatewayTimeout, Timeout, "Request timed out")
+	ErrInternal                = New(http.StatusInternalServerError, InternalError, "Internal server error")
+)
+
+// FieldError is a field of the request that failed validation.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error is an error with the status and the code it is answered with.
+type Error struct {
+	Status  int          `json:"-"`
+	Code    Code         `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+
+	cause error
+}
+
+// Response is the envelope every error is answered with.
+type Response struct {
+	Error *Error `json:"error"`
+}
+
+func New(status int, code Code, message string) *Error {
+	return &Error{
+		Status:  status,
+		Code:    code,
+		Message: message,
+	}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.cause)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is matches errors by code and message, so a wrapped copy of one of the
+// errors above still matches it.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code && t.Message == e.Message
+}
+
+// Wrap returns a copy of the error that keeps cause for the logs and traces,
+// the cause is never sent to the client.
+func (e *Error) Wrap(cause error) *Error {
+	wrapped := *e
+	wrapped.cause = cause
+	return &wrapped
+}
@@ -0,0 +1,171 @@
+package apierror
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"on-air/logging"
+	"on-air/repository"
+	"on-air/server/services"
+	"on-air/server/services/gateway"
+	"on-air/utils"
+
+	"github.com/eapache/go-resiliency/breaker"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// mapped are the errors of the repository, the gateways and the provider the
+// handlers can return as they are. The first match wins, so the specific
+// errors come before the ones they may wrap.
+var mapped = []struct {
+	target error
+	err    *Error
+}{
+	{breaker.ErrBreakerOpen, ErrProviderUnavailable},
+	{context.DeadlineExceeded, ErrTimeout},
+	{repository.ErrFlightDeparted, ErrFlightDeparted},
+	{repository.ErrTicketNotExtendable, ErrTicketNotExtendable},
+	{repository.ErrInvalidTransition, ErrInvalidTransition},
+	{repository.ErrStatusChanged, ErrStatusChanged},
+	{repository.ErrPaymentAlreadyVerified, ErrPaymentAlreadyVerified},
+	{repository.ErrCallbackReplayed, ErrPaymentInProgress},
+	{repository.ErrPaymentNotPayable, ErrPaymentNotPayable},
+	{repository.ErrTicketNotReserved, ErrTicketNotReserved},
+	{repository.ErrAmountMismatch, ErrPaymentAmountMismatch},
+	{repository.ErrRefundExceedsPayment, ErrRefundExceedsPayment},
+	{gateway.ErrUnknownGateway, ErrGatewayNotFound},
+	{gateway.ErrInvalidSignature, ErrInvalidSignature},
+	{gateway.ErrInvalidCallback, ErrInvalidCallback},
+	{gateway.DeclinedError{}, ErrPaymentDeclined},
+	{gorm.ErrRecordNotFound, ErrNotFound},
+}
+
+// From returns the API error err is answered with, errors it does not know
+// are internal errors.
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	var validationErr *utils.ValidationError
+	if errors.As(err, &validationErr) {
+		return Validation(validationErr)
+	}
+
+	for _, m := range mapped {
+		if errors.Is(err, m.target) {
+			return m.err.Wrap(err)
+		}
+	}
+
+	var providerErr *services.ProviderError
+	if errors.As(err, &providerErr) {
+		return ErrProvider.Wrap(err)
+	}
+
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return fromHTTPError(httpErr)
+	}
+
+	return ErrInternal.Wrap(err)
+}
+
+// Validation returns the error of a request that failed validation, with a
+// message per field.
+func Validation(err *utils.ValidationError) *Error {
+	fields := make([]FieldError, 0, len(err.Fields))
+	for _, field := range err.Fields {
+		fields = append(fields, FieldError{
+			Field:   field.Field,
+			Rule:    field.Rule,
+			Message: ruleMessage(field.Rule, field.Param),
+		})
+	}
+
+	validationErr := New(http.StatusBadRequest, ValidationFailed, "Validation failed").Wrap(err)
+	validationErr.Fields = fields
+	return validationErr
+}
+
+func ruleMessage(rule string, param string) string {
+	switch rule {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		return "must be at least " + param
+	case "max":
+		return "must be at most " + param
+	case "len":
+		return "must have a length of " + param
+	case "oneof":
+		return "must be one of " + param
+	case "datetime":
+		return "must be formatted as " + param
+	case "eqfield":
+		return "must be equal to " + param
+	case "national_code":
+		return "must be a valid national code"
+	case "mobile":
+		return "must be an Iranian mobile number"
+	case "CustomTimeValidator":
+		return "must be formatted as 15:04"
+	default:
+		return "failed the " + rule + " rule"
+	}
+}
+
+// fromHTTPError keeps the status of the errors Echo itself returns, e.g. for
+// an unknown route or a body that is too large.
+func fromHTTPError(err *echo.HTTPError) *Error {
+	message, ok := err.Message.(string)
+	if !ok {
+		message = http.StatusText(err.Code)
+	}
+
+	var code Code
+	switch {
+	case err.Code == http.StatusUnauthorized:
+		code = Unauthorized
+	case err.Code == http.StatusForbidden:
+		code = Forbidden
+	case err.Code == http.StatusNotFound:
+		code = NotFound
+	case err.Code == http.StatusMethodNotAllowed:
+		code = MethodNotAllowed
+	case err.Code == http.StatusConflict:
+		code = Conflict
+	case err.Code == http.StatusTooManyRequests:
+		code = TooManyRequests
+	case err.Code >= http.StatusInternalServerError:
+		return ErrInternal.Wrap(err)
+	default:
+		code = InvalidRequest
+	}
+
+	return New(err.Code, code, message).Wrap(err)
+}
+
+// HTTPErrorHandler answers the errors the handlers and the middlewares
+// return with the error envelope, it is installed as e.HTTPErrorHandler.
+func HTTPErrorHandler(err error, ctx echo.Context) {
+	if ctx.Response().Committed {
+		return
+	}
+
+	apiErr := From(err)
+
+	if ctx.Request().Method == http.MethodHead {
+		err = ctx.NoContent(apiErr.Status)
+	} else {
+		err = ctx.JSON(apiErr.Status, Response{Error: apiErr})
+	}
+
+	if err != nil {
+		logging.FromContext(ctx.Request().Context()).WithError(err).Error("apierror: write error response failed")
+	}
+}
@@ -0,0 +1,127 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"on-air/repository"
+	"on-air/server/services"
+	"on-air/utils"
+	"testing"
+
+	"github.com/eapache/go-resiliency/breaker"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+type HandlerTestSuite struct {
+	suite.Suite
+	e *echo.Echo
+}
+
+func (suite *HandlerTestSuite) SetupSuite() {
+	suite.e = echo.New()
+	suite.e.HTTPErrorHandler = HTTPErrorHandler
+}
+
+func (suite *HandlerTestSuite) CallHandler(method string, err error) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/tickets", nil)
+	res := httptest.NewRecorder()
+	ctx := suite.e.NewContext(req, res)
+
+	HTTPErrorHandler(err, ctx)
+
+	return res
+}
+
+func (suite *HandlerTestSuite) TestFrom_Mapped() {
+	require := suite.Require()
+
+	tests := []struct {
+		err    error
+		status int
+		code   Code
+	}{
+		{ErrTicketNotFound, http.StatusNotFound, TicketNotFound},
+		{ErrFlightSoldOut.Wrap(errors.New("rejected")), http.StatusConflict, FlightSoldOut},
+		{fmt.Errorf("cancel: %w", repository.ErrFlightDeparted), http.StatusConflict, FlightDeparted},
+		{repository.ErrAmountMismatch, http.StatusUnprocessableEntity, PaymentAmountMismatch},
+		{gorm.ErrRecordNotFound, http.StatusNotFound, NotFound},
+		{breaker.ErrBreakerOpen, http.StatusServiceUnavailable, ProviderUnavailable},
+		{&services.ProviderError{Operation: "get_flight", Err: errors.New("status: 500")}, http.StatusBadGateway, ProviderError},
+		{echo.ErrNotFound, http.StatusNotFound, NotFound},
+		{echo.ErrMethodNotAllowed, http.StatusMethodNotAllowed, MethodNotAllowed},
+		{echo.ErrTooManyRequests, http.StatusTooManyRequests, TooManyRequests},
+		{errors.New("connection refused"), http.StatusInternalServerError, InternalError},
+	}
+
+	for _, test := range tests {
+		apiErr := From(test.err)
+		require.Equal(test.status, apiErr.Status, test.err.Error())
+		require.Equal(test.code, apiErr.Code, test.err.Error())
+	}
+}
+
+func (suite *HandlerTestSuite) TestHTTPErrorHandler_Envelope() {
+	require := suite.Require()
+
+	res := suite.CallHandler(http.MethodGet, ErrTicketNotFound)
+	require.Equal(http.StatusNotFound, res.Code)
+	require.Equal("{\"error\":{\"code\":\"TICKET_NOT_FOUND\",\"message\":\"Ticket not found\"}}\n", res.Body.String())
+}
+
+func (suite *HandlerTestSuite) TestHTTPErrorHandler_HidesCause() {
+	require := suite.Require()
+
+	res := suite.CallHandler(http.MethodGet, errors.New("pq: password authentication failed"))
+	require.Equal(http.StatusInternalServerError, res.Code)
+	require.Equal("{\"error\":{\"code\":\"INTERNAL_ERROR\",\"message\":\"Internal server error\"}}\n", res.Body.String())
+}
+
+func (suite *HandlerTestSuite) TestHTTPErrorHandler_ValidationFields() {
+	require := suite.Require()
+
+	err := &utils.ValidationError{Fields: []utils.FieldError{
+		{Field: "email", Rule: "required"},
+		{Field: "passengers", Rule: "min", Param: "1"},
+	}}
+
+	res := suite.CallHandler(http.MethodPost, err)
+	require.Equal(http.StatusBadRequest, res.Code)
+
+	var response Response
+	require.NoError(json.Unmarshal(res.Body.Bytes(), &response))
+	require.Equal(ValidationFailed, response.Error.Code)
+	require.Equal([]FieldError{
+		{Field: "email", Rule: "required", Message: "is required"},
+		{Field: "passengers", Rule: "min", Message: "must be at least 1"},
+	}, response.Error.Fields)
+}
+
+func (suite *HandlerTestSuite) TestHTTPErrorHandler_Head() {
+	require := suite.Require()
+
+	res := suite.CallHandler(http.MethodHead, ErrTicketNotFound)
+	require.Equal(http.StatusNotFound, res.Code)
+	require.Empty(res.Body.String())
+}
+
+func (suite *HandlerTestSuite) TestHTTPErrorHandler_Committed() {
+	require := suite.Require()
+
+	req := httptest.NewRequest(http.MethodGet, "/tickets", nil)
+	res := httptest.NewRecorder()
+	ctx := suite.e.NewContext(req, res)
+	require.NoError(ctx.JSON(http.StatusOK, map[string]int{"ticket_id": 5}))
+
+	HTTPErrorHandler(ErrInternal, ctx)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal("{\"ticket_id\":5}\n", res.Body.String())
+}
+
+func TestHandler(t *testing.T) {
+	suite.Run(t, new(HandlerTestSuite))
+}
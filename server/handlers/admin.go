@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"on-air/models"
+	"on-air/repository"
+	"on-air/server/apierror"
+	"on-air/server/middlewares"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const defaultSearchLimit = 20
+
+// Admin is the API of the staff, every action is recorded with the staff
+// member who made it.
+type Admin struct {
+	DB     *gorm.DB
+	Outbox *repository.Outbox
+}
+
+type SearchUsersRequest struct {
+	Email  string `query:"email"`
+	Role   string `query:"role" validate:"omitempty,oneof=customer support finance admin"`
+	Limit  int    `query:"limit" validate:"omitempty,min=1,max=100"`
+	Offset int    `query:"offset" validate:"min=0"`
+}
+
+type AdminUserResponse struct {
+	ID          uint   `json:"id"`
+	Email       string `json:"email"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	PhoneNumber string `json:"phone_number"`
+	Role        string `json:"role"`
+	CreatedAt   string `json:"created_at"`
+}
+
+func (a *Admin) SearchUsers(ctx echo.Context) error {
+	var req SearchUsersRequest
+	if err := ctx.Bind(&req); err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	if req.Limit == 0 {
+		req.Limit = defaultSearchLimit
+	}
+
+	users, err := repository.SearchUsers(ctx.Request().Context(), a.DB, repository.UserFilter{
+		Email:  req.Email,
+		Role:   models.Role(req.Role),
+		Limit:  req.Limit,
+		Offset: req.Offset,
+	})
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("admin_handler: SearchUsers failed when use repository.SearchUsers")
+		return err
+	}
+
+	response := make([]AdminUserResponse, 0, len(users))
+	for _, user := range users {
+		response = append(response, newAdminUserResponse(user))
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+type ChangeRoleRequest struct {
+	Role string `json:"role" validate:"required,oneof=customer support finance admin"`
+}
+
+func (a *Admin) ChangeRole(ctx echo.Context) error {
+	staffID, _ := ctx.Get("user_id").(int)
+	userID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	var req ChangeRoleRequest
+	if err := ctx.Bind(&req); err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	user, err := repository.GetUserByID(ctx.Request().Context(), a.DB, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return apierror.ErrUserNotFound
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("admin_handler: ChangeRole failed when use repository.GetUserByID")
+		return err
+	}
+
+	from := user.Role
+	err = repository.ChangeUserRole(ctx.Request().Context(), a.DB, user, models.Role(req.Role))
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("admin_handler: ChangeRole failed when use repository.ChangeUserRole")
+		return err
+	}
+
+	middlewares.Logger(ctx).
+		WithField("staff_id", staffID).
+		WithField("target_user_id", userID).
+		WithField("from", from).
+		WithField("to", req.Role).
+		Info("admin_handler: role changed")
+
+	return ctx.JSON(http.StatusOK, newAdminUserResponse(*user))
+}
+
+func newAdminUserResponse(user models.User) AdminUserResponse {
+	return AdminUserResponse{
+		ID:          user.ID,
+		Email:       user.Email,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		PhoneNumber: user.PhoneNumber,
+		Role:        user.Role,
+		CreatedAt:   user.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+type AdminTicketResponse struct {
+	TicketResponse
+	UserID    uint   `json:"user_id"`
+	ExpiresAt string `json:"expires_at"`
+	Extended  bool   `json:"extended"`
+}
+
+func (a *Admin) GetTicket(ctx echo.Context) error {
+	ticket, err := a.getTicket(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, AdminTicketResponse{
+		TicketResponse: newTicketResponse(ticket),
+		UserID:         ticket.UserID,
+		ExpiresAt:      ticket.ExpiresAt.Format(time.RFC3339),
+		Extended:       ticket.Extended,
+	})
+}
+
+type StaffActionRequest struct {
+	Reason string `json:"reason" validate:"max=255"`
+}
+
+type TicketStatusResponse struct {
+	TicketID uint   `json:"ticket_id"`
+	Status   string `json:"status"`
+}
+
+// ExpireTicket ends the hold of a reserved ticket right away, e.g. when the
+// user asks support to release the seats.
+func (a *Admin) ExpireTicket(ctx echo.Context) error {
+	staffID, _ := ctx.Get("user_id").(int)
+	req, err := bindStaffAction(ctx, "expired by staff")
+	if err != nil {
+		return err
+	}
+
+	ticket, err := a.getTicket(ctx)
+	if err != nil {
+		return err
+	}
+
+	if ticket.Status != string(models.Reserved) {
+		return apierror.ErrTicketNotExpirable
+	}
+
+	message, err := repository.ExpireTicket(ctx.Request().Context(), a.DB, &ticket, a.Outbox.Backoff, models.StaffActor(staffID), req.Reason)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("admin_handler: ExpireTicket failed when use repository.ExpireTicket")
+		return err
+	}
+
+	dispatchRefunds(ctx.Request().Context(), a.Outbox, message)
+
+	return ctx.JSON(http.StatusOK, TicketStatusResponse{
+		TicketID: ticket.ID,
+		Status:   string(models.TicketExpired),
+	})
+}
+
+// CancelTicket cancels any ticket the way its user would, with the same
+// refund.
+func (a *Admin) CancelTicket(ctx echo.Context) error {
+	staffID, _ := ctx.Get("user_id").(int)
+	req, err := bindStaffAction(ctx, "cancelled by staff")
+	if err != nil {
+		return err
+	}
+
+	ticket, err := a.getTicket(ctx)
+	if err != nil {
+		return err
+	}
+
+	refundAmount, err := cancelTicket(ctx, a.DB, a.Outbox, &ticket, models.StaffActor(staffID), req.Reason)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, CancelResponse{
+		TicketID:     ticket.ID,
+		Status:       string(models.TicketCancelled),
+		RefundAmount: refundAmount,
+	})
+}
+
+type RefundRequest struct {
+	Amount int `json:"amount" validate:"min=0"`
+}
+
+type RefundResponse struct {
+	TicketID       uint `json:"ticket_id"`
+	PaymentID      uint `json:"payment_id"`
+	RefundAmount   int  `json:"refund_amount"`
+	RefundedAmount int  `json:"refunded_amount"`
+}
+
+// RefundTicket refunds the verified payment of the ticket without cancelling
+// it, a missing or zero amount refunds what is left of the payment. The refund
+// is recorded right away and made through the gateway by the worker.
+func (a *Admin) RefundTicket(ctx echo.Context) error {
+	staffID, _ := ctx.Get("user_id").(int)
+	var req RefundRequest
+	if err := ctx.Bind(&req); err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	ticket, err := a.getTicket(ctx)
+	if err != nil {
+		return err
+	}
+
+	payment, refundAmount, _, err := repository.RefundTicket(ctx.Request().Context(), a.DB, ticket.ID, req.Amount, a.Outbox.Backoff)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return apierror.ErrPaymentNotFound.Wrap(err)
+	}
+
+	if errors.Is(err, repository.ErrRefundExceedsPayment) {
+		return apierror.ErrRefundExceedsPayment.Wrap(err)
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("admin_handler: RefundTicket failed when use repository.RefundTicket")
+		return err
+	}
+
+	middlewares.Logger(ctx).
+		WithField("staff_id", staffID).
+		WithField("ticket_id", ticket.ID).
+		WithField("payment_id", payment.ID).
+		WithField("amount", refundAmount).
+		Info("admin_handler: payment refund enqueued")
+
+	return ctx.JSON(http.StatusAccepted, RefundResponse{
+		TicketID:       ticket.ID,
+		PaymentID:      payment.ID,
+		RefundAmount:   refundAmount,
+		RefundedAmount: payment.RefundedAmount,
+	})
+}
+
+func (a *Admin) getTicket(ctx echo.Context) (models.Ticket, error) {
+	ticketID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return models.Ticket{}, apierror.ErrInvalidTicketID.Wrap(err)
+	}
+
+	ticket, err := repository.GetTicketByID(ctx.Request().Context(), a.DB, ticketID)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("admin_handler: get ticket failed when use repository.GetTicketByID")
+		return models.Ticket{}, err
+	}
+
+	if ticket.ID == 0 {
+		return models.Ticket{}, apierror.ErrTicketNotFound
+	}
+
+	return ticket, nil
+}
+
+func bindStaffAction(ctx echo.Context, defaultReason string) (*StaffActionRequest, error) {
+	var req StaffActionRequest
+	if err := ctx.Bind(&req); err != nil {
+		return nil, apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return nil, err
+	}
+
+	if req.Reason == "" {
+		req.Reason = defaultReason
+	}
+
+	return &req, nil
+}
@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"on-air/models"
+	"on-air/repository"
+	"on-air/server/apierror"
+	"on-air/utils"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"bou.ke/monkey"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type AdminTestSuite struct {
+	suite.Suite
+	sqlMock sqlmock.Sqlmock
+	e       *echo.Echo
+	admin   *Admin
+	StaffID int
+}
+
+func (suite *AdminTestSuite) SetupTest() {
+	mockDB, sqlMock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: mockDB,
+	}))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.sqlMock = sqlMock
+	suite.admin = &Admin{
+		DB: db,
+		Outbox: &repository.Outbox{
+			DB:      db,
+			Backoff: time.Second,
+		},
+	}
+	suite.e = echo.New()
+	suite.e.HTTPErrorHandler = apierror.HTTPErrorHandler
+	suite.e.Validator = &utils.CustomValidator{Validator: validator.New()}
+	suite.StaffID = 2
+}
+
+func (suite *AdminTestSuite) CallHandler(handler echo.HandlerFunc, method string, target string, id string, requestBody string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(method, target, strings.NewReader(requestBody))
+	if requestBody != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+
+	res := httptest.NewRecorder()
+	c := suite.e.NewContext(req, res)
+	if id != "" {
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+	}
+
+	c.Set("user_id", suite.StaffID)
+	err := handler(c)
+	if err != nil {
+		c.Error(err)
+	}
+	return res, err
+}
+
+func (suite *AdminTestSuite) patchTicket(status models.TicketStatus) *monkey.PatchGuard {
+	return monkey.Patch(repository.GetTicketByID, func(ctx context.Context, db *gorm.DB, ticketID int) (models.Ticket, error) {
+		if ticketID != 7 {
+			return models.Ticket{}, nil
+		}
+
+		ticket := models.Ticket{UserID: 1, UnitPrice: 1000, Count: 2, Status: string(status)}
+		ticket.ID = 7
+		ticket.Flight.Number = "FL005"
+		return ticket, nil
+	})
+}
+
+func (suite *AdminTestSuite) patchDispatch(dispatched *uint) *monkey.PatchGuard {
+	return monkey.PatchInstanceMethod(
+		reflect.TypeOf(suite.admin.Outbox),
+		"Dispatch",
+		func(_ *repository.Outbox, ctx context.Context, id uint) (*models.OutboxMessage, error) {
+			*dispatched = id
+			return &models.OutboxMessage{Status: string(models.OutboxDelivered)}, nil
+		},
+	)
+}
+
+func (suite *AdminTestSuite) TestSearchUsers_Success() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE email ILIKE .* AND role = .* ORDER BY id LIMIT 20`).
+		WithArgs("%gmail%", string(models.RoleFinance)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).AddRow(3, "finance@gmail.com", string(models.RoleFinance)))
+
+	res, err := suite.CallHandler(suite.admin.SearchUsers, http.MethodGet, "/admin/users?email=gmail&role=finance", "", "")
+	require.NoError(err)
+	require.Equal(http.StatusOK, res.Code)
+
+	var users []AdminUserResponse
+	require.NoError(json.Unmarshal(res.Body.Bytes(), &users))
+	require.Len(users, 1)
+	require.Equal(uint(3), users[0].ID)
+	require.Equal(string(models.RoleFinance), users[0].Role)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *AdminTestSuite) TestSearchUsers_Failure_Validation() {
+	require := suite.Require()
+
+	res, err := suite.CallHandler(suite.admin.SearchUsers, http.MethodGet, "/admin/users?role=owner", "", "")
+	require.Error(err)
+	require.Equal(http.StatusBadRequest, res.Code)
+	require.Contains(res.Body.String(), `"field":"role"`)
+}
+
+func (suite *AdminTestSuite) TestChangeRole_Success() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE id = `).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).AddRow(5, "agent@gmail.com", string(models.RoleCustomer)))
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "users" SET "role"`).
+		WithArgs(string(models.RoleSupport), sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	res, err := suite.CallHandler(suite.admin.ChangeRole, http.MethodPut, "/admin/users/5/role", "5", `{"role": "support"}`)
+	require.NoError(err)
+	require.Equal(http.StatusOK, res.Code)
+	require.Contains(res.Body.String(), `"role":"support"`)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *AdminTestSuite) TestChangeRole_Failure_NotFound() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE id = `).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	res, err := suite.CallHandler(suite.admin.ChangeRole, http.MethodPut, "/admin/users/5/role", "5", `{"role": "admin"}`)
+	require.ErrorIs(err, apierror.ErrUserNotFound)
+	require.Equal(http.StatusNotFound, res.Code)
+}
+
+func (suite *AdminTestSuite) TestGetTicket_Success() {
+	require := suite.Require()
+
+	patch := suite.patchTicket(models.TicketPaid)
+	defer patch.Unpatch()
+
+	res, err := suite.CallHandler(suite.admin.GetTicket, http.MethodGet, "/admin/tickets/7", "7", "")
+	require.NoError(err)
+	require.Equal(http.StatusOK, res.Code)
+
+	var ticket AdminTicketResponse
+	require.NoError(json.Unmarshal(res.Body.Bytes(), &ticket))
+	require.Equal(uint(7), ticket.ID)
+	require.Equal(uint(1), ticket.UserID)
+	require.Equal(string(models.TicketPaid), ticket.Status)
+	require.Contains(res.Body.String(), `"user_id":1`)
+}
+
+func (suite *AdminTestSuite) TestGetTicket_Failure_NotFound() {
+	require := suite.Require()
+
+	patch := suite.patchTicket(models.TicketPaid)
+	defer patch.Unpatch()
+
+	res, err := suite.CallHandler(suite.admin.GetTicket, http.MethodGet, "/admin/tickets/8", "8", "")
+	require.ErrorIs(err, apierror.ErrTicketNotFound)
+	require.Equal(http.StatusNotFound, res.Code)
+}
+
+func (suite *AdminTestSuite) TestExpireTicket_Success() {
+	require := suite.Require()
+
+	patchGet := suite.patchTicket(models.Reserved)
+	defer patchGet.Unpatch()
+
+	var dispatched uint
+	patchDispatch := suite.patchDispatch(&dispatched)
+	defer patchDispatch.Unpatch()
+
+	var actor, reason string
+	patchExpire := monkey.Patch(repository.ExpireTicket, func(ctx context.Context, db *gorm.DB, ticket *models.Ticket, dispatchDelay time.Duration, a string, r string) (*models.OutboxMessage, error) {
+		actor, reason = a, r
+		message := &models.OutboxMessage{}
+		message.ID = 21
+		return message, nil
+	})
+	defer patchExpire.Unpatch()
+
+	res, err := suite.CallHandler(suite.admin.ExpireTicket, http.MethodPost, "/admin/tickets/7/expire", "7", `{"reason": "requested by the user"}`)
+	require.NoError(err)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal("{\"ticket_id\":7,\"status\":\"Expired\"}\n", res.Body.String())
+	require.Equal(models.StaffActor(suite.StaffID), actor)
+	require.Equal("requested by the user", reason)
+	require.Equal(uint(21), dispatched)
+}
+
+func (suite *AdminTestSuite) TestExpireTicket_Failure_NotReserved() {
+	require := suite.Require()
+
+	patch := suite.patchTicket(models.TicketPaid)
+	defer patch.Unpatch()
+
+	res, err := suite.CallHandler(suite.admin.ExpireTicket, http.MethodPost, "/admin/tickets/7/expire", "7", "")
+	require.ErrorIs(err, apierror.ErrTicketNotExpirable)
+	require.Equal(http.StatusConflict, res.Code)
+}
+
+func (suite *AdminTestSuite) TestCancelTicket_Success() {
+	require := suite.Require()
+
+	patchGet := suite.patchTicket(models.Reserved)
+	defer patchGet.Unpatch()
+
+	var dispatched uint
+	patchDispatch := suite.patchDispatch(&dispatched)
+	defer patchDispatch.Unpatch()
+
+	var actor, reason string
+	patchCancel := monkey.Patch(repository.CancelTicket, func(ctx context.Context, db *gorm.DB, ticket *models.Ticket, dispatchDelay time.Duration, a string, r string) (int, []*models.OutboxMessage, error) {
+		actor, reason = a, r
+		message := &models.OutboxMessage{}
+		message.ID = 22
+		return 0, []*models.OutboxMessage{message}, nil
+	})
+	defer patchCancel.Unpatch()
+
+	res, err := suite.CallHandler(suite.admin.CancelTicket, http.MethodPost, "/admin/tickets/7/cancel", "7", "")
+	require.NoError(err)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal("{\"ticket_id\":7,\"status\":\"Cancelled\",\"refund_amount\":0}\n", res.Body.String())
+	require.Equal(models.StaffActor(suite.StaffID), actor)
+	require.Equal("cancelled by staff", reason)
+	require.Equal(uint(22), dispatched)
+}
+
+func (suite *AdminTestSuite) TestRefundTicket_Success() {
+	require := suite.Require()
+
+	patchGet := suite.patchTicket(models.TicketPaid)
+	defer patchGet.Unpatch()
+
+	patchRefund := monkey.Patch(repository.RefundTicket, func(ctx context.Context, db *gorm.DB, ticketID uint, amount int, dispatchDelay time.Duration) (*models.Payment, int, *models.OutboxMessage, error) {
+		payment := &models.Payment{Amount: 2000, RefundedAmount: 500 + amount, TicketID: ticketID}
+		payment.ID = 3
+		return payment, amount, &models.OutboxMessage{}, nil
+	})
+	defer patchRefund.Unpatch()
+
+	expectedJSON, _ := json.Marshal(RefundResponse{
+		TicketID:       7,
+		PaymentID:      3,
+		RefundAmount:   300,
+		RefundedAmount: 800,
+	})
+
+	res, err := suite.CallHandler(suite.admin.RefundTicket, http.MethodPost, "/admin/tickets/7/refund", "7", `{"amount": 300}`)
+	require.NoError(err)
+	require.Equal(http.StatusAccepted, res.Code)
+	require.Equal(string(expectedJSON)+"\n", res.Body.String())
+}
+
+func (suite *AdminTestSuite) TestRefundTicket_Failure_ExceedsPayment() {
+	require := suite.Require()
+
+	patchGet := suite.patchTicket(models.TicketPaid)
+	defer patchGet.Unpatch()
+
+	patchRefund := monkey.Patch(repository.RefundTicket, func(ctx context.Context, db *gorm.DB, ticketID uint, amount int, dispatchDelay time.Duration) (*models.Payment, int, *models.OutboxMessage, error) {
+		return nil, 0, nil, fmt.Errorf("%w: %d of %d", repository.ErrRefundExceedsPayment, amount, 0)
+	})
+	defer patchRefund.Unpatch()
+
+	res, err := suite.CallHandler(suite.admin.RefundTicket, http.MethodPost, "/admin/tickets/7/refund", "7", `{"amount": 300}`)
+	require.ErrorIs(err, apierror.ErrRefundExceedsPayment)
+	require.Equal(http.StatusConflict, res.Code)
+	require.Contains(res.Body.String(), `"code":"REFUND_EXCEEDS_PAYMENT"`)
+}
+
+func TestAdmin(t *testing.T) {
+	suite.Run(t, new(AdminTestSuite))
+}
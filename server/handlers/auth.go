@@ -1,21 +1,38 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"net/url"
 	"on-air/config"
+	"on-air/mailer"
+	"on-air/models"
 	"on-air/repository"
+	"on-air/server/apierror"
+	"on-air/server/middlewares"
+	"on-air/sms"
 	"on-air/utils"
+	"strconv"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/labstack/echo/v4"
-	"github.com/sirupsen/logrus"
+	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
 const Bearer = "Bearer"
 
 type Auth struct {
-	DB  *gorm.DB
-	JWT *config.JWT
+	DB        *gorm.DB
+	Redis     *redis.Client
+	JWT       *config.JWT
+	Keys      *repository.TokenKeys
+	Account   *config.Account
+	Mailer    mailer.Mailer
+	SMS       sms.Sender
+	RateLimit *config.RateLimit
 }
 
 type LoginRequest struct {
@@ -24,39 +41,157 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type" binding:"required"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type" binding:"required"`
+	ExpiresIn    int    `json:"expires_in"`
 }
 
 func (a *Auth) Login(ctx echo.Context) error {
 	var req LoginRequest
 	if err := ctx.Bind(&req); err != nil {
-		return ctx.JSON(http.StatusBadRequest, "Bind Error")
+		return apierror.ErrInvalidRequest.Wrap(err)
 	}
 
 	if err := ctx.Validate(&req); err != nil {
-		return ctx.JSON(http.StatusBadRequest, err.Error())
+		return err
 	}
 
-	dbUser, err := repository.GetUserByEmail(a.DB, req.Email)
+	lockedFor, err := repository.LoginLockedFor(ctx.Request().Context(), a.Redis, req.Email)
 	if err != nil {
-		return ctx.JSON(http.StatusUnauthorized, "Invalid credentials")
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: Login failed when call repository.LoginLockedFor")
+	}
+
+	if lockedFor > 0 {
+		ctx.Response().Header().Set(middlewares.RetryAfterHeader, strconv.Itoa(int(math.Ceil(lockedFor.Seconds()))))
+		return apierror.ErrLoginLocked
+	}
+
+	dbUser, err := repository.GetUserByEmail(ctx.Request().Context(), a.DB, req.Email)
+	if err != nil {
+		a.recordLoginFailure(ctx, req.Email)
+		return apierror.ErrInvalidCredentials
 	}
 
 	err = utils.CheckPassword(req.Password, dbUser.Password)
 	if err != nil {
-		return ctx.JSON(http.StatusUnauthorized, "Invalid credentials")
+		a.recordLoginFailure(ctx, req.Email)
+		return apierror.ErrInvalidCredentials
+	}
+
+	err = repository.ResetLoginFailures(ctx.Request().Context(), a.Redis, req.Email)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: Login failed when call repository.ResetLoginFailures")
+	}
+
+	refreshToken, err := repository.CreateRefreshToken(ctx.Request().Context(), a.DB, a.JWT, int(dbUser.ID))
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: Login failed when call repository.CreateRefreshToken")
+		return err
+	}
+
+	return a.sendTokens(ctx, dbUser, refreshToken)
+}
+
+// recordLoginFailure counts a failed login of the email, unknown emails
+// included so a lockout does not tell which emails are registered. The
+// lockout is best effort, Redis failures are only logged.
+func (a *Auth) recordLoginFailure(ctx echo.Context, email string) {
+	locked, err := repository.RecordLoginFailure(ctx.Request().Context(), a.Redis, a.RateLimit, email)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: Login failed when call repository.RecordLoginFailure")
+		return
+	}
+
+	if locked {
+		middlewares.Logger(ctx).WithField("email", email).Warn("auth_handler: logins locked after repeated failures")
+	}
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token, the refresh token can only be used once. The user is read again so
+// the new access token carries their current role.
+func (a *Auth) Refresh(ctx echo.Context) error {
+	var req RefreshRequest
+	if err := ctx.Bind(&req); err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	userID, refreshToken, err := repository.RotateRefreshToken(ctx.Request().Context(), a.DB, a.JWT, req.RefreshToken)
+	if errors.Is(err, repository.ErrInvalidRefreshToken) || errors.Is(err, repository.ErrRefreshTokenReused) {
+		return apierror.ErrInvalidRefreshToken.Wrap(err)
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: Refresh failed when call repository.RotateRefreshToken")
+		return err
+	}
+
+	dbUser, err := repository.GetUserByID(ctx.Request().Context(), a.DB, userID)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: Refresh failed when call repository.GetUserByID")
+		return err
+	}
+
+	return a.sendTokens(ctx, dbUser, refreshToken)
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// Logout revokes the access token the request is authenticated with and the
+// refresh token of the session.
+func (a *Auth) Logout(ctx echo.Context) error {
+	var req LogoutRequest
+	if err := ctx.Bind(&req); err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	claims, ok := ctx.Get(middlewares.ClaimsContextField).(*repository.Claims)
+	if !ok {
+		return apierror.ErrUnauthorized
+	}
+
+	err := repository.RevokeRefreshToken(ctx.Request().Context(), a.DB, claims.UserID, req.RefreshToken)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: Logout failed when call repository.RevokeRefreshToken")
+		return err
 	}
 
-	accessToken, err := repository.CreateToken(a.JWT, int(dbUser.ID))
+	err = repository.RevokeToken(ctx.Request().Context(), a.Redis, claims)
 	if err != nil {
-		logrus.Error("auth_handler: Login failed when call repository.createToken, error:", err)
-		return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: Logout failed when call repository.RevokeToken")
+		return err
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (a *Auth) sendTokens(ctx echo.Context, user *models.User, refreshToken string) error {
+	accessToken, err := repository.CreateToken(a.Keys, int(user.ID), models.Role(user.Role))
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: issue tokens failed when call repository.CreateToken")
+		return err
 	}
 
 	return ctx.JSON(http.StatusOK, LoginResponse{
-		AccessToken: accessToken,
-		TokenType:   Bearer,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    Bearer,
+		ExpiresIn:    int(a.JWT.ExpiresIn.Seconds()),
 	})
 }
 
@@ -73,28 +208,387 @@ type RegisterResponse struct {
 func (a *Auth) Register(ctx echo.Context) error {
 	user := new(RegisterRequest)
 	if err := ctx.Bind(user); err != nil {
-		return ctx.JSON(http.StatusBadRequest, "Bind Error")
+		return apierror.ErrInvalidRequest.Wrap(err)
 	}
 
 	if err := ctx.Validate(user); err != nil {
-		return ctx.JSON(http.StatusBadRequest, err.Error())
+		return err
 	}
 
-	dbUser, _ := repository.GetUserByEmail(a.DB, user.Email)
+	dbUser, _ := repository.GetUserByEmail(ctx.Request().Context(), a.DB, user.Email)
 	if dbUser != nil {
-		return ctx.JSON(http.StatusBadRequest, "User exist")
+		return apierror.ErrUserDuplicate
+	}
+
+	dbUser, err := repository.RegisterUser(ctx.Request().Context(), a.DB, user.Email, user.Password)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return apierror.ErrUserDuplicate.Wrap(err)
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: Register failed when call repository.RegisterUser")
+		return err
 	}
 
-	_, err := repository.RegisterUser(a.DB, user.Email, user.Password)
+	// The user is registered by now, a failed email does not fail the
+	// registration.
+	err = a.sendVerificationEmail(ctx, dbUser)
 	if err != nil {
-		logrus.Error("auth_handler: Register failed when call repository.RegisterUser, error:", err)
-		return ctx.JSON(http.StatusBadRequest, "Internal server error")
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: Register failed when send the verification email")
 	}
 
 	res := RegisterResponse{
 		Status:  true,
-		Message: "Registration completed successfully.",
+		Message: "Registration completed successfully, check your email to verify it.",
 	}
 
 	return ctx.JSON(http.StatusCreated, res)
 }
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// VerifyEmail marks the email of the user as verified with the token mailed
+// to them on registration.
+func (a *Auth) VerifyEmail(ctx echo.Context) error {
+	var req VerifyEmailRequest
+	if err := ctx.Bind(&req); err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	err := repository.VerifyEmail(ctx.Request().Context(), a.DB, a.Account, req.Token)
+	if errors.Is(err, repository.ErrInvalidUserToken) {
+		return apierror.ErrInvalidUserToken.Wrap(err)
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: VerifyEmail failed when call repository.VerifyEmail")
+		return err
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+type ConfirmEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ConfirmEmail changes the email of the user to the new email the token was
+// mailed to.
+func (a *Auth) ConfirmEmail(ctx echo.Context) error {
+	var req ConfirmEmailRequest
+	if err := ctx.Bind(&req); err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	err := repository.ConfirmEmailChange(ctx.Request().Context(), a.DB, a.Account, req.Token)
+	if errors.Is(err, repository.ErrInvalidUserToken) {
+		return apierror.ErrInvalidUserToken.Wrap(err)
+	}
+
+	// The email may have been taken since the link was mailed.
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return apierror.ErrUserDuplicate.Wrap(err)
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: ConfirmEmail failed when call repository.ConfirmEmailChange")
+		return err
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ForgotPassword mails a password reset link to the user. It is accepted
+// whether the email is registered and the link sent or not, so it does not
+// tell which emails are registered.
+func (a *Auth) ForgotPassword(ctx echo.Context) error {
+	var req ForgotPasswordRequest
+	if err := ctx.Bind(&req); err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	dbUser, err := repository.GetUserByEmail(ctx.Request().Context(), a.DB, req.Email)
+	if err != nil {
+		return ctx.NoContent(http.StatusAccepted)
+	}
+
+	token, err := repository.CreateUserToken(ctx.Request().Context(), a.DB, a.Account, int(dbUser.ID), models.ResetPasswordPurpose)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: ForgotPassword failed when call repository.CreateUserToken")
+		return ctx.NoContent(http.StatusAccepted)
+	}
+
+	err = a.Mailer.Send(ctx.Request().Context(), mailer.Message{
+		To:      dbUser.Email,
+		Subject: "Reset your on-air password",
+		Body: "Someone asked to reset the password of your on-air account. If it was you, open the link below to choose a new password:\n\n" +
+			tokenLink(a.Account.ResetPasswordURL, token) +
+			"\n\nThe link works once and expires soon. If it was not you, ignore this email.",
+	})
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: ForgotPassword failed when call a.Mailer.Send")
+	}
+
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+}
+
+// ResetPassword sets a new password with the token of a reset link, the
+// sessions of the user end and they log in with the new password.
+func (a *Auth) ResetPassword(ctx echo.Context) error {
+	var req ResetPasswordRequest
+	if err := ctx.Bind(&req); err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	err := repository.ResetPassword(ctx.Request().Context(), a.DB, a.Account, req.Token, req.Password)
+	if errors.Is(err, repository.ErrInvalidUserToken) {
+		return apierror.ErrInvalidUserToken.Wrap(err)
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: ResetPassword failed when call repository.ResetPassword")
+		return err
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+type RequestOTPRequest struct {
+	PhoneNumber string `json:"phone_number" validate:"required"`
+}
+
+// RequestOTP texts a login code to the mobile number. Like ForgotPassword it
+// is accepted whether a user has the number or not.
+func (a *Auth) RequestOTP(ctx echo.Context) error {
+	var req RequestOTPRequest
+	if err := ctx.Bind(&req); err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	phoneNumber, err := normalizeMobileNumber(req.PhoneNumber)
+	if err != nil {
+		return err
+	}
+
+	err = checkOTPLock(ctx, a.Redis, phoneNumber)
+	if err != nil {
+		return err
+	}
+
+	code, err := repository.RequestOTP(ctx.Request().Context(), a.Redis, a.Account, phoneNumber)
+	if errors.Is(err, repository.ErrOTPTooSoon) {
+		return apierror.ErrOTPTooSoon.Wrap(err)
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: RequestOTP failed when call repository.RequestOTP")
+		return err
+	}
+
+	_, err = repository.GetUserByPhoneNumber(ctx.Request().Context(), a.DB, phoneNumber)
+	if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, repository.ErrPhoneNumberShared) {
+		return ctx.NoContent(http.StatusAccepted)
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: RequestOTP failed when call repository.GetUserByPhoneNumber")
+		return err
+	}
+
+	err = a.SMS.Send(ctx.Request().Context(), sms.Message{
+		To:   phoneNumber,
+		Text: fmt.Sprintf("Your on-air login code is %s. Do not share it with anyone.", code),
+	})
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: RequestOTP failed when call a.SMS.Send")
+	}
+
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+type VerifyOTPRequest struct {
+	PhoneNumber string `json:"phone_number" validate:"required"`
+	Code        string `json:"code" validate:"required,numeric,max=10"`
+}
+
+// VerifyOTP logs the user of the mobile number in with the code texted to
+// them, answering with the tokens Login answers with.
+func (a *Auth) VerifyOTP(ctx echo.Context) error {
+	var req VerifyOTPRequest
+	if err := ctx.Bind(&req); err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	phoneNumber, err := normalizeMobileNumber(req.PhoneNumber)
+	if err != nil {
+		return err
+	}
+
+	err = verifyOTP(ctx, a.Redis, a.Account, a.RateLimit, phoneNumber, req.Code)
+	if err != nil {
+		return err
+	}
+
+	// Codes are kept for numbers without a user too, so a right code alone
+	// does not log in.
+	dbUser, err := repository.GetUserByPhoneNumber(ctx.Request().Context(), a.DB, phoneNumber)
+	if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, repository.ErrPhoneNumberShared) {
+		return apierror.ErrInvalidOTP.Wrap(err)
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: VerifyOTP failed when call repository.GetUserByPhoneNumber")
+		return err
+	}
+
+	refreshToken, err := repository.CreateRefreshToken(ctx.Request().Context(), a.DB, a.JWT, int(dbUser.ID))
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: VerifyOTP failed when call repository.CreateRefreshToken")
+		return err
+	}
+
+	return a.sendTokens(ctx, dbUser, refreshToken)
+}
+
+// verifyOTP uses up the code of the mobile number. No code is checked while
+// the codes of the number are locked and every wrong one counts towards the
+// lock, so a number can not be guessed a few codes at a time by asking for
+// new ones. The lock is best effort, Redis failures are only logged.
+func verifyOTP(ctx echo.Context, redisClient *redis.Client, account *config.Account, rateLimit *config.RateLimit, phoneNumber string, code string) error {
+	err := checkOTPLock(ctx, redisClient, phoneNumber)
+	if err != nil {
+		return err
+	}
+
+	err = repository.VerifyOTP(ctx.Request().Context(), redisClient, account, phoneNumber, code)
+	if errors.Is(err, repository.ErrInvalidOTP) || errors.Is(err, repository.ErrOTPAttemptsExceeded) {
+		locked, lockErr := repository.RecordOTPFailure(ctx.Request().Context(), redisClient, rateLimit, phoneNumber)
+		if lockErr != nil {
+			middlewares.Logger(ctx).WithError(lockErr).Error("auth_handler: verify otp failed when call repository.RecordOTPFailure")
+		}
+
+		if locked {
+			middlewares.Logger(ctx).WithField("phone_number", phoneNumber).Warn("auth_handler: codes locked after repeated failures")
+		}
+	}
+
+	if errors.Is(err, repository.ErrInvalidOTP) {
+		return apierror.ErrInvalidOTP.Wrap(err)
+	}
+
+	if errors.Is(err, repository.ErrOTPAttemptsExceeded) {
+		return apierror.ErrOTPAttemptsExceeded.Wrap(err)
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: verify otp failed when call repository.VerifyOTP")
+		return err
+	}
+
+	err = repository.ResetOTPFailures(ctx.Request().Context(), redisClient, phoneNumber)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: verify otp failed when call repository.ResetOTPFailures")
+	}
+
+	return nil
+}
+
+// checkOTPLock refuses the codes of a mobile number locked by wrong ones.
+func checkOTPLock(ctx echo.Context, redisClient *redis.Client, phoneNumber string) error {
+	lockedFor, err := repository.OTPLockedFor(ctx.Request().Context(), redisClient, phoneNumber)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("auth_handler: check otp lock failed when call repository.OTPLockedFor")
+	}
+
+	if lockedFor > 0 {
+		ctx.Response().Header().Set(middlewares.RetryAfterHeader, strconv.Itoa(int(math.Ceil(lockedFor.Seconds()))))
+		return apierror.ErrOTPLocked
+	}
+
+	return nil
+}
+
+// normalizeMobileNumber returns the number in the form users are stored with,
+// or the validation error of phone_number.
+func normalizeMobileNumber(number string) (string, error) {
+	normalized, ok := utils.NormalizeMobileNumber(number)
+	if !ok {
+		return "", apierror.Validation(&utils.ValidationError{
+			Fields: []utils.FieldError{{Field: "phone_number", Rule: "mobile"}},
+		})
+	}
+
+	return normalized, nil
+}
+
+func (a *Auth) sendVerificationEmail(ctx echo.Context, user *models.User) error {
+	token, err := repository.CreateUserToken(ctx.Request().Context(), a.DB, a.Account, int(user.ID), models.VerifyEmailPurpose)
+	if err != nil {
+		return err
+	}
+
+	return a.Mailer.Send(ctx.Request().Context(), mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your on-air email",
+		Body: "Welcome to on-air! Open the link below to verify your email:\n\n" +
+			tokenLink(a.Account.VerifyEmailURL, token),
+	})
+}
+
+// tokenLink returns link with the token as its token query parameter.
+func tokenLink(link string, token string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link + "?token=" + url.QueryEscape(token)
+	}
+
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// JWKS publishes the public keys the access tokens are signed with, so other
+// services can verify them.
+func (a *Auth) JWKS(ctx echo.Context) error {
+	ctx.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return ctx.JSON(http.StatusOK, a.Keys.JWKS())
+}
@@ -1,13 +1,23 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"golang.org/x/crypto/bcrypt"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"on-air/config"
+	"on-air/mailer"
+	"on-air/models"
+	"on-air/repository"
+	"on-air/server/apierror"
+	"on-air/server/middlewares"
+	"on-air/sms"
 	"on-air/utils"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -15,8 +25,10 @@ import (
 	"bou.ke/monkey"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/go-playground/validator/v10"
+	"github.com/go-redis/redismock/v9"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/suite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -24,10 +36,23 @@ import (
 
 type AuthTestSuite struct {
 	suite.Suite
-	sqlMock  sqlmock.Sqlmock
-	e        *echo.Echo
-	endpoint string
-	auth     *Auth
+	sqlMock   sqlmock.Sqlmock
+	mockRedis redismock.ClientMock
+	e         *echo.Echo
+	endpoint  string
+	auth      *Auth
+	mailer    *MockMailer
+	sms       *sms.FakeSender
+}
+
+// MockMailer keeps the messages instead of sending them.
+type MockMailer struct {
+	Messages []mailer.Message
+}
+
+func (m *MockMailer) Send(_ context.Context, message mailer.Message) error {
+	m.Messages = append(m.Messages, message)
+	return nil
 }
 
 func (suite *AuthTestSuite) SetupSuite() {
@@ -44,13 +69,46 @@ func (suite *AuthTestSuite) SetupSuite() {
 		log.Fatal(err)
 	}
 
+	redisClient, mockRedis := redismock.NewClientMock()
+
 	suite.sqlMock = sqlMock
-	suite.auth = &Auth{DB: db, JWT: &config.JWT{
-		SecretKey: "testSecret",
-		ExpiresIn: time.Minute * 3,
-	}}
+	suite.mockRedis = mockRedis
+	jwtConfig := &config.JWT{
+		SecretKey:        "testSecret",
+		ExpiresIn:        time.Minute * 3,
+		RefreshExpiresIn: time.Hour,
+	}
+	tokenKeys, err := repository.NewTokenKeys(jwtConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.mailer = &MockMailer{}
+	suite.sms = &sms.FakeSender{}
+	suite.auth = &Auth{
+		DB:     db,
+		Redis:  redisClient,
+		JWT:    jwtConfig,
+		Keys:   tokenKeys,
+		Mailer: suite.mailer,
+		SMS:    suite.sms,
+		RateLimit: &config.RateLimit{
+			MaxLoginFailures:   5,
+			LoginFailureWindow: 15 * time.Minute,
+			LockoutDuration:    15 * time.Minute,
+			MaxOTPFailures:     3,
+			OTPFailureWindow:   time.Hour,
+			OTPLockoutDuration: time.Hour,
+		},
+		Account: &config.Account{
+			TokenSecret:      "tokenSecret",
+			VerifyEmailURL:   "https://on-air.test/verify-email",
+			ResetPasswordURL: "https://on-air.test/reset-password",
+		},
+	}
 
 	suite.e = echo.New()
+	suite.e.HTTPErrorHandler = apierror.HTTPErrorHandler
 	suite.e.Validator = &utils.CustomValidator{Validator: validator.New()}
 	suite.endpoint = "/auth"
 }
@@ -58,6 +116,20 @@ func (suite *AuthTestSuite) SetupSuite() {
 func (suite *AuthTestSuite) SetupTest() {
 	suite.e.Validator = &utils.CustomValidator{Validator: validator.New()}
 	suite.e.Binder = &echo.DefaultBinder{}
+	suite.mailer.Messages = nil
+	suite.sms.Reset()
+}
+
+func (suite *AuthTestSuite) CallHandler(handler echo.HandlerFunc, endpoint string, requestBody string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPost, suite.endpoint+endpoint, strings.NewReader(requestBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	res := httptest.NewRecorder()
+	c := suite.e.NewContext(req, res)
+	err := handler(c)
+	if err != nil {
+		c.Error(err)
+	}
+	return res, err
 }
 
 func (suite *AuthTestSuite) CallRegisterHandler(requestBody string) (*httptest.ResponseRecorder, error) {
@@ -67,6 +139,9 @@ func (suite *AuthTestSuite) CallRegisterHandler(requestBody string) (*httptest.R
 	res := httptest.NewRecorder()
 	c := suite.e.NewContext(req, res)
 	err := suite.auth.Register(c)
+	if err != nil {
+		c.Error(err)
+	}
 	return res, err
 }
 
@@ -78,6 +153,40 @@ func (suite *AuthTestSuite) CallLoginHandler(requestBody string) (*httptest.Resp
 	res := httptest.NewRecorder()
 	c := suite.e.NewContext(req, res)
 	err := suite.auth.Login(c)
+	if err != nil {
+		c.Error(err)
+	}
+	return res, err
+}
+
+func (suite *AuthTestSuite) CallRefreshHandler(requestBody string) (*httptest.ResponseRecorder, error) {
+	endpoint := fmt.Sprintf("%s/refresh", suite.endpoint)
+
+	req := httptest.NewRequest(http.MethodPost, endpoint, strings.NewReader(requestBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	res := httptest.NewRecorder()
+	c := suite.e.NewContext(req, res)
+	err := suite.auth.Refresh(c)
+	if err != nil {
+		c.Error(err)
+	}
+	return res, err
+}
+
+func (suite *AuthTestSuite) CallLogoutHandler(requestBody string, claims *repository.Claims) (*httptest.ResponseRecorder, error) {
+	endpoint := fmt.Sprintf("%s/logout", suite.endpoint)
+
+	req := httptest.NewRequest(http.MethodPost, endpoint, strings.NewReader(requestBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	res := httptest.NewRecorder()
+	c := suite.e.NewContext(req, res)
+	if claims != nil {
+		c.Set(middlewares.ClaimsContextField, claims)
+	}
+	err := suite.auth.Logout(c)
+	if err != nil {
+		c.Error(err)
+	}
 	return res, err
 }
 
@@ -97,32 +206,39 @@ func (suite *AuthTestSuite) TestAuth_Register_Success() {
 	suite.sqlMock.ExpectBegin()
 	suite.sqlMock.ExpectQuery(
 		`INSERT`).
-		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	suite.sqlMock.ExpectCommit()
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "user_tokens" SET "used_at"`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.sqlMock.ExpectQuery(`INSERT INTO "user_tokens"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 	suite.sqlMock.ExpectCommit()
 
 	requestBody := `{"email": "admin@gmail.com" , "password" : "adminadmin"}`
 	res, err := suite.CallRegisterHandler(requestBody)
 	require.NoError(err)
 	require.Equal(expectedStatusCode, res.Code)
+	require.Len(suite.mailer.Messages, 1)
+	require.Contains(suite.mailer.Messages[0].Body, "https://on-air.test/verify-email?token=")
 }
 
 func (suite *AuthTestSuite) TestAuth_Register_Failure_Invalid_Body() {
 	require := suite.Require()
 	expectedStatusCode := http.StatusBadRequest
 
-	suite.e.Binder = &MockBinder{}
-
 	suite.e.Validator = &MockValidator{}
 
-	requestBody := `{}`
+	requestBody := `{"email": 1}`
 	res, err := suite.CallRegisterHandler(requestBody)
-	require.NoError(err)
+	require.ErrorIs(err, apierror.ErrInvalidRequest)
 	require.Equal(expectedStatusCode, res.Code)
+	require.Contains(res.Body.String(), `"code":"INVALID_REQUEST"`)
 }
 
 func (suite *AuthTestSuite) TestAuth_Register_Failure_Duplicate_User() {
 	require := suite.Require()
-	expectedStatusCode := http.StatusBadRequest
+	expectedStatusCode := http.StatusConflict
 
 	suite.e.Binder = &MockBinder{}
 
@@ -140,8 +256,9 @@ func (suite *AuthTestSuite) TestAuth_Register_Failure_Duplicate_User() {
 
 	requestBody := `{"email" : "admin@gmail.com" , "password" : "admin"}`
 	res, err := suite.CallRegisterHandler(requestBody)
-	require.NoError(err)
+	require.ErrorIs(err, apierror.ErrUserDuplicate)
 	require.Equal(expectedStatusCode, res.Code)
+	require.Contains(res.Body.String(), `"code":"USER_DUPLICATE"`)
 
 }
 
@@ -149,8 +266,6 @@ func (suite *AuthTestSuite) TestAuth_Login_Success() {
 	require := suite.Require()
 	expectedStatusCode := http.StatusOK
 
-	suite.e.Binder = &MockBinder{}
-
 	suite.e.Validator = &MockValidator{}
 
 	monkey.Patch(bcrypt.CompareHashAndPassword, func(_, _ []byte) error {
@@ -158,6 +273,7 @@ func (suite *AuthTestSuite) TestAuth_Login_Success() {
 	})
 	defer monkey.Unpatch(bcrypt.CompareHashAndPassword)
 
+	suite.mockRedis.ExpectPTTL("login_lock_admin@gmail.com").SetVal(-2)
 	mockUser := suite.sqlMock.NewRows(
 		[]string{
 			"id", "email", "password",
@@ -165,11 +281,615 @@ func (suite *AuthTestSuite) TestAuth_Login_Success() {
 		AddRow("1", "admin@gmail.com", "admin")
 	suite.sqlMock.ExpectQuery(`SELECT`).
 		WillReturnRows(mockUser)
+	suite.mockRedis.ExpectDel("login_failures_admin@gmail.com").SetVal(1)
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(`INSERT INTO "refresh_tokens"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	suite.sqlMock.ExpectCommit()
 
 	requestBody := `{"email": "admin@gmail.com" , "password" : "admin"}`
 	res, err := suite.CallLoginHandler(requestBody)
 	require.NoError(err)
 	require.Equal(expectedStatusCode, res.Code)
+
+	var response LoginResponse
+	require.NoError(json.Unmarshal(res.Body.Bytes(), &response))
+	require.NotEmpty(response.AccessToken)
+	require.NotEmpty(response.RefreshToken)
+	require.Equal(180, response.ExpiresIn)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_Login_Failure_WrongPassword() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusUnauthorized
+
+	monkey.Patch(bcrypt.CompareHashAndPassword, func(_, _ []byte) error {
+		return bcrypt.ErrMismatchedHashAndPassword
+	})
+	defer monkey.Unpatch(bcrypt.CompareHashAndPassword)
+
+	suite.mockRedis.ExpectPTTL("login_lock_admin@gmail.com").SetVal(-2)
+	suite.sqlMock.ExpectQuery(`SELECT`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password"}).AddRow(1, "admin@gmail.com", "hash"))
+	suite.mockRedis.ExpectTxPipeline()
+	suite.mockRedis.ExpectIncr("login_failures_admin@gmail.com").SetVal(1)
+	suite.mockRedis.ExpectPExpire("login_failures_admin@gmail.com", 15*time.Minute).SetVal(true)
+	suite.mockRedis.ExpectTxPipelineExec()
+
+	res, err := suite.CallLoginHandler(`{"email": "Admin@gmail.com", "password": "wrong"}`)
+	require.ErrorIs(err, apierror.ErrInvalidCredentials)
+	require.Equal(expectedStatusCode, res.Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_Login_Failure_Locked() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusTooManyRequests
+
+	suite.mockRedis.ExpectPTTL("login_lock_admin@gmail.com").SetVal(4*time.Minute + 30*time.Second)
+
+	res, err := suite.CallLoginHandler(`{"email": "admin@gmail.com", "password": "admin"}`)
+	require.ErrorIs(err, apierror.ErrLoginLocked)
+	require.Equal(expectedStatusCode, res.Code)
+	require.Equal("270", res.Header().Get(middlewares.RetryAfterHeader))
+	require.Contains(res.Body.String(), `"code":"TOO_MANY_REQUESTS"`)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_Refresh_Success() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusOK
+
+	mockToken := sqlmock.NewRows([]string{"id", "user_id", "token_hash", "expires_at"}).
+		AddRow(1, 7, "hash", time.Now().Add(time.Hour))
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "refresh_tokens" WHERE token_hash = .* FOR UPDATE`).
+		WillReturnRows(mockToken)
+	suite.sqlMock.ExpectQuery(`INSERT INTO "refresh_tokens"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	suite.sqlMock.ExpectExec(`UPDATE "refresh_tokens" SET .*"replaced_by_id"=.*"revoked_at"=`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE id = `).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).AddRow(7, "support@gmail.com", "support"))
+
+	res, err := suite.CallRefreshHandler(`{"refresh_token": "refresh"}`)
+	require.NoError(err)
+	require.Equal(expectedStatusCode, res.Code)
+
+	var response LoginResponse
+	require.NoError(json.Unmarshal(res.Body.Bytes(), &response))
+	require.NotEqual("refresh", response.RefreshToken)
+
+	claims, err := repository.VerifyToken(suite.auth.Keys, response.AccessToken)
+	require.NoError(err)
+	require.Equal(7, claims.UserID)
+	require.Equal(models.RoleSupport, claims.Role)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_Refresh_Failure_Reused() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusUnauthorized
+
+	mockToken := sqlmock.NewRows([]string{"id", "user_id", "token_hash", "expires_at", "revoked_at"}).
+		AddRow(1, 7, "hash", time.Now().Add(time.Hour), time.Now())
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "refresh_tokens"`).
+		WillReturnRows(mockToken)
+	suite.sqlMock.ExpectCommit()
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=.* WHERE \(user_id = .* AND revoked_at IS NULL\)`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	suite.sqlMock.ExpectCommit()
+
+	res, err := suite.CallRefreshHandler(`{"refresh_token": "stolen"}`)
+	require.ErrorIs(err, apierror.ErrInvalidRefreshToken)
+	require.Equal(expectedStatusCode, res.Code)
+	require.Equal("{\"error\":{\"code\":\"INVALID_REFRESH_TOKEN\",\"message\":\"Invalid refresh token\"}}\n", res.Body.String())
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_Refresh_Failure_Unknown() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusUnauthorized
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "refresh_tokens"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	suite.sqlMock.ExpectRollback()
+
+	res, err := suite.CallRefreshHandler(`{"refresh_token": "unknown"}`)
+	require.ErrorIs(err, apierror.ErrInvalidRefreshToken)
+	require.Equal(expectedStatusCode, res.Code)
+}
+
+func (suite *AuthTestSuite) TestAuth_Refresh_Failure_Validation() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusBadRequest
+
+	res, err := suite.CallRefreshHandler(`{}`)
+	require.Error(err)
+	require.Equal(expectedStatusCode, res.Code)
+	require.Contains(res.Body.String(), `"field":"refresh_token"`)
+}
+
+func (suite *AuthTestSuite) TestAuth_Logout_Success() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusNoContent
+
+	accessToken, err := repository.CreateToken(suite.auth.Keys, 7, models.RoleCustomer)
+	require.NoError(err)
+	claims, err := repository.VerifyToken(suite.auth.Keys, accessToken)
+	require.NoError(err)
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=.* WHERE \(token_hash = .* AND user_id = .* AND revoked_at IS NULL\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+	suite.mockRedis.CustomMatch(func(expected, actual []interface{}) error {
+		require.Equal("set", actual[0])
+		require.Equal("revoked_token_"+claims.ID, actual[1])
+		return nil
+	}).ExpectSet("revoked_token_"+claims.ID, 1, suite.auth.JWT.ExpiresIn).SetVal("OK")
+
+	res, err := suite.CallLogoutHandler(`{"refresh_token": "refresh"}`, claims)
+	require.NoError(err)
+	require.Equal(expectedStatusCode, res.Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_Logout_Failure_Unauthenticated() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusUnauthorized
+
+	res, err := suite.CallLogoutHandler(`{"refresh_token": "refresh"}`, nil)
+	require.ErrorIs(err, apierror.ErrUnauthorized)
+	require.Equal(expectedStatusCode, res.Code)
+}
+
+func (suite *AuthTestSuite) TestAuth_VerifyEmail_Success() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusNoContent
+
+	monkey.Patch(repository.VerifyEmail, func(_ context.Context, _ *gorm.DB, _ *config.Account, token string) error {
+		require.Equal("token", token)
+		return nil
+	})
+	defer monkey.Unpatch(repository.VerifyEmail)
+
+	res, err := suite.CallHandler(suite.auth.VerifyEmail, "/verify-email", `{"token": "token"}`)
+	require.NoError(err)
+	require.Equal(expectedStatusCode, res.Code)
+}
+
+func (suite *AuthTestSuite) TestAuth_VerifyEmail_Failure_InvalidToken() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusBadRequest
+
+	monkey.Patch(repository.VerifyEmail, func(_ context.Context, _ *gorm.DB, _ *config.Account, _ string) error {
+		return repository.ErrInvalidUserToken
+	})
+	defer monkey.Unpatch(repository.VerifyEmail)
+
+	res, err := suite.CallHandler(suite.auth.VerifyEmail, "/verify-email", `{"token": "used"}`)
+	require.ErrorIs(err, apierror.ErrInvalidUserToken)
+	require.Equal(expectedStatusCode, res.Code)
+	require.Contains(res.Body.String(), `"code":"INVALID_TOKEN"`)
+}
+
+func (suite *AuthTestSuite) TestAuth_ConfirmEmail_Success() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusNoContent
+
+	monkey.Patch(repository.ConfirmEmailChange, func(_ context.Context, _ *gorm.DB, _ *config.Account, token string) error {
+		require.Equal("token", token)
+		return nil
+	})
+	defer monkey.Unpatch(repository.ConfirmEmailChange)
+
+	res, err := suite.CallHandler(suite.auth.ConfirmEmail, "/confirm-email", `{"token": "token"}`)
+	require.NoError(err)
+	require.Equal(expectedStatusCode, res.Code)
+}
+
+func (suite *AuthTestSuite) TestAuth_ConfirmEmail_Failure_InvalidToken() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusBadRequest
+
+	monkey.Patch(repository.ConfirmEmailChange, func(_ context.Context, _ *gorm.DB, _ *config.Account, _ string) error {
+		return repository.ErrInvalidUserToken
+	})
+	defer monkey.Unpatch(repository.ConfirmEmailChange)
+
+	res, err := suite.CallHandler(suite.auth.ConfirmEmail, "/confirm-email", `{"token": "used"}`)
+	require.ErrorIs(err, apierror.ErrInvalidUserToken)
+	require.Equal(expectedStatusCode, res.Code)
+}
+
+func (suite *AuthTestSuite) TestAuth_ConfirmEmail_Failure_EmailTaken() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusConflict
+
+	monkey.Patch(repository.ConfirmEmailChange, func(_ context.Context, _ *gorm.DB, _ *config.Account, _ string) error {
+		return &pgconn.PgError{Code: "23505"}
+	})
+	defer monkey.Unpatch(repository.ConfirmEmailChange)
+
+	res, err := suite.CallHandler(suite.auth.ConfirmEmail, "/confirm-email", `{"token": "token"}`)
+	require.ErrorIs(err, apierror.ErrUserDuplicate)
+	require.Equal(expectedStatusCode, res.Code)
+}
+
+func (suite *AuthTestSuite) TestAuth_ForgotPassword_Success() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusAccepted
+
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE email = `).
+		WithArgs("admin@gmail.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(1, "admin@gmail.com"))
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "user_tokens" SET "used_at"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectQuery(`INSERT INTO "user_tokens"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	suite.sqlMock.ExpectCommit()
+
+	res, err := suite.CallHandler(suite.auth.ForgotPassword, "/forgot-password", `{"email": "admin@gmail.com"}`)
+	require.NoError(err)
+	require.Equal(expectedStatusCode, res.Code)
+	require.Len(suite.mailer.Messages, 1)
+	require.Equal("admin@gmail.com", suite.mailer.Messages[0].To)
+	require.Contains(suite.mailer.Messages[0].Body, "https://on-air.test/reset-password?token=")
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_ForgotPassword_UnknownEmail() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusAccepted
+
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE email = `).
+		WithArgs("unknown@gmail.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	res, err := suite.CallHandler(suite.auth.ForgotPassword, "/forgot-password", `{"email": "unknown@gmail.com"}`)
+	require.NoError(err)
+	require.Equal(expectedStatusCode, res.Code)
+	require.Empty(suite.mailer.Messages)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_ForgotPassword_Failure_Validation() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusBadRequest
+
+	res, err := suite.CallHandler(suite.auth.ForgotPassword, "/forgot-password", `{"email": "admin"}`)
+	require.Error(err)
+	require.Equal(expectedStatusCode, res.Code)
+	require.Contains(res.Body.String(), `"field":"email"`)
+}
+
+func (suite *AuthTestSuite) TestAuth_ResetPassword_Success() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusNoContent
+
+	monkey.Patch(repository.ResetPassword, func(_ context.Context, _ *gorm.DB, _ *config.Account, token string, password string) error {
+		require.Equal("token", token)
+		require.Equal("newPassword@123", password)
+		return nil
+	})
+	defer monkey.Unpatch(repository.ResetPassword)
+
+	res, err := suite.CallHandler(suite.auth.ResetPassword, "/reset-password", `{"token": "token", "password": "newPassword@123"}`)
+	require.NoError(err)
+	require.Equal(expectedStatusCode, res.Code)
+}
+
+func (suite *AuthTestSuite) TestAuth_ResetPassword_Failure_Validation() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusBadRequest
+
+	res, err := suite.CallHandler(suite.auth.ResetPassword, "/reset-password", `{"token": "token", "password": "short"}`)
+	require.Error(err)
+	require.Equal(expectedStatusCode, res.Code)
+	require.Contains(res.Body.String(), `"field":"password"`)
+}
+
+func (suite *AuthTestSuite) TestAuth_ResetPassword_Failure_InvalidToken() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusBadRequest
+
+	monkey.Patch(repository.ResetPassword, func(_ context.Context, _ *gorm.DB, _ *config.Account, _ string, _ string) error {
+		return repository.ErrInvalidUserToken
+	})
+	defer monkey.Unpatch(repository.ResetPassword)
+
+	res, err := suite.CallHandler(suite.auth.ResetPassword, "/reset-password", `{"token": "expired", "password": "newPassword@123"}`)
+	require.ErrorIs(err, apierror.ErrInvalidUserToken)
+	require.Equal(expectedStatusCode, res.Code)
+}
+
+func (suite *AuthTestSuite) expectOTPUnlocked() {
+	suite.mockRedis.ExpectPTTL("otp_lock_09121234567").SetVal(-2)
+}
+
+func (suite *AuthTestSuite) expectOTPFailure(failures int64) {
+	suite.mockRedis.ExpectTxPipeline()
+	suite.mockRedis.ExpectIncr("otp_failures_09121234567").SetVal(failures)
+	suite.mockRedis.ExpectPExpire("otp_failures_09121234567", time.Hour).SetVal(true)
+	suite.mockRedis.ExpectTxPipelineExec()
+}
+
+func (suite *AuthTestSuite) TestAuth_RequestOTP_Success() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusAccepted
+
+	monkey.Patch(repository.RequestOTP, func(_ context.Context, _ *redis.Client, _ *config.Account, phoneNumber string) (string, error) {
+		require.Equal("09121234567", phoneNumber)
+		return "01234", nil
+	})
+	defer monkey.Unpatch(repository.RequestOTP)
+
+	suite.expectOTPUnlocked()
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE phone_number = `).
+		WithArgs("09121234567").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "phone_number"}).AddRow(1, "09121234567"))
+
+	res, err := suite.CallHandler(suite.auth.RequestOTP, "/otp/request", `{"phone_number": "+98 912 123 4567"}`)
+	require.NoError(err)
+	require.Equal(expectedStatusCode, res.Code)
+	require.Len(suite.sms.Messages(), 1)
+	require.Equal("09121234567", suite.sms.Messages()[0].To)
+	require.Contains(suite.sms.Messages()[0].Text, "01234")
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_RequestOTP_UnknownNumber() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusAccepted
+
+	monkey.Patch(repository.RequestOTP, func(_ context.Context, _ *redis.Client, _ *config.Account, _ string) (string, error) {
+		return "01234", nil
+	})
+	defer monkey.Unpatch(repository.RequestOTP)
+
+	suite.expectOTPUnlocked()
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE phone_number = `).
+		WithArgs("09121234567").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	res, err := suite.CallHandler(suite.auth.RequestOTP, "/otp/request", `{"phone_number": "09121234567"}`)
+	require.NoError(err)
+	require.Equal(expectedStatusCode, res.Code)
+	require.Empty(suite.sms.Messages())
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_RequestOTP_SendFailed() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusAccepted
+
+	monkey.Patch(repository.RequestOTP, func(_ context.Context, _ *redis.Client, _ *config.Account, _ string) (string, error) {
+		return "01234", nil
+	})
+	defer monkey.Unpatch(repository.RequestOTP)
+
+	monkey.PatchInstanceMethod(reflect.TypeOf(suite.sms), "Send", func(_ *sms.FakeSender, _ context.Context, _ sms.Message) error {
+		return errors.New("sms provider down")
+	})
+	defer monkey.UnpatchInstanceMethod(reflect.TypeOf(suite.sms), "Send")
+
+	suite.expectOTPUnlocked()
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE phone_number = `).
+		WithArgs("09121234567").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "phone_number"}).AddRow(1, "09121234567"))
+
+	// Answered like an unknown number, so a failed send does not tell the
+	// number is registered.
+	res, err := suite.CallHandler(suite.auth.RequestOTP, "/otp/request", `{"phone_number": "09121234567"}`)
+	require.NoError(err)
+	require.Equal(expectedStatusCode, res.Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_RequestOTP_Failure_TooSoon() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusTooManyRequests
+
+	monkey.Patch(repository.RequestOTP, func(_ context.Context, _ *redis.Client, _ *config.Account, _ string) (string, error) {
+		return "", repository.ErrOTPTooSoon
+	})
+	defer monkey.Unpatch(repository.RequestOTP)
+
+	suite.expectOTPUnlocked()
+
+	res, err := suite.CallHandler(suite.auth.RequestOTP, "/otp/request", `{"phone_number": "09121234567"}`)
+	require.ErrorIs(err, apierror.ErrOTPTooSoon)
+	require.Equal(expectedStatusCode, res.Code)
+	require.Contains(res.Body.String(), `"code":"TOO_MANY_REQUESTS"`)
+	require.Empty(suite.sms.Messages())
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_RequestOTP_Failure_Validation() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusBadRequest
+
+	res, err := suite.CallHandler(suite.auth.RequestOTP, "/otp/request", `{"phone_number": "02112345678"}`)
+	require.Error(err)
+	require.Equal(expectedStatusCode, res.Code)
+	require.Contains(res.Body.String(), `"field":"phone_number","rule":"mobile"`)
+}
+
+func (suite *AuthTestSuite) TestAuth_VerifyOTP_Success() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusOK
+
+	monkey.Patch(repository.VerifyOTP, func(_ context.Context, _ *redis.Client, _ *config.Account, phoneNumber string, code string) error {
+		require.Equal("09121234567", phoneNumber)
+		require.Equal("01234", code)
+		return nil
+	})
+	defer monkey.Unpatch(repository.VerifyOTP)
+
+	suite.expectOTPUnlocked()
+	suite.mockRedis.ExpectDel("otp_failures_09121234567").SetVal(1)
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE phone_number = `).
+		WithArgs("09121234567").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "phone_number", "role"}).AddRow(7, "09121234567", "customer"))
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(`INSERT INTO "refresh_tokens"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	suite.sqlMock.ExpectCommit()
+
+	res, err := suite.CallHandler(suite.auth.VerifyOTP, "/otp/verify", `{"phone_number": "9121234567", "code": "01234"}`)
+	require.NoError(err)
+	require.Equal(expectedStatusCode, res.Code)
+
+	var response LoginResponse
+	require.NoError(json.Unmarshal(res.Body.Bytes(), &response))
+	require.NotEmpty(response.RefreshToken)
+
+	claims, err := repository.VerifyToken(suite.auth.Keys, response.AccessToken)
+	require.NoError(err)
+	require.Equal(7, claims.UserID)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_VerifyOTP_Failure_InvalidCode() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusUnauthorized
+
+	monkey.Patch(repository.VerifyOTP, func(_ context.Context, _ *redis.Client, _ *config.Account, _ string, _ string) error {
+		return repository.ErrInvalidOTP
+	})
+	defer monkey.Unpatch(repository.VerifyOTP)
+
+	suite.expectOTPUnlocked()
+	suite.expectOTPFailure(1)
+
+	res, err := suite.CallHandler(suite.auth.VerifyOTP, "/otp/verify", `{"phone_number": "09121234567", "code": "99999"}`)
+	require.ErrorIs(err, apierror.ErrInvalidOTP)
+	require.Equal(expectedStatusCode, res.Code)
+	require.Contains(res.Body.String(), `"code":"INVALID_OTP"`)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_VerifyOTP_Failure_AttemptsExceeded() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusUnauthorized
+
+	monkey.Patch(repository.VerifyOTP, func(_ context.Context, _ *redis.Client, _ *config.Account, _ string, _ string) error {
+		return repository.ErrOTPAttemptsExceeded
+	})
+	defer monkey.Unpatch(repository.VerifyOTP)
+
+	suite.expectOTPUnlocked()
+	suite.expectOTPFailure(1)
+
+	res, err := suite.CallHandler(suite.auth.VerifyOTP, "/otp/verify", `{"phone_number": "09121234567", "code": "99999"}`)
+	require.ErrorIs(err, apierror.ErrOTPAttemptsExceeded)
+	require.Equal(expectedStatusCode, res.Code)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_VerifyOTP_Failure_UnknownNumber() {
+	require := suite.Require()
+	expectedStatusCode := http.StatusUnauthorized
+
+	monkey.Patch(repository.VerifyOTP, func(_ context.Context, _ *redis.Client, _ *config.Account, _ string, _ string) error {
+		return nil
+	})
+	defer monkey.Unpatch(repository.VerifyOTP)
+
+	suite.expectOTPUnlocked()
+	suite.mockRedis.ExpectDel("otp_failures_09121234567").SetVal(0)
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE phone_number = `).
+		WithArgs("09121234567").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	res, err := suite.CallHandler(suite.auth.VerifyOTP, "/otp/verify", `{"phone_number": "09121234567", "code": "01234"}`)
+	require.ErrorIs(err, apierror.ErrInvalidOTP)
+	require.Equal(expectedStatusCode, res.Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_VerifyOTP_Failure_Locks() {
+	require := suite.Require()
+
+	monkey.Patch(repository.VerifyOTP, func(_ context.Context, _ *redis.Client, _ *config.Account, _ string, _ string) error {
+		return repository.ErrInvalidOTP
+	})
+	defer monkey.Unpatch(repository.VerifyOTP)
+
+	// The third wrong code, whichever codes they were for, locks the number.
+	suite.expectOTPUnlocked()
+	suite.expectOTPFailure(3)
+	suite.mockRedis.ExpectTxPipeline()
+	suite.mockRedis.ExpectSet("otp_lock_09121234567", 1, time.Hour).SetVal("OK")
+	suite.mockRedis.ExpectDel("otp_failures_09121234567").SetVal(1)
+	suite.mockRedis.ExpectTxPipelineExec()
+
+	res, err := suite.CallHandler(suite.auth.VerifyOTP, "/otp/verify", `{"phone_number": "09121234567", "code": "99999"}`)
+	require.ErrorIs(err, apierror.ErrInvalidOTP)
+	require.Equal(http.StatusUnauthorized, res.Code)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_VerifyOTP_Failure_Locked() {
+	require := suite.Require()
+
+	verified := false
+	monkey.Patch(repository.VerifyOTP, func(_ context.Context, _ *redis.Client, _ *config.Account, _ string, _ string) error {
+		verified = true
+		return nil
+	})
+	defer monkey.Unpatch(repository.VerifyOTP)
+
+	suite.mockRedis.ExpectPTTL("otp_lock_09121234567").SetVal(30*time.Minute + 500*time.Millisecond)
+
+	res, err := suite.CallHandler(suite.auth.VerifyOTP, "/otp/verify", `{"phone_number": "09121234567", "code": "01234"}`)
+	require.ErrorIs(err, apierror.ErrOTPLocked)
+	require.Equal(http.StatusTooManyRequests, res.Code)
+	require.Equal("1801", res.Header().Get(middlewares.RetryAfterHeader))
+	require.False(verified)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_RequestOTP_Failure_Locked() {
+	require := suite.Require()
+
+	suite.mockRedis.ExpectPTTL("otp_lock_09121234567").SetVal(time.Minute)
+
+	res, err := suite.CallHandler(suite.auth.RequestOTP, "/otp/request", `{"phone_number": "09121234567"}`)
+	require.ErrorIs(err, apierror.ErrOTPLocked)
+	require.Equal(http.StatusTooManyRequests, res.Code)
+	require.Empty(suite.sms.Messages())
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *AuthTestSuite) TestAuth_JWKS() {
+	require := suite.Require()
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	res := httptest.NewRecorder()
+	c := suite.e.NewContext(req, res)
+
+	require.NoError(suite.auth.JWKS(c))
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal("{\"keys\":[]}\n", res.Body.String())
+	require.Equal("public, max-age=300", res.Header().Get("Cache-Control"))
 }
 
 func TestAuth(t *testing.T) {
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"net/http"
 	"on-air/config"
+	"on-air/metrics"
+	"on-air/server/apierror"
+	"on-air/server/middlewares"
 	"on-air/server/services"
 	"sort"
 	"strconv"
@@ -13,7 +16,6 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"github.com/redis/go-redis/v9"
-	"github.com/sirupsen/logrus"
 )
 
 type Flight struct {
@@ -62,11 +64,11 @@ type GetFlightsResponse struct {
 func (f *Flight) GetFlights(ctx echo.Context) error {
 	var req GetFlightsRequest
 	if err := ctx.Bind(&req); err != nil {
-		return ctx.JSON(http.StatusBadRequest, "Bind Error")
+		return apierror.ErrInvalidRequest.Wrap(err)
 	}
 
 	if err := ctx.Validate(req); err != nil {
-		return ctx.JSON(http.StatusUnprocessableEntity, err.Error())
+		return err
 	}
 
 	var flights []services.FlightResponse
@@ -74,34 +76,38 @@ func (f *Flight) GetFlights(ctx echo.Context) error {
 	cashResult, err := f.Redis.Get(ctx.Request().Context(), redisKey).Result()
 
 	if err != nil && err != redis.Nil {
-		logrus.Error("flight_handler: GetFlights failed when use f.Redis.Get, error:", err)
-		return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+		middlewares.Logger(ctx).WithError(err).Error("flight_handler: GetFlights failed when use f.Redis.Get")
+		return err
 	} else if err == redis.Nil {
-		apiResult, err := f.APIMockClient.GetFlights(req.Origin, req.Destination, req.Date)
+		metrics.FlightsCacheRequests.WithLabelValues(metrics.CacheMiss).Inc()
+
+		apiResult, err := f.APIMockClient.GetFlights(ctx.Request().Context(), req.Origin, req.Destination, req.Date)
 		if err != nil {
-			logrus.Error("flight_handler: GetFlights failed when use f.APIMockClient.GetFlights, error:", err)
-			return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+			middlewares.Logger(ctx).WithError(err).Error("flight_handler: GetFlights failed when use f.APIMockClient.GetFlights")
+			return err
 		}
 
 		if len(apiResult) > 0 {
 			jsonData, err := json.Marshal(apiResult)
 			if err != nil {
-				logrus.Error("flight_handler: GetFlights failed when use json.Marshal, error:", err)
-				return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+				middlewares.Logger(ctx).WithError(err).Error("flight_handler: GetFlights failed when use json.Marshal")
+				return err
 			}
 
 			if err := f.Redis.Set(ctx.Request().Context(), redisKey, jsonData, f.Cache.TTL).Err(); err != nil {
-				logrus.Error("flight_handler: GetFlights failed when use f.Redis.Set, error:", err)
-				return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+				middlewares.Logger(ctx).WithError(err).Error("flight_handler: GetFlights failed when use f.Redis.Set")
+				return err
 			}
 
 			flights = apiResult
 		}
 
 	} else {
+		metrics.FlightsCacheRequests.WithLabelValues(metrics.CacheHit).Inc()
+
 		if err := json.Unmarshal([]byte(cashResult), &flights); err != nil {
-			logrus.Error("flight_handler: GetFlights failed when use json.Unmarshal, error:", err)
-			return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+			middlewares.Logger(ctx).WithError(err).Error("flight_handler: GetFlights failed when use json.Unmarshal")
+			return err
 		}
 	}
 
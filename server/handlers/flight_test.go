@@ -1,12 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"on-air/config"
+	"on-air/server/apierror"
 	"on-air/server/services"
 	"on-air/utils"
 	"reflect"
@@ -41,6 +43,9 @@ func (suite *FlightHandlerTestSuite) CallHandler(queryString string) (*httptest.
 	res := httptest.NewRecorder()
 	ctx := suite.e.NewContext(req, res)
 	err := suite.flight.GetFlights(ctx)
+	if err != nil {
+		ctx.Error(err)
+	}
 	return res, err
 }
 
@@ -49,6 +54,7 @@ func (suite *FlightHandlerTestSuite) SetupSuite() {
 	suite.redis = mockRedis
 	suite.mockRedis = mock
 	suite.e = echo.New()
+	suite.e.HTTPErrorHandler = apierror.HTTPErrorHandler
 	suite.endpoint = "/flights"
 	validator := validator.New()
 	validator.RegisterValidation("CustomTimeValidator", utils.CustomTimeValidator)
@@ -111,7 +117,7 @@ func (suite *FlightHandlerTestSuite) TestGetFlightsList_WithoutCache_Success() {
 	patch := monkey.PatchInstanceMethod(
 		reflect.TypeOf(suite.flight.APIMockClient),
 		"GetFlights",
-		func(_ *services.APIMockClient, origin, destination, date string) ([]services.FlightResponse, error) {
+		func(_ *services.APIMockClient, ctx context.Context, origin, destination, date string) ([]services.FlightResponse, error) {
 			return flights, nil
 		},
 	)
@@ -138,17 +144,24 @@ func (suite *FlightHandlerTestSuite) TestGetFlightsList_ParseReq_Failure() {
 	expectedStatusCode := http.StatusBadRequest
 	queryString := "?origin=Shiraz&destination=Esfahan&&date2023-06-27&empty_capacity=test"
 	res, err := suite.CallHandler(queryString)
-	require.NoError(err)
+	require.ErrorIs(err, apierror.ErrInvalidRequest)
 	require.Equal(expectedStatusCode, res.Code)
 }
 
 func (suite *FlightHandlerTestSuite) TestGetFlightsList_Validation_Failure() {
 	require := suite.Require()
-	expectedStatusCode := http.StatusUnprocessableEntity
-	queryParams := ""
+	expectedStatusCode := http.StatusBadRequest
+	queryParams := "?destination=Esfahan&date=2023-06-27"
 	res, err := suite.CallHandler(queryParams)
-	require.NoError(err)
+	require.Error(err)
 	require.Equal(expectedStatusCode, res.Code)
+
+	var response apierror.Response
+	require.NoError(json.Unmarshal(res.Body.Bytes(), &response))
+	require.Equal(apierror.ValidationFailed, response.Error.Code)
+	require.Equal([]apierror.FieldError{
+		{Field: "origin", Rule: "required", Message: "is required"},
+	}, response.Error.Fields)
 }
 
 func (suite *FlightHandlerTestSuite) TestGetFlightsList_GetFromWebService_Failure() {
@@ -159,7 +172,7 @@ func (suite *FlightHandlerTestSuite) TestGetFlightsList_GetFromWebService_Failur
 	patch := monkey.PatchInstanceMethod(
 		reflect.TypeOf(suite.flight.APIMockClient),
 		"GetFlights",
-		func(_ *services.APIMockClient, origin, destination, date string) ([]services.FlightResponse, error) {
+		func(_ *services.APIMockClient, ctx context.Context, origin, destination, date string) ([]services.FlightResponse, error) {
 			return nil, errors.New("error")
 		},
 	)
@@ -167,7 +180,7 @@ func (suite *FlightHandlerTestSuite) TestGetFlightsList_GetFromWebService_Failur
 
 	queryParams := "?origin=Shiraz&destination=Esfahan&date=2023-06-27"
 	res, err := suite.CallHandler(queryParams)
-	require.NoError(err)
+	require.Error(err)
 	require.Equal(expectedStatusCode, res.Code)
 }
 
@@ -180,7 +193,7 @@ func (suite *FlightHandlerTestSuite) TestGetFlightsList_FilterByAirline() {
 	patch := monkey.PatchInstanceMethod(
 		reflect.TypeOf(suite.flight.APIMockClient),
 		"GetFlights",
-		func(_ *services.APIMockClient, origin, destination, date string) ([]services.FlightResponse, error) {
+		func(_ *services.APIMockClient, ctx context.Context, origin, destination, date string) ([]services.FlightResponse, error) {
 			return flights, nil
 		},
 	)
@@ -201,7 +214,7 @@ func (suite *FlightHandlerTestSuite) TestGetFlightsList_FilterByAirplane() {
 	patch := monkey.PatchInstanceMethod(
 		reflect.TypeOf(suite.flight.APIMockClient),
 		"GetFlights",
-		func(_ *services.APIMockClient, origin, destination, date string) ([]services.FlightResponse, error) {
+		func(_ *services.APIMockClient, ctx context.Context, origin, destination, date string) ([]services.FlightResponse, error) {
 			return flights, nil
 		},
 	)
@@ -222,7 +235,7 @@ func (suite *FlightHandlerTestSuite) TestGetFlightsList_FilterTime() {
 	patch := monkey.PatchInstanceMethod(
 		reflect.TypeOf(suite.flight.APIMockClient),
 		"GetFlights",
-		func(_ *services.APIMockClient, origin, destination, date string) ([]services.FlightResponse, error) {
+		func(_ *services.APIMockClient, ctx context.Context, origin, destination, date string) ([]services.FlightResponse, error) {
 			return flights, nil
 		},
 	)
@@ -243,7 +256,7 @@ func (suite *FlightHandlerTestSuite) TestGetFlightsList_FilterByCapacity() {
 	patch := monkey.PatchInstanceMethod(
 		reflect.TypeOf(suite.flight.APIMockClient),
 		"GetFlights",
-		func(_ *services.APIMockClient, origin, destination, date string) ([]services.FlightResponse, error) {
+		func(_ *services.APIMockClient, ctx context.Context, origin, destination, date string) ([]services.FlightResponse, error) {
 			return flights, nil
 		},
 	)
@@ -264,7 +277,7 @@ func (suite *FlightHandlerTestSuite) TestGetFlightsList_SortByPrice() {
 	patch := monkey.PatchInstanceMethod(
 		reflect.TypeOf(suite.flight.APIMockClient),
 		"GetFlights",
-		func(_ *services.APIMockClient, origin, destination, date string) ([]services.FlightResponse, error) {
+		func(_ *services.APIMockClient, ctx context.Context, origin, destination, date string) ([]services.FlightResponse, error) {
 			return flights, nil
 		},
 	)
@@ -285,7 +298,7 @@ func (suite *FlightHandlerTestSuite) TestGetFlightsList_SortByTime() {
 	patch := monkey.PatchInstanceMethod(
 		reflect.TypeOf(suite.flight.APIMockClient),
 		"GetFlights",
-		func(_ *services.APIMockClient, origin, destination, date string) ([]services.FlightResponse, error) {
+		func(_ *services.APIMockClient, ctx context.Context, origin, destination, date string) ([]services.FlightResponse, error) {
 			return flights, nil
 		},
 	)
@@ -306,7 +319,7 @@ func (suite *FlightHandlerTestSuite) TestGetFlightsList_SortByDuration() {
 	patch := monkey.PatchInstanceMethod(
 		reflect.TypeOf(suite.flight.APIMockClient),
 		"GetFlights",
-		func(_ *services.APIMockClient, origin, destination, date string) ([]services.FlightResponse, error) {
+		func(_ *services.APIMockClient, ctx context.Context, origin, destination, date string) ([]services.FlightResponse, error) {
 			return flights, nil
 		},
 	)
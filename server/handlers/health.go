@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"on-air/logging"
+	"on-air/server/services"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+const (
+	healthOK          = "ok"
+	healthDegraded    = "degraded"
+	healthUnavailable = "unavailable"
+)
+
+type Health struct {
+	DB            *gorm.DB
+	Redis         *redis.Client
+	APIMockClient *services.APIMockClient
+	Timeout       time.Duration
+
+	shuttingDown atomic.Bool
+}
+
+type ReadyResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// ShutDown makes the server report not ready, so the load balancer stops
+// sending requests while the open ones finish.
+func (h *Health) ShutDown() {
+	h.shuttingDown.Store(true)
+}
+
+// Healthz reports that the process is alive, it does not look at the
+// dependencies so a database outage does not get the server restarted.
+func (h *Health) Healthz(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, healthOK)
+}
+
+// Readyz reports whether the server can take requests: Postgres and Redis
+// answer. An open circuit breaker of the flight provider only degrades the
+// server, the breaker closes again on the calls of a ready server and every
+// server shares the provider, so failing readiness would not help.
+func (h *Health) Readyz(ctx echo.Context) error {
+	if h.shuttingDown.Load() {
+		return ctx.JSON(http.StatusServiceUnavailable, ReadyResponse{
+			Status: healthUnavailable,
+			Checks: map[string]string{"server": "shutting down"},
+		})
+	}
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx.Request().Context(), timeout)
+	defer cancel()
+
+	response := ReadyResponse{
+		Status: healthOK,
+		Checks: map[string]string{
+			"postgres": h.checkPostgres(checkCtx),
+			"redis":    h.checkRedis(checkCtx),
+		},
+	}
+
+	for _, check := range response.Checks {
+		if check != healthOK {
+			response.Status = healthUnavailable
+		}
+	}
+
+	response.Checks["flight_provider"] = h.checkFlightProvider()
+	if response.Status == healthOK && response.Checks["flight_provider"] != healthOK {
+		response.Status = healthDegraded
+	}
+
+	if response.Status == healthUnavailable {
+		return ctx.JSON(http.StatusServiceUnavailable, response)
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+func (h *Health) checkPostgres(ctx context.Context) string {
+	sqlDB, err := h.DB.DB()
+	if err == nil {
+		err = sqlDB.PingContext(ctx)
+	}
+
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("health_handler: Readyz failed when ping postgres")
+		return healthUnavailable
+	}
+
+	return healthOK
+}
+
+func (h *Health) checkRedis(ctx context.Context) string {
+	err := h.Redis.Ping(ctx).Err()
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("health_handler: Readyz failed when ping redis")
+		return healthUnavailable
+	}
+
+	return healthOK
+}
+
+func (h *Health) checkFlightProvider() string {
+	if h.APIMockClient.BreakerOpen() {
+		return "circuit open"
+	}
+
+	return healthOK
+}
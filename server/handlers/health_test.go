@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"on-air/server/services"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/eapache/go-resiliency/breaker"
+	"github.com/go-redis/redismock/v9"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type HealthHandlerTestSuite struct {
+	suite.Suite
+	sqlMock   sqlmock.Sqlmock
+	mockRedis redismock.ClientMock
+	e         *echo.Echo
+	health    *Health
+}
+
+func (suite *HealthHandlerTestSuite) SetupTest() {
+	mockDB, sqlMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dbMock, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: mockDB,
+	}), &gorm.Config{DisableAutomaticPing: true})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	redisClient, mockRedis := redismock.NewClientMock()
+
+	suite.sqlMock = sqlMock
+	suite.mockRedis = mockRedis
+	suite.e = echo.New()
+	suite.health = &Health{
+		DB:    dbMock,
+		Redis: redisClient,
+		APIMockClient: &services.APIMockClient{
+			Client:  &http.Client{},
+			Breaker: &breaker.Breaker{},
+			BaseURL: "http://example.com",
+			Timeout: time.Second,
+		},
+	}
+}
+
+func (suite *HealthHandlerTestSuite) CallReadyz() (*httptest.ResponseRecorder, ReadyResponse) {
+	require := suite.Require()
+
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	res := httptest.NewRecorder()
+	ctx := suite.e.NewContext(req, res)
+	require.NoError(suite.health.Readyz(ctx))
+
+	var response ReadyResponse
+	require.NoError(json.Unmarshal(res.Body.Bytes(), &response))
+
+	return res, response
+}
+
+func (suite *HealthHandlerTestSuite) TestHealthz() {
+	require := suite.Require()
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	res := httptest.NewRecorder()
+	ctx := suite.e.NewContext(req, res)
+
+	require.NoError(suite.health.Healthz(ctx))
+	require.Equal(http.StatusOK, res.Code)
+}
+
+func (suite *HealthHandlerTestSuite) TestReadyz_Success() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectPing()
+	suite.mockRedis.ExpectPing().SetVal("PONG")
+
+	res, response := suite.CallReadyz()
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal(healthOK, response.Status)
+	require.Equal(map[string]string{
+		"postgres":        healthOK,
+		"redis":           healthOK,
+		"flight_provider": healthOK,
+	}, response.Checks)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *HealthHandlerTestSuite) TestReadyz_PostgresDown() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	suite.mockRedis.ExpectPing().SetVal("PONG")
+
+	res, response := suite.CallReadyz()
+	require.Equal(http.StatusServiceUnavailable, res.Code)
+	require.Equal(healthUnavailable, response.Status)
+	require.Equal(healthUnavailable, response.Checks["postgres"])
+	require.Equal(healthOK, response.Checks["redis"])
+}
+
+func (suite *HealthHandlerTestSuite) TestReadyz_RedisDown() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectPing()
+	suite.mockRedis.ExpectPing().SetErr(errors.New("connection refused"))
+
+	res, response := suite.CallReadyz()
+	require.Equal(http.StatusServiceUnavailable, res.Code)
+	require.Equal(healthUnavailable, response.Checks["redis"])
+}
+
+func (suite *HealthHandlerTestSuite) TestReadyz_BreakerOpen() {
+	require := suite.Require()
+
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer provider.Close()
+
+	suite.health.APIMockClient.BaseURL = provider.URL
+	suite.health.APIMockClient.Breaker = breaker.New(1, 1, time.Minute)
+	_, _ = suite.health.APIMockClient.GetFlights(context.Background(), "", "", "")
+	_, _ = suite.health.APIMockClient.GetFlights(context.Background(), "", "", "")
+	require.True(suite.health.APIMockClient.BreakerOpen())
+
+	suite.sqlMock.ExpectPing()
+	suite.mockRedis.ExpectPing().SetVal("PONG")
+
+	res, response := suite.CallReadyz()
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal(healthDegraded, response.Status)
+	require.Equal("circuit open", response.Checks["flight_provider"])
+}
+
+func (suite *HealthHandlerTestSuite) TestReadyz_ShuttingDown() {
+	require := suite.Require()
+
+	suite.health.ShutDown()
+
+	res, response := suite.CallReadyz()
+	require.Equal(http.StatusServiceUnavailable, res.Code)
+	require.Equal("shutting down", response.Checks["server"])
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func TestHealthHandler(t *testing.T) {
+	suite.Run(t, new(HealthHandlerTestSuite))
+}
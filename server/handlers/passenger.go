@@ -3,11 +3,12 @@ package handlers
 import (
 	"net/http"
 	"on-air/repository"
+	"on-air/server/apierror"
+	"on-air/server/middlewares"
 	"on-air/utils"
 
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/labstack/echo/v4"
-	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
@@ -31,18 +32,21 @@ func (p *Passenger) Create(ctx echo.Context) error {
 	userID, _ := ctx.Get("user_id").(int)
 	var req CreateRequest
 	if err := ctx.Bind(&req); err != nil {
-		return ctx.JSON(http.StatusBadRequest, "Bind Error")
+		return apierror.ErrInvalidRequest.Wrap(err)
 	}
 
 	if err := ctx.Validate(&req); err != nil {
-		return ctx.JSON(http.StatusBadRequest, err.Error())
+		return err
 	}
 
 	if !utils.ValidateNationalCode(req.NationalCode) {
-		return ctx.JSON(http.StatusBadRequest, "Invalid national code")
+		return apierror.Validation(&utils.ValidationError{
+			Fields: []utils.FieldError{{Field: "national_code", Rule: "national_code"}},
+		})
 	}
 
 	_, err := repository.CreatePassenger(
+		ctx.Request().Context(),
 		p.DB,
 		userID,
 		req.NationalCode,
@@ -53,10 +57,10 @@ func (p *Passenger) Create(ctx echo.Context) error {
 
 	if err != nil {
 		if pgerr, ok := err.(*pgconn.PgError); ok && pgerr.Code == "23505" {
-			return ctx.JSON(http.StatusBadRequest, "Passenger exists")
+			return apierror.ErrPassengerDuplicate.Wrap(err)
 		} else {
-			logrus.Error("passenger_handler: Create failed when use repository.CreatePassenger, error:", err)
-			return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+			middlewares.Logger(ctx).WithError(err).Error("passenger_handler: Create failed when use repository.CreatePassenger")
+			return err
 		}
 	}
 
@@ -75,11 +79,11 @@ type GetResponse struct {
 
 func (p *Passenger) Get(ctx echo.Context) error {
 	userID, _ := ctx.Get("user_id").(int)
-	passengers, err := repository.GetPassengersByUserID(p.DB, userID)
+	passengers, err := repository.GetPassengersByUserID(ctx.Request().Context(), p.DB, userID)
 
 	if err != nil {
-		logrus.Error("passenger_handler: Get failed when use repository.GetPassengersByUserID, error:", err)
-		return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+		middlewares.Logger(ctx).WithError(err).Error("passenger_handler: Get failed when use repository.GetPassengersByUserID")
+		return err
 	}
 
 	var response []GetResponse
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"on-air/server/apierror"
 	"on-air/utils"
 	"regexp"
 	"strconv"
@@ -62,6 +63,7 @@ func (suite *PassengerTestSuite) SetupSuite() {
 	suite.sqlMock = sqlMock
 	suite.passenger = &Passenger{DB: db}
 	suite.e = echo.New()
+	suite.e.HTTPErrorHandler = apierror.HTTPErrorHandler
 	suite.e.Validator = &utils.CustomValidator{Validator: validator.New()}
 	suite.endpoint = "/passenger"
 	suite.UserID = 3
@@ -79,6 +81,9 @@ func (suite *PassengerTestSuite) CallCreateHandler(requestBody string) (*httptes
 	c := suite.e.NewContext(req, res)
 	c.Set("id", strconv.Itoa(suite.UserID))
 	err := suite.passenger.Create(c)
+	if err != nil {
+		c.Error(err)
+	}
 	return res, err
 }
 
@@ -89,6 +94,9 @@ func (suite *PassengerTestSuite) CallGetHandler() (*httptest.ResponseRecorder, e
 	c := suite.e.NewContext(req, res)
 	c.Set("id", strconv.Itoa(suite.UserID))
 	err := suite.passenger.Get(c)
+	if err != nil {
+		c.Error(err)
+	}
 	return res, err
 }
 
@@ -123,7 +131,7 @@ func (suite *PassengerTestSuite) TestCreatePassenger_CreatePassenger_Success() {
 func (suite *PassengerTestSuite) TestCreatePassenger_CreatePassenger_Failure() {
 	require := suite.Require()
 	expectedStatusCode := http.StatusInternalServerError
-	expectedBody := "\"Internal server error\"\n"
+	expectedBody := "{\"error\":{\"code\":\"INTERNAL_ERROR\",\"message\":\"Internal server error\"}}\n"
 
 	suite.e.Binder = &MockBinder{}
 
@@ -147,14 +155,14 @@ func (suite *PassengerTestSuite) TestCreatePassenger_CreatePassenger_Failure() {
 	res, err := suite.CallCreateHandler(requestBody)
 	body, _ := io.ReadAll(res.Body)
 	require.Equal(expectedBody, string(body))
-	require.NoError(err)
+	require.Error(err)
 	require.Equal(expectedStatusCode, res.Code)
 }
 
 func (suite *PassengerTestSuite) TestCreatePassenger_CreatePassenger_Duplicate_Failure() {
 	require := suite.Require()
-	expectedStatusCode := http.StatusBadRequest
-	expectedBody := "\"Passenger exists\"\n"
+	expectedStatusCode := http.StatusConflict
+	expectedBody := "{\"error\":{\"code\":\"PASSENGER_DUPLICATE\",\"message\":\"Passenger exists\"}}\n"
 
 	suite.e.Binder = &MockBinder{}
 
@@ -183,20 +191,20 @@ func (suite *PassengerTestSuite) TestCreatePassenger_CreatePassenger_Duplicate_F
 	res, err := suite.CallCreateHandler(requestBody)
 	body, _ := io.ReadAll(res.Body)
 	require.Equal(expectedBody, string(body))
-	require.NoError(err)
+	require.Error(err)
 	require.Equal(expectedStatusCode, res.Code)
 }
 
 func (suite *PassengerTestSuite) TestCreatePassenger_InvalidBody_Failure() {
 	require := suite.Require()
 	expectedStatusCode := http.StatusBadRequest
-	expectedBody := "\"Bind Error\"\n"
+	expectedBody := "{\"error\":{\"code\":\"INVALID_REQUEST\",\"message\":\"Invalid request body\"}}\n"
 
 	requestBody := `{"national_code: "1000011111", "first_name": "name", "last_name": "lname", "gender": "f"}`
 
 	res, err := suite.CallCreateHandler(requestBody)
 	body, _ := io.ReadAll(res.Body)
-	require.NoError(err)
+	require.Error(err)
 	require.Equal(expectedBody, string(body))
 	require.Equal(expectedStatusCode, res.Code)
 }
@@ -204,13 +212,13 @@ func (suite *PassengerTestSuite) TestCreatePassenger_InvalidBody_Failure() {
 func (suite *PassengerTestSuite) TestCreatePassenger_InvalidValue_Failure() {
 	require := suite.Require()
 	expectedStatusCode := http.StatusBadRequest
-	expectedBody := "\"Bind Error\"\n"
+	expectedBody := "{\"error\":{\"code\":\"INVALID_REQUEST\",\"message\":\"Invalid request body\"}}\n"
 
 	requestBody := `{"national_code": 1382122489, "firstname": "", "last_name": "lname", "gender": "f"}`
 
 	res, err := suite.CallCreateHandler(requestBody)
 	body, _ := io.ReadAll(res.Body)
-	require.NoError(err)
+	require.Error(err)
 	require.Equal(expectedBody, string(body))
 	require.Equal(expectedStatusCode, res.Code)
 }
@@ -218,22 +226,22 @@ func (suite *PassengerTestSuite) TestCreatePassenger_InvalidValue_Failure() {
 func (suite *PassengerTestSuite) TestCreatePassenger_InvalidKey_Failure() {
 	require := suite.Require()
 	expectedStatusCode := http.StatusBadRequest
-	expectedBody := "Error:Field validation for 'NationalCode'"
+	expectedBody := "{\"field\":\"national_code\",\"rule\":\"required\",\"message\":\"is required\"}"
 
 	suite.e.Binder = &MockBinder{}
 	requestBody := `{"national_code": "1000011111", "firstname": "name", "last_name": "lname", "gender": "f"}`
 
 	res, err := suite.CallCreateHandler(requestBody)
-	body, err := io.ReadAll(res.Body)
+	body, _ := io.ReadAll(res.Body)
 	require.Contains(string(body), expectedBody)
-	require.NoError(err)
+	require.Error(err)
 	require.Equal(expectedStatusCode, res.Code)
 }
 
 func (suite *PassengerTestSuite) TestCreatePassenger_ValidateNationalCode_Failure() {
 	require := suite.Require()
 	expectedStatusCode := http.StatusBadRequest
-	expectedErr := "\"Invalid national code\"\n"
+	expectedErr := "{\"error\":{\"code\":\"VALIDATION_FAILED\",\"message\":\"Validation failed\",\"fields\":[{\"field\":\"national_code\",\"rule\":\"national_code\",\"message\":\"must be a valid national code\"}]}}\n"
 
 	suite.e.Binder = &MockBinder{}
 
@@ -244,7 +252,7 @@ func (suite *PassengerTestSuite) TestCreatePassenger_ValidateNationalCode_Failur
 	res, err := suite.CallCreateHandler(requestBody)
 	body, _ := io.ReadAll(res.Body)
 	require.Equal(expectedErr, string(body))
-	require.NoError(err)
+	require.Error(err)
 	require.Equal(expectedStatusCode, res.Code)
 }
 
@@ -272,7 +280,7 @@ func (suite *PassengerTestSuite) TestGetPassenger_Success() {
 
 func (suite *PassengerTestSuite) TestGetPassenger_Failure() {
 	require := suite.Require()
-	expectedBody := "\"Internal server error\"\n"
+	expectedBody := "{\"error\":{\"code\":\"INTERNAL_ERROR\",\"message\":\"Internal server error\"}}\n"
 	expectedStatusCode := http.StatusInternalServerError
 
 	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "passengers" WHERE user_id = (.+)`).
@@ -1,20 +1,25 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
 	"on-air/config"
+	"on-air/models"
 	"on-air/repository"
+	"on-air/server/apierror"
+	"on-air/server/middlewares"
+	"on-air/server/services/gateway"
 	"strconv"
-	"time"
 
 	"github.com/labstack/echo/v4"
-	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
 type Payment struct {
-	DB  *gorm.DB
-	IPG *config.IPG
+	DB       *gorm.DB
+	IPG      *config.IPG
+	Gateways *gateway.Registry
 }
 
 type PayRequest struct {
@@ -26,19 +31,28 @@ type PayResponse struct {
 }
 
 func (t *Payment) Pay(ctx echo.Context) error {
+	userID, _ := ctx.Get("user_id").(int)
 	var req PayRequest
 	if err := ctx.Bind(&req); err != nil {
-		return ctx.JSON(http.StatusBadRequest, "Bind Error")
+		return apierror.ErrInvalidRequest.Wrap(err)
 	}
 
 	if err := ctx.Validate(&req); err != nil {
-		return ctx.JSON(http.StatusBadRequest, err.Error())
+		return err
+	}
+
+	address, err := repository.PayTicket(ctx.Request().Context(), t.DB, t.Gateways, userID, req.TicketID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return apierror.ErrTicketNotFound.Wrap(err)
+	}
+
+	if errors.Is(err, repository.ErrTicketNotReserved) {
+		return apierror.ErrTicketNotReserved.Wrap(err)
 	}
 
-	address, err := repository.PayTicket(t.DB, t.IPG, req.TicketID)
 	if err != nil {
-		logrus.Error("payment_handler: Pay failed when use repository.PayTicket, error:", err)
-		return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+		middlewares.Logger(ctx).WithError(err).Error("payment_handler: Pay failed when use repository.PayTicket")
+		return err
 	}
 
 	return ctx.JSON(http.StatusOK, PayResponse{
@@ -46,33 +60,119 @@ func (t *Payment) Pay(ctx echo.Context) error {
 	})
 }
 
-type CallBackRequest struct {
-	PaymentID              int
-	PaymentDate            time.Time
-	TransactionReferenceID int
-}
-
 type CallBackResponse struct {
 	Status string `json:"status" binding:"required"`
 }
 
+// callbackReasons are the reasons a rejected callback is recorded and the
+// payer is redirected to the failure page with.
+var callbackReasons = []struct {
+	err    error
+	reason string
+}{
+	{gateway.ErrUnknownGateway, "unknown_gateway"},
+	{gateway.ErrInvalidSignature, "invalid_signature"},
+	{gateway.ErrInvalidCallback, "invalid_callback"},
+	{gorm.ErrRecordNotFound, "payment_not_found"},
+	{repository.ErrPaymentAlreadyVerified, "already_verified"},
+	{repository.ErrCallbackReplayed, "replayed"},
+	{repository.ErrPaymentNotPayable, "not_payable"},
+	{repository.ErrTicketNotReserved, "ticket_not_reserved"},
+	{repository.ErrAmountMismatch, "amount_mismatch"},
+	{gateway.DeclinedError{}, "declined"},
+}
+
+func callbackReason(err error) string {
+	for _, callbackReason := range callbackReasons {
+		if errors.Is(err, callbackReason.err) {
+			return callbackReason.reason
+		}
+	}
+
+	return "internal_error"
+}
+
+// CallBack verifies the payment the payer is sent back with, the gateway
+// comes from the path and defaults to Pasargad for the legacy callback URL.
+// Every callback is recorded, and the payer is redirected to the configured
+// success or failure page, API clients get JSON when they are not set.
 func (t *Payment) CallBack(ctx echo.Context) error {
-	var req CallBackRequest
-	req.PaymentID, _ = strconv.Atoi(ctx.Request().URL.Query().Get("iN"))
-	req.PaymentDate, _ = time.Parse("2006/01/02", ctx.Request().URL.Query().Get("iD"))
-	req.TransactionReferenceID, _ = strconv.Atoi(ctx.Request().URL.Query().Get("tref"))
+	name := ctx.Param("gateway")
+	if name == "" {
+		name = gateway.Pasargad
+	}
 
-	if err := ctx.Validate(&req); err != nil {
-		return ctx.JSON(http.StatusBadRequest, "Bind Error")
+	audit := models.PaymentCallback{
+		Gateway:    name,
+		Query:      ctx.QueryString(),
+		RemoteAddr: ctx.RealIP(),
+		Result:     string(models.CallbackAccepted),
+	}
+	if len(audit.Gateway) > 20 {
+		audit.Gateway = audit.Gateway[:20]
 	}
 
-	status, err := repository.VerifyPayment(t.DB, t.IPG, req.PaymentID, req.PaymentDate, req.TransactionReferenceID)
+	payment, err := t.verifyCallback(ctx, name)
+	if payment != nil {
+		audit.PaymentID = &payment.ID
+	}
+
+	reason := ""
 	if err != nil {
-		logrus.Error("payment_handler: CallBack failed when use repository.VerifyPayment, error:", err)
-		return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+		reason = callbackReason(err)
+		audit.Result = string(models.CallbackRejected)
+		audit.Reason = reason
+		middlewares.Logger(ctx).WithError(err).Error("payment_handler: CallBack rejected when use repository.VerifyPayment")
 	}
 
-	return ctx.JSON(http.StatusOK, CallBackResponse{
-		Status: status,
-	})
+	if auditErr := repository.CreatePaymentCallback(ctx.Request().Context(), t.DB, &audit); auditErr != nil {
+		middlewares.Logger(ctx).WithError(auditErr).Error("payment_handler: CallBack failed when use repository.CreatePaymentCallback")
+	}
+
+	query := url.Values{}
+	if payment != nil {
+		query.Set("payment_id", strconv.Itoa(int(payment.ID)))
+		query.Set("ticket_id", strconv.Itoa(int(payment.TicketID)))
+	}
+
+	if err != nil {
+		if t.IPG.Callback.FailureURL == "" {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return apierror.ErrPaymentNotFound.Wrap(err)
+			}
+
+			return err
+		}
+
+		query.Set("reason", reason)
+		return ctx.Redirect(http.StatusFound, gateway.CallbackURL(t.IPG.Callback.FailureURL, query))
+	}
+
+	if t.IPG.Callback.SuccessURL == "" {
+		return ctx.JSON(http.StatusOK, CallBackResponse{
+			Status: payment.Status,
+		})
+	}
+
+	return ctx.Redirect(http.StatusFound, gateway.CallbackURL(t.IPG.Callback.SuccessURL, query))
+}
+
+func (t *Payment) verifyCallback(ctx echo.Context, name string) (*models.Payment, error) {
+	paymentGateway, err := t.Gateways.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	query := ctx.QueryParams()
+	callback, err := paymentGateway.ParseCallback(query)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.Gateways.VerifyCallback(name, query, callback)
+	if err != nil {
+		return nil, err
+	}
+
+	return repository.VerifyPayment(ctx.Request().Context(), t.DB, paymentGateway, callback)
 }
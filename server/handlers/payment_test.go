@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"on-air/config"
+	"on-air/models"
+	"on-air/repository"
+	"on-air/server/services/gateway"
+	"on-air/server/services/pasargad"
+	"testing"
+
+	"bou.ke/monkey"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type CallBackTestSuite struct {
+	suite.Suite
+	sqlMock sqlmock.Sqlmock
+	e       *echo.Echo
+	payment *Payment
+}
+
+func (suite *CallBackTestSuite) SetupSuite() {
+	mockDB, sqlMock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: mockDB,
+	}))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.sqlMock = sqlMock
+	suite.e = echo.New()
+	suite.payment = &Payment{
+		DB: db,
+		IPG: &config.IPG{
+			Callback: config.Callback{
+				SuccessURL: "http://on-air.test/success",
+				FailureURL: "http://on-air.test/failure",
+			},
+		},
+		Gateways: gateway.NewRegistry(&gateway.PasargadGateway{
+			API: pasargad.PasargadAPI(0, 0, "", ""),
+		}),
+	}
+}
+
+func (suite *CallBackTestSuite) CallHandler(query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/payments/callBack/pasargad?"+query, nil)
+	res := httptest.NewRecorder()
+	ctx := suite.e.NewContext(req, res)
+	ctx.SetParamNames("gateway")
+	ctx.SetParamValues(gateway.Pasargad)
+
+	suite.Require().NoError(suite.payment.CallBack(ctx))
+
+	return res
+}
+
+func (suite *CallBackTestSuite) expectAudit(result models.PaymentCallbackResult, reason string) {
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectQuery(`INSERT INTO "payment_callbacks"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, gateway.Pasargad, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), string(result), reason).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	suite.sqlMock.ExpectCommit()
+}
+
+func (suite *CallBackTestSuite) patchVerify(payment *models.Payment, err error) *monkey.PatchGuard {
+	return monkey.Patch(repository.VerifyPayment, func(ctx context.Context, db *gorm.DB, paymentGateway gateway.PaymentGateway, callback *gateway.Callback) (*models.Payment, error) {
+		return payment, err
+	})
+}
+
+func (suite *CallBackTestSuite) TestCallBack_Success() {
+	require := suite.Require()
+
+	payment := &models.Payment{Status: string(models.Verified), TicketID: 9}
+	payment.ID = 4
+	patch := suite.patchVerify(payment, nil)
+	defer patch.Unpatch()
+
+	suite.expectAudit(models.CallbackAccepted, "")
+
+	res := suite.CallHandler("iN=4&iD=2023/07/01&tref=1001")
+	require.Equal(http.StatusFound, res.Code)
+	require.Equal("http://on-air.test/success?payment_id=4&ticket_id=9", res.Header().Get(echo.HeaderLocation))
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *CallBackTestSuite) TestCallBack_AlreadyVerified() {
+	require := suite.Require()
+
+	payment := &models.Payment{Status: string(models.Verified), TicketID: 9}
+	payment.ID = 4
+	patch := suite.patchVerify(payment, repository.ErrPaymentAlreadyVerified)
+	defer patch.Unpatch()
+
+	suite.expectAudit(models.CallbackRejected, "already_verified")
+
+	res := suite.CallHandler("iN=4&iD=2023/07/01&tref=1001")
+	require.Equal(http.StatusFound, res.Code)
+	require.Equal("http://on-air.test/failure?payment_id=4&reason=already_verified&ticket_id=9", res.Header().Get(echo.HeaderLocation))
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *CallBackTestSuite) TestCallBack_InvalidCallback() {
+	require := suite.Require()
+
+	suite.expectAudit(models.CallbackRejected, "invalid_callback")
+
+	res := suite.CallHandler("iN=abc&iD=2023/07/01&tref=1001")
+	require.Equal(http.StatusFound, res.Code)
+	require.Equal("http://on-air.test/failure?reason=invalid_callback", res.Header().Get(echo.HeaderLocation))
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func TestCallBack(t *testing.T) {
+	suite.Run(t, new(CallBackTestSuite))
+}
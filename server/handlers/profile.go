@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"on-air/config"
+	"on-air/mailer"
+	"on-air/models"
+	"on-air/repository"
+	"on-air/server/apierror"
+	"on-air/server/middlewares"
+	"on-air/sms"
+	"on-air/utils"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// Profile is the API of the authenticated user on their own account.
+type Profile struct {
+	DB        *gorm.DB
+	Redis     *redis.Client
+	Account   *config.Account
+	RateLimit *config.RateLimit
+	Mailer    mailer.Mailer
+	SMS       sms.Sender
+}
+
+type ProfileResponse struct {
+	ID            uint   `json:"id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	FirstName     string `json:"first_name"`
+	LastName      string `json:"last_name"`
+	PhoneNumber   string `json:"phone_number"`
+	Role          string `json:"role"`
+	CreatedAt     string `json:"created_at"`
+}
+
+func (p *Profile) Get(ctx echo.Context) error {
+	user, err := p.getUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, newProfileResponse(user))
+}
+
+type UpdateProfileRequest struct {
+	FirstName   *string `json:"first_name" validate:"omitempty,max=50"`
+	LastName    *string `json:"last_name" validate:"omitempty,max=50"`
+	PhoneNumber *string `json:"phone_number"`
+	OTPCode     string  `json:"otp_code" validate:"omitempty,numeric,max=10"`
+}
+
+// Update changes the fields of the profile sent in the request, an empty
+// phone_number removes the number. A new number is saved only with the code
+// RequestPhoneNumberOTP texted to it, since it logs in with OTP.
+func (p *Profile) Update(ctx echo.Context) error {
+	var req UpdateProfileRequest
+	if err := ctx.Bind(&req); err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	if req.PhoneNumber != nil && *req.PhoneNumber != "" {
+		phoneNumber, err := normalizeMobileNumber(*req.PhoneNumber)
+		if err != nil {
+			return err
+		}
+		req.PhoneNumber = &phoneNumber
+	}
+
+	user, err := p.getUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	if req.PhoneNumber != nil && *req.PhoneNumber != "" && *req.PhoneNumber != user.PhoneNumber {
+		err = p.verifyPhoneNumber(ctx, user, *req.PhoneNumber, req.OTPCode)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = repository.UpdateUserProfile(ctx.Request().Context(), p.DB, user, repository.UserProfile{
+		FirstName:   req.FirstName,
+		LastName:    req.LastName,
+		PhoneNumber: req.PhoneNumber,
+	})
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return apierror.ErrPhoneNumberTaken.Wrap(err)
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("profile_handler: Update failed when call repository.UpdateUserProfile")
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, newProfileResponse(user))
+}
+
+type RequestPhoneNumberOTPRequest struct {
+	PhoneNumber string `json:"phone_number" validate:"required"`
+}
+
+// RequestPhoneNumberOTP texts a code to the mobile number the user wants on
+// their profile, Update takes it as otp_code. A number of another user is
+// refused.
+func (p *Profile) RequestPhoneNumberOTP(ctx echo.Context) error {
+	var req RequestPhoneNumberOTPRequest
+	if err := ctx.Bind(&req); err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	phoneNumber, err := normalizeMobileNumber(req.PhoneNumber)
+	if err != nil {
+		return err
+	}
+
+	user, err := p.getUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = p.checkPhoneNumber(ctx, user, phoneNumber)
+	if err != nil {
+		return err
+	}
+
+	err = checkOTPLock(ctx, p.Redis, phoneNumber)
+	if err != nil {
+		return err
+	}
+
+	code, err := repository.RequestOTP(ctx.Request().Context(), p.Redis, p.Account, phoneNumber)
+	if errors.Is(err, repository.ErrOTPTooSoon) {
+		return apierror.ErrOTPTooSoon.Wrap(err)
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("profile_handler: RequestPhoneNumberOTP failed when call repository.RequestOTP")
+		return err
+	}
+
+	err = p.SMS.Send(ctx.Request().Context(), sms.Message{
+		To:   phoneNumber,
+		Text: fmt.Sprintf("Your on-air code to add this number is %s. Do not share it with anyone.", code),
+	})
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("profile_handler: RequestPhoneNumberOTP failed when call p.SMS.Send")
+		return err
+	}
+
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=8,max=72"`
+}
+
+// ChangePassword sets a new password once the old one is confirmed, the
+// other sessions of the user end.
+func (p *Profile) ChangePassword(ctx echo.Context) error {
+	var req ChangePasswordRequest
+	if err := ctx.Bind(&req); err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	user, err := p.getUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = utils.CheckPassword(req.OldPassword, user.Password)
+	if err != nil {
+		return apierror.ErrWrongPassword.Wrap(err)
+	}
+
+	err = repository.ChangePassword(ctx.Request().Context(), p.DB, int(user.ID), req.NewPassword)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("profile_handler: ChangePassword failed when call repository.ChangePassword")
+		return err
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+type ChangeEmailRequest struct {
+	Email    string `json:"email" validate:"required,email,max=50"`
+	Password string `json:"password" validate:"required"`
+}
+
+// ChangeEmail mails a confirmation link to the new email, the email changes
+// once the link is followed. The old email is told about the change.
+func (p *Profile) ChangeEmail(ctx echo.Context) error {
+	var req ChangeEmailRequest
+	if err := ctx.Bind(&req); err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	user, err := p.getUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = utils.CheckPassword(req.Password, user.Password)
+	if err != nil {
+		return apierror.ErrWrongPassword.Wrap(err)
+	}
+
+	dbUser, _ := repository.GetUserByEmail(ctx.Request().Context(), p.DB, req.Email)
+	if dbUser != nil {
+		return apierror.ErrUserDuplicate
+	}
+
+	token, err := repository.CreateEmailChangeToken(ctx.Request().Context(), p.DB, p.Account, int(user.ID), req.Email)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("profile_handler: ChangeEmail failed when call repository.CreateEmailChangeToken")
+		return err
+	}
+
+	err = p.Mailer.Send(ctx.Request().Context(), mailer.Message{
+		To:      req.Email,
+		Subject: "Confirm your new on-air email",
+		Body: "Open the link below to make this the email of your on-air account:\n\n" +
+			tokenLink(p.Account.ChangeEmailURL, token),
+	})
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("profile_handler: ChangeEmail failed when call p.Mailer.Send")
+		return err
+	}
+
+	err = p.Mailer.Send(ctx.Request().Context(), mailer.Message{
+		To:      user.Email,
+		Subject: "Your on-air email is being changed",
+		Body: "Someone asked to change the email of your on-air account to " + req.Email + ". " +
+			"If it was not you, change your password right away.",
+	})
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("profile_handler: ChangeEmail failed when notify the old email")
+	}
+
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// Delete closes the account once the password is confirmed. The personal data
+// is wiped, the tickets and payments are kept for accounting.
+func (p *Profile) Delete(ctx echo.Context) error {
+	var req DeleteAccountRequest
+	if err := ctx.Bind(&req); err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	user, err := p.getUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = utils.CheckPassword(req.Password, user.Password)
+	if err != nil {
+		return apierror.ErrWrongPassword.Wrap(err)
+	}
+
+	err = repository.DeleteUser(ctx.Request().Context(), p.DB, int(user.ID))
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("profile_handler: Delete failed when call repository.DeleteUser")
+		return err
+	}
+
+	// The refresh tokens are revoked with the account, the access token of
+	// the request is revoked here. Other access tokens run out on their own.
+	if claims, ok := ctx.Get(middlewares.ClaimsContextField).(*repository.Claims); ok {
+		err = repository.RevokeToken(ctx.Request().Context(), p.Redis, claims)
+		if err != nil {
+			middlewares.Logger(ctx).WithError(err).Error("profile_handler: Delete failed when call repository.RevokeToken")
+		}
+	}
+
+	middlewares.Logger(ctx).WithField("target_user_id", user.ID).Info("profile_handler: account deleted")
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (p *Profile) getUser(ctx echo.Context) (*models.User, error) {
+	userID, _ := ctx.Get("user_id").(int)
+
+	user, err := repository.GetUserByID(ctx.Request().Context(), p.DB, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, apierror.ErrUserNotFound.Wrap(err)
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("profile_handler: get user failed when call repository.GetUserByID")
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// verifyPhoneNumber uses up the code texted to the new number of the user.
+func (p *Profile) verifyPhoneNumber(ctx echo.Context, user *models.User, phoneNumber string, code string) error {
+	if code == "" {
+		return apierror.Validation(&utils.ValidationError{
+			Fields: []utils.FieldError{{Field: "otp_code", Rule: "required"}},
+		})
+	}
+
+	err := p.checkPhoneNumber(ctx, user, phoneNumber)
+	if err != nil {
+		return err
+	}
+
+	return verifyOTP(ctx, p.Redis, p.Account, p.RateLimit, phoneNumber, code)
+}
+
+// checkPhoneNumber refuses a number another user already has.
+func (p *Profile) checkPhoneNumber(ctx echo.Context, user *models.User, phoneNumber string) error {
+	dbUser, err := repository.GetUserByPhoneNumber(ctx.Request().Context(), p.DB, phoneNumber)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+
+	if errors.Is(err, repository.ErrPhoneNumberShared) {
+		return apierror.ErrPhoneNumberTaken.Wrap(err)
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("profile_handler: check phone number failed when call repository.GetUserByPhoneNumber")
+		return err
+	}
+
+	if dbUser.ID != user.ID {
+		return apierror.ErrPhoneNumberTaken
+	}
+
+	return nil
+}
+
+func newProfileResponse(user *models.User) ProfileResponse {
+	return ProfileResponse{
+		ID:            user.ID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		PhoneNumber:   user.PhoneNumber,
+		Role:          user.Role,
+		CreatedAt:     user.CreatedAt.Format(time.RFC3339),
+	}
+}
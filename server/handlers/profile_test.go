@@ -0,0 +1,423 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"on-air/config"
+	"on-air/models"
+	"on-air/repository"
+	"on-air/server/apierror"
+	"on-air/server/middlewares"
+	"on-air/sms"
+	"on-air/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"bou.ke/monkey"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-playground/validator/v10"
+	"github.com/go-redis/redismock/v9"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type ProfileTestSuite struct {
+	suite.Suite
+	sqlMock   sqlmock.Sqlmock
+	mockRedis redismock.ClientMock
+	e         *echo.Echo
+	profile   *Profile
+	mailer    *MockMailer
+	sms       *sms.FakeSender
+	password  string
+	UserID    int
+}
+
+func (suite *ProfileTestSuite) SetupSuite() {
+	hashedPassword, err := utils.HashPassword("password@123")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.password = hashedPassword
+}
+
+func (suite *ProfileTestSuite) SetupTest() {
+	mockDB, sqlMock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: mockDB,
+	}))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	redisClient, mockRedis := redismock.NewClientMock()
+
+	suite.sqlMock = sqlMock
+	suite.mockRedis = mockRedis
+	suite.mailer = &MockMailer{}
+	suite.sms = &sms.FakeSender{}
+	suite.profile = &Profile{
+		DB:        db,
+		Redis:     redisClient,
+		RateLimit: &config.RateLimit{MaxOTPFailures: 3, OTPFailureWindow: time.Hour, OTPLockoutDuration: time.Hour},
+		Mailer:    suite.mailer,
+		SMS:       suite.sms,
+		Account: &config.Account{
+			TokenSecret:    "tokenSecret",
+			ChangeEmailURL: "https://on-air.test/confirm-email",
+		},
+	}
+	suite.e = echo.New()
+	suite.e.HTTPErrorHandler = apierror.HTTPErrorHandler
+	suite.e.Validator = &utils.CustomValidator{Validator: validator.New()}
+	suite.UserID = 1
+}
+
+func (suite *ProfileTestSuite) CallHandler(handler echo.HandlerFunc, method string, requestBody string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(method, "/me", strings.NewReader(requestBody))
+	if requestBody != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+
+	res := httptest.NewRecorder()
+	c := suite.e.NewContext(req, res)
+	c.Set("user_id", suite.UserID)
+	err := handler(c)
+	if err != nil {
+		c.Error(err)
+	}
+	return res, err
+}
+
+func (suite *ProfileTestSuite) expectUser() {
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE id = `).
+		WithArgs(suite.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "email", "phone_number", "password", "role", "email_verified_at"}).
+			AddRow(suite.UserID, "Ali", "Rezaei", "ali@gmail.com", "09121234567", suite.password, string(models.RoleCustomer), time.Now()))
+}
+
+func (suite *ProfileTestSuite) TestGet_Success() {
+	require := suite.Require()
+
+	suite.expectUser()
+
+	res, err := suite.CallHandler(suite.profile.Get, http.MethodGet, "")
+	require.NoError(err)
+	require.Equal(http.StatusOK, res.Code)
+
+	var profile ProfileResponse
+	require.NoError(json.Unmarshal(res.Body.Bytes(), &profile))
+	require.Equal(uint(suite.UserID), profile.ID)
+	require.Equal("ali@gmail.com", profile.Email)
+	require.True(profile.EmailVerified)
+	require.Equal("09121234567", profile.PhoneNumber)
+	require.NotContains(res.Body.String(), "password")
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *ProfileTestSuite) TestGet_Failure_Deleted() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE id = `).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	res, err := suite.CallHandler(suite.profile.Get, http.MethodGet, "")
+	require.ErrorIs(err, apierror.ErrUserNotFound)
+	require.Equal(http.StatusNotFound, res.Code)
+}
+
+func (suite *ProfileTestSuite) expectPhoneNumberUser(phoneNumber string, userIDs ...int) {
+	rows := sqlmock.NewRows([]string{"id", "phone_number"})
+	for _, userID := range userIDs {
+		rows.AddRow(userID, phoneNumber)
+	}
+
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE phone_number = `).
+		WithArgs(phoneNumber).
+		WillReturnRows(rows)
+}
+
+func (suite *ProfileTestSuite) TestUpdate_Success() {
+	require := suite.Require()
+
+	monkey.Patch(repository.VerifyOTP, func(_ context.Context, _ *redis.Client, _ *config.Account, phoneNumber string, code string) error {
+		require.Equal("09351234567", phoneNumber)
+		require.Equal("01234", code)
+		return nil
+	})
+	defer monkey.Unpatch(repository.VerifyOTP)
+
+	suite.expectUser()
+	suite.expectPhoneNumberUser("09351234567")
+	suite.mockRedis.ExpectPTTL("otp_lock_09351234567").SetVal(-2)
+	suite.mockRedis.ExpectDel("otp_failures_09351234567").SetVal(0)
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "users" SET "first_name"=.*,"phone_number"=.*,"updated_at"=.* WHERE id = `).
+		WithArgs("Sara", "09351234567", sqlmock.AnyArg(), suite.UserID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	res, err := suite.CallHandler(suite.profile.Update, http.MethodPatch, `{"first_name": "Sara", "phone_number": "+98 935 123 4567", "otp_code": "01234"}`)
+	require.NoError(err)
+	require.Equal(http.StatusOK, res.Code)
+	require.Contains(res.Body.String(), `"first_name":"Sara"`)
+	require.Contains(res.Body.String(), `"last_name":"Rezaei"`)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *ProfileTestSuite) TestUpdate_SamePhoneNumber() {
+	require := suite.Require()
+
+	// The number the user already has needs no code.
+	suite.expectUser()
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "users" SET "phone_number"=.*,"updated_at"=.* WHERE id = `).
+		WithArgs("09121234567", sqlmock.AnyArg(), suite.UserID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	res, err := suite.CallHandler(suite.profile.Update, http.MethodPatch, `{"phone_number": "09121234567"}`)
+	require.NoError(err)
+	require.Equal(http.StatusOK, res.Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *ProfileTestSuite) TestUpdate_Failure_OTPRequired() {
+	require := suite.Require()
+
+	suite.expectUser()
+
+	res, err := suite.CallHandler(suite.profile.Update, http.MethodPatch, `{"phone_number": "09351234567"}`)
+	require.Error(err)
+	require.Equal(http.StatusBadRequest, res.Code)
+	require.Contains(res.Body.String(), `"field":"otp_code"`)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *ProfileTestSuite) TestUpdate_Failure_InvalidOTP() {
+	require := suite.Require()
+
+	monkey.Patch(repository.VerifyOTP, func(_ context.Context, _ *redis.Client, _ *config.Account, _ string, _ string) error {
+		return repository.ErrInvalidOTP
+	})
+	defer monkey.Unpatch(repository.VerifyOTP)
+
+	suite.expectUser()
+	suite.expectPhoneNumberUser("09351234567")
+	suite.mockRedis.ExpectPTTL("otp_lock_09351234567").SetVal(-2)
+	suite.mockRedis.ExpectTxPipeline()
+	suite.mockRedis.ExpectIncr("otp_failures_09351234567").SetVal(1)
+	suite.mockRedis.ExpectPExpire("otp_failures_09351234567", time.Hour).SetVal(true)
+	suite.mockRedis.ExpectTxPipelineExec()
+
+	res, err := suite.CallHandler(suite.profile.Update, http.MethodPatch, `{"phone_number": "09351234567", "otp_code": "99999"}`)
+	require.ErrorIs(err, apierror.ErrInvalidOTP)
+	require.Equal(http.StatusUnauthorized, res.Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *ProfileTestSuite) TestUpdate_Failure_PhoneNumberTaken() {
+	require := suite.Require()
+
+	suite.expectUser()
+	suite.expectPhoneNumberUser("09351234567", 2)
+
+	res, err := suite.CallHandler(suite.profile.Update, http.MethodPatch, `{"phone_number": "09351234567", "otp_code": "01234"}`)
+	require.ErrorIs(err, apierror.ErrPhoneNumberTaken)
+	require.Equal(http.StatusConflict, res.Code)
+	require.Contains(res.Body.String(), `"code":"PHONE_NUMBER_TAKEN"`)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *ProfileTestSuite) TestRequestPhoneNumberOTP_Success() {
+	require := suite.Require()
+	defer suite.sms.Reset()
+
+	monkey.Patch(repository.RequestOTP, func(_ context.Context, _ *redis.Client, _ *config.Account, phoneNumber string) (string, error) {
+		require.Equal("09351234567", phoneNumber)
+		return "01234", nil
+	})
+	defer monkey.Unpatch(repository.RequestOTP)
+
+	suite.expectUser()
+	suite.expectPhoneNumberUser("09351234567")
+	suite.mockRedis.ExpectPTTL("otp_lock_09351234567").SetVal(-2)
+
+	res, err := suite.CallHandler(suite.profile.RequestPhoneNumberOTP, http.MethodPost, `{"phone_number": "+98 935 123 4567"}`)
+	require.NoError(err)
+	require.Equal(http.StatusAccepted, res.Code)
+	require.Len(suite.sms.Messages(), 1)
+	require.Equal("09351234567", suite.sms.Messages()[0].To)
+	require.Contains(suite.sms.Messages()[0].Text, "01234")
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *ProfileTestSuite) TestRequestPhoneNumberOTP_Failure_PhoneNumberTaken() {
+	require := suite.Require()
+	defer suite.sms.Reset()
+
+	suite.expectUser()
+	suite.expectPhoneNumberUser("09351234567", 2)
+
+	res, err := suite.CallHandler(suite.profile.RequestPhoneNumberOTP, http.MethodPost, `{"phone_number": "09351234567"}`)
+	require.ErrorIs(err, apierror.ErrPhoneNumberTaken)
+	require.Equal(http.StatusConflict, res.Code)
+	require.Empty(suite.sms.Messages())
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *ProfileTestSuite) TestUpdate_Failure_InvalidPhoneNumber() {
+	require := suite.Require()
+
+	res, err := suite.CallHandler(suite.profile.Update, http.MethodPatch, `{"phone_number": "12345"}`)
+	require.Error(err)
+	require.Equal(http.StatusBadRequest, res.Code)
+	require.Contains(res.Body.String(), `"field":"phone_number"`)
+}
+
+func (suite *ProfileTestSuite) TestChangePassword_Success() {
+	require := suite.Require()
+
+	suite.expectUser()
+	monkey.Patch(repository.ChangePassword, func(_ context.Context, _ *gorm.DB, userID int, password string) error {
+		require.Equal(suite.UserID, userID)
+		require.Equal("newPassword@123", password)
+		return nil
+	})
+	defer monkey.Unpatch(repository.ChangePassword)
+
+	res, err := suite.CallHandler(suite.profile.ChangePassword, http.MethodPost, `{"old_password": "password@123", "new_password": "newPassword@123"}`)
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, res.Code)
+}
+
+func (suite *ProfileTestSuite) TestChangePassword_Failure_WrongPassword() {
+	require := suite.Require()
+
+	suite.expectUser()
+
+	res, err := suite.CallHandler(suite.profile.ChangePassword, http.MethodPost, `{"old_password": "wrong", "new_password": "newPassword@123"}`)
+	require.ErrorIs(err, apierror.ErrWrongPassword)
+	require.Equal(http.StatusForbidden, res.Code)
+}
+
+func (suite *ProfileTestSuite) TestChangePassword_Failure_Validation() {
+	require := suite.Require()
+
+	res, err := suite.CallHandler(suite.profile.ChangePassword, http.MethodPost, `{"old_password": "password@123", "new_password": "short"}`)
+	require.Error(err)
+	require.Equal(http.StatusBadRequest, res.Code)
+	require.Contains(res.Body.String(), `"field":"new_password"`)
+}
+
+func (suite *ProfileTestSuite) TestChangeEmail_Success() {
+	require := suite.Require()
+
+	suite.expectUser()
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE email = `).
+		WithArgs("new@gmail.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "user_tokens" SET "used_at"`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.sqlMock.ExpectQuery(`INSERT INTO "user_tokens"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	suite.sqlMock.ExpectCommit()
+
+	res, err := suite.CallHandler(suite.profile.ChangeEmail, http.MethodPost, `{"email": "new@gmail.com", "password": "password@123"}`)
+	require.NoError(err)
+	require.Equal(http.StatusAccepted, res.Code)
+	require.Len(suite.mailer.Messages, 2)
+	require.Equal("new@gmail.com", suite.mailer.Messages[0].To)
+	require.Contains(suite.mailer.Messages[0].Body, "https://on-air.test/confirm-email?token=")
+	require.Equal("ali@gmail.com", suite.mailer.Messages[1].To)
+	require.NotContains(suite.mailer.Messages[1].Body, "token=")
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *ProfileTestSuite) TestChangeEmail_Failure_Duplicate() {
+	require := suite.Require()
+
+	suite.expectUser()
+	suite.sqlMock.ExpectQuery(`SELECT \* FROM "users" WHERE email = `).
+		WithArgs("taken@gmail.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(2, "taken@gmail.com"))
+
+	res, err := suite.CallHandler(suite.profile.ChangeEmail, http.MethodPost, `{"email": "taken@gmail.com", "password": "password@123"}`)
+	require.ErrorIs(err, apierror.ErrUserDuplicate)
+	require.Equal(http.StatusConflict, res.Code)
+	require.Empty(suite.mailer.Messages)
+}
+
+func (suite *ProfileTestSuite) TestChangeEmail_Failure_WrongPassword() {
+	require := suite.Require()
+
+	suite.expectUser()
+
+	res, err := suite.CallHandler(suite.profile.ChangeEmail, http.MethodPost, `{"email": "new@gmail.com", "password": "wrong"}`)
+	require.ErrorIs(err, apierror.ErrWrongPassword)
+	require.Equal(http.StatusForbidden, res.Code)
+	require.Empty(suite.mailer.Messages)
+}
+
+func (suite *ProfileTestSuite) TestDelete_Success() {
+	require := suite.Require()
+
+	suite.expectUser()
+	monkey.Patch(repository.DeleteUser, func(_ context.Context, _ *gorm.DB, userID int) error {
+		require.Equal(suite.UserID, userID)
+		return nil
+	})
+	defer monkey.Unpatch(repository.DeleteUser)
+
+	var revoked string
+	monkey.Patch(repository.RevokeToken, func(_ context.Context, _ *redis.Client, claims *repository.Claims) error {
+		revoked = claims.ID
+		return nil
+	})
+	defer monkey.Unpatch(repository.RevokeToken)
+
+	req := httptest.NewRequest(http.MethodDelete, "/me", strings.NewReader(`{"password": "password@123"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	res := httptest.NewRecorder()
+	c := suite.e.NewContext(req, res)
+	c.Set("user_id", suite.UserID)
+	claims := &repository.Claims{UserID: suite.UserID}
+	claims.ID = "token-id"
+	c.Set(middlewares.ClaimsContextField, claims)
+
+	err := suite.profile.Delete(c)
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, res.Code)
+	require.Equal("token-id", revoked)
+}
+
+func (suite *ProfileTestSuite) TestDelete_Failure_WrongPassword() {
+	require := suite.Require()
+
+	suite.expectUser()
+
+	res, err := suite.CallHandler(suite.profile.Delete, http.MethodDelete, `{"password": "wrong"}`)
+	require.ErrorIs(err, apierror.ErrWrongPassword)
+	require.Equal(http.StatusForbidden, res.Code)
+}
+
+func TestProfile(t *testing.T) {
+	suite.Run(t, new(ProfileTestSuite))
+}
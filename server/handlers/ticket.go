@@ -1,16 +1,21 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"on-air/config"
+	"on-air/logging"
 	"on-air/models"
 	"on-air/repository"
+	"on-air/server/apierror"
+	"on-air/server/middlewares"
 	"on-air/server/services"
 	"on-air/utils"
 	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
-	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
@@ -18,6 +23,8 @@ type Ticket struct {
 	DB            *gorm.DB
 	JWT           *config.JWT
 	APIMockClient *services.APIMockClient
+	Outbox        *repository.Outbox
+	Reservation   *config.Reservation
 }
 
 type CountryResponse struct {
@@ -67,51 +74,54 @@ type TicketResponse struct {
 func (t *Ticket) GetTickets(ctx echo.Context) error {
 	userID, _ := ctx.Get("user_id").(int)
 
-	tickets, err := repository.GetUserTickets(t.DB, uint(userID))
+	tickets, err := repository.GetUserTickets(ctx.Request().Context(), t.DB, uint(userID))
 	if err != nil {
-		logrus.Error("ticket_handler: GetTickets failed when use repository.GetUserTickets, error:", err)
-		return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+		middlewares.Logger(ctx).WithError(err).Error("ticket_handler: GetTickets failed when use repository.GetUserTickets")
+		return err
 	}
 
 	var ticketResponses []TicketResponse
 	for _, ticket := range tickets {
-		t := TicketResponse{
-			ID:        ticket.ID,
-			UnitPrice: ticket.UnitPrice,
-			Count:     ticket.Count,
-			Status:    ticket.Status,
-			CreatedAt: ticket.CreatedAt.Format("2006-01-02 15:04"),
-			User: UserResponse{
-				FirstName:   ticket.User.FirstName,
-				LastName:    ticket.User.LastName,
-				Email:       ticket.User.Email,
-				PhoneNumber: ticket.User.PhoneNumber,
-			},
-			Flight: FlightResponse{
-				Number:     ticket.Flight.Number,
-				Airplane:   ticket.Flight.Airplane,
-				Airline:    ticket.Flight.Airline,
-				StartedAt:  ticket.Flight.StartedAt.Format("2006-01-02 15:04"),
-				FinishedAt: ticket.Flight.FinishedAt.Format("2006-01-02 15:04"),
-				FromCity: CityResponse{
-					Name: ticket.Flight.FromCity.Name,
-					Country: CountryResponse{
-						Name: ticket.Flight.FromCity.Country.Name,
-					},
+		ticketResponses = append(ticketResponses, newTicketResponse(ticket))
+	}
+
+	return ctx.JSON(http.StatusOK, ticketResponses)
+}
+
+func newTicketResponse(ticket models.Ticket) TicketResponse {
+	return TicketResponse{
+		ID:        ticket.ID,
+		UnitPrice: ticket.UnitPrice,
+		Count:     ticket.Count,
+		Status:    ticket.Status,
+		CreatedAt: ticket.CreatedAt.Format("2006-01-02 15:04"),
+		User: UserResponse{
+			FirstName:   ticket.User.FirstName,
+			LastName:    ticket.User.LastName,
+			Email:       ticket.User.Email,
+			PhoneNumber: ticket.User.PhoneNumber,
+		},
+		Flight: FlightResponse{
+			Number:     ticket.Flight.Number,
+			Airplane:   ticket.Flight.Airplane,
+			Airline:    ticket.Flight.Airline,
+			StartedAt:  ticket.Flight.StartedAt.Format("2006-01-02 15:04"),
+			FinishedAt: ticket.Flight.FinishedAt.Format("2006-01-02 15:04"),
+			FromCity: CityResponse{
+				Name: ticket.Flight.FromCity.Name,
+				Country: CountryResponse{
+					Name: ticket.Flight.FromCity.Country.Name,
 				},
-				ToCity: CityResponse{
-					Name: ticket.Flight.ToCity.Name,
-					Country: CountryResponse{
-						Name: ticket.Flight.ToCity.Country.Name,
-					},
+			},
+			ToCity: CityResponse{
+				Name: ticket.Flight.ToCity.Name,
+				Country: CountryResponse{
+					Name: ticket.Flight.ToCity.Country.Name,
 				},
 			},
-			Passengers: getPassengers(ticket.Passengers),
-		}
-		ticketResponses = append(ticketResponses, t)
+		},
+		Passengers: getPassengers(ticket.Passengers),
 	}
-
-	return ctx.JSON(http.StatusOK, ticketResponses)
 }
 
 type ReserveRequest struct {
@@ -120,34 +130,36 @@ type ReserveRequest struct {
 }
 
 type ReserveResponse struct {
-	TicketId int `json:"ticket_id" binding:"required"`
+	TicketId  int    `json:"ticket_id" binding:"required"`
+	Status    string `json:"status"`
+	ExpiresAt string `json:"expires_at"`
 }
 
 func (t *Ticket) Reserve(ctx echo.Context) error {
 	userId, _ := ctx.Get("user_id").(int)
 	var req ReserveRequest
 	if err := ctx.Bind(&req); err != nil {
-		return ctx.JSON(http.StatusBadRequest, "Bind Error")
+		return apierror.ErrInvalidRequest.Wrap(err)
 	}
 
 	if err := ctx.Validate(&req); err != nil {
-		return ctx.JSON(http.StatusBadRequest, err.Error())
+		return err
 	}
 
-	flightInfo, err := t.APIMockClient.GetFlight(req.FlightNumber)
+	flightInfo, err := t.APIMockClient.GetFlight(ctx.Request().Context(), req.FlightNumber)
 	if err != nil {
-		logrus.Error("ticket_handler: Reserve failed when use t.APIMockClient.GetFlight, error:", err)
-		return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+		middlewares.Logger(ctx).WithError(err).Error("ticket_handler: Reserve failed when use t.APIMockClient.GetFlight")
+		return err
 	}
 
-	flight, err := repository.FindFlight(t.DB, flightInfo.Number)
+	flight, err := repository.FindFlight(ctx.Request().Context(), t.DB, flightInfo.Number)
 	if err != nil && err.Error() != "record not found" {
-		logrus.Error("ticket_handler: Reserve failed when use repository.FindFlight, error:", err)
-		return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+		middlewares.Logger(ctx).WithError(err).Error("ticket_handler: Reserve failed when use repository.FindFlight")
+		return err
 	}
 
 	if flight == nil {
-		flight, err = repository.AddFlight(t.DB,
+		flight, err = repository.AddFlight(ctx.Request().Context(), t.DB,
 			flightInfo.Number,
 			flightInfo.Origin,
 			flightInfo.Destination,
@@ -160,36 +172,55 @@ func (t *Ticket) Reserve(ctx echo.Context) error {
 	}
 
 	if err != nil {
-		logrus.Error("ticket_handler: Reserve failed when use repository.AddFlight, error:", err)
-		return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+		middlewares.Logger(ctx).WithError(err).Error("ticket_handler: Reserve failed when use repository.AddFlight")
+		return err
 	}
 
-	flightReserve, err := t.APIMockClient.Reserve(req.FlightNumber, len(req.PassengerIDs))
-	if err != nil {
-		logrus.Error("ticket_handler: Reserve failed when use t.APIMockClient.Reserve, error:", err)
-		return ctx.JSON(http.StatusInternalServerError, "Internal server error")
-	}
-
-	if !flightReserve {
-		return ctx.JSON(http.StatusInternalServerError, "Sold out")
-	}
-
-	ticket, err := repository.ReserveTicket(
+	ticket, message, err := repository.ReserveTicket(
+		ctx.Request().Context(),
 		t.DB,
 		userId,
 		int(flight.ID),
+		flight.Number,
 		flightInfo.Price,
 		req.PassengerIDs,
+		t.Reservation.HoldFor(flight.Airline),
+		t.Outbox.Backoff,
 	)
 	if err != nil {
-		t.APIMockClient.Refund(req.FlightNumber, len(req.PassengerIDs))
-		logrus.Error("ticket_handler: Reserve failed when use repository.ReserveTicket, error:", err)
-		return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+		middlewares.Logger(ctx).WithError(err).Error("ticket_handler: Reserve failed when use repository.ReserveTicket")
+		return err
 	}
 
-	return ctx.JSON(http.StatusOK, ReserveResponse{
-		TicketId: int(ticket.ID),
-	})
+	// The worker retries the reservation when this attempt does not go through.
+	message, err = t.Outbox.Dispatch(ctx.Request().Context(), message.ID)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("ticket_handler: Reserve failed when use t.Outbox.Dispatch")
+		return ctx.JSON(http.StatusAccepted, ReserveResponse{
+			TicketId:  int(ticket.ID),
+			Status:    string(models.TicketPending),
+			ExpiresAt: ticket.ExpiresAt.Format(time.RFC3339),
+		})
+	}
+
+	switch models.OutboxStatus(message.Status) {
+	case models.OutboxDelivered:
+		return ctx.JSON(http.StatusOK, ReserveResponse{
+			TicketId:  int(ticket.ID),
+			Status:    string(models.Reserved),
+			ExpiresAt: ticket.ExpiresAt.Format(time.RFC3339),
+		})
+	case models.OutboxRejected:
+		return apierror.ErrFlightSoldOut
+	case models.OutboxDead:
+		return apierror.ErrTicketReservationFailed
+	default:
+		return ctx.JSON(http.StatusAccepted, ReserveResponse{
+			TicketId:  int(ticket.ID),
+			Status:    string(models.TicketPending),
+			ExpiresAt: ticket.ExpiresAt.Format(time.RFC3339),
+		})
+	}
 }
 
 func getPassengers(passengers []models.Passenger) []PassengerResponse {
@@ -211,24 +242,313 @@ func (t *Ticket) GetPDF(ctx echo.Context) error {
 	userID, _ := ctx.Get("user_id").(int)
 	ticketID, err := strconv.Atoi(ctx.QueryParam("ticket_id"))
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, "Invalid ticket_id")
+		return apierror.ErrInvalidTicketID.Wrap(err)
 	}
 
-	ticket, err := repository.GetTicket(t.DB, userID, ticketID)
+	ticket, err := repository.GetTicket(ctx.Request().Context(), t.DB, userID, ticketID)
 	if err != nil {
-		logrus.Error("ticket_handler: GetPDF failed when use repository.GetTicket, error:", err)
-		return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+		middlewares.Logger(ctx).WithError(err).Error("ticket_handler: GetPDF failed when use repository.GetTicket")
+		return err
 	}
 
 	result, err := utils.GeneratePDF(ticket)
 	if err != nil {
-		logrus.Error("ticket_handler: GetPDF failed when use utils.GeneratePDF, error:", err)
-		return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+		middlewares.Logger(ctx).WithError(err).Error("ticket_handler: GetPDF failed when use utils.GeneratePDF")
+		return err
 	}
 
+	return sendPDF(ctx, result)
+}
+
+func sendPDF(ctx echo.Context, result []byte) error {
 	ctx.Response().Header().Set("Content-Type", "application/pdf")
 	ctx.Response().Header().Set("Content-Disposition", "attachment; filename=myfile.pdf")
 	ctx.Response().Header().Set("Content-Length", strconv.Itoa(len(result)))
 
 	return ctx.Blob(http.StatusOK, "application/pdf", result)
 }
+
+type CancelResponse struct {
+	TicketID     uint   `json:"ticket_id"`
+	Status       string `json:"status"`
+	RefundAmount int    `json:"refund_amount"`
+}
+
+func (t *Ticket) Cancel(ctx echo.Context) error {
+	userID, _ := ctx.Get("user_id").(int)
+	ticketID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return apierror.ErrInvalidTicketID.Wrap(err)
+	}
+
+	ticket, err := repository.GetTicket(ctx.Request().Context(), t.DB, userID, ticketID)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("ticket_handler: Cancel failed when use repository.GetTicket")
+		return err
+	}
+
+	if ticket.ID == 0 {
+		return apierror.ErrTicketNotFound
+	}
+
+	refundAmount, err := cancelTicket(ctx, t.DB, t.Outbox, &ticket, models.UserActor(userID), "cancelled by user")
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, CancelResponse{
+		TicketID:     ticket.ID,
+		Status:       string(models.TicketCancelled),
+		RefundAmount: refundAmount,
+	})
+}
+
+// cancelTicket cancels the ticket and refunds what it is owed after the
+// penalties of its flight, for the users cancelling their own tickets and the
+// staff cancelling any ticket.
+func cancelTicket(ctx echo.Context, db *gorm.DB, outbox *repository.Outbox, ticket *models.Ticket, actor string, reason string) (int, error) {
+	if ticket.Status != string(models.Reserved) && ticket.Status != string(models.TicketPaid) {
+		return 0, apierror.ErrTicketNotCancellable
+	}
+
+	refundAmount, messages, err := repository.CancelTicket(ctx.Request().Context(), db, ticket, outbox.Backoff, actor, reason)
+	if errors.Is(err, repository.ErrTicketNotCancellable) || errors.Is(err, repository.ErrStatusChanged) {
+		return 0, apierror.ErrTicketNotCancellable.Wrap(err)
+	}
+
+	if errors.Is(err, repository.ErrFlightDeparted) {
+		return 0, apierror.ErrFlightDeparted.Wrap(err)
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("ticket_handler: cancel failed when use repository.CancelTicket")
+		return 0, err
+	}
+
+	dispatchRefunds(ctx.Request().Context(), outbox, messages...)
+
+	return refundAmount, nil
+}
+
+type CancelPassengersRequest struct {
+	PassengerIDs []int `json:"passengers" binding:"required" validate:"required,min=1"`
+}
+
+func (t *Ticket) CancelPassengers(ctx echo.Context) error {
+	userID, _ := ctx.Get("user_id").(int)
+	ticketID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return apierror.ErrInvalidTicketID.Wrap(err)
+	}
+
+	var req CancelPassengersRequest
+	if err := ctx.Bind(&req); err != nil {
+		return apierror.ErrInvalidRequest.Wrap(err)
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	ticket, err := repository.GetTicket(ctx.Request().Context(), t.DB, userID, ticketID)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("ticket_handler: CancelPassengers failed when use repository.GetTicket")
+		return err
+	}
+
+	if ticket.ID == 0 {
+		return apierror.ErrTicketNotFound
+	}
+
+	if ticket.Status != string(models.Reserved) && ticket.Status != string(models.TicketPaid) {
+		return apierror.ErrTicketNotCancellable
+	}
+
+	passengers, ok := selectPassengers(ticket.Passengers, req.PassengerIDs)
+	if !ok {
+		return apierror.ErrPassengerNotOnTicket
+	}
+
+	if len(passengers) == len(ticket.Passengers) {
+		return apierror.ErrTicketAllPassengers
+	}
+
+	_, messages, err := repository.RemoveTicketPassengers(ctx.Request().Context(), t.DB, &ticket, passengers, t.Outbox.Backoff, models.UserActor(userID))
+	if errors.Is(err, repository.ErrTicketNotCancellable) || errors.Is(err, repository.ErrStatusChanged) {
+		return apierror.ErrTicketNotCancellable.Wrap(err)
+	}
+
+	if errors.Is(err, repository.ErrPassengerNotOnTicket) {
+		return apierror.ErrPassengerNotOnTicket.Wrap(err)
+	}
+
+	if errors.Is(err, repository.ErrTicketAllPassengers) {
+		return apierror.ErrTicketAllPassengers.Wrap(err)
+	}
+
+	if errors.Is(err, repository.ErrFlightDeparted) {
+		return apierror.ErrFlightDeparted.Wrap(err)
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("ticket_handler: CancelPassengers failed when use repository.RemoveTicketPassengers")
+		return err
+	}
+
+	dispatchRefunds(ctx.Request().Context(), t.Outbox, messages...)
+
+	ticket.Passengers = removePassengers(ticket.Passengers, passengers)
+
+	result, err := utils.GeneratePDF(ticket)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("ticket_handler: CancelPassengers failed when use utils.GeneratePDF")
+		return err
+	}
+
+	return sendPDF(ctx, result)
+}
+
+// dispatchRefunds tries to deliver the release of the seats and the refund of
+// the payment right away, the worker retries them when the provider or the
+// gateway is not reachable.
+func dispatchRefunds(ctx context.Context, outbox *repository.Outbox, messages ...*models.OutboxMessage) {
+	for _, message := range messages {
+		_, err := outbox.Dispatch(ctx, message.ID)
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).Error("ticket_handler: refund failed when use outbox.Dispatch")
+		}
+	}
+}
+
+func selectPassengers(passengers []models.Passenger, ids []int) ([]models.Passenger, bool) {
+	byID := make(map[uint]models.Passenger, len(passengers))
+	for _, passenger := range passengers {
+		byID[passenger.ID] = passenger
+	}
+
+	selected := make([]models.Passenger, 0, len(ids))
+	seen := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		passenger, ok := byID[uint(id)]
+		if !ok || seen[passenger.ID] {
+			return nil, false
+		}
+
+		seen[passenger.ID] = true
+		selected = append(selected, passenger)
+	}
+
+	return selected, true
+}
+
+func removePassengers(passengers []models.Passenger, removed []models.Passenger) []models.Passenger {
+	removedIDs := make(map[uint]bool, len(removed))
+	for _, passenger := range removed {
+		removedIDs[passenger.ID] = true
+	}
+
+	remaining := make([]models.Passenger, 0, len(passengers))
+	for _, passenger := range passengers {
+		if !removedIDs[passenger.ID] {
+			remaining = append(remaining, passenger)
+		}
+	}
+
+	return remaining
+}
+
+type ExtendResponse struct {
+	TicketID  uint   `json:"ticket_id"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// Extend gives the user more time on the payment page, once per ticket.
+func (t *Ticket) Extend(ctx echo.Context) error {
+	userID, _ := ctx.Get("user_id").(int)
+	ticketID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return apierror.ErrInvalidTicketID.Wrap(err)
+	}
+
+	ticket, err := repository.GetTicket(ctx.Request().Context(), t.DB, userID, ticketID)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("ticket_handler: Extend failed when use repository.GetTicket")
+		return err
+	}
+
+	if ticket.ID == 0 {
+		return apierror.ErrTicketNotFound
+	}
+
+	if ticket.Extended {
+		return apierror.ErrTicketAlreadyExtended
+	}
+
+	if ticket.Status != string(models.Reserved) || !ticket.ExpiresAt.After(time.Now()) {
+		return apierror.ErrTicketNotExtendable
+	}
+
+	err = repository.ExtendTicket(ctx.Request().Context(), t.DB, &ticket, t.Reservation.ExtensionOrDefault())
+	if errors.Is(err, repository.ErrTicketNotExtendable) {
+		return apierror.ErrTicketNotExtendable
+	}
+
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("ticket_handler: Extend failed when use repository.ExtendTicket")
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, ExtendResponse{
+		TicketID:  ticket.ID,
+		ExpiresAt: ticket.ExpiresAt.Format(time.RFC3339),
+	})
+}
+
+type TimelineEntry struct {
+	Entity   string `json:"entity"`
+	EntityID uint   `json:"entity_id"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Actor    string `json:"actor"`
+	Reason   string `json:"reason,omitempty"`
+	At       string `json:"at"`
+}
+
+func (t *Ticket) GetTimeline(ctx echo.Context) error {
+	userID, _ := ctx.Get("user_id").(int)
+	ticketID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return apierror.ErrInvalidTicketID.Wrap(err)
+	}
+
+	ticket, err := repository.GetTicket(ctx.Request().Context(), t.DB, userID, ticketID)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("ticket_handler: GetTimeline failed when use repository.GetTicket")
+		return err
+	}
+
+	if ticket.ID == 0 {
+		return apierror.ErrTicketNotFound
+	}
+
+	history, err := repository.GetTicketTimeline(ctx.Request().Context(), t.DB, ticket.ID)
+	if err != nil {
+		middlewares.Logger(ctx).WithError(err).Error("ticket_handler: GetTimeline failed when use repository.GetTicketTimeline")
+		return err
+	}
+
+	timeline := make([]TimelineEntry, 0, len(history))
+	for _, entry := range history {
+		timeline = append(timeline, TimelineEntry{
+			Entity:   entry.Entity,
+			EntityID: entry.EntityID,
+			From:     entry.FromStatus,
+			To:       entry.ToStatus,
+			Actor:    entry.Actor,
+			Reason:   entry.Reason,
+			At:       entry.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, timeline)
+}
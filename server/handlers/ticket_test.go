@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -10,13 +11,18 @@ import (
 	"on-air/config"
 	"on-air/models"
 	"on-air/repository"
+	"on-air/server/apierror"
+	"on-air/server/services"
 	"on-air/utils"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"bou.ke/monkey"
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/eapache/go-resiliency/breaker"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/suite"
@@ -40,6 +46,9 @@ func (suite *GetTicketTestSuite) CallHandler() (*httptest.ResponseRecorder, erro
 	c := suite.e.NewContext(req, res)
 	c.Set("id", strconv.Itoa(suite.UserID))
 	err := suite.ticket.GetTickets(c)
+	if err != nil {
+		c.Error(err)
+	}
 	return res, err
 }
 
@@ -66,6 +75,7 @@ func (suite *GetTicketTestSuite) SetupSuite() {
 		},
 	}
 	suite.e = echo.New()
+	suite.e.HTTPErrorHandler = apierror.HTTPErrorHandler
 	suite.e.Validator = &utils.CustomValidator{Validator: validator.New()}
 	suite.endpoint = "/tickets"
 	suite.UserID = 1
@@ -162,7 +172,7 @@ func (suite *GetTicketTestSuite) TestGetTickets_Success() {
 	tickets[0].ID = 1
 	tickets[0].CreatedAt = time
 
-	patch := monkey.Patch(repository.GetUserTickets, func(db *gorm.DB, userID uint) ([]models.Ticket, error) {
+	patch := monkey.Patch(repository.GetUserTickets, func(ctx context.Context, db *gorm.DB, userID uint) ([]models.Ticket, error) {
 		return tickets, nil
 	})
 
@@ -203,6 +213,7 @@ func (suite *GetTicketPDFTestSuite) SetupSuite() {
 	suite.sqlMock = sqlMock
 	suite.ticket = &Ticket{DB: db}
 	suite.e = echo.New()
+	suite.e.HTTPErrorHandler = apierror.HTTPErrorHandler
 	suite.e.Validator = &utils.CustomValidator{Validator: validator.New()}
 	suite.endpoint = "/ticketPDF"
 	suite.UserID = 3
@@ -218,6 +229,9 @@ func (suite *GetTicketPDFTestSuite) CallGetHandler(ticketId int) (*httptest.Resp
 		c.QueryParams().Add("ticket_id", strconv.Itoa(ticketId))
 	}
 	err := suite.ticket.GetPDF(c)
+	if err != nil {
+		c.Error(err)
+	}
 	return res, err
 }
 
@@ -270,7 +284,7 @@ func (suite *GetTicketPDFTestSuite) TestGetList_Success() {
 	tickets[0].ID = 1
 	tickets[0].CreatedAt = time
 
-	patch := monkey.Patch(repository.GetTicket, func(db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+	patch := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
 		return tickets[0], nil
 	})
 
@@ -283,15 +297,15 @@ func (suite *GetTicketPDFTestSuite) TestGetList_Success() {
 func (suite *GetTicketPDFTestSuite) TestGetList_Failure_InvalidTicketID() {
 	require := suite.Require()
 	expectedStatusCode := http.StatusBadRequest
-	expectedBody := "\"Invalid ticket_id\"\n"
+	expectedBody := "{\"error\":{\"code\":\"INVALID_REQUEST\",\"message\":\"Invalid ticket_id\"}}\n"
 
-	patch := monkey.Patch(repository.GetTicket, func(db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+	patch := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
 		return models.Ticket{}, nil
 	})
 	defer patch.Unpatch()
 
 	res, err := suite.CallGetHandler(0)
-	require.NoError(err)
+	require.Error(err)
 	body, _ := io.ReadAll(res.Body)
 	require.Equal(expectedBody, string(body))
 	require.Equal(expectedStatusCode, res.Code)
@@ -300,15 +314,15 @@ func (suite *GetTicketPDFTestSuite) TestGetList_Failure_InvalidTicketID() {
 func (suite *GetTicketPDFTestSuite) TestGetList_Failure_InternalError() {
 	require := suite.Require()
 	expectedStatusCode := http.StatusInternalServerError
-	expectedBody := "\"Internal server error\"\n"
+	expectedBody := "{\"error\":{\"code\":\"INTERNAL_ERROR\",\"message\":\"Internal server error\"}}\n"
 
-	patch := monkey.Patch(repository.GetTicket, func(db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+	patch := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
 		return models.Ticket{}, errors.New("Internal server error")
 	})
 	defer patch.Unpatch()
 
 	res, err := suite.CallGetHandler(2)
-	require.NoError(err)
+	require.Error(err)
 	body, _ := io.ReadAll(res.Body)
 	require.Equal(expectedBody, string(body))
 	require.Equal(expectedStatusCode, res.Code)
@@ -317,9 +331,9 @@ func (suite *GetTicketPDFTestSuite) TestGetList_Failure_InternalError() {
 func (suite *GetTicketPDFTestSuite) TestGetList_Failure_GenerateOutput() {
 	require := suite.Require()
 	expectedStatusCode := http.StatusInternalServerError
-	expectedBody := "\"Internal server error\"\n"
+	expectedBody := "{\"error\":{\"code\":\"INTERNAL_ERROR\",\"message\":\"Internal server error\"}}\n"
 
-	patch1 := monkey.Patch(repository.GetTicket, func(db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+	patch1 := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
 		return models.Ticket{}, nil
 	})
 	defer patch1.Unpatch()
@@ -330,12 +344,463 @@ func (suite *GetTicketPDFTestSuite) TestGetList_Failure_GenerateOutput() {
 	defer patch2.Unpatch()
 
 	res, err := suite.CallGetHandler(2)
-	require.NoError(err)
+	require.Error(err)
 	body, _ := io.ReadAll(res.Body)
 	require.Equal(expectedBody, string(body))
 	require.Equal(expectedStatusCode, res.Code)
 }
 
+type CancelTicketTestSuite struct {
+	suite.Suite
+	sqlMock sqlmock.Sqlmock
+	e       *echo.Echo
+	ticket  *Ticket
+	UserID  int
+}
+
+func (suite *CancelTicketTestSuite) SetupSuite() {
+	mockDB, sqlMock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: mockDB,
+	}))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.sqlMock = sqlMock
+	apiMock := &services.APIMockClient{
+		Client:  &http.Client{},
+		Breaker: &breaker.Breaker{},
+		BaseURL: "http://example.com",
+		Timeout: time.Second,
+	}
+	suite.ticket = &Ticket{
+		DB:            db,
+		APIMockClient: apiMock,
+		Outbox: &repository.Outbox{
+			DB:            db,
+			APIMockClient: apiMock,
+			MaxAttempts:   3,
+			Backoff:       time.Second,
+		},
+		Reservation: &config.Reservation{Extension: 10 * time.Minute},
+	}
+	suite.e = echo.New()
+	suite.e.HTTPErrorHandler = apierror.HTTPErrorHandler
+	suite.e.Validator = &utils.CustomValidator{Validator: validator.New()}
+	suite.UserID = 1
+}
+
+func (suite *CancelTicketTestSuite) CallCancelPassengersHandler(ticketID string, requestBody string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPost, "/tickets/"+ticketID+"/passengers/cancel", strings.NewReader(requestBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	res := httptest.NewRecorder()
+	c := suite.e.NewContext(req, res)
+	c.SetParamNames("id")
+	c.SetParamValues(ticketID)
+	c.Set("user_id", suite.UserID)
+	err := suite.ticket.CancelPassengers(c)
+	if err != nil {
+		c.Error(err)
+	}
+	return res, err
+}
+
+func (suite *CancelTicketTestSuite) CallHandler(ticketID string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPost, "/tickets/"+ticketID+"/cancel", nil)
+	res := httptest.NewRecorder()
+	c := suite.e.NewContext(req, res)
+	c.SetParamNames("id")
+	c.SetParamValues(ticketID)
+	c.Set("user_id", suite.UserID)
+	err := suite.ticket.Cancel(c)
+	if err != nil {
+		c.Error(err)
+	}
+	return res, err
+}
+
+func (suite *CancelTicketTestSuite) paidTicket() models.Ticket {
+	ticket := models.Ticket{
+		UserID:    uint(suite.UserID),
+		UnitPrice: 1000,
+		Count:     2,
+		Status:    string(models.TicketPaid),
+		Flight: models.Flight{
+			Number:    "FL005",
+			StartedAt: time.Now().Add(24 * time.Hour),
+		},
+	}
+	ticket.ID = 7
+	ticket.Passengers = []models.Passenger{
+		{FirstName: "p1_fname", LastName: "p1_lname", NationalCode: "2550000000"},
+		{FirstName: "p2_fname", LastName: "p2_lname", NationalCode: "2550000001"},
+	}
+	ticket.Passengers[0].ID = 11
+	ticket.Passengers[1].ID = 12
+
+	return ticket
+}
+
+func (suite *CancelTicketTestSuite) TestCancel_Success() {
+	require := suite.Require()
+	expectedJSON, _ := json.Marshal(CancelResponse{
+		TicketID:     7,
+		Status:       string(models.TicketCancelled),
+		RefundAmount: 2000,
+	})
+
+	patchGet := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+		return suite.paidTicket(), nil
+	})
+	defer patchGet.Unpatch()
+
+	var actor string
+	patchCancel := monkey.Patch(repository.CancelTicket, func(ctx context.Context, db *gorm.DB, ticket *models.Ticket, dispatchDelay time.Duration, a string, r string) (int, []*models.OutboxMessage, error) {
+		actor = a
+		refund := &models.OutboxMessage{Kind: string(models.OutboxPaymentRefund)}
+		refund.ID = 20
+		release := &models.OutboxMessage{Kind: string(models.OutboxRefund)}
+		release.ID = 21
+		return 2000, []*models.OutboxMessage{refund, release}, nil
+	})
+	defer patchCancel.Unpatch()
+
+	var dispatched []uint
+	patchDispatch := monkey.PatchInstanceMethod(
+		reflect.TypeOf(suite.ticket.Outbox),
+		"Dispatch",
+		func(_ *repository.Outbox, ctx context.Context, id uint) (*models.OutboxMessage, error) {
+			dispatched = append(dispatched, id)
+			return &models.OutboxMessage{Status: string(models.OutboxDelivered)}, nil
+		},
+	)
+	defer patchDispatch.Unpatch()
+
+	res, err := suite.CallHandler("7")
+	require.NoError(err)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal(string(expectedJSON)+"\n", res.Body.String())
+	require.Equal(models.UserActor(suite.UserID), actor)
+	require.Equal([]uint{20, 21}, dispatched)
+}
+
+func (suite *CancelTicketTestSuite) TestCancel_Failure_CancelledMeanwhile() {
+	require := suite.Require()
+
+	patchGet := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+		return suite.paidTicket(), nil
+	})
+	defer patchGet.Unpatch()
+
+	patchCancel := monkey.Patch(repository.CancelTicket, func(ctx context.Context, db *gorm.DB, ticket *models.Ticket, dispatchDelay time.Duration, a string, r string) (int, []*models.OutboxMessage, error) {
+		return 0, nil, repository.ErrTicketNotCancellable
+	})
+	defer patchCancel.Unpatch()
+
+	res, err := suite.CallHandler("7")
+	require.ErrorIs(err, apierror.ErrTicketNotCancellable)
+	require.Equal(http.StatusConflict, res.Code)
+}
+
+func (suite *CancelTicketTestSuite) TestCancel_Failure_NotFound() {
+	require := suite.Require()
+
+	patch := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+		return models.Ticket{}, nil
+	})
+	defer patch.Unpatch()
+
+	res, err := suite.CallHandler("7")
+	require.ErrorIs(err, apierror.ErrTicketNotFound)
+	require.Equal(http.StatusNotFound, res.Code)
+}
+
+func (suite *CancelTicketTestSuite) TestCancel_Failure_Expired() {
+	require := suite.Require()
+
+	patch := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+		ticket := suite.paidTicket()
+		ticket.Status = string(models.TicketExpired)
+		return ticket, nil
+	})
+	defer patch.Unpatch()
+
+	res, err := suite.CallHandler("7")
+	require.ErrorIs(err, apierror.ErrTicketNotCancellable)
+	require.Equal(http.StatusConflict, res.Code)
+}
+
+func (suite *CancelTicketTestSuite) TestCancel_Failure_Departed() {
+	require := suite.Require()
+
+	patchGet := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+		return suite.paidTicket(), nil
+	})
+	defer patchGet.Unpatch()
+
+	patchCancel := monkey.Patch(repository.CancelTicket, func(ctx context.Context, db *gorm.DB, ticket *models.Ticket, dispatchDelay time.Duration, a string, r string) (int, []*models.OutboxMessage, error) {
+		return 0, nil, repository.ErrFlightDeparted
+	})
+	defer patchCancel.Unpatch()
+
+	res, err := suite.CallHandler("7")
+	require.ErrorIs(err, apierror.ErrFlightDeparted)
+	require.Equal(http.StatusConflict, res.Code)
+	require.Equal("{\"error\":{\"code\":\"FLIGHT_DEPARTED\",\"message\":\"Flight departed\"}}\n", res.Body.String())
+}
+
+func (suite *CancelTicketTestSuite) TestCancelPassengers_Success() {
+	require := suite.Require()
+
+	patchGet := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+		return suite.paidTicket(), nil
+	})
+	defer patchGet.Unpatch()
+
+	var removed []models.Passenger
+	patchRemove := monkey.Patch(repository.RemoveTicketPassengers, func(ctx context.Context, db *gorm.DB, ticket *models.Ticket, passengers []models.Passenger, dispatchDelay time.Duration, actor string) (int, []*models.OutboxMessage, error) {
+		removed = passengers
+		ticket.Count = 1
+		refund := &models.OutboxMessage{Kind: string(models.OutboxPaymentRefund)}
+		refund.ID = 22
+		release := &models.OutboxMessage{Kind: string(models.OutboxRefund)}
+		release.ID = 23
+		return 1000, []*models.OutboxMessage{refund, release}, nil
+	})
+	defer patchRemove.Unpatch()
+
+	var dispatched []uint
+	patchDispatch := monkey.PatchInstanceMethod(
+		reflect.TypeOf(suite.ticket.Outbox),
+		"Dispatch",
+		func(_ *repository.Outbox, ctx context.Context, id uint) (*models.OutboxMessage, error) {
+			dispatched = append(dispatched, id)
+			return &models.OutboxMessage{Status: string(models.OutboxDelivered)}, nil
+		},
+	)
+	defer patchDispatch.Unpatch()
+
+	var printed models.Ticket
+	patchPDF := monkey.Patch(utils.GeneratePDF, func(ticket models.Ticket) ([]byte, error) {
+		printed = ticket
+		return []byte("pdf"), nil
+	})
+	defer patchPDF.Unpatch()
+
+	res, err := suite.CallCancelPassengersHandler("7", `{"passengers": [12]}`)
+	require.NoError(err)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal("application/pdf", res.Header().Get("Content-Type"))
+	require.Len(removed, 1)
+	require.Equal(uint(12), removed[0].ID)
+	require.Equal([]uint{22, 23}, dispatched)
+	require.Equal(1, printed.Count)
+	require.Len(printed.Passengers, 1)
+	require.Equal(uint(11), printed.Passengers[0].ID)
+}
+
+func (suite *CancelTicketTestSuite) TestCancelPassengers_Failure_CancelledMeanwhile() {
+	require := suite.Require()
+
+	patchGet := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+		return suite.paidTicket(), nil
+	})
+	defer patchGet.Unpatch()
+
+	patchRemove := monkey.Patch(repository.RemoveTicketPassengers, func(ctx context.Context, db *gorm.DB, ticket *models.Ticket, passengers []models.Passenger, dispatchDelay time.Duration, actor string) (int, []*models.OutboxMessage, error) {
+		return 0, nil, repository.ErrPassengerNotOnTicket
+	})
+	defer patchRemove.Unpatch()
+
+	res, err := suite.CallCancelPassengersHandler("7", `{"passengers": [12]}`)
+	require.ErrorIs(err, apierror.ErrPassengerNotOnTicket)
+	require.Equal(http.StatusBadRequest, res.Code)
+}
+
+func (suite *CancelTicketTestSuite) TestCancelPassengers_Failure_InvalidPassengers() {
+	require := suite.Require()
+
+	patch := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+		return suite.paidTicket(), nil
+	})
+	defer patch.Unpatch()
+
+	res, err := suite.CallCancelPassengersHandler("7", `{"passengers": [13]}`)
+	require.ErrorIs(err, apierror.ErrPassengerNotOnTicket)
+	require.Equal(http.StatusBadRequest, res.Code)
+	require.Equal("{\"error\":{\"code\":\"PASSENGER_NOT_ON_TICKET\",\"message\":\"Invalid passengers\"}}\n", res.Body.String())
+
+	res, err = suite.CallCancelPassengersHandler("7", `{"passengers": [11, 12]}`)
+	require.ErrorIs(err, apierror.ErrTicketAllPassengers)
+	require.Equal(http.StatusConflict, res.Code)
+}
+
+func (suite *CancelTicketTestSuite) CallExtendHandler(ticketID string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPost, "/tickets/"+ticketID+"/extend", nil)
+	res := httptest.NewRecorder()
+	c := suite.e.NewContext(req, res)
+	c.SetParamNames("id")
+	c.SetParamValues(ticketID)
+	c.Set("user_id", suite.UserID)
+	err := suite.ticket.Extend(c)
+	if err != nil {
+		c.Error(err)
+	}
+	return res, err
+}
+
+func (suite *CancelTicketTestSuite) reservedTicket() models.Ticket {
+	ticket := suite.paidTicket()
+	ticket.Status = string(models.Reserved)
+	ticket.ExpiresAt = time.Now().Add(5 * time.Minute).Truncate(time.Second)
+	return ticket
+}
+
+func (suite *CancelTicketTestSuite) TestExtend_Success() {
+	require := suite.Require()
+	ticket := suite.reservedTicket()
+
+	patchGet := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+		return ticket, nil
+	})
+	defer patchGet.Unpatch()
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "tickets" SET "expires_at"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	expectedJSON, _ := json.Marshal(ExtendResponse{
+		TicketID:  7,
+		ExpiresAt: ticket.ExpiresAt.Add(10 * time.Minute).Format(time.RFC3339),
+	})
+
+	res, err := suite.CallExtendHandler("7")
+	require.NoError(err)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal(string(expectedJSON)+"\n", res.Body.String())
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *CancelTicketTestSuite) TestExtend_DefaultExtension() {
+	require := suite.Require()
+	ticket := suite.reservedTicket()
+	suite.ticket.Reservation = &config.Reservation{}
+
+	patchGet := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+		return ticket, nil
+	})
+	defer patchGet.Unpatch()
+
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(`UPDATE "tickets" SET "expires_at"`).
+		WithArgs(ticket.ExpiresAt.Add(config.DefaultExtension), true, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	expectedJSON, _ := json.Marshal(ExtendResponse{
+		TicketID:  7,
+		ExpiresAt: ticket.ExpiresAt.Add(config.DefaultExtension).Format(time.RFC3339),
+	})
+
+	res, err := suite.CallExtendHandler("7")
+	require.NoError(err)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal(string(expectedJSON)+"\n", res.Body.String())
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *CancelTicketTestSuite) TestExtend_Failure_AlreadyExtended() {
+	require := suite.Require()
+	ticket := suite.reservedTicket()
+	ticket.Extended = true
+
+	patchGet := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+		return ticket, nil
+	})
+	defer patchGet.Unpatch()
+
+	res, err := suite.CallExtendHandler("7")
+	require.ErrorIs(err, apierror.ErrTicketAlreadyExtended)
+	require.Equal(http.StatusConflict, res.Code)
+	require.Equal("{\"error\":{\"code\":\"TICKET_ALREADY_EXTENDED\",\"message\":\"Ticket already extended\"}}\n", res.Body.String())
+}
+
+func (suite *CancelTicketTestSuite) TestExtend_Failure_NotReserved() {
+	require := suite.Require()
+
+	patchGet := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+		return suite.paidTicket(), nil
+	})
+	defer patchGet.Unpatch()
+
+	res, err := suite.CallExtendHandler("7")
+	require.ErrorIs(err, apierror.ErrTicketNotExtendable)
+	require.Equal(http.StatusConflict, res.Code)
+	require.Equal("{\"error\":{\"code\":\"TICKET_NOT_EXTENDABLE\",\"message\":\"Ticket can not be extended\"}}\n", res.Body.String())
+}
+
+func (suite *CancelTicketTestSuite) CallTimelineHandler(ticketID string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodGet, "/tickets/"+ticketID+"/timeline", nil)
+	res := httptest.NewRecorder()
+	c := suite.e.NewContext(req, res)
+	c.SetParamNames("id")
+	c.SetParamValues(ticketID)
+	c.Set("user_id", suite.UserID)
+	err := suite.ticket.GetTimeline(c)
+	if err != nil {
+		c.Error(err)
+	}
+	return res, err
+}
+
+func (suite *CancelTicketTestSuite) TestGetTimeline_Success() {
+	require := suite.Require()
+	at := time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC)
+
+	patchGet := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+		return suite.paidTicket(), nil
+	})
+	defer patchGet.Unpatch()
+
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "status_history" WHERE ticket_id = (.+)`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "entity", "entity_id", "ticket_id", "from_status", "to_status", "actor", "reason"}).
+			AddRow(1, at, "ticket", 7, 7, "", string(models.TicketPending), models.UserActor(suite.UserID), "").
+			AddRow(2, at, "ticket", 7, 7, string(models.TicketPending), string(models.Reserved), models.ActorOutbox, "seats reserved by provider"))
+
+	expectedJSON, _ := json.Marshal([]TimelineEntry{
+		{Entity: "ticket", EntityID: 7, To: string(models.TicketPending), Actor: models.UserActor(suite.UserID), At: at.Format(time.RFC3339)},
+		{Entity: "ticket", EntityID: 7, From: string(models.TicketPending), To: string(models.Reserved), Actor: models.ActorOutbox, Reason: "seats reserved by provider", At: at.Format(time.RFC3339)},
+	})
+
+	res, err := suite.CallTimelineHandler("7")
+	require.NoError(err)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal(string(expectedJSON)+"\n", res.Body.String())
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *CancelTicketTestSuite) TestGetTimeline_Failure_NotFound() {
+	require := suite.Require()
+
+	patchGet := monkey.Patch(repository.GetTicket, func(ctx context.Context, db *gorm.DB, userID int, ticketID int) (models.Ticket, error) {
+		return models.Ticket{}, nil
+	})
+	defer patchGet.Unpatch()
+
+	res, err := suite.CallTimelineHandler("7")
+	require.ErrorIs(err, apierror.ErrTicketNotFound)
+	require.Equal(http.StatusNotFound, res.Code)
+	require.Equal("{\"error\":{\"code\":\"TICKET_NOT_FOUND\",\"message\":\"Ticket not found\"}}\n", res.Body.String())
+}
+
 func TestGetTicket(t *testing.T) {
 	suite.Run(t, new(GetTicketTestSuite))
 }
@@ -343,3 +808,7 @@ func TestGetTicket(t *testing.T) {
 func TestGetTicketPDF(t *testing.T) {
 	suite.Run(t, new(GetTicketPDFTestSuite))
 }
+
+func TestCancelTicket(t *testing.T) {
+	suite.Run(t, new(CancelTicketTestSuite))
+}
@@ -1,48 +1,64 @@
 package middlewares
 
 import (
-	"net/http"
-	"on-air/config"
 	"on-air/repository"
+	"on-air/server/apierror"
 	"strings"
 
 	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
 )
 
 const (
 	AuthHeader         = "Authorization"
 	Bearer             = "bearer"
 	UserIdContextField = "user_id"
+	RoleContextField   = "role"
+	ClaimsContextField = "claims"
 )
 
 type Auth struct {
-	JWT *config.JWT
+	Keys  *repository.TokenKeys
+	Redis *redis.Client
 }
 
 func (a *Auth) AuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
 		authHeader := ctx.Request().Header.Get(AuthHeader)
 		if authHeader == "" {
-			return ctx.NoContent(http.StatusUnauthorized)
+			return apierror.ErrUnauthorized
 		}
 
 		authParams := strings.Split(authHeader, " ")
 		if len(authParams) < 2 {
-			return ctx.NoContent(http.StatusUnauthorized)
+			return apierror.ErrUnauthorized
 		}
 
 		authType := strings.ToLower(authParams[0])
 		if authType != Bearer {
-			return ctx.NoContent(http.StatusUnauthorized)
+			return apierror.ErrUnauthorized
 		}
 
 		accessToken := authParams[1]
-		payload, err := repository.VerifyToken(a.JWT, accessToken)
+		claims, err := repository.VerifyToken(a.Keys, accessToken)
 		if err != nil {
-			return ctx.NoContent(http.StatusUnauthorized)
+			return apierror.ErrUnauthorized
 		}
 
-		ctx.Set(UserIdContextField, payload.UserID)
+		revoked, err := repository.IsTokenRevoked(ctx.Request().Context(), a.Redis, claims)
+		if err != nil {
+			Logger(ctx).WithError(err).Error("auth_middleware: check denylist failed when use repository.IsTokenRevoked")
+			return err
+		}
+
+		if revoked {
+			return apierror.ErrUnauthorized
+		}
+
+		ctx.Set(UserIdContextField, claims.UserID)
+		ctx.Set(RoleContextField, claims.Role)
+		ctx.Set(ClaimsContextField, claims)
+		setLogger(ctx, Logger(ctx).WithField(UserIdContextField, claims.UserID))
 		return next(ctx)
 	}
 }
@@ -0,0 +1,106 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"on-air/config"
+	"on-air/models"
+	"on-air/repository"
+	"on-air/server/apierror"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+)
+
+type AuthMiddlewareTestSuite struct {
+	suite.Suite
+	mockRedis redismock.ClientMock
+	e         *echo.Echo
+	auth      *Auth
+}
+
+func (suite *AuthMiddlewareTestSuite) SetupSuite() {
+	redisClient, mockRedis := redismock.NewClientMock()
+	suite.mockRedis = mockRedis
+	suite.e = echo.New()
+	suite.e.HTTPErrorHandler = apierror.HTTPErrorHandler
+	tokenKeys, err := repository.NewTokenKeys(&config.JWT{
+		SecretKey: "testSecret",
+		ExpiresIn: time.Minute,
+	})
+	suite.Require().NoError(err)
+
+	suite.auth = &Auth{
+		Keys:  tokenKeys,
+		Redis: redisClient,
+	}
+}
+
+func (suite *AuthMiddlewareTestSuite) SetupTest() {
+	suite.mockRedis.ClearExpect()
+}
+
+func (suite *AuthMiddlewareTestSuite) CallHandler(authHeader string) (*httptest.ResponseRecorder, echo.Context) {
+	req := httptest.NewRequest(http.MethodGet, "/tickets", nil)
+	if authHeader != "" {
+		req.Header.Set(AuthHeader, authHeader)
+	}
+
+	res := httptest.NewRecorder()
+	ctx := suite.e.NewContext(req, res)
+
+	handler := suite.auth.AuthMiddleware(func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	})
+
+	err := handler(ctx)
+	if err != nil {
+		ctx.Error(err)
+	}
+
+	return res, ctx
+}
+
+func (suite *AuthMiddlewareTestSuite) TestAuthMiddleware_Success() {
+	require := suite.Require()
+
+	accessToken, _ := repository.CreateToken(suite.auth.Keys, 7, models.RoleSupport)
+	claims, _ := repository.VerifyToken(suite.auth.Keys, accessToken)
+	suite.mockRedis.ExpectExists("revoked_token_" + claims.ID).SetVal(0)
+
+	res, ctx := suite.CallHandler("Bearer " + accessToken)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal(7, ctx.Get(UserIdContextField))
+	require.Equal(models.RoleSupport, ctx.Get(RoleContextField))
+	require.Equal(claims.ID, ctx.Get(ClaimsContextField).(*repository.Claims).ID)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *AuthMiddlewareTestSuite) TestAuthMiddleware_Revoked() {
+	require := suite.Require()
+
+	accessToken, _ := repository.CreateToken(suite.auth.Keys, 7, models.RoleCustomer)
+	claims, _ := repository.VerifyToken(suite.auth.Keys, accessToken)
+	suite.mockRedis.ExpectExists("revoked_token_" + claims.ID).SetVal(1)
+
+	res, _ := suite.CallHandler("Bearer " + accessToken)
+	require.Equal(http.StatusUnauthorized, res.Code)
+	require.Contains(res.Body.String(), `"code":"UNAUTHORIZED"`)
+}
+
+func (suite *AuthMiddlewareTestSuite) TestAuthMiddleware_InvalidToken() {
+	require := suite.Require()
+
+	res, _ := suite.CallHandler("Bearer invalid")
+	require.Equal(http.StatusUnauthorized, res.Code)
+
+	res, _ = suite.CallHandler("")
+	require.Equal(http.StatusUnauthorized, res.Code)
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	suite.Run(t, new(AuthMiddlewareTestSuite))
+}
@@ -0,0 +1,32 @@
+package middlewares
+
+import (
+	"on-air/models"
+	"on-air/server/apierror"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Authorization lets through the users with one of its roles, it runs after
+// the auth middleware which puts the role of the user into the context.
+type Authorization struct {
+	Roles []models.Role
+}
+
+func (a *Authorization) AuthorizationMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		role, ok := ctx.Get(RoleContextField).(models.Role)
+		if !ok {
+			return apierror.ErrUnauthorized
+		}
+
+		for _, allowed := range a.Roles {
+			if role == allowed {
+				return next(ctx)
+			}
+		}
+
+		Logger(ctx).WithField(RoleContextField, role).Warn("authorization_middleware: role is not allowed")
+		return apierror.ErrForbidden
+	}
+}
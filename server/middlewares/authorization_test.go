@@ -0,0 +1,75 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"on-air/models"
+	"on-air/server/apierror"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+)
+
+type AuthorizationMiddlewareTestSuite struct {
+	suite.Suite
+	e             *echo.Echo
+	authorization *Authorization
+}
+
+func (suite *AuthorizationMiddlewareTestSuite) SetupSuite() {
+	suite.e = echo.New()
+	suite.e.HTTPErrorHandler = apierror.HTTPErrorHandler
+	suite.authorization = &Authorization{
+		Roles: []models.Role{models.RoleFinance, models.RoleAdmin},
+	}
+}
+
+func (suite *AuthorizationMiddlewareTestSuite) CallHandler(role interface{}) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+	res := httptest.NewRecorder()
+	ctx := suite.e.NewContext(req, res)
+	if role != nil {
+		ctx.Set(RoleContextField, role)
+	}
+
+	handler := suite.authorization.AuthorizationMiddleware(func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	})
+
+	err := handler(ctx)
+	if err != nil {
+		ctx.Error(err)
+	}
+
+	return res
+}
+
+func (suite *AuthorizationMiddlewareTestSuite) TestAuthorizationMiddleware_Allowed() {
+	require := suite.Require()
+
+	require.Equal(http.StatusOK, suite.CallHandler(models.RoleFinance).Code)
+	require.Equal(http.StatusOK, suite.CallHandler(models.RoleAdmin).Code)
+}
+
+func (suite *AuthorizationMiddlewareTestSuite) TestAuthorizationMiddleware_Forbidden() {
+	require := suite.Require()
+
+	res := suite.CallHandler(models.RoleSupport)
+	require.Equal(http.StatusForbidden, res.Code)
+	require.Equal("{\"error\":{\"code\":\"FORBIDDEN\",\"message\":\"Permission denied\"}}\n", res.Body.String())
+
+	res = suite.CallHandler(models.RoleCustomer)
+	require.Equal(http.StatusForbidden, res.Code)
+}
+
+func (suite *AuthorizationMiddlewareTestSuite) TestAuthorizationMiddleware_Unauthenticated() {
+	require := suite.Require()
+
+	res := suite.CallHandler(nil)
+	require.Equal(http.StatusUnauthorized, res.Code)
+}
+
+func TestAuthorizationMiddleware(t *testing.T) {
+	suite.Run(t, new(AuthorizationMiddlewareTestSuite))
+}
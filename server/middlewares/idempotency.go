@@ -0,0 +1,180 @@
+package middlewares
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"on-air/server/apierror"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	idempotencyRedisKeyPrefix = "idempotency"
+)
+
+// DefaultIdempotencyTTL is how long a key is remembered when idempotency.ttl
+// is not set, a zero TTL would keep the keys in Redis forever.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+type Idempotency struct {
+	Redis *redis.Client
+	TTL   time.Duration
+}
+
+func (i *Idempotency) ttl() time.Duration {
+	if i.TTL > 0 {
+		return i.TTL
+	}
+
+	return DefaultIdempotencyTTL
+}
+
+// idempotentResponse is what is stored in Redis for an idempotency key. It is
+// stored without a response while the first request is being processed.
+type idempotentResponse struct {
+	Fingerprint string `json:"fingerprint"`
+	Completed   bool   `json:"completed"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// IdempotencyMiddleware replays the stored response of a request carrying an
+// already seen Idempotency-Key header instead of running the handler again.
+// It must run after AuthMiddleware, keys are scoped to the authenticated user.
+func (i *Idempotency) IdempotencyMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		key := ctx.Request().Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			return next(ctx)
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			return apierror.ErrIdempotencyKeyInvalid
+		}
+
+		body, err := io.ReadAll(ctx.Request().Body)
+		if err != nil {
+			return apierror.ErrInvalidRequest.Wrap(err)
+		}
+		ctx.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+		reqCtx := ctx.Request().Context()
+		redisKey := fmt.Sprintf("%s_%v_%s", idempotencyRedisKeyPrefix, ctx.Get(UserIdContextField), key)
+		fingerprint := requestFingerprint(ctx.Request().Method, ctx.Path(), body)
+
+		claim, _ := json.Marshal(idempotentResponse{Fingerprint: fingerprint})
+		claimed, err := i.Redis.SetNX(reqCtx, redisKey, claim, i.ttl()).Result()
+		if err != nil {
+			Logger(ctx).WithError(err).Error("idempotency_middleware: claim key failed when use i.Redis.SetNX")
+			return err
+		}
+
+		if !claimed {
+			return i.replay(ctx, redisKey, fingerprint)
+		}
+
+		recorder := &responseRecorder{ResponseWriter: ctx.Response().Writer}
+		ctx.Response().Writer = recorder
+
+		err = next(ctx)
+		if err != nil {
+			// The error response is written here, so a rejected request is
+			// stored and replayed like any other response.
+			ctx.Error(err)
+		}
+
+		status := ctx.Response().Status
+		if status >= http.StatusInternalServerError {
+			// Let the client retry a request that did not complete.
+			if delErr := i.Redis.Del(reqCtx, redisKey).Err(); delErr != nil {
+				Logger(ctx).WithError(delErr).Error("idempotency_middleware: release key failed when use i.Redis.Del")
+			}
+			return err
+		}
+
+		stored, _ := json.Marshal(idempotentResponse{
+			Fingerprint: fingerprint,
+			Completed:   true,
+			StatusCode:  status,
+			ContentType: ctx.Response().Header().Get(echo.HeaderContentType),
+			Body:        recorder.body.Bytes(),
+		})
+		if setErr := i.Redis.Set(reqCtx, redisKey, stored, i.ttl()).Err(); setErr != nil {
+			Logger(ctx).WithError(setErr).Error("idempotency_middleware: store response failed when use i.Redis.Set")
+		}
+
+		return err
+	}
+}
+
+func (i *Idempotency) replay(ctx echo.Context, redisKey string, fingerprint string) error {
+	result, err := i.Redis.Get(ctx.Request().Context(), redisKey).Bytes()
+	if err == redis.Nil {
+		return apierror.ErrIdempotencyInProgress
+	}
+
+	if err != nil {
+		Logger(ctx).WithError(err).Error("idempotency_middleware: replay failed when use i.Redis.Get")
+		return err
+	}
+
+	var stored idempotentResponse
+	if err := json.Unmarshal(result, &stored); err != nil {
+		Logger(ctx).WithError(err).Error("idempotency_middleware: replay failed when use json.Unmarshal")
+		return err
+	}
+
+	if stored.Fingerprint != fingerprint {
+		return apierror.ErrIdempotencyKeyReused
+	}
+
+	if !stored.Completed {
+		return apierror.ErrIdempotencyInProgress
+	}
+
+	ctx.Response().Header().Set(IdempotentReplayedHeader, "true")
+	return ctx.Blob(stored.StatusCode, stored.ContentType, stored.Body)
+}
+
+func requestFingerprint(method string, path string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method))
+	hash.Write([]byte(path))
+	hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder keeps a copy of the response body while writing it through.
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return r.ResponseWriter.(http.Hijacker).Hijack()
+}
@@ -0,0 +1,211 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"on-air/server/apierror"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+)
+
+type IdempotencyTestSuite struct {
+	suite.Suite
+	mockRedis   redismock.ClientMock
+	e           *echo.Echo
+	idempotency *Idempotency
+	calls       int
+	handlerErr  error
+}
+
+func (suite *IdempotencyTestSuite) SetupSuite() {
+	redisClient, mockRedis := redismock.NewClientMock()
+	suite.mockRedis = mockRedis
+	suite.e = echo.New()
+	suite.e.HTTPErrorHandler = apierror.HTTPErrorHandler
+	suite.idempotency = &Idempotency{
+		Redis: redisClient,
+		TTL:   time.Hour,
+	}
+}
+
+func (suite *IdempotencyTestSuite) SetupTest() {
+	suite.calls = 0
+	suite.handlerErr = nil
+	suite.mockRedis.ClearExpect()
+}
+
+func (suite *IdempotencyTestSuite) CallHandler(key string, body string, status int) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/tickets/reserve", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+
+	res := httptest.NewRecorder()
+	ctx := suite.e.NewContext(req, res)
+	ctx.SetPath("/tickets/reserve")
+	ctx.Set(UserIdContextField, 1)
+
+	handler := suite.idempotency.IdempotencyMiddleware(func(ctx echo.Context) error {
+		suite.calls++
+		if suite.handlerErr != nil {
+			return suite.handlerErr
+		}
+		return ctx.JSON(status, map[string]int{"ticket_id": 5})
+	})
+	if err := handler(ctx); err != nil {
+		ctx.Error(err)
+	}
+
+	return res
+}
+
+func (suite *IdempotencyTestSuite) claim(body string) []byte {
+	claim, _ := json.Marshal(idempotentResponse{
+		Fingerprint: requestFingerprint(http.MethodPost, "/tickets/reserve", []byte(body)),
+	})
+
+	return claim
+}
+
+func (suite *IdempotencyTestSuite) TestIdempotency_WithoutKey() {
+	require := suite.Require()
+
+	res := suite.CallHandler("", `{}`, http.StatusOK)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal(1, suite.calls)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *IdempotencyTestSuite) TestIdempotency_FirstRequest() {
+	require := suite.Require()
+	body := `{"flight_number":"FL001"}`
+
+	stored, _ := json.Marshal(idempotentResponse{
+		Fingerprint: requestFingerprint(http.MethodPost, "/tickets/reserve", []byte(body)),
+		Completed:   true,
+		StatusCode:  http.StatusOK,
+		ContentType: echo.MIMEApplicationJSONCharsetUTF8,
+		Body:        []byte("{\"ticket_id\":5}\n"),
+	})
+
+	suite.mockRedis.ExpectSetNX("idempotency_1_key-1", suite.claim(body), time.Hour).SetVal(true)
+	suite.mockRedis.ExpectSet("idempotency_1_key-1", stored, time.Hour).SetVal("OK")
+
+	res := suite.CallHandler("key-1", body, http.StatusOK)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal(1, suite.calls)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *IdempotencyTestSuite) TestIdempotency_DefaultTTL() {
+	require := suite.Require()
+	body := `{"flight_number":"FL001"}`
+
+	suite.idempotency.TTL = 0
+	defer func() { suite.idempotency.TTL = time.Hour }()
+
+	// Without idempotency.ttl the keys still expire.
+	suite.mockRedis.ExpectSetNX("idempotency_1_key-1", suite.claim(body), DefaultIdempotencyTTL).SetVal(true)
+	suite.mockRedis.Regexp().ExpectSet("idempotency_1_key-1", `.*`, DefaultIdempotencyTTL).SetVal("OK")
+
+	res := suite.CallHandler("key-1", body, http.StatusOK)
+	require.Equal(http.StatusOK, res.Code)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *IdempotencyTestSuite) TestIdempotency_Replay() {
+	require := suite.Require()
+	body := `{"flight_number":"FL001"}`
+
+	stored, _ := json.Marshal(idempotentResponse{
+		Fingerprint: requestFingerprint(http.MethodPost, "/tickets/reserve", []byte(body)),
+		Completed:   true,
+		StatusCode:  http.StatusOK,
+		ContentType: echo.MIMEApplicationJSONCharsetUTF8,
+		Body:        []byte("{\"ticket_id\":5}\n"),
+	})
+
+	suite.mockRedis.ExpectSetNX("idempotency_1_key-1", suite.claim(body), time.Hour).SetVal(false)
+	suite.mockRedis.ExpectGet("idempotency_1_key-1").SetVal(string(stored))
+
+	res := suite.CallHandler("key-1", body, http.StatusOK)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal(0, suite.calls)
+	require.Equal("{\"ticket_id\":5}\n", res.Body.String())
+	require.Equal("true", res.Header().Get(IdempotentReplayedHeader))
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *IdempotencyTestSuite) TestIdempotency_Conflict_DifferentBody() {
+	require := suite.Require()
+	body := `{"flight_number":"FL002"}`
+
+	suite.mockRedis.ExpectSetNX("idempotency_1_key-1", suite.claim(body), time.Hour).SetVal(false)
+	suite.mockRedis.ExpectGet("idempotency_1_key-1").SetVal(string(suite.claim(`{"flight_number":"FL001"}`)))
+
+	res := suite.CallHandler("key-1", body, http.StatusOK)
+	require.Equal(http.StatusUnprocessableEntity, res.Code)
+	require.Equal(0, suite.calls)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *IdempotencyTestSuite) TestIdempotency_Conflict_InProgress() {
+	require := suite.Require()
+	body := `{"flight_number":"FL001"}`
+
+	suite.mockRedis.ExpectSetNX("idempotency_1_key-1", suite.claim(body), time.Hour).SetVal(false)
+	suite.mockRedis.ExpectGet("idempotency_1_key-1").SetVal(string(suite.claim(body)))
+
+	res := suite.CallHandler("key-1", body, http.StatusOK)
+	require.Equal(http.StatusConflict, res.Code)
+	require.Equal(0, suite.calls)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *IdempotencyTestSuite) TestIdempotency_ServerError_ReleasesKey() {
+	require := suite.Require()
+	body := `{"flight_number":"FL001"}`
+
+	suite.mockRedis.ExpectSetNX("idempotency_1_key-1", suite.claim(body), time.Hour).SetVal(true)
+	suite.mockRedis.ExpectDel("idempotency_1_key-1").SetVal(1)
+
+	res := suite.CallHandler("key-1", body, http.StatusInternalServerError)
+	require.Equal(http.StatusInternalServerError, res.Code)
+	require.Equal(1, suite.calls)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *IdempotencyTestSuite) TestIdempotency_RejectedRequest_Stored() {
+	require := suite.Require()
+	body := `{"flight_number":"FL001"}`
+	errorBody := "{\"error\":{\"code\":\"FLIGHT_SOLD_OUT\",\"message\":\"Sold out\"}}\n"
+	suite.handlerErr = apierror.ErrFlightSoldOut
+
+	stored, _ := json.Marshal(idempotentResponse{
+		Fingerprint: requestFingerprint(http.MethodPost, "/tickets/reserve", []byte(body)),
+		Completed:   true,
+		StatusCode:  http.StatusConflict,
+		ContentType: echo.MIMEApplicationJSONCharsetUTF8,
+		Body:        []byte(errorBody),
+	})
+
+	suite.mockRedis.ExpectSetNX("idempotency_1_key-1", suite.claim(body), time.Hour).SetVal(true)
+	suite.mockRedis.ExpectSet("idempotency_1_key-1", stored, time.Hour).SetVal("OK")
+
+	res := suite.CallHandler("key-1", body, http.StatusOK)
+	require.Equal(http.StatusConflict, res.Code)
+	require.Equal(errorBody, res.Body.String())
+	require.Equal(1, suite.calls)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func TestIdempotency(t *testing.T) {
+	suite.Run(t, new(IdempotencyTestSuite))
+}
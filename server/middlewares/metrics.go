@@ -0,0 +1,51 @@
+package middlewares
+
+import (
+	"on-air/metrics"
+	"on-air/server/apierror"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// unmatchedRoute labels the requests that did not match a route, so unknown
+// paths do not grow the number of series.
+const unmatchedRoute = "unmatched"
+
+type Metrics struct{}
+
+// MetricsMiddleware records the latency and the status of the requests per
+// route template, e.g. /tickets/:id/cancel.
+func (m *Metrics) MetricsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		start := time.Now()
+		err := next(ctx)
+
+		metrics.HTTPRequestDuration.
+			WithLabelValues(ctx.Request().Method, routeOf(ctx), strconv.Itoa(responseStatus(ctx, err))).
+			Observe(time.Since(start).Seconds())
+
+		return err
+	}
+}
+
+// routeOf returns the route template the request matched.
+func routeOf(ctx echo.Context) string {
+	route := ctx.Path()
+	if route == "" {
+		return unmatchedRoute
+	}
+
+	return route
+}
+
+// responseStatus returns the status the request is answered with, including
+// the one the error handler writes for err after the middleware returns.
+func responseStatus(ctx echo.Context, err error) int {
+	if err == nil || ctx.Response().Committed {
+		return ctx.Response().Status
+	}
+
+	return apierror.From(err).Status
+}
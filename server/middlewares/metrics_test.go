@@ -0,0 +1,72 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"on-air/metrics"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/suite"
+)
+
+type MetricsMiddlewareTestSuite struct {
+	suite.Suite
+	e *echo.Echo
+}
+
+func (suite *MetricsMiddlewareTestSuite) SetupSuite() {
+	metricsMiddleware := &Metrics{}
+
+	suite.e = echo.New()
+	suite.e.Use(metricsMiddleware.MetricsMiddleware)
+	suite.e.GET("/tickets/:id", func(ctx echo.Context) error {
+		if ctx.Param("id") == "0" {
+			return echo.NewHTTPError(http.StatusNotFound)
+		}
+
+		return ctx.JSON(http.StatusOK, "OK")
+	})
+}
+
+func (suite *MetricsMiddlewareTestSuite) serve(method string, path string) {
+	req := httptest.NewRequest(method, path, nil)
+	res := httptest.NewRecorder()
+	suite.e.ServeHTTP(res, req)
+}
+
+func (suite *MetricsMiddlewareTestSuite) sampleCount(method string, route string, status string) uint64 {
+	var metric dto.Metric
+	err := metrics.HTTPRequestDuration.WithLabelValues(method, route, status).(prometheus.Metric).Write(&metric)
+	suite.Require().NoError(err)
+
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func (suite *MetricsMiddlewareTestSuite) TestMetricsMiddleware_Route() {
+	require := suite.Require()
+	ok := suite.sampleCount(http.MethodGet, "/tickets/:id", "200")
+	notFound := suite.sampleCount(http.MethodGet, "/tickets/:id", "404")
+
+	suite.serve(http.MethodGet, "/tickets/1")
+	suite.serve(http.MethodGet, "/tickets/2")
+	suite.serve(http.MethodGet, "/tickets/0")
+
+	require.Equal(ok+2, suite.sampleCount(http.MethodGet, "/tickets/:id", "200"))
+	require.Equal(notFound+1, suite.sampleCount(http.MethodGet, "/tickets/:id", "404"))
+}
+
+func (suite *MetricsMiddlewareTestSuite) TestMetricsMiddleware_MethodNotAllowed() {
+	require := suite.Require()
+	count := suite.sampleCount(http.MethodPost, "/tickets/:id", "405")
+
+	suite.serve(http.MethodPost, "/tickets/1")
+
+	require.Equal(count+1, suite.sampleCount(http.MethodPost, "/tickets/:id", "405"))
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	suite.Run(t, new(MetricsMiddlewareTestSuite))
+}
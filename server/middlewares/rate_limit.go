@@ -0,0 +1,208 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"on-air/config"
+	"on-air/server/apierror"
+	"on-air/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	RateLimitLimitHeader     = "X-RateLimit-Limit"
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	RateLimitResetHeader     = "X-RateLimit-Reset"
+	RetryAfterHeader         = "Retry-After"
+	rateLimitRedisKeyPrefix  = "rate_limit"
+)
+
+// slidingWindowScript keeps the times of the requests of KEYS[1] in the last
+// ARGV[1] milliseconds in a sorted set and adds the request ARGV[3] when
+// there are fewer than ARGV[2]. The time is the Redis time, so the servers
+// agree on it. It returns whether the request is allowed, the requests in
+// the window and the milliseconds until the oldest one leaves it.
+var slidingWindowScript = redis.NewScript(`
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+
+local count = redis.call("ZCARD", KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call("ZADD", KEYS[1], now, ARGV[3])
+	count = count + 1
+	allowed = 1
+end
+redis.call("PEXPIRE", KEYS[1], window)
+
+local reset = window
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+
+return {allowed, count, reset}
+`)
+
+// RateLimitKey returns the key the requests are counted by, e.g. the IP of
+// the client. Requests with an empty key are not limited.
+type RateLimitKey func(ctx echo.Context) (string, error)
+
+// RateLimit allows the requests of a key Rule.Limit times in a sliding window
+// of Rule.Window. Name is the name of the rule, routes sharing it share the
+// counts.
+type RateLimit struct {
+	Redis *redis.Client
+	Name  string
+	Rule  config.RateLimitRule
+	Key   RateLimitKey
+}
+
+// RateLimitMiddleware answers the requests over the limit with 429 and a
+// Retry-After header, every limited response carries the X-RateLimit-*
+// headers. Requests are let through when Redis fails, a broken limiter must
+// not take the API down.
+func (r *RateLimit) RateLimitMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	if r.Rule.Limit <= 0 || r.Rule.Window <= 0 {
+		return next
+	}
+
+	return func(ctx echo.Context) error {
+		key, err := r.Key(ctx)
+		if err != nil {
+			return err
+		}
+
+		if key == "" {
+			return next(ctx)
+		}
+
+		member := make([]byte, 8)
+		_, _ = rand.Read(member)
+
+		result, err := slidingWindowScript.Run(ctx.Request().Context(), r.Redis,
+			[]string{fmt.Sprintf("%s_%s_%s", rateLimitRedisKeyPrefix, r.Name, key)},
+			r.Rule.Window.Milliseconds(), r.Rule.Limit, hex.EncodeToString(member),
+		).Int64Slice()
+		if err != nil || len(result) != 3 {
+			Logger(ctx).WithError(err).WithField("rule", r.Name).Error("rate_limit_middleware: count request failed when use slidingWindowScript.Run")
+			return next(ctx)
+		}
+
+		allowed, count, reset := result[0] == 1, int(result[1]), time.Duration(result[2])*time.Millisecond
+		resetSeconds := strconv.Itoa(int(math.Ceil(reset.Seconds())))
+
+		header := ctx.Response().Header()
+		header.Set(RateLimitLimitHeader, strconv.Itoa(r.Rule.Limit))
+		header.Set(RateLimitRemainingHeader, strconv.Itoa(r.Rule.Limit-count))
+		header.Set(RateLimitResetHeader, resetSeconds)
+
+		if !allowed {
+			header.Set(RetryAfterHeader, resetSeconds)
+			return apierror.ErrRateLimited
+		}
+
+		return next(ctx)
+	}
+}
+
+// KeyByIP counts the requests of the client IP, as the IPExtractor of the
+// server finds it.
+func KeyByIP(ctx echo.Context) (string, error) {
+	return ctx.RealIP(), nil
+}
+
+// IPExtractor finds the client IP in X-Forwarded-For only when the request
+// comes from one of the trustedProxies CIDR ranges, otherwise a client could
+// send any IP in the header and get past the limits. With no proxies it is
+// the address of the connection.
+func IPExtractor(trustedProxies []string) (echo.IPExtractor, error) {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, proxy := range trustedProxies {
+		_, ipRange, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
+// KeyByUserID counts the requests of the authenticated user, it must run
+// after AuthMiddleware.
+func KeyByUserID(ctx echo.Context) (string, error) {
+	userID, ok := ctx.Get(UserIdContextField).(int)
+	if !ok {
+		return "", nil
+	}
+
+	return strconv.Itoa(userID), nil
+}
+
+// KeyByEmail counts the requests for the email of the JSON body, e.g. the
+// logins of an account from any IP. The body is left for the handler.
+func KeyByEmail(ctx echo.Context) (string, error) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	ok, err := peekBody(ctx, &req)
+	if err != nil || !ok {
+		return "", err
+	}
+
+	return strings.ToLower(strings.TrimSpace(req.Email)), nil
+}
+
+// KeyByPhoneNumber counts the requests for the mobile number of the JSON body,
+// in the form users are stored with, e.g. the codes asked for and tried for a
+// number from any IP. A body without a mobile number is left to the handler
+// to refuse.
+func KeyByPhoneNumber(ctx echo.Context) (string, error) {
+	var req struct {
+		PhoneNumber string `json:"phone_number"`
+	}
+	ok, err := peekBody(ctx, &req)
+	if err != nil || !ok {
+		return "", err
+	}
+
+	phoneNumber, _ := utils.NormalizeMobileNumber(req.PhoneNumber)
+
+	return phoneNumber, nil
+}
+
+// peekBody decodes the JSON body into v and leaves the body for the handler,
+// it reports false for a body that is not JSON.
+func peekBody(ctx echo.Context, v interface{}) (bool, error) {
+	body, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return false, apierror.ErrInvalidRequest.Wrap(err)
+	}
+	ctx.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+	return json.Unmarshal(body, v) == nil, nil
+}
@@ -0,0 +1,236 @@
+package middlewares
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"on-air/config"
+	"on-air/server/apierror"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+)
+
+type RateLimitTestSuite struct {
+	suite.Suite
+	mockRedis redismock.ClientMock
+	e         *echo.Echo
+	rateLimit *RateLimit
+	calls     int
+}
+
+func (suite *RateLimitTestSuite) SetupSuite() {
+	redisClient, mockRedis := redismock.NewClientMock()
+	suite.mockRedis = mockRedis
+	suite.e = echo.New()
+	suite.e.HTTPErrorHandler = apierror.HTTPErrorHandler
+	suite.rateLimit = &RateLimit{
+		Redis: redisClient,
+		Name:  "login_ip",
+		Rule:  config.RateLimitRule{Limit: 5, Window: time.Minute},
+		Key:   KeyByIP,
+	}
+}
+
+func (suite *RateLimitTestSuite) SetupTest() {
+	suite.calls = 0
+	suite.mockRedis.ClearExpect()
+}
+
+func (suite *RateLimitTestSuite) CallHandler(rateLimit *RateLimit) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{}`))
+	req.Header.Set(echo.HeaderXRealIP, "10.0.0.1")
+	res := httptest.NewRecorder()
+	ctx := suite.e.NewContext(req, res)
+
+	handler := rateLimit.RateLimitMiddleware(func(ctx echo.Context) error {
+		suite.calls++
+		return ctx.NoContent(http.StatusOK)
+	})
+	if err := handler(ctx); err != nil {
+		ctx.Error(err)
+	}
+
+	return res
+}
+
+// expectWindow expects the count of a request of 10.0.0.1 and answers it
+// with result.
+func (suite *RateLimitTestSuite) expectWindow(result []interface{}, err error) {
+	expect := suite.mockRedis.CustomMatch(func(expected, actual []interface{}) error {
+		suite.Require().Equal("evalsha", actual[0])
+		suite.Require().Equal(slidingWindowScript.Hash(), actual[1])
+		suite.Require().Equal("rate_limit_login_ip_10.0.0.1", actual[3])
+		suite.Require().EqualValues(60000, actual[4])
+		suite.Require().EqualValues(5, actual[5])
+		return nil
+	}).ExpectEvalSha(slidingWindowScript.Hash(), []string{"rate_limit_login_ip_10.0.0.1"}, int64(60000), 5, "member")
+
+	if err != nil {
+		expect.SetErr(err)
+		return
+	}
+	expect.SetVal(result)
+}
+
+func (suite *RateLimitTestSuite) TestRateLimit_Allowed() {
+	require := suite.Require()
+
+	suite.expectWindow([]interface{}{int64(1), int64(2), int64(41500)}, nil)
+
+	res := suite.CallHandler(suite.rateLimit)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal(1, suite.calls)
+	require.Equal("5", res.Header().Get(RateLimitLimitHeader))
+	require.Equal("3", res.Header().Get(RateLimitRemainingHeader))
+	require.Equal("42", res.Header().Get(RateLimitResetHeader))
+	require.Empty(res.Header().Get(RetryAfterHeader))
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *RateLimitTestSuite) TestRateLimit_Limited() {
+	require := suite.Require()
+
+	suite.expectWindow([]interface{}{int64(0), int64(5), int64(12000)}, nil)
+
+	res := suite.CallHandler(suite.rateLimit)
+	require.Equal(http.StatusTooManyRequests, res.Code)
+	require.Equal(0, suite.calls)
+	require.Equal("0", res.Header().Get(RateLimitRemainingHeader))
+	require.Equal("12", res.Header().Get(RetryAfterHeader))
+	require.Contains(res.Body.String(), `"code":"TOO_MANY_REQUESTS"`)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *RateLimitTestSuite) TestRateLimit_RedisDown() {
+	require := suite.Require()
+
+	suite.expectWindow(nil, errors.New("connection refused"))
+
+	res := suite.CallHandler(suite.rateLimit)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal(1, suite.calls)
+	require.Empty(res.Header().Get(RateLimitLimitHeader))
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *RateLimitTestSuite) TestRateLimit_NoLimit() {
+	require := suite.Require()
+
+	rateLimit := *suite.rateLimit
+	rateLimit.Rule = config.RateLimitRule{}
+
+	res := suite.CallHandler(&rateLimit)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal(1, suite.calls)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *RateLimitTestSuite) TestRateLimit_EmptyKey() {
+	require := suite.Require()
+
+	rateLimit := *suite.rateLimit
+	rateLimit.Key = KeyByUserID
+
+	res := suite.CallHandler(&rateLimit)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal(1, suite.calls)
+	require.NoError(suite.mockRedis.ExpectationsWereMet())
+}
+
+func (suite *RateLimitTestSuite) TestKeyByEmail() {
+	require := suite.Require()
+
+	body := `{"email": " Admin@Gmail.com ", "password": "admin"}`
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(body))
+	ctx := suite.e.NewContext(req, httptest.NewRecorder())
+
+	key, err := KeyByEmail(ctx)
+	require.NoError(err)
+	require.Equal("admin@gmail.com", key)
+
+	// The handler still reads the whole body.
+	read, err := io.ReadAll(ctx.Request().Body)
+	require.NoError(err)
+	require.Equal(body, string(read))
+
+	req = httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`not json`))
+	key, err = KeyByEmail(suite.e.NewContext(req, httptest.NewRecorder()))
+	require.NoError(err)
+	require.Empty(key)
+}
+
+func (suite *RateLimitTestSuite) TestKeyByPhoneNumber() {
+	require := suite.Require()
+
+	// Every form of a number counts as the same number.
+	body := `{"phone_number": "+98 912 123 4567", "code": "01234"}`
+	req := httptest.NewRequest(http.MethodPost, "/auth/otp/verify", strings.NewReader(body))
+	ctx := suite.e.NewContext(req, httptest.NewRecorder())
+
+	key, err := KeyByPhoneNumber(ctx)
+	require.NoError(err)
+	require.Equal("09121234567", key)
+
+	read, err := io.ReadAll(ctx.Request().Body)
+	require.NoError(err)
+	require.Equal(body, string(read))
+
+	req = httptest.NewRequest(http.MethodPost, "/auth/otp/verify", strings.NewReader(`{"phone_number": "12345"}`))
+	key, err = KeyByPhoneNumber(suite.e.NewContext(req, httptest.NewRecorder()))
+	require.NoError(err)
+	require.Empty(key)
+}
+
+func (suite *RateLimitTestSuite) TestKeyByUserID() {
+	require := suite.Require()
+
+	ctx := suite.e.NewContext(httptest.NewRequest(http.MethodGet, "/admin/users", nil), httptest.NewRecorder())
+	ctx.Set(UserIdContextField, 7)
+
+	key, err := KeyByUserID(ctx)
+	require.NoError(err)
+	require.Equal("7", key)
+}
+
+func (suite *RateLimitTestSuite) TestKeyByIP_SpoofedHeaders() {
+	require := suite.Require()
+
+	keyOf := func(extractor echo.IPExtractor, remoteAddr string) string {
+		e := echo.New()
+		e.IPExtractor = extractor
+
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(echo.HeaderXForwardedFor, "1.2.3.4")
+		req.Header.Set(echo.HeaderXRealIP, "5.6.7.8")
+
+		key, err := KeyByIP(e.NewContext(req, httptest.NewRecorder()))
+		require.NoError(err)
+		return key
+	}
+
+	// Without proxies the headers are ignored.
+	direct, err := IPExtractor(nil)
+	require.NoError(err)
+	require.Equal("203.0.113.7", keyOf(direct, "203.0.113.7:4321"))
+
+	// Behind a proxy only its header is believed.
+	proxied, err := IPExtractor([]string{"10.0.0.0/8"})
+	require.NoError(err)
+	require.Equal("1.2.3.4", keyOf(proxied, "10.1.2.3:4321"))
+	require.Equal("203.0.113.7", keyOf(proxied, "203.0.113.7:4321"))
+	require.Equal("192.168.1.5", keyOf(proxied, "192.168.1.5:4321"))
+
+	_, err = IPExtractor([]string{"10.0.0.0"})
+	require.Error(err)
+}
+
+func TestRateLimit(t *testing.T) {
+	suite.Run(t, new(RateLimitTestSuite))
+}
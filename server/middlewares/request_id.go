@@ -0,0 +1,91 @@
+package middlewares
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"on-air/logging"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	LoggerContextField = "logger"
+	maxRequestIDLength = 128
+)
+
+type RequestID struct{}
+
+// RequestIDMiddleware gives every request an id, the one the client sends in
+// X-Request-ID when it is valid, and answers with it. The request is logged
+// through an entry carrying the id and the route, which is put in the Echo
+// context and in the request context for the handlers and the clients of the
+// provider and the gateways.
+func (r *RequestID) RequestIDMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		requestID := ctx.Request().Header.Get(logging.RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		ctx.Response().Header().Set(logging.RequestIDHeader, requestID)
+
+		req := ctx.Request()
+		ctx.SetRequest(req.WithContext(logging.WithRequestID(req.Context(), requestID)))
+		setLogger(ctx, logrus.WithFields(logrus.Fields{
+			"request_id": requestID,
+			"route":      routeOf(ctx),
+		}))
+
+		start := time.Now()
+		err := next(ctx)
+
+		// The entry is read again since AuthMiddleware adds the user to it.
+		Logger(ctx).WithFields(logrus.Fields{
+			"method":     ctx.Request().Method,
+			"status":     responseStatus(ctx, err),
+			"latency_ms": time.Since(start).Milliseconds(),
+		}).Info("request completed")
+
+		return err
+	}
+}
+
+// Logger returns the request-scoped entry of the request, or an entry of the
+// standard logger when RequestIDMiddleware did not run, e.g. in tests.
+func Logger(ctx echo.Context) *logrus.Entry {
+	if entry, ok := ctx.Get(LoggerContextField).(*logrus.Entry); ok {
+		return entry
+	}
+
+	return logging.FromContext(ctx.Request().Context())
+}
+
+func setLogger(ctx echo.Context, entry *logrus.Entry) {
+	ctx.Set(LoggerContextField, entry)
+
+	req := ctx.Request()
+	ctx.SetRequest(req.WithContext(logging.WithLogger(req.Context(), entry)))
+}
+
+// validRequestID accepts printable ASCII ids of a sane length, anything else
+// is replaced so it can not forge log lines.
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] < '!' || requestID[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
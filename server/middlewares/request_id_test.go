@@ -0,0 +1,83 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"on-air/logging"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type RequestIDMiddlewareTestSuite struct {
+	suite.Suite
+	e *echo.Echo
+
+	requestID string
+	entry     *logrus.Entry
+}
+
+func (suite *RequestIDMiddlewareTestSuite) SetupSuite() {
+	requestIDMiddleware := &RequestID{}
+
+	suite.e = echo.New()
+	suite.e.Use(requestIDMiddleware.RequestIDMiddleware)
+	suite.e.GET("/tickets/:id", func(ctx echo.Context) error {
+		suite.requestID = logging.RequestID(ctx.Request().Context())
+		suite.entry = Logger(ctx)
+
+		return ctx.JSON(http.StatusOK, "OK")
+	})
+}
+
+func (suite *RequestIDMiddlewareTestSuite) serve(requestID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/tickets/1", nil)
+	if requestID != "" {
+		req.Header.Set(logging.RequestIDHeader, requestID)
+	}
+
+	res := httptest.NewRecorder()
+	suite.e.ServeHTTP(res, req)
+
+	return res
+}
+
+func (suite *RequestIDMiddlewareTestSuite) TestRequestIDMiddleware_Accepted() {
+	require := suite.Require()
+
+	res := suite.serve("client-request-1")
+	require.Equal("client-request-1", res.Header().Get(logging.RequestIDHeader))
+	require.Equal("client-request-1", suite.requestID)
+	require.Equal("client-request-1", suite.entry.Data["request_id"])
+	require.Equal("/tickets/:id", suite.entry.Data["route"])
+}
+
+func (suite *RequestIDMiddlewareTestSuite) TestRequestIDMiddleware_Generated() {
+	require := suite.Require()
+
+	res := suite.serve("")
+	requestID := res.Header().Get(logging.RequestIDHeader)
+	require.Len(requestID, 32)
+	require.Equal(requestID, suite.requestID)
+	require.Equal(requestID, suite.entry.Data["request_id"])
+
+	res = suite.serve("")
+	require.NotEqual(requestID, res.Header().Get(logging.RequestIDHeader))
+}
+
+func (suite *RequestIDMiddlewareTestSuite) TestRequestIDMiddleware_Invalid() {
+	require := suite.Require()
+
+	for _, requestID := range []string{"forged\nlevel=error", strings.Repeat("a", maxRequestIDLength+1)} {
+		res := suite.serve(requestID)
+		require.NotEqual(requestID, res.Header().Get(logging.RequestIDHeader))
+		require.Len(res.Header().Get(logging.RequestIDHeader), 32)
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	suite.Run(t, new(RequestIDMiddlewareTestSuite))
+}
@@ -0,0 +1,52 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"on-air/tracing"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Tracing struct{}
+
+// TracingMiddleware starts the server span of the request, continuing the
+// trace of the caller when it sends a traceparent header. The span is put in
+// the request context, so everything run with ctx.Request().Context() is
+// recorded under it.
+func (t *Tracing) TracingMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		req := ctx.Request()
+		parent := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+		route := routeOf(ctx)
+		spanCtx, span := tracing.Tracer().Start(parent, fmt.Sprintf("%s %s", req.Method, route),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethod(req.Method),
+				semconv.HTTPRoute(route),
+			),
+		)
+		defer span.End()
+
+		ctx.SetRequest(req.WithContext(spanCtx))
+
+		err := next(ctx)
+
+		status := responseStatus(ctx, err)
+		span.SetAttributes(semconv.HTTPStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		if err != nil {
+			span.RecordError(err)
+		}
+
+		return err
+	}
+}
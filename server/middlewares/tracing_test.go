@@ -0,0 +1,74 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type TracingMiddlewareTestSuite struct {
+	suite.Suite
+	e        *echo.Echo
+	recorder *tracetest.SpanRecorder
+	handled  trace.SpanContext
+}
+
+func (suite *TracingMiddlewareTestSuite) SetupTest() {
+	suite.recorder = tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(suite.recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	tracingMiddleware := &Tracing{}
+
+	suite.e = echo.New()
+	suite.e.Use(tracingMiddleware.TracingMiddleware)
+	suite.e.GET("/tickets/:id", func(ctx echo.Context) error {
+		suite.handled = trace.SpanContextFromContext(ctx.Request().Context())
+		if ctx.Param("id") == "0" {
+			return ctx.JSON(http.StatusInternalServerError, "Internal server error")
+		}
+
+		return ctx.JSON(http.StatusOK, "OK")
+	})
+}
+
+func (suite *TracingMiddlewareTestSuite) TestTracingMiddleware_ContinuesTrace() {
+	require := suite.Require()
+
+	req := httptest.NewRequest(http.MethodGet, "/tickets/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	suite.e.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := suite.recorder.Ended()
+	require.Len(spans, 1)
+	require.Equal("GET /tickets/:id", spans[0].Name())
+	require.Equal(trace.SpanKindServer, spans[0].SpanKind())
+	require.Equal("4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	require.Equal("00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+	require.Equal(spans[0].SpanContext().SpanID(), suite.handled.SpanID())
+}
+
+func (suite *TracingMiddlewareTestSuite) TestTracingMiddleware_ServerError() {
+	require := suite.Require()
+
+	req := httptest.NewRequest(http.MethodGet, "/tickets/0", nil)
+	suite.e.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := suite.recorder.Ended()
+	require.Len(spans, 1)
+	require.False(spans[0].Parent().IsValid())
+	require.Equal(codes.Error, spans[0].Status().Code)
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	suite.Run(t, new(TracingMiddlewareTestSuite))
+}
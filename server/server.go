@@ -1,24 +1,37 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"on-air/server/middlewares"
 
 	"net/http"
 	"on-air/config"
+	"on-air/mailer"
+	"on-air/metrics"
+	"on-air/models"
 	"on-air/repository"
+	"on-air/server/apierror"
 	"on-air/server/handlers"
 	"on-air/server/services"
+	"on-air/server/services/gateway"
+	"on-air/sms"
+	"on-air/tracing"
 	"on-air/utils"
 
 	"github.com/eapache/go-resiliency/breaker"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
+const defaultShutdownTimeout = 10 * time.Second
+
 type CustomValidator struct {
 	validator *validator.Validate
 }
@@ -30,8 +43,20 @@ func (cv *CustomValidator) Validate(i interface{}) error {
 	return nil
 }
 
-func SetupServer(cfg *config.Config, db *gorm.DB, redis *redis.Client, port string) error {
+// SetupServer serves the API until ctx is done and then shuts down
+// gracefully: /readyz starts failing and the open requests get
+// server.shutdown_timeout to finish.
+func SetupServer(ctx context.Context, cfg *config.Config, db *gorm.DB, redis *redis.Client, port string) error {
 	e := echo.New()
+	e.HideBanner = true
+	e.HTTPErrorHandler = apierror.HTTPErrorHandler
+
+	ipExtractor, err := middlewares.IPExtractor(cfg.Server.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	e.IPExtractor = ipExtractor
+
 	customValidator := &utils.CustomValidator{
 		Validator: validator.New(),
 	}
@@ -39,63 +64,169 @@ func SetupServer(cfg *config.Config, db *gorm.DB, redis *redis.Client, port stri
 	_ = customValidator.Validator.RegisterValidation("CustomTimeValidator", utils.CustomTimeValidator)
 	e.Validator = customValidator
 
+	requestIDMiddleware := &middlewares.RequestID{}
+	tracingMiddleware := &middlewares.Tracing{}
+	metricsMiddleware := &middlewares.Metrics{}
+	e.Use(requestIDMiddleware.RequestIDMiddleware, tracingMiddleware.TracingMiddleware, metricsMiddleware.MetricsMiddleware)
+	e.GET("/metrics", echo.WrapHandler(metrics.Handler()))
+
 	apiMock := &services.APIMockClient{
-		Client:  &http.Client{},
+		Client:  &http.Client{Transport: tracing.Transport(nil)},
 		Breaker: &breaker.Breaker{},
 		BaseURL: cfg.Services.ApiMock.BaseURL,
 		Timeout: cfg.Services.ApiMock.Timeout,
 	}
 
-	cityRepo := &repository.City{
-		APIMockClient: apiMock,
+	health := &handlers.Health{
 		DB:            db,
-		SyncPeriod:    cfg.Services.ApiMock.CitiesSyncPeriod,
+		Redis:         redis,
+		APIMockClient: apiMock,
 	}
 
-	go cityRepo.SyncCities()
+	e.GET("/healthz", health.Healthz)
+	e.GET("/readyz", health.Readyz)
+
+	tokenKeys, err := repository.NewTokenKeys(&cfg.JWT)
+	if err != nil {
+		return err
+	}
 
 	authMiddleware := &middlewares.Auth{
-		JWT: &cfg.JWT,
+		Keys:  tokenKeys,
+		Redis: redis,
+	}
+
+	idempotencyMiddleware := &middlewares.Idempotency{
+		Redis: redis,
+		TTL:   cfg.Idempotency.TTL,
+	}
+
+	mail, err := mailer.New(&cfg.Mail)
+	if err != nil {
+		return err
+	}
+
+	smsSender, err := sms.New(&cfg.SMS)
+	if err != nil {
+		return err
+	}
+
+	rateLimit := func(rule string, key middlewares.RateLimitKey) echo.MiddlewareFunc {
+		limiter := &middlewares.RateLimit{
+			Redis: redis,
+			Name:  rule,
+			Rule:  cfg.RateLimit.Rules[rule],
+			Key:   key,
+		}
+		return limiter.RateLimitMiddleware
 	}
 
 	auth := &handlers.Auth{
-		DB:  db,
-		JWT: &cfg.JWT,
+		DB:        db,
+		Redis:     redis,
+		JWT:       &cfg.JWT,
+		Keys:      tokenKeys,
+		Account:   &cfg.Account,
+		Mailer:    mail,
+		SMS:       smsSender,
+		RateLimit: &cfg.RateLimit,
 	}
 
-	e.POST("/auth/login", auth.Login)
-	e.POST("/auth/register", auth.Register)
+	e.POST("/auth/login", auth.Login, rateLimit("login_ip", middlewares.KeyByIP), rateLimit("login_email", middlewares.KeyByEmail))
+	e.POST("/auth/register", auth.Register, rateLimit("register_ip", middlewares.KeyByIP))
+	e.POST("/auth/refresh", auth.Refresh, rateLimit("token_ip", middlewares.KeyByIP))
+	e.POST("/auth/logout", auth.Logout, authMiddleware.AuthMiddleware)
+	e.POST("/auth/verify-email", auth.VerifyEmail, rateLimit("token_ip", middlewares.KeyByIP))
+	e.POST("/auth/forgot-password", auth.ForgotPassword, rateLimit("forgot_password_ip", middlewares.KeyByIP), rateLimit("forgot_password_email", middlewares.KeyByEmail))
+	e.POST("/auth/reset-password", auth.ResetPassword, rateLimit("token_ip", middlewares.KeyByIP))
+	e.POST("/auth/confirm-email", auth.ConfirmEmail, rateLimit("token_ip", middlewares.KeyByIP))
+	e.POST("/auth/otp/request", auth.RequestOTP, rateLimit("otp_ip", middlewares.KeyByIP), rateLimit("otp_phone_number", middlewares.KeyByPhoneNumber))
+	e.POST("/auth/otp/verify", auth.VerifyOTP, rateLimit("otp_ip", middlewares.KeyByIP), rateLimit("otp_phone_number", middlewares.KeyByPhoneNumber))
+	e.GET("/.well-known/jwks.json", auth.JWKS)
+
+	profile := &handlers.Profile{
+		DB:        db,
+		Redis:     redis,
+		Account:   &cfg.Account,
+		RateLimit: &cfg.RateLimit,
+		Mailer:    mail,
+		SMS:       smsSender,
+	}
+
+	// The routes that check the password are limited so it can not be guessed
+	// with a stolen access token.
+	passwordCheck := rateLimit("password_check_user_id", middlewares.KeyByUserID)
+	e.GET("/me", profile.Get, authMiddleware.AuthMiddleware)
+	e.PATCH("/me", profile.Update, authMiddleware.AuthMiddleware)
+	e.POST("/me/phone_number/otp", profile.RequestPhoneNumberOTP, authMiddleware.AuthMiddleware, rateLimit("otp_ip", middlewares.KeyByIP), rateLimit("otp_phone_number", middlewares.KeyByPhoneNumber))
+	e.DELETE("/me", profile.Delete, authMiddleware.AuthMiddleware, passwordCheck)
+	e.POST("/me/password", profile.ChangePassword, authMiddleware.AuthMiddleware, passwordCheck)
+	e.POST("/me/email", profile.ChangeEmail, authMiddleware.AuthMiddleware, passwordCheck)
+
+	gateways, err := gateway.NewRegistryFromConfig(&cfg.IPG)
+	if err != nil {
+		return err
+	}
+
+	outbox := &repository.Outbox{
+		DB:            db,
+		APIMockClient: apiMock,
+		Gateways:      gateways,
+		MaxAttempts:   cfg.Outbox.MaxAttemptsOrDefault(),
+		Backoff:       cfg.Outbox.BackoffOrDefault(),
+	}
 
 	ticket := &handlers.Ticket{
 		DB:            db,
 		JWT:           &cfg.JWT,
 		APIMockClient: apiMock,
+		Outbox:        outbox,
+		Reservation:   &cfg.Reservation,
 	}
 
 	e.GET("/tickets", ticket.GetTickets, authMiddleware.AuthMiddleware)
-	e.POST("/tickets/reserve", ticket.Reserve, authMiddleware.AuthMiddleware)
+	e.POST("/tickets/reserve", ticket.Reserve, authMiddleware.AuthMiddleware, idempotencyMiddleware.IdempotencyMiddleware)
 	e.GET("/tickets/pdf", ticket.GetPDF, authMiddleware.AuthMiddleware)
+	e.POST("/tickets/:id/extend", ticket.Extend, authMiddleware.AuthMiddleware)
+	e.GET("/tickets/:id/timeline", ticket.GetTimeline, authMiddleware.AuthMiddleware)
+	e.POST("/tickets/:id/cancel", ticket.Cancel, authMiddleware.AuthMiddleware)
+	e.POST("/tickets/:id/passengers/cancel", ticket.CancelPassengers, authMiddleware.AuthMiddleware)
+
+	admin := &handlers.Admin{
+		DB:     db,
+		Outbox: outbox,
+	}
+
+	staff := &middlewares.Authorization{Roles: []models.Role{models.RoleSupport, models.RoleFinance, models.RoleAdmin}}
+	support := &middlewares.Authorization{Roles: []models.Role{models.RoleSupport, models.RoleAdmin}}
+	finance := &middlewares.Authorization{Roles: []models.Role{models.RoleFinance, models.RoleAdmin}}
+	admins := &middlewares.Authorization{Roles: []models.Role{models.RoleAdmin}}
+
+	adminGroup := e.Group("/admin", authMiddleware.AuthMiddleware)
+	adminGroup.GET("/users", admin.SearchUsers, staff.AuthorizationMiddleware, rateLimit("search_users_user_id", middlewares.KeyByUserID))
+	adminGroup.PUT("/users/:id/role", admin.ChangeRole, admins.AuthorizationMiddleware)
+	adminGroup.GET("/tickets/:id", admin.GetTicket, staff.AuthorizationMiddleware)
+	adminGroup.POST("/tickets/:id/expire", admin.ExpireTicket, support.AuthorizationMiddleware)
+	adminGroup.POST("/tickets/:id/cancel", admin.CancelTicket, support.AuthorizationMiddleware)
+	adminGroup.POST("/tickets/:id/refund", admin.RefundTicket, finance.AuthorizationMiddleware, idempotencyMiddleware.IdempotencyMiddleware)
 
 	payment := &handlers.Payment{
-		DB:  db,
-		IPG: &cfg.IPG,
+		DB:       db,
+		IPG:      &cfg.IPG,
+		Gateways: gateways,
 	}
 
-	e.POST("/payments/pay", payment.Pay, authMiddleware.AuthMiddleware)
+	e.POST("/payments/pay", payment.Pay, authMiddleware.AuthMiddleware, idempotencyMiddleware.IdempotencyMiddleware)
 	e.GET("/payments/callBack", payment.CallBack)
+	e.GET("/payments/callBack/:gateway", payment.CallBack)
 
 	flight := &handlers.Flight{
-		Redis: redis,
-		APIMockClient: &services.APIMockClient{
-			Client:  &http.Client{},
-			Breaker: &breaker.Breaker{},
-			BaseURL: cfg.Services.ApiMock.BaseURL,
-			Timeout: cfg.Services.ApiMock.Timeout,
-		},
-		Cache: &cfg.Redis,
+		Redis:         redis,
+		APIMockClient: apiMock,
+		Cache:         &cfg.Redis,
 	}
 
-	e.GET("/flights", flight.GetFlights)
+	e.GET("/flights", flight.GetFlights, rateLimit("flights_ip", middlewares.KeyByIP))
 
 	passenger := &handlers.Passenger{
 		DB: db,
@@ -104,5 +235,37 @@ func SetupServer(cfg *config.Config, db *gorm.DB, redis *redis.Client, port stri
 	e.GET("/passengers", passenger.Get, authMiddleware.AuthMiddleware)
 	e.POST("/passengers", passenger.Create, authMiddleware.AuthMiddleware)
 
-	return e.Start(fmt.Sprintf(":%s", port))
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- e.Start(fmt.Sprintf(":%s", port))
+	}()
+
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	logrus.Info("server: shutting down")
+	health.ShutDown()
+
+	timeout := cfg.Server.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err = e.Shutdown(shutdownCtx)
+	if err != nil {
+		return err
+	}
+
+	err = <-serverErr
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
 }
@@ -2,21 +2,27 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"on-air/config"
+	"on-air/mailer"
 	"on-air/models"
+	"on-air/repository"
 	"on-air/server/handlers"
 	"on-air/server/middlewares"
+	"on-air/sms"
 	"on-air/utils"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/suite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -56,13 +62,25 @@ func (suite *IntegrationTestSuite) TearDownSuite() {
 }
 
 func (suite *IntegrationTestSuite) initHandlers() {
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+
+	tokenKeys, err := repository.NewTokenKeys(&suite.JWT)
+	suite.Require().NoError(err)
+
 	authMiddleware := &middlewares.Auth{
-		JWT: &suite.JWT,
+		Keys:  tokenKeys,
+		Redis: redisClient,
 	}
 
 	auth := &handlers.Auth{
-		DB:  suite.db,
-		JWT: &suite.JWT,
+		DB:        suite.db,
+		Redis:     redisClient,
+		JWT:       &suite.JWT,
+		Keys:      tokenKeys,
+		Account:   &config.Account{TokenSecret: "tokenSecret"},
+		Mailer:    &mailer.LogMailer{},
+		SMS:       &sms.ConsoleSender{},
+		RateLimit: &config.RateLimit{},
 	}
 	suite.e.POST("/auth/register", auth.Register)
 	suite.e.POST("/auth/login", auth.Login)
@@ -104,12 +122,16 @@ func (suite *IntegrationTestSuite) initDB() {
 	}
 
 	db.AutoMigrate(&models.User{})
+	db.AutoMigrate(&models.RefreshToken{})
+	db.AutoMigrate(&models.UserToken{})
 	db.AutoMigrate(&models.Country{})
 	db.AutoMigrate(&models.City{})
 	db.AutoMigrate(&models.Flight{})
 	db.AutoMigrate(&models.Ticket{})
 	db.AutoMigrate(&models.Passenger{})
 	db.AutoMigrate(&models.Payment{})
+	db.AutoMigrate(&models.StatusHistory{})
+	db.AutoMigrate(&models.OutboxMessage{})
 	suite.db = db
 }
 
@@ -162,8 +184,8 @@ func (suite *IntegrationTestSuite) TestRegister_Success() {
 
 func (suite *IntegrationTestSuite) TestRegister_Failure() {
 	require := suite.Require()
-	expectedStatusCode := http.StatusBadRequest
-	expectedBody := "\"User exist\"\n"
+	expectedStatusCode := http.StatusConflict
+	expectedBody := "{\"error\":{\"code\":\"USER_DUPLICATE\",\"message\":\"User exists\"}}\n"
 
 	newUser := handlers.RegisterRequest{
 		Email:    "masoud.aghdasifam@gmail.com",
@@ -212,7 +234,7 @@ func (suite *IntegrationTestSuite) TestLogin_Success() {
 func (suite *IntegrationTestSuite) TestLogin_Failure() {
 	require := suite.Require()
 	expectedStatusCode := http.StatusUnauthorized
-	expectedBody := "\"Invalid credentials\"\n"
+	expectedBody := "{\"error\":{\"code\":\"INVALID_CREDENTIALS\",\"message\":\"Invalid credentials\"}}\n"
 
 	newUser := handlers.RegisterRequest{
 		Email:    "mohammad.serpush@gmail.com",
@@ -289,8 +311,8 @@ func (suite *IntegrationTestSuite) TestPassenger_Success() {
 
 func (suite *IntegrationTestSuite) TestPassenger_Failure() {
 	require := suite.Require()
-	expectedStatusCode := http.StatusBadRequest
-	expectedBody := "\"Passenger exists\"\n"
+	expectedStatusCode := http.StatusConflict
+	expectedBody := "{\"error\":{\"code\":\"PASSENGER_DUPLICATE\",\"message\":\"Passenger exists\"}}\n"
 
 	newUser := handlers.RegisterRequest{
 		Email:    "ehsan.fayez@gmail.com",
@@ -339,6 +361,132 @@ func (suite *IntegrationTestSuite) TestPassenger_Failure() {
 	require.Equal(expectedStatusCode, res.Code)
 }
 
+// paidTicket creates a paid ticket of two passengers whose 2000 payment is
+// verified.
+func (suite *IntegrationTestSuite) paidTicket(email string, flightNumber string) models.Ticket {
+	require := suite.Require()
+
+	city := models.City{Name: "Tehran", Country: models.Country{Name: "Iran"}}
+	require.NoError(suite.db.Create(&city).Error)
+
+	user := models.User{Email: email, Role: string(models.RoleCustomer)}
+	require.NoError(suite.db.Create(&user).Error)
+
+	passengers := []models.Passenger{
+		{UserID: user.ID, NationalCode: "2550000000", FirstName: "p1_fname", LastName: "p1_lname"},
+		{UserID: user.ID, NationalCode: "2550000001", FirstName: "p2_fname", LastName: "p2_lname"},
+	}
+	require.NoError(suite.db.Create(&passengers).Error)
+
+	ticket := models.Ticket{
+		UserID:    user.ID,
+		UnitPrice: 1000,
+		Count:     2,
+		Status:    string(models.TicketPaid),
+		ExpiresAt: time.Now(),
+		Flight: models.Flight{
+			Number:     flightNumber,
+			FromCityID: city.ID,
+			ToCityID:   city.ID,
+			StartedAt:  time.Now().Add(48 * time.Hour),
+			FinishedAt: time.Now().Add(50 * time.Hour),
+		},
+		Passengers: passengers,
+	}
+	require.NoError(suite.db.Create(&ticket).Error)
+
+	payment := models.Payment{
+		TicketID: ticket.ID,
+		Amount:   2000,
+		Status:   string(models.Verified),
+		Gateway:  "pasargad",
+	}
+	require.NoError(suite.db.Create(&payment).Error)
+
+	return ticket
+}
+
+func (suite *IntegrationTestSuite) TestCancelTicket_Concurrent() {
+	require := suite.Require()
+	ticket := suite.paidTicket("cancel.concurrent@gmail.com", "FL900")
+
+	// The user and the staff cancel the ticket at once, only one of them
+	// cancels and refunds it.
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	refunds := make([]int, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			copied := ticket
+			refunds[i], _, errs[i] = repository.CancelTicket(context.Background(), suite.db, &copied, time.Minute, models.UserActor(int(ticket.UserID)), "cancelled")
+		}(i)
+	}
+	wg.Wait()
+
+	cancelled := 0
+	for i, err := range errs {
+		if err == nil {
+			cancelled++
+			require.Equal(2000, refunds[i])
+			continue
+		}
+
+		require.ErrorIs(err, repository.ErrTicketNotCancellable)
+	}
+	require.Equal(1, cancelled)
+
+	var payment models.Payment
+	require.NoError(suite.db.First(&payment, "ticket_id = ?", ticket.ID).Error)
+	require.Equal(2000, payment.RefundedAmount)
+
+	var refundMessages int64
+	require.NoError(suite.db.Model(&models.OutboxMessage{}).
+		Where("ticket_id = ? AND kind = ?", ticket.ID, string(models.OutboxPaymentRefund)).
+		Count(&refundMessages).Error)
+	require.Equal(int64(1), refundMessages)
+}
+
+func (suite *IntegrationTestSuite) TestRemoveTicketPassengers_Concurrent() {
+	require := suite.Require()
+	ticket := suite.paidTicket("remove.concurrent@gmail.com", "FL901")
+	passenger := ticket.Passengers[1]
+
+	// The same passenger is cancelled twice at once, their seat is dropped
+	// and refunded once.
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			copied := ticket
+			_, _, errs[i] = repository.RemoveTicketPassengers(context.Background(), suite.db, &copied, []models.Passenger{passenger}, time.Minute, models.UserActor(int(ticket.UserID)))
+		}(i)
+	}
+	wg.Wait()
+
+	removed := 0
+	for _, err := range errs {
+		if err == nil {
+			removed++
+			continue
+		}
+
+		require.ErrorIs(err, repository.ErrPassengerNotOnTicket)
+	}
+	require.Equal(1, removed)
+
+	var dbTicket models.Ticket
+	require.NoError(suite.db.First(&dbTicket, ticket.ID).Error)
+	require.Equal(1, dbTicket.Count)
+
+	var payment models.Payment
+	require.NoError(suite.db.First(&payment, "ticket_id = ?", ticket.ID).Error)
+	require.Equal(1000, payment.RefundedAmount)
+}
+
 func TestIntegration(t *testing.T) {
 	suite.Run(t, new(IntegrationTestSuite))
 }
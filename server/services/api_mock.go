@@ -4,20 +4,88 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"on-air/logging"
+	"on-air/metrics"
+	"on-air/tracing"
+	"sync/atomic"
 	"time"
 
 	"github.com/eapache/go-resiliency/breaker"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/datatypes"
 )
 
+// IdempotencyKeyHeader carries the key that lets the provider handle a retried
+// reserve or refund only once.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
 type APIMockClient struct {
 	Client  *http.Client
 	Breaker *breaker.Breaker
 	BaseURL string
 	Timeout time.Duration
+
+	breakerOpen atomic.Bool
+}
+
+// BreakerOpen reports whether the circuit breaker turned down the last call
+// to the provider.
+func (c *APIMockClient) BreakerOpen() bool {
+	return c.breakerOpen.Load()
+}
+
+// ProviderError is returned when the flight provider could not be reached or
+// answered with an error, a call the breaker turned down is returned as
+// breaker.ErrBreakerOpen instead.
+type ProviderError struct {
+	Operation string
+	Err       error
+}
+
+func (e *ProviderError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// run calls the provider through the circuit breaker in a span of its own
+// and records the latency and the outcome of operation.
+func (c *APIMockClient) run(ctx context.Context, operation string, work func(ctx context.Context) error) error {
+	ctx, span := tracing.Tracer().Start(ctx, "provider."+operation, trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	start := time.Now()
+	err := c.Breaker.Run(func() error {
+		return work(ctx)
+	})
+	metrics.ProviderRequestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+
+	open := errors.Is(err, breaker.ErrBreakerOpen)
+	c.breakerOpen.Store(open)
+	if open {
+		metrics.ProviderBreakerOpen.Set(1)
+	} else {
+		metrics.ProviderBreakerOpen.Set(0)
+	}
+
+	if err != nil {
+		metrics.ProviderErrors.WithLabelValues(operation).Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	if err != nil && !open {
+		return &ProviderError{Operation: operation, Err: err}
+	}
+
+	return err
 }
 
 type Penalties struct {
@@ -47,7 +115,7 @@ type City struct {
 	Name string `json:"name"`
 }
 
-func (c *APIMockClient) GetFlights(origin, destination, date string) ([]FlightResponse, error) {
+func (c *APIMockClient) GetFlights(ctx context.Context, origin, destination, date string) ([]FlightResponse, error) {
 	url := c.BaseURL + "/flights" + fmt.Sprintf("?origin=%s&destination=%s&date=%s", origin, destination, date)
 
 	req, err := http.NewRequest("GET", url, nil)
@@ -56,10 +124,11 @@ func (c *APIMockClient) GetFlights(origin, destination, date string) ([]FlightRe
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	logging.SetRequestID(ctx, req.Header)
 
 	var resp []FlightResponse
-	err = c.Breaker.Run(func() error {
-		ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	err = c.run(ctx, "get_flights", func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, c.Timeout)
 		defer cancel()
 
 		req = req.WithContext(ctx)
@@ -95,7 +164,7 @@ func (c *APIMockClient) GetFlights(origin, destination, date string) ([]FlightRe
 	return resp, nil
 }
 
-func (c *APIMockClient) GetCities() ([]string, error) {
+func (c *APIMockClient) GetCities(ctx context.Context) ([]string, error) {
 	url := c.BaseURL + "/flights/cities"
 
 	req, err := http.NewRequest("GET", url, nil)
@@ -104,10 +173,11 @@ func (c *APIMockClient) GetCities() ([]string, error) {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	logging.SetRequestID(ctx, req.Header)
 
 	var resp []string
-	err = c.Breaker.Run(func() error {
-		ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	err = c.run(ctx, "get_cities", func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, c.Timeout)
 		defer cancel()
 
 		req = req.WithContext(ctx)
@@ -143,7 +213,7 @@ func (c *APIMockClient) GetCities() ([]string, error) {
 	return resp, nil
 }
 
-func (c *APIMockClient) GetDates() ([]string, error) {
+func (c *APIMockClient) GetDates(ctx context.Context) ([]string, error) {
 	url := c.BaseURL + "/flights/dates"
 
 	req, err := http.NewRequest("GET", url, nil)
@@ -152,10 +222,11 @@ func (c *APIMockClient) GetDates() ([]string, error) {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	logging.SetRequestID(ctx, req.Header)
 
 	var resp []string
-	err = c.Breaker.Run(func() error {
-		ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	err = c.run(ctx, "get_dates", func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, c.Timeout)
 		defer cancel()
 
 		req = req.WithContext(ctx)
@@ -191,7 +262,7 @@ func (c *APIMockClient) GetDates() ([]string, error) {
 	return resp, nil
 }
 
-func (c *APIMockClient) GetFlight(number string) (*FlightResponse, error) {
+func (c *APIMockClient) GetFlight(ctx context.Context, number string) (*FlightResponse, error) {
 	url := c.BaseURL + "/flights/" + number
 
 	req, err := http.NewRequest("GET", url, nil)
@@ -200,10 +271,11 @@ func (c *APIMockClient) GetFlight(number string) (*FlightResponse, error) {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	logging.SetRequestID(ctx, req.Header)
 
 	var resp FlightResponse
-	err = c.Breaker.Run(func() error {
-		ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	err = c.run(ctx, "get_flight", func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, c.Timeout)
 		defer cancel()
 
 		req = req.WithContext(ctx)
@@ -249,7 +321,7 @@ type ReserveRequestParameters struct {
 	Count  int
 }
 
-func (c *APIMockClient) Reserve(flightNumber string, ticketCount int) (bool, error) {
+func (c *APIMockClient) Reserve(ctx context.Context, flightNumber string, ticketCount int, idempotencyKey string) (bool, error) {
 	baseUrl := c.BaseURL + "/flights/reserve"
 	data := ReserveRequestParameters{
 		Number: flightNumber,
@@ -268,10 +340,14 @@ func (c *APIMockClient) Reserve(flightNumber string, ticketCount int) (bool, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		req.Header.Set(IdempotencyKeyHeader, idempotencyKey)
+	}
+	logging.SetRequestID(ctx, req.Header)
 
 	var resp ReserveResponse
-	err = c.Breaker.Run(func() error {
-		ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	err = c.run(ctx, "reserve", func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, c.Timeout)
 		defer cancel()
 
 		req = req.WithContext(ctx)
@@ -317,7 +393,7 @@ type RefundRequestParameters struct {
 	Count  int
 }
 
-func (c *APIMockClient) Refund(flightNumber string, ticketCount int) (bool, error) {
+func (c *APIMockClient) Refund(ctx context.Context, flightNumber string, ticketCount int, idempotencyKey string) (bool, error) {
 	baseUrl := c.BaseURL + "/flights/refund"
 	data := RefundRequestParameters{
 		Number: flightNumber,
@@ -336,10 +412,14 @@ func (c *APIMockClient) Refund(flightNumber string, ticketCount int) (bool, erro
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		req.Header.Set(IdempotencyKeyHeader, idempotencyKey)
+	}
+	logging.SetRequestID(ctx, req.Header)
 
 	var resp RefundResponse
-	err = c.Breaker.Run(func() error {
-		ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	err = c.run(ctx, "refund", func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, c.Timeout)
 		defer cancel()
 
 		req = req.WithContext(ctx)
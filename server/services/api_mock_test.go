@@ -1,16 +1,20 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"time"
 
 	"net/http"
 	"net/http/httptest"
+	"on-air/logging"
+	"on-air/metrics"
 	"testing"
 
 	"github.com/eapache/go-resiliency/breaker"
 	"github.com/jarcoal/httpmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"gorm.io/datatypes"
 
 	"github.com/stretchr/testify/require"
@@ -78,13 +82,27 @@ func (suite *FlightServiceTestSuite) TestGetFlightsListFromApi_Success() {
 			Penalties:     penalties,
 		},
 	}
-	flights, err := suite.APIMockClient.GetFlights("origin", "destination", "date")
+	flights, err := suite.APIMockClient.GetFlights(context.Background(), "origin", "destination", "date")
 	require.NoError(suite.T(), err)
 	require.NotNil(suite.T(), flights)
 	require.Len(suite.T(), flights, 1)
 	require.Equal(suite.T(), expectedFlights, flights)
 }
 
+func (suite *FlightServiceTestSuite) TestGetFlightsListFromApi_RequestID() {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(suite.T(), "request-1", r.Header.Get(logging.RequestIDHeader))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]"))
+	}))
+	defer mockServer.Close()
+	suite.APIMockClient.BaseURL = mockServer.URL
+
+	ctx := logging.WithRequestID(context.Background(), "request-1")
+	_, err := suite.APIMockClient.GetFlights(ctx, "origin", "destination", "date")
+	require.NoError(suite.T(), err)
+}
+
 type ErrorTransport struct{}
 
 func (t *ErrorTransport) RoundTrip(*http.Request) (*http.Response, error) {
@@ -98,15 +116,19 @@ func (suite *FlightServiceTestSuite) TestGetFlightsListFromApi_Unhandled_Respons
 	expectedURL := "http://example.com/flights?origin=origin&destination=destination&date=date"
 	httpmock.RegisterResponder("GET", expectedURL, httpmock.NewStringResponder(http.StatusInternalServerError, "Internal Server Error"))
 
-	flights, err := suite.APIMockClient.GetFlights("origin", "destination", "date")
+	flights, err := suite.APIMockClient.GetFlights(context.Background(), "origin", "destination", "date")
 	require.NotNil(suite.T(), err)
 	require.Nil(suite.T(), flights)
 }
 
 func (suite *FlightServiceTestSuite) TestGetFlightsListFromApi_Request_Failure() {
-	flights, err := suite.APIMockClient.GetFlights("origin", "destination", "date")
+	failures := testutil.ToFloat64(metrics.ProviderErrors.WithLabelValues("get_flights"))
+
+	flights, err := suite.APIMockClient.GetFlights(context.Background(), "origin", "destination", "date")
 	require.NotNil(suite.T(), err)
 	require.Nil(suite.T(), flights)
+	require.Equal(suite.T(), failures+1, testutil.ToFloat64(metrics.ProviderErrors.WithLabelValues("get_flights")))
+	require.False(suite.T(), suite.APIMockClient.BreakerOpen())
 }
 
 func (suite *FlightServiceTestSuite) TestGetFlightsCitiesFromApi_Success() {
@@ -121,7 +143,7 @@ func (suite *FlightServiceTestSuite) TestGetFlightsCitiesFromApi_Success() {
 	defer mockServer.Close()
 	suite.APIMockClient.BaseURL = mockServer.URL
 	expectedCities := []string{"Shiraz", "Esfahan", "Tehran", "Tabriz"}
-	cities, err := suite.APIMockClient.GetCities()
+	cities, err := suite.APIMockClient.GetCities(context.Background())
 	require.NoError(suite.T(), err)
 	require.NotNil(suite.T(), cities)
 	require.Len(suite.T(), cities, 4)
@@ -140,7 +162,7 @@ func (suite *FlightServiceTestSuite) TestGetFlightsDatesFromApi_Success() {
 	defer mockServer.Close()
 	suite.APIMockClient.BaseURL = mockServer.URL
 	expectedDates := []string{"2023-03-07", "2023-03-08", "2023-03-10", "2023-03-11", "2023-03-20"}
-	dates, err := suite.APIMockClient.GetDates()
+	dates, err := suite.APIMockClient.GetDates(context.Background())
 	require.NoError(suite.T(), err)
 	require.NotNil(suite.T(), dates)
 	require.Len(suite.T(), dates, 5)
@@ -183,7 +205,7 @@ func (suite *FlightServiceTestSuite) TestGetFlightFromApi_Success() {
 		FinishedAt:    time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC),
 		Penalties:     penalties,
 	}
-	flight, err := suite.APIMockClient.GetFlight("FL001")
+	flight, err := suite.APIMockClient.GetFlight(context.Background(), "FL001")
 	require.NoError(suite.T(), err)
 	require.NotNil(suite.T(), flight)
 	require.Equal(suite.T(), &expectedFlight, flight)
@@ -196,6 +218,7 @@ func (suite *FlightServiceTestSuite) TestGetFlightReserveFromApi_Success() {
 			return
 		}
 		require.Equal(suite.T(), "/flights/reserve", r.URL.String())
+		require.Equal(suite.T(), "outbox_5", r.Header.Get(IdempotencyKeyHeader))
 		cities := ReserveResponse{
 			Status:  true,
 			Message: "Flight reservation was successful.",
@@ -208,7 +231,7 @@ func (suite *FlightServiceTestSuite) TestGetFlightReserveFromApi_Success() {
 	defer mockServer.Close()
 	suite.APIMockClient.BaseURL = mockServer.URL
 	expectedRes := true
-	reserveRes, err := suite.APIMockClient.Reserve("FL001", 1)
+	reserveRes, err := suite.APIMockClient.Reserve(context.Background(), "FL001", 1, "outbox_5")
 	require.NoError(suite.T(), err)
 	require.NotNil(suite.T(), reserveRes)
 	require.Equal(suite.T(), expectedRes, reserveRes)
@@ -221,6 +244,7 @@ func (suite *FlightServiceTestSuite) TestGetFlightRefundFromApi_Success() {
 			return
 		}
 		require.Equal(suite.T(), "/flights/refund", r.URL.String())
+		require.Equal(suite.T(), "outbox_6", r.Header.Get(IdempotencyKeyHeader))
 		cities := RefundResponse{
 			Status:  true,
 			Message: "Flight refund failed.",
@@ -233,7 +257,7 @@ func (suite *FlightServiceTestSuite) TestGetFlightRefundFromApi_Success() {
 	defer mockServer.Close()
 	suite.APIMockClient.BaseURL = mockServer.URL
 	expectedFlights := true
-	reserveRes, err := suite.APIMockClient.Refund("FL001", 1)
+	reserveRes, err := suite.APIMockClient.Refund(context.Background(), "FL001", 1, "outbox_6")
 	require.NoError(suite.T(), err)
 	require.NotNil(suite.T(), reserveRes)
 	require.Equal(suite.T(), expectedFlights, reserveRes)
@@ -0,0 +1,307 @@
+package fakeipg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"on-air/server/services/pasargad"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcome decides how the fake gateway treats a transaction.
+type Outcome string
+
+const (
+	// OutcomeSuccess pays, checks, verifies and refunds the transaction.
+	OutcomeSuccess Outcome = "success"
+	// OutcomeAmountMismatch reports half of the invoice amount on check.
+	OutcomeAmountMismatch Outcome = "amount_mismatch"
+	// OutcomeTimeout holds check and verify calls for the configured delay.
+	OutcomeTimeout Outcome = "timeout"
+	// OutcomeDeclined sends the payer back without paying, so check fails.
+	OutcomeDeclined Outcome = "declined"
+)
+
+// PATH_SCRIPT accepts a ScriptRequest to queue the outcomes of the next
+// transactions, PATH_TRANSACTIONS returns a transaction by its invoice number.
+const (
+	PATH_SCRIPT       = "/fake/script"
+	PATH_TRANSACTIONS = "/fake/transactions/"
+)
+
+func ParseOutcome(value string) (Outcome, error) {
+	switch outcome := Outcome(value); outcome {
+	case OutcomeSuccess, OutcomeAmountMismatch, OutcomeTimeout, OutcomeDeclined:
+		return outcome, nil
+	}
+
+	return "", fmt.Errorf("unknown outcome %q", value)
+}
+
+type Transaction struct {
+	Token                  string  `json:"token"`
+	InvoiceNumber          string  `json:"invoice_number"`
+	InvoiceDate            string  `json:"invoice_date"`
+	Amount                 int64   `json:"amount"`
+	RedirectAddress        string  `json:"redirect_address"`
+	TransactionReferenceID string  `json:"transaction_reference_id"`
+	Outcome                Outcome `json:"outcome"`
+	Paid                   bool    `json:"paid"`
+	Verified               bool    `json:"verified"`
+	RefundedAmount         int64   `json:"refunded_amount"`
+}
+
+type ScriptRequest struct {
+	Outcomes []Outcome `json:"outcomes"`
+}
+
+// FakeIPG mimics the Pasargad API and gateway page. Every transaction gets the
+// next scripted outcome, or the default one once the script runs out.
+type FakeIPG struct {
+	DefaultOutcome Outcome
+	Delay          time.Duration
+
+	mu           sync.Mutex
+	script       []Outcome
+	sequence     int
+	transactions map[string]*Transaction
+	tokens       map[string]string
+}
+
+func New(outcome Outcome, delay time.Duration) *FakeIPG {
+	return &FakeIPG{
+		DefaultOutcome: outcome,
+		Delay:          delay,
+		transactions:   make(map[string]*Transaction),
+		tokens:         make(map[string]string),
+	}
+}
+
+// Script queues the outcomes of the next transactions.
+func (f *FakeIPG) Script(outcomes ...Outcome) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.script = append(f.script, outcomes...)
+}
+
+func (f *FakeIPG) Transaction(invoiceNumber string) (Transaction, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	transaction, ok := f.transactions[invoiceNumber]
+	if !ok {
+		return Transaction{}, false
+	}
+
+	return *transaction, true
+}
+
+func (f *FakeIPG) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(pasargad.PATH_GET_TOKEN, f.getToken)
+	mux.HandleFunc(pasargad.PATH_PAYMENT_GATEWAY, f.gateway)
+	mux.HandleFunc(pasargad.PATH_CHECK_TRANSACTION, f.checkTransaction)
+	mux.HandleFunc(pasargad.PATH_VERIFY_PAYMENT, f.verifyPayment)
+	mux.HandleFunc(pasargad.PATH_REFUND, f.refund)
+	mux.HandleFunc(PATH_SCRIPT, f.scriptOutcomes)
+	mux.HandleFunc(PATH_TRANSACTIONS, f.getTransaction)
+
+	return mux
+}
+
+func (f *FakeIPG) getToken(w http.ResponseWriter, r *http.Request) {
+	var request pasargad.CreatePaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, pasargad.ErrorResponse{Message: "invalid request"})
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	outcome := f.DefaultOutcome
+	if len(f.script) > 0 {
+		outcome = f.script[0]
+		f.script = f.script[1:]
+	}
+
+	f.sequence++
+	transaction := &Transaction{
+		Token:                  fmt.Sprintf("token-%d", f.sequence),
+		InvoiceNumber:          request.InvoiceNumber,
+		InvoiceDate:            request.InvoiceDate,
+		Amount:                 request.Amount,
+		RedirectAddress:        request.RedirectAddress,
+		TransactionReferenceID: strconv.Itoa(100000 + f.sequence),
+		Outcome:                outcome,
+	}
+	f.transactions[transaction.InvoiceNumber] = transaction
+	f.tokens[transaction.Token] = transaction.InvoiceNumber
+
+	writeJSON(w, http.StatusOK, pasargad.RedirectResponse{IsSuccess: true, Token: transaction.Token})
+}
+
+// gateway plays the payer: it pays unless the transaction is declined and
+// redirects back to the merchant with the invoice query parameters.
+func (f *FakeIPG) gateway(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	transaction, ok := f.transactions[f.tokens[r.URL.Query().Get("n")]]
+	if !ok {
+		f.mu.Unlock()
+		http.Error(w, "unknown token", http.StatusNotFound)
+		return
+	}
+
+	query := url.Values{}
+	query.Set("iN", transaction.InvoiceNumber)
+	query.Set("iD", transaction.InvoiceDate)
+	if transaction.Outcome != OutcomeDeclined {
+		transaction.Paid = true
+		query.Set("tref", transaction.TransactionReferenceID)
+	}
+	separator := "?"
+	if strings.Contains(transaction.RedirectAddress, "?") {
+		separator = "&"
+	}
+	location := transaction.RedirectAddress + separator + query.Encode()
+	f.mu.Unlock()
+
+	http.Redirect(w, r, location, http.StatusFound)
+}
+
+func (f *FakeIPG) checkTransaction(w http.ResponseWriter, r *http.Request) {
+	var request pasargad.CreateCheckTransactionRequest
+	transaction, ok := f.lookup(w, r, &request, func() string { return request.InvoiceNumber })
+	if !ok {
+		return
+	}
+
+	amount := transaction.Amount
+	if transaction.Outcome == OutcomeAmountMismatch {
+		amount = amount / 2
+	}
+
+	writeJSON(w, http.StatusOK, pasargad.CheckTransactionResponse{
+		IsSuccess:              true,
+		TransactionReferenceID: transaction.TransactionReferenceID,
+		InvoiceNumber:          transaction.InvoiceNumber,
+		InvoiceDate:            transaction.InvoiceDate,
+		Amount:                 amount,
+	})
+}
+
+func (f *FakeIPG) verifyPayment(w http.ResponseWriter, r *http.Request) {
+	var request pasargad.CreateVerifyPaymentRequest
+	transaction, ok := f.lookup(w, r, &request, func() string { return request.InvoiceNumber })
+	if !ok {
+		return
+	}
+
+	if request.Amount != transaction.Amount {
+		writeJSON(w, http.StatusOK, pasargad.VerifyPaymentResponse{Message: "amount does not match the invoice"})
+		return
+	}
+
+	f.mu.Lock()
+	f.transactions[transaction.InvoiceNumber].Verified = true
+	f.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, pasargad.VerifyPaymentResponse{
+		IsSuccess:         true,
+		MaskedCardNumber:  "5022-29**-****-2328",
+		ShaparakRefNumber: transaction.TransactionReferenceID,
+	})
+}
+
+func (f *FakeIPG) refund(w http.ResponseWriter, r *http.Request) {
+	var request pasargad.CreateRefundRequest
+	transaction, ok := f.lookup(w, r, &request, func() string { return request.InvoiceNumber })
+	if !ok {
+		return
+	}
+
+	amount := request.Amount
+	if amount == 0 {
+		amount = transaction.Amount - transaction.RefundedAmount
+	}
+
+	if transaction.RefundedAmount+amount > transaction.Amount {
+		writeJSON(w, http.StatusOK, pasargad.RefundResponse{Message: "refund exceeds the invoice amount"})
+		return
+	}
+
+	f.mu.Lock()
+	f.transactions[transaction.InvoiceNumber].RefundedAmount += amount
+	f.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, pasargad.RefundResponse{IsSuccess: true})
+}
+
+// lookup decodes the request and returns a copy of the paid transaction it
+// refers to, it writes the failure response itself.
+func (f *FakeIPG) lookup(w http.ResponseWriter, r *http.Request, request interface{}, invoiceNumber func() string) (Transaction, bool) {
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		writeJSON(w, http.StatusBadRequest, pasargad.ErrorResponse{Message: "invalid request"})
+		return Transaction{}, false
+	}
+
+	transaction, ok := f.Transaction(invoiceNumber())
+	if !ok || !transaction.Paid {
+		writeJSON(w, http.StatusOK, pasargad.ErrorResponse{Message: "transaction not found"})
+		return Transaction{}, false
+	}
+
+	if transaction.Outcome == OutcomeTimeout {
+		select {
+		case <-time.After(f.Delay):
+		case <-r.Context().Done():
+			return Transaction{}, false
+		}
+	}
+
+	return transaction, true
+}
+
+func (f *FakeIPG) scriptOutcomes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request ScriptRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	for _, outcome := range request.Outcomes {
+		if _, err := ParseOutcome(string(outcome)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	f.Script(request.Outcomes...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *FakeIPG) getTransaction(w http.ResponseWriter, r *http.Request) {
+	transaction, ok := f.Transaction(r.URL.Path[len(PATH_TRANSACTIONS):])
+	if !ok {
+		http.Error(w, "transaction not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, transaction)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,127 @@
+package fakeipg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"on-air/server/services/gateway"
+	"on-air/server/services/pasargad"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type FakeIPGTestSuite struct {
+	suite.Suite
+	fake    *FakeIPG
+	server  *httptest.Server
+	gateway *gateway.PasargadGateway
+	invoice gateway.Invoice
+}
+
+func (suite *FakeIPGTestSuite) SetupTest() {
+	suite.fake = New(OutcomeSuccess, time.Second)
+	suite.server = httptest.NewServer(suite.fake.Handler())
+
+	api := pasargad.PasargadAPIClient(1, 2, "http://on-air.test/payments/callBack", "", &http.Client{Timeout: 200 * time.Millisecond})
+	api.SetBaseURL(suite.server.URL)
+	suite.gateway = &gateway.PasargadGateway{API: api}
+	suite.invoice = gateway.Invoice{Number: "4", Date: time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC)}
+}
+
+func (suite *FakeIPGTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+// pay redirects the payer to the fake gateway and returns the callback it
+// sends the payer back with.
+func (suite *FakeIPGTestSuite) pay() *gateway.Callback {
+	require := suite.Require()
+
+	result, err := suite.gateway.Redirect(context.Background(), gateway.RedirectRequest{Invoice: suite.invoice, Amount: 2000})
+	require.NoError(err)
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	response, err := client.Get(result.URL)
+	require.NoError(err)
+	response.Body.Close()
+	require.Equal(http.StatusFound, response.StatusCode)
+
+	location, err := url.Parse(response.Header.Get("Location"))
+	require.NoError(err)
+	require.Equal("/payments/callBack", location.Path)
+
+	callback, err := suite.gateway.ParseCallback(location.Query())
+	require.NoError(err)
+
+	return callback
+}
+
+func (suite *FakeIPGTestSuite) TestSuccess() {
+	require := suite.Require()
+
+	callback := suite.pay()
+	require.True(callback.Succeeded)
+	require.Equal("4", callback.Number)
+
+	suite.invoice.Reference = callback.Reference
+	check, err := suite.gateway.Check(context.Background(), gateway.CheckRequest{Invoice: suite.invoice, Amount: 2000})
+	require.NoError(err)
+	require.Equal(int64(2000), check.Amount)
+
+	_, err = suite.gateway.Verify(context.Background(), gateway.VerifyRequest{Invoice: suite.invoice, Amount: 2000})
+	require.NoError(err)
+
+	require.NoError(suite.gateway.Refund(context.Background(), gateway.RefundRequest{Invoice: suite.invoice, Amount: 500}))
+	require.NoError(suite.gateway.Refund(context.Background(), gateway.RefundRequest{Invoice: suite.invoice}))
+	require.Error(suite.gateway.Refund(context.Background(), gateway.RefundRequest{Invoice: suite.invoice, Amount: 1}))
+
+	transaction, ok := suite.fake.Transaction("4")
+	require.True(ok)
+	require.True(transaction.Verified)
+	require.Equal(int64(2000), transaction.RefundedAmount)
+}
+
+func (suite *FakeIPGTestSuite) TestAmountMismatch() {
+	require := suite.Require()
+	suite.fake.Script(OutcomeAmountMismatch)
+
+	suite.pay()
+	check, err := suite.gateway.Check(context.Background(), gateway.CheckRequest{Invoice: suite.invoice, Amount: 2000})
+	require.NoError(err)
+	require.Equal(int64(1000), check.Amount)
+}
+
+func (suite *FakeIPGTestSuite) TestDeclined() {
+	require := suite.Require()
+	suite.fake.Script(OutcomeDeclined)
+
+	callback := suite.pay()
+	require.False(callback.Succeeded)
+
+	_, err := suite.gateway.Check(context.Background(), gateway.CheckRequest{Invoice: suite.invoice, Amount: 2000})
+	require.ErrorAs(err, &gateway.DeclinedError{})
+}
+
+func (suite *FakeIPGTestSuite) TestTimeout() {
+	require := suite.Require()
+	suite.fake.Script(OutcomeTimeout, OutcomeSuccess)
+
+	suite.pay()
+	_, err := suite.gateway.Check(context.Background(), gateway.CheckRequest{Invoice: suite.invoice, Amount: 2000})
+	require.Error(err)
+	require.NotErrorIs(err, gateway.DeclinedError{})
+
+	suite.invoice.Number = "5"
+	suite.pay()
+	_, err = suite.gateway.Check(context.Background(), gateway.CheckRequest{Invoice: suite.invoice, Amount: 2000})
+	require.NoError(err)
+}
+
+func TestFakeIPG(t *testing.T) {
+	suite.Run(t, new(FakeIPGTestSuite))
+}
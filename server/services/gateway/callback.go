@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// The query parameters signed callbacks carry next to the gateway ones.
+const (
+	CallbackPaymentParam   = "pid"
+	CallbackSignatureParam = "sig"
+)
+
+var (
+	ErrInvalidCallback  = errors.New("invalid payment callback")
+	ErrInvalidSignature = errors.New("invalid payment callback signature")
+)
+
+// CallbackURL appends query to the callback url of a gateway.
+func CallbackURL(base string, query url.Values) string {
+	if len(query) == 0 {
+		return base
+	}
+
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
+	}
+
+	return base + separator + query.Encode()
+}
+
+// SetCallbackSecret makes the registry sign the callback urls of new payments
+// and require the signature on callbacks, it is disabled while empty.
+func (r *Registry) SetCallbackSecret(secret string) {
+	r.secret = []byte(secret)
+}
+
+func (r *Registry) callbackQuery(gateway string, number string) url.Values {
+	if len(r.secret) == 0 {
+		return nil
+	}
+
+	return url.Values{
+		CallbackPaymentParam:   {number},
+		CallbackSignatureParam: {r.sign(gateway, number)},
+	}
+}
+
+// VerifyCallback checks the signature of a callback and binds it to the signed
+// invoice number, so a callback can not be replayed against another payment.
+func (r *Registry) VerifyCallback(gateway string, query url.Values, callback *Callback) error {
+	if len(r.secret) == 0 {
+		return nil
+	}
+
+	number := query.Get(CallbackPaymentParam)
+	signature, err := hex.DecodeString(query.Get(CallbackSignatureParam))
+	if err != nil || number == "" {
+		return ErrInvalidSignature
+	}
+
+	expected, _ := hex.DecodeString(r.sign(gateway, number))
+	if !hmac.Equal(signature, expected) {
+		return ErrInvalidSignature
+	}
+
+	if callback.Number != "" && callback.Number != number {
+		return ErrInvalidSignature
+	}
+
+	callback.Number = number
+
+	return nil
+}
+
+func (r *Registry) sign(gateway string, number string) string {
+	mac := hmac.New(sha256.New, r.secret)
+	mac.Write([]byte(gateway + ":" + number))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,98 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+)
+
+var ErrUnknownGateway = errors.New("unknown payment gateway")
+
+// PaymentGateway is an acquirer the tickets are paid through. Invoices are
+// identified by the payment id and creation date, gateways that hand out
+// their own reference for a transaction return it on Redirect and get it
+// back on the other calls.
+type PaymentGateway interface {
+	Name() string
+	Redirect(ctx context.Context, request RedirectRequest) (*RedirectResult, error)
+	Check(ctx context.Context, request CheckRequest) (*CheckResult, error)
+	Verify(ctx context.Context, request VerifyRequest) (*VerifyResult, error)
+	Refund(ctx context.Context, request RefundRequest) error
+	ParseCallback(query url.Values) (*Callback, error)
+}
+
+type Invoice struct {
+	Number    string
+	Date      time.Time
+	Reference string
+}
+
+// RedirectRequest starts a payment, CallbackQuery is appended to the callback
+// url the gateway sends the payer back to.
+type RedirectRequest struct {
+	Invoice
+	Amount        int64
+	Mobile        string
+	Email         string
+	CallbackQuery url.Values
+}
+
+type RedirectResult struct {
+	URL       string
+	Reference string
+}
+
+type CheckRequest struct {
+	Invoice
+	Amount int64
+}
+
+// CheckResult is the transaction as the gateway sees it. Gateways that do not
+// report the paid amount return the requested one and enforce it on Verify.
+type CheckResult struct {
+	Amount    int64
+	Reference string
+}
+
+type VerifyRequest struct {
+	Invoice
+	Amount int64
+}
+
+type VerifyResult struct {
+	Reference        string
+	MaskedCardNumber string
+}
+
+// RefundRequest refunds Amount of the invoice, a zero amount refunds the
+// whole invoice.
+type RefundRequest struct {
+	Invoice
+	Amount int64
+}
+
+// Callback is what the gateway sends back with the payer, Number is empty for
+// gateways that only send their own reference.
+type Callback struct {
+	Number    string
+	Reference string
+	Succeeded bool
+}
+
+// DeclinedError is returned when the gateway answered but refused the
+// request, e.g. the transaction is unknown or was not paid.
+type DeclinedError struct {
+	Gateway string
+	Message string
+}
+
+func (e DeclinedError) Error() string {
+	return e.Gateway + ": " + e.Message
+}
+
+// Is makes errors.Is(err, DeclinedError{}) match any declined request.
+func (e DeclinedError) Is(target error) bool {
+	_, ok := target.(DeclinedError)
+	return ok
+}
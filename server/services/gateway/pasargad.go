@@ -0,0 +1,117 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"on-air/server/services/pasargad"
+	"strconv"
+	"time"
+)
+
+const Pasargad = "pasargad"
+
+const pasargadDateFormat = "2006/01/02"
+
+type PasargadGateway struct {
+	API *pasargad.PasargadPaymentAPI
+}
+
+func (g *PasargadGateway) Name() string {
+	return Pasargad
+}
+
+func (g *PasargadGateway) Redirect(ctx context.Context, request RedirectRequest) (*RedirectResult, error) {
+	address, err := g.API.Redirect(ctx, pasargad.CreatePaymentRequest{
+		Amount:          request.Amount,
+		InvoiceNumber:   request.Number,
+		InvoiceDate:     request.Date.Format(pasargadDateFormat),
+		Mobile:          request.Mobile,
+		Email:           request.Email,
+		RedirectAddress: CallbackURL(g.API.RedirectURL(), request.CallbackQuery),
+	})
+	if err != nil {
+		return nil, pasargadError(err)
+	}
+
+	return &RedirectResult{URL: address}, nil
+}
+
+func (g *PasargadGateway) Check(ctx context.Context, request CheckRequest) (*CheckResult, error) {
+	response, err := g.API.CheckTransaction(ctx, pasargad.CreateCheckTransactionRequest{
+		InvoiceNumber:          request.Number,
+		InvoiceDate:            request.Date.Format(pasargadDateFormat),
+		TransactionReferenceID: request.Reference,
+	})
+	if err != nil {
+		return nil, pasargadError(err)
+	}
+
+	return &CheckResult{
+		Amount:    response.Amount,
+		Reference: response.TransactionReferenceID,
+	}, nil
+}
+
+func (g *PasargadGateway) Verify(ctx context.Context, request VerifyRequest) (*VerifyResult, error) {
+	response, err := g.API.VerifyPayment(ctx, pasargad.CreateVerifyPaymentRequest{
+		Amount:        request.Amount,
+		InvoiceNumber: request.Number,
+		InvoiceDate:   request.Date.Format(pasargadDateFormat),
+	})
+	if err != nil {
+		return nil, pasargadError(err)
+	}
+
+	return &VerifyResult{
+		Reference:        response.ShaparakRefNumber,
+		MaskedCardNumber: response.MaskedCardNumber,
+	}, nil
+}
+
+func (g *PasargadGateway) Refund(ctx context.Context, request RefundRequest) error {
+	_, err := g.API.Refund(ctx, pasargad.CreateRefundRequest{
+		Amount:        request.Amount,
+		InvoiceNumber: request.Number,
+		InvoiceDate:   request.Date.Format(pasargadDateFormat),
+	})
+
+	return pasargadError(err)
+}
+
+// ParseCallback reads the iN (invoice number), iD (invoice date) and tref
+// (transaction reference) query parameters, tref is missing when the payer
+// did not pay.
+func (g *PasargadGateway) ParseCallback(query url.Values) (*Callback, error) {
+	number := query.Get("iN")
+	if _, err := strconv.ParseUint(number, 10, 64); err != nil {
+		return nil, fmt.Errorf("%w: invoice number %q", ErrInvalidCallback, number)
+	}
+
+	if _, err := time.Parse(pasargadDateFormat, query.Get("iD")); err != nil {
+		return nil, fmt.Errorf("%w: invoice date %q", ErrInvalidCallback, query.Get("iD"))
+	}
+
+	reference := query.Get("tref")
+	if reference != "" {
+		if _, err := strconv.ParseUint(reference, 10, 64); err != nil {
+			return nil, fmt.Errorf("%w: transaction reference %q", ErrInvalidCallback, reference)
+		}
+	}
+
+	return &Callback{
+		Number:    number,
+		Reference: reference,
+		Succeeded: reference != "",
+	}, nil
+}
+
+func pasargadError(err error) error {
+	var declined pasargad.ErrorResponse
+	if errors.As(err, &declined) {
+		return DeclinedError{Gateway: Pasargad, Message: declined.Message}
+	}
+
+	return err
+}
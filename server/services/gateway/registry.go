@@ -0,0 +1,117 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"on-air/config"
+	"on-air/server/services/pasargad"
+	"on-air/server/services/zarinpal"
+	"on-air/tracing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Registry holds the configured gateways in the order new payments are routed
+// to them, the first one is the primary and the rest are fail overs.
+type Registry struct {
+	gateways map[string]PaymentGateway
+	order    []string
+	secret   []byte
+}
+
+func NewRegistry(gateways ...PaymentGateway) *Registry {
+	registry := &Registry{
+		gateways: make(map[string]PaymentGateway),
+	}
+
+	for _, gateway := range gateways {
+		registry.gateways[gateway.Name()] = gateway
+		registry.order = append(registry.order, gateway.Name())
+	}
+
+	return registry
+}
+
+// NewRegistryFromConfig builds the gateways listed in gatepay.gateways, only
+// Pasargad is used when the list is empty.
+func NewRegistryFromConfig(ipg *config.IPG) (*Registry, error) {
+	names := ipg.Gateways
+	if len(names) == 0 {
+		names = []string{Pasargad}
+	}
+
+	httpClient := &http.Client{Timeout: ipg.Timeout, Transport: tracing.Transport(nil)}
+
+	var gateways []PaymentGateway
+	for _, name := range names {
+		switch name {
+		case Pasargad:
+			api := pasargad.PasargadAPIClient(
+				int64(ipg.MerchantCode),
+				int64(ipg.TerminalId),
+				ipg.RedirectUrl,
+				ipg.CertFile,
+				httpClient,
+			)
+			api.SetBaseURL(ipg.BaseURL)
+
+			gateways = append(gateways, &PasargadGateway{API: api})
+		case Zarinpal:
+			gateways = append(gateways, &ZarinpalGateway{
+				API: zarinpal.ZarinpalAPIClient(
+					ipg.Zarinpal.MerchantID,
+					ipg.Zarinpal.BaseURL,
+					ipg.Zarinpal.GatewayURL,
+					ipg.Zarinpal.CallbackURL,
+					httpClient,
+				),
+			})
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnknownGateway, name)
+		}
+	}
+
+	registry := NewRegistry(gateways...)
+	registry.SetCallbackSecret(ipg.Callback.Secret)
+
+	return registry, nil
+}
+
+// Get returns the gateway by name, an empty name is the primary gateway.
+func (r *Registry) Get(name string) (PaymentGateway, error) {
+	if name == "" && len(r.order) > 0 {
+		name = r.order[0]
+	}
+
+	gateway, ok := r.gateways[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownGateway, name)
+	}
+
+	return gateway, nil
+}
+
+// Redirect starts the payment on the first gateway that accepts it and
+// returns that gateway along with the result.
+func (r *Registry) Redirect(ctx context.Context, request RedirectRequest) (PaymentGateway, *RedirectResult, error) {
+	if len(r.order) == 0 {
+		return nil, nil, ErrUnknownGateway
+	}
+
+	var lastErr error
+	for _, name := range r.order {
+		gateway := r.gateways[name]
+		request.CallbackQuery = r.callbackQuery(name, request.Number)
+
+		result, err := gateway.Redirect(ctx, request)
+		if err == nil {
+			return gateway, result, nil
+		}
+
+		logrus.Error("gateway_registry: redirect failed on ", name, ", error:", err)
+		lastErr = err
+	}
+
+	return nil, nil, fmt.Errorf("all payment gateways failed, last error: %w", lastErr)
+}
@@ -0,0 +1,146 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"on-air/config"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type fakeGateway struct {
+	name string
+	err  error
+}
+
+func (g *fakeGateway) Name() string {
+	return g.name
+}
+
+func (g *fakeGateway) Redirect(ctx context.Context, request RedirectRequest) (*RedirectResult, error) {
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	return &RedirectResult{URL: "https://" + g.name + "/" + request.Number}, nil
+}
+
+func (g *fakeGateway) Check(ctx context.Context, request CheckRequest) (*CheckResult, error) {
+	return &CheckResult{Amount: request.Amount}, g.err
+}
+
+func (g *fakeGateway) Verify(ctx context.Context, request VerifyRequest) (*VerifyResult, error) {
+	return &VerifyResult{}, g.err
+}
+
+func (g *fakeGateway) Refund(ctx context.Context, request RefundRequest) error {
+	return g.err
+}
+
+func (g *fakeGateway) ParseCallback(query url.Values) (*Callback, error) {
+	return &Callback{Reference: query.Get("ref")}, g.err
+}
+
+type RegistryTestSuite struct {
+	suite.Suite
+}
+
+func (suite *RegistryTestSuite) TestRedirect_Primary() {
+	require := suite.Require()
+
+	registry := NewRegistry(&fakeGateway{name: "first"}, &fakeGateway{name: "second"})
+	gateway, result, err := registry.Redirect(context.Background(), RedirectRequest{Invoice: Invoice{Number: "4"}})
+	require.NoError(err)
+	require.Equal("first", gateway.Name())
+	require.Equal("https://first/4", result.URL)
+}
+
+func (suite *RegistryTestSuite) TestRedirect_FailOver() {
+	require := suite.Require()
+
+	registry := NewRegistry(&fakeGateway{name: "first", err: errors.New("timeout")}, &fakeGateway{name: "second"})
+	gateway, result, err := registry.Redirect(context.Background(), RedirectRequest{Invoice: Invoice{Number: "4"}})
+	require.NoError(err)
+	require.Equal("second", gateway.Name())
+	require.Equal("https://second/4", result.URL)
+}
+
+func (suite *RegistryTestSuite) TestRedirect_AllFailed() {
+	require := suite.Require()
+
+	declined := DeclinedError{Gateway: "second", Message: "terminal disabled"}
+	registry := NewRegistry(&fakeGateway{name: "first", err: errors.New("timeout")}, &fakeGateway{name: "second", err: declined})
+	_, _, err := registry.Redirect(context.Background(), RedirectRequest{})
+	require.ErrorIs(err, declined)
+}
+
+func (suite *RegistryTestSuite) TestGet() {
+	require := suite.Require()
+
+	registry := NewRegistry(&fakeGateway{name: "first"}, &fakeGateway{name: "second"})
+
+	gateway, err := registry.Get("")
+	require.NoError(err)
+	require.Equal("first", gateway.Name())
+
+	gateway, err = registry.Get("second")
+	require.NoError(err)
+	require.Equal("second", gateway.Name())
+
+	_, err = registry.Get("third")
+	require.ErrorIs(err, ErrUnknownGateway)
+}
+
+func (suite *RegistryTestSuite) TestNewRegistryFromConfig() {
+	require := suite.Require()
+
+	registry, err := NewRegistryFromConfig(&config.IPG{})
+	require.NoError(err)
+	gateway, err := registry.Get("")
+	require.NoError(err)
+	require.Equal(Pasargad, gateway.Name())
+
+	registry, err = NewRegistryFromConfig(&config.IPG{Gateways: []string{Zarinpal, Pasargad}})
+	require.NoError(err)
+	gateway, err = registry.Get("")
+	require.NoError(err)
+	require.Equal(Zarinpal, gateway.Name())
+
+	_, err = NewRegistryFromConfig(&config.IPG{Gateways: []string{"unknown"}})
+	require.ErrorIs(err, ErrUnknownGateway)
+}
+
+func (suite *RegistryTestSuite) TestCallbackSignature() {
+	require := suite.Require()
+
+	registry := NewRegistry(&fakeGateway{name: "first"})
+	registry.SetCallbackSecret("secret")
+
+	query := registry.callbackQuery("first", "4")
+	require.Equal("4", query.Get(CallbackPaymentParam))
+
+	callback := &Callback{Reference: "A0001"}
+	require.NoError(registry.VerifyCallback("first", query, callback))
+	require.Equal("4", callback.Number)
+
+	require.ErrorIs(registry.VerifyCallback("second", query, &Callback{}), ErrInvalidSignature)
+	require.ErrorIs(registry.VerifyCallback("first", query, &Callback{Number: "5"}), ErrInvalidSignature)
+
+	query.Set(CallbackPaymentParam, "5")
+	require.ErrorIs(registry.VerifyCallback("first", query, &Callback{}), ErrInvalidSignature)
+	require.ErrorIs(registry.VerifyCallback("first", url.Values{}, &Callback{}), ErrInvalidSignature)
+}
+
+func (suite *RegistryTestSuite) TestCallbackSignature_Disabled() {
+	require := suite.Require()
+
+	registry := NewRegistry(&fakeGateway{name: "first"})
+	require.Nil(registry.callbackQuery("first", "4"))
+	require.NoError(registry.VerifyCallback("first", url.Values{}, &Callback{Number: "4"}))
+}
+
+func TestRegistry(t *testing.T) {
+	suite.Run(t, new(RegistryTestSuite))
+}
@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"on-air/server/services/zarinpal"
+	"strconv"
+)
+
+const Zarinpal = "zarinpal"
+
+type ZarinpalGateway struct {
+	API *zarinpal.ZarinpalPaymentAPI
+}
+
+func (g *ZarinpalGateway) Name() string {
+	return Zarinpal
+}
+
+func (g *ZarinpalGateway) Redirect(ctx context.Context, request RedirectRequest) (*RedirectResult, error) {
+	address, authority, err := g.API.Redirect(ctx, request.Amount, "on-air invoice "+request.Number, zarinpal.Metadata{
+		Mobile: request.Mobile,
+		Email:  request.Email,
+	}, request.CallbackQuery)
+	if err != nil {
+		return nil, zarinpalError(err)
+	}
+
+	return &RedirectResult{URL: address, Reference: authority}, nil
+}
+
+// Check asks for the transaction status, the gateway does not report the paid
+// amount, it is enforced by Verify instead.
+func (g *ZarinpalGateway) Check(ctx context.Context, request CheckRequest) (*CheckResult, error) {
+	response, err := g.API.Inquiry(ctx, request.Reference)
+	if err != nil {
+		return nil, zarinpalError(err)
+	}
+
+	if response.Status != "PAID" && response.Status != "VERIFIED" {
+		return nil, DeclinedError{Gateway: Zarinpal, Message: "transaction is " + response.Status}
+	}
+
+	return &CheckResult{
+		Amount:    request.Amount,
+		Reference: request.Reference,
+	}, nil
+}
+
+func (g *ZarinpalGateway) Verify(ctx context.Context, request VerifyRequest) (*VerifyResult, error) {
+	response, err := g.API.Verify(ctx, request.Amount, request.Reference)
+	if err != nil {
+		return nil, zarinpalError(err)
+	}
+
+	return &VerifyResult{
+		Reference:        strconv.FormatInt(response.RefID, 10),
+		MaskedCardNumber: response.CardPan,
+	}, nil
+}
+
+func (g *ZarinpalGateway) Refund(ctx context.Context, request RefundRequest) error {
+	_, err := g.API.Refund(ctx, request.Reference, request.Amount)
+
+	return zarinpalError(err)
+}
+
+// ParseCallback reads the Authority and Status query parameters, the invoice
+// is looked up by the authority.
+func (g *ZarinpalGateway) ParseCallback(query url.Values) (*Callback, error) {
+	authority := query.Get("Authority")
+	if authority == "" {
+		return nil, fmt.Errorf("%w: missing authority", ErrInvalidCallback)
+	}
+
+	return &Callback{
+		Reference: authority,
+		Succeeded: query.Get("Status") == "OK",
+	}, nil
+}
+
+func zarinpalError(err error) error {
+	var declined zarinpal.ErrorResponse
+	if errors.As(err, &declined) {
+		return DeclinedError{Gateway: Zarinpal, Message: declined.Message}
+	}
+
+	return err
+}
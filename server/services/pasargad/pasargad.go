@@ -2,23 +2,28 @@ package pasargad
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"io/ioutil"
 	"net/http"
+	"on-air/logging"
+	"strings"
 	"time"
 )
 
-// The API URLs
+// DefaultBaseURL is the sandbox the API paths are relative to.
+const DefaultBaseURL = "https://sandbox.banktest.ir/pasargad/pep.shaparak.ir"
+
+// The API paths
 const (
-	URL_GET_TOKEN = "https://sandbox.banktest.ir/pasargad/pep.shaparak.ir/Api/v1/Payment/GetToken"
+	PATH_GET_TOKEN = "/Api/v1/Payment/GetToken"
 
 	// Redirect User with token to this URL.
 	// e.q: https://sandbox.banktest.ir/pasargad/pep.shaparak.ir/gateway.aspx?n=Token
-	URL_PAYMENT_GATEWAY   = "https://sandbox.banktest.ir/pasargad/pep.shaparak.ir/gateway.aspx"
-	URL_CHECK_TRANSACTION = "https://sandbox.banktest.ir/pasargad/pep.shaparak.ir/Api/v1/Payment/CheckTransactionResult"
-	URL_VERIFY_PAYMENT    = "https://sandbox.banktest.ir/pasargad/pep.shaparak.ir/Api/v1/Payment/VerifyPayment"
-	URL_REFUND            = "https://sandbox.banktest.ir/pasargad/pep.shaparak.ir/Api/v1/Payment/RefundPayment"
+	PATH_PAYMENT_GATEWAY   = "/gateway.aspx"
+	PATH_CHECK_TRANSACTION = "/Api/v1/Payment/CheckTransactionResult"
+	PATH_VERIFY_PAYMENT    = "/Api/v1/Payment/VerifyPayment"
+	PATH_REFUND            = "/Api/v1/Payment/RefundPayment"
 )
 
 const ACTION_PAYMENT = "1003"
@@ -34,6 +39,7 @@ type HTTPClient interface {
 // PasargadPaymentAPI for rest
 type PasargadPaymentAPI struct {
 	httpClient        HTTPClient
+	baseURL           string
 	merchantCode      int64
 	terminalId        int64
 	redirectUrl       string
@@ -51,6 +57,7 @@ func PasargadAPI(merchantCode int64, terminalId int64, redirectUrl string, certi
 func PasargadAPIClient(merchantCode int64, terminalId int64, redirectUrl string, certificationFile string, httpClient HTTPClient) *PasargadPaymentAPI {
 	return &PasargadPaymentAPI{
 		httpClient:        httpClient,
+		baseURL:           DefaultBaseURL,
 		merchantCode:      merchantCode,
 		terminalId:        terminalId,
 		redirectUrl:       redirectUrl,
@@ -59,7 +66,7 @@ func PasargadAPIClient(merchantCode int64, terminalId int64, redirectUrl string,
 }
 
 // makeRequest is our RequestBuilder object (used in other packages of pepco-api)
-func (m *PasargadPaymentAPI) makeRequest(url, method string, body interface{}, resp interface{}) error {
+func (m *PasargadPaymentAPI) makeRequest(ctx context.Context, url, method string, body interface{}, resp interface{}) error {
 	var data []byte
 	if body != nil {
 		var err error
@@ -69,7 +76,7 @@ func (m *PasargadPaymentAPI) makeRequest(url, method string, body interface{}, r
 		}
 	}
 
-	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
@@ -77,6 +84,7 @@ func (m *PasargadPaymentAPI) makeRequest(url, method string, body interface{}, r
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Sign", "NoCheckSign")
+	logging.SetRequestID(ctx, req.Header)
 
 	r, err := m.httpClient.Do(req)
 
@@ -108,6 +116,19 @@ func (m *PasargadPaymentAPI) makeRequest(url, method string, body interface{}, r
 	return nil
 }
 
+// SetBaseURL points the client at another deployment of the API, e.g. a fake
+// gateway in tests. An empty url keeps the sandbox.
+func (m *PasargadPaymentAPI) SetBaseURL(url string) {
+	if url != "" {
+		m.baseURL = strings.TrimRight(url, "/")
+	}
+}
+
+// RedirectURL returns the default redirect url of the payments.
+func (m *PasargadPaymentAPI) RedirectURL() string {
+	return m.redirectUrl
+}
+
 // SetSign sets new  key.
 func (m *PasargadPaymentAPI) SetSign(sign string) {
 	m.sign = sign
@@ -151,54 +172,56 @@ func (m *PasargadPaymentAPI) getTimestamp() string {
 }
 
 // Generate Payment URL
-func (m *PasargadPaymentAPI) Redirect(request CreatePaymentRequest) (string, error) {
+func (m *PasargadPaymentAPI) Redirect(ctx context.Context, request CreatePaymentRequest) (string, error) {
 	requestBody := request.GetRedirectRequest()
 	requestBody.Action = ACTION_PAYMENT
 	requestBody.MerchantCode = m.merchantCode
 	requestBody.TerminalCode = m.terminalId
-	requestBody.RedirectAddress = m.redirectUrl
+	if requestBody.RedirectAddress == "" {
+		requestBody.RedirectAddress = m.redirectUrl
+	}
 	requestBody.TimeStamp = m.getTimestamp()
 
 	m.signData(requestBody)
 	var resp RedirectResponse
-	err := m.makeRequest(URL_GET_TOKEN, "POST", requestBody, &resp)
+	err := m.makeRequest(ctx, m.baseURL+PATH_GET_TOKEN, "POST", requestBody, &resp)
 
 	if err != nil {
 		return "", err
 	}
 
 	if resp.IsSuccess == false {
-		requestError := errors.New(resp.Message)
+		requestError := ErrorResponse{IsSuccess: resp.IsSuccess, Message: resp.Message}
 		return "", requestError
 	}
 	// In this stage, we got a successful response from Pasargad IPG
-	var redirectAddress string = URL_PAYMENT_GATEWAY + "?n=" + resp.Token
+	var redirectAddress string = m.baseURL + PATH_PAYMENT_GATEWAY + "?n=" + resp.Token
 	return redirectAddress, nil
 }
 
 // CheckTransaction method
-func (m *PasargadPaymentAPI) CheckTransaction(request CreateCheckTransactionRequest) (*CheckTransactionResponse, error) {
+func (m *PasargadPaymentAPI) CheckTransaction(ctx context.Context, request CreateCheckTransactionRequest) (*CheckTransactionResponse, error) {
 	requestBody := request.GetCheckTransactionRequest()
 	requestBody.MerchantCode = m.merchantCode
 	requestBody.TerminalCode = m.terminalId
 
 	m.signData(requestBody)
 	var resp CheckTransactionResponse
-	err := m.makeRequest(URL_CHECK_TRANSACTION, "POST", requestBody, &resp)
+	err := m.makeRequest(ctx, m.baseURL+PATH_CHECK_TRANSACTION, "POST", requestBody, &resp)
 
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.IsSuccess == false {
-		requestError := errors.New(resp.Message)
+		requestError := ErrorResponse{IsSuccess: resp.IsSuccess, Message: resp.Message}
 		return nil, requestError
 	}
 	return &resp, nil
 }
 
 // VerifyPayment method
-func (m *PasargadPaymentAPI) VerifyPayment(request CreateVerifyPaymentRequest) (*VerifyPaymentResponse, error) {
+func (m *PasargadPaymentAPI) VerifyPayment(ctx context.Context, request CreateVerifyPaymentRequest) (*VerifyPaymentResponse, error) {
 	requestBody := request.GetVerifyPaymentRequest()
 	requestBody.MerchantCode = m.merchantCode
 	requestBody.TerminalCode = m.terminalId
@@ -206,21 +229,21 @@ func (m *PasargadPaymentAPI) VerifyPayment(request CreateVerifyPaymentRequest) (
 
 	m.signData(requestBody)
 	var resp VerifyPaymentResponse
-	err := m.makeRequest(URL_VERIFY_PAYMENT, "POST", requestBody, &resp)
+	err := m.makeRequest(ctx, m.baseURL+PATH_VERIFY_PAYMENT, "POST", requestBody, &resp)
 
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.IsSuccess == false {
-		requestError := errors.New(resp.Message)
+		requestError := ErrorResponse{IsSuccess: resp.IsSuccess, Message: resp.Message}
 		return nil, requestError
 	}
 	return &resp, nil
 }
 
 // Refund method
-func (m *PasargadPaymentAPI) Refund(request CreateRefundRequest) (*RefundResponse, error) {
+func (m *PasargadPaymentAPI) Refund(ctx context.Context, request CreateRefundRequest) (*RefundResponse, error) {
 	requestBody := request.GetRefundRequest()
 	requestBody.MerchantCode = m.merchantCode
 	requestBody.TerminalCode = m.terminalId
@@ -228,14 +251,14 @@ func (m *PasargadPaymentAPI) Refund(request CreateRefundRequest) (*RefundRespons
 
 	m.signData(requestBody)
 	var resp RefundResponse
-	err := m.makeRequest(URL_REFUND, "POST", requestBody, &resp)
+	err := m.makeRequest(ctx, m.baseURL+PATH_REFUND, "POST", requestBody, &resp)
 
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.IsSuccess == false {
-		requestError := errors.New(resp.Message)
+		requestError := ErrorResponse{IsSuccess: resp.IsSuccess, Message: resp.Message}
 		return nil, requestError
 	}
 	return &resp, nil
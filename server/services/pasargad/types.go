@@ -123,16 +123,18 @@ type VerifyPaymentResponse struct {
 // Refund Data Types ==================================================
 // CreateRefundRequest is the struct to create a Refund Payment to user
 type CreateRefundRequest struct {
-	InvoiceNumber string `json:"invoiceNumber"` // invoice number
-	InvoiceDate   string `json:"invoiceDate"`   // invoice date
-	TerminalCode  int64  `json:"terminalCode"`  // terminal code
-	MerchantCode  int64  `json:"merchantCode"`  // merchant code
-	TimeStamp     string `json:"timeStamp"`     // Current timestamp (Y/m/d H:i:s)
+	Amount        int64  `json:"amount,omitempty"` // refund amount (the whole invoice when empty)
+	InvoiceNumber string `json:"invoiceNumber"`    // invoice number
+	InvoiceDate   string `json:"invoiceDate"`      // invoice date
+	TerminalCode  int64  `json:"terminalCode"`     // terminal code
+	MerchantCode  int64  `json:"merchantCode"`     // merchant code
+	TimeStamp     string `json:"timeStamp"`        // Current timestamp (Y/m/d H:i:s)
 }
 
 // GetRefundRequest and parameters
 func (m *CreateRefundRequest) GetRefundRequest() CreateRefundRequest {
 	return CreateRefundRequest{
+		Amount:        m.Amount,
 		InvoiceNumber: m.InvoiceNumber,
 		InvoiceDate:   m.InvoiceDate,
 		MerchantCode:  m.MerchantCode,
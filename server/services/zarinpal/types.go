@@ -0,0 +1,96 @@
+package zarinpal
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Codes returned in the data of the API responses.
+const (
+	CodeSuccess         = 100
+	CodeAlreadyVerified = 101
+)
+
+// ErrorResponse is the API error response.
+type ErrorResponse struct {
+	Code    int    `json:"code"`    // error code (negative values)
+	Message string `json:"message"` // message - the response of server
+}
+
+// Error returns a formatted error string.
+func (m ErrorResponse) Error() string {
+	return fmt.Sprintf("Error Code: %d, Message: %s", m.Code, m.Message)
+}
+
+// envelope wraps every API response, data and errors are an empty array when
+// they are not set, so they are decoded lazily.
+type envelope struct {
+	Data   json.RawMessage `json:"data"`
+	Errors json.RawMessage `json:"errors"`
+}
+
+// Metadata data type
+type Metadata struct {
+	Mobile string `json:"mobile,omitempty"` // mobile number of the user
+	Email  string `json:"email,omitempty"`  // email address of the user
+}
+
+// PaymentRequest is the format of our data to send to the gateway
+type PaymentRequest struct {
+	MerchantID  string   `json:"merchant_id"`  // merchant id
+	Amount      int64    `json:"amount"`       // invoice amount
+	CallbackURL string   `json:"callback_url"` // redirect url
+	Description string   `json:"description"`  // invoice description
+	Metadata    Metadata `json:"metadata"`     // payer details
+}
+
+// PaymentResponse data type
+type PaymentResponse struct {
+	Code      int    `json:"code"`      // response code
+	Message   string `json:"message"`   // message - the response of server
+	Authority string `json:"authority"` // authority of the transaction
+	FeeType   string `json:"fee_type"`  // who pays the fee
+	Fee       int64  `json:"fee"`       // fee amount
+}
+
+// VerifyRequest is the struct to create a verify request
+type VerifyRequest struct {
+	MerchantID string `json:"merchant_id"` // merchant id
+	Amount     int64  `json:"amount"`      // invoice amount
+	Authority  string `json:"authority"`   // authority of the transaction
+}
+
+// VerifyResponse data type
+type VerifyResponse struct {
+	Code     int    `json:"code"`      // response code
+	Message  string `json:"message"`   // message - the response of server
+	RefID    int64  `json:"ref_id"`    // shaparak reference id
+	CardPan  string `json:"card_pan"`  // masked card number
+	CardHash string `json:"card_hash"` // hashed card number
+}
+
+// InquiryRequest is the struct to create an inquiry request
+type InquiryRequest struct {
+	MerchantID string `json:"merchant_id"` // merchant id
+	Authority  string `json:"authority"`   // authority of the transaction
+}
+
+// InquiryResponse data type
+type InquiryResponse struct {
+	Code    int    `json:"code"`    // response code
+	Message string `json:"message"` // message - the response of server
+	Status  string `json:"status"`  // transaction status (PAID, VERIFIED, IN_BANK, FAILED, REVERSED)
+}
+
+// RefundRequest is the struct to create a refund request
+type RefundRequest struct {
+	MerchantID string `json:"merchant_id"`      // merchant id
+	Authority  string `json:"authority"`        // authority of the transaction
+	Amount     int64  `json:"amount,omitempty"` // refund amount (the whole invoice when empty)
+}
+
+// RefundResponse data type
+type RefundResponse struct {
+	Code    int    `json:"code"`    // response code
+	Message string `json:"message"` // message - the response of server
+}
@@ -0,0 +1,212 @@
+package zarinpal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The API paths, relative to the base URL.
+const (
+	PATH_REQUEST = "/pg/v4/payment/request.json"
+	PATH_VERIFY  = "/pg/v4/payment/verify.json"
+	PATH_INQUIRY = "/pg/v4/payment/inquiry.json"
+	PATH_REFUND  = "/pg/v4/payment/refund.json"
+)
+
+// The default URLs of the gateway.
+const (
+	DefaultBaseURL = "https://api.zarinpal.com"
+
+	// Redirect User with the authority to this URL.
+	// e.q: https://www.zarinpal.com/pg/StartPay/Authority
+	DefaultGatewayURL = "https://www.zarinpal.com/pg/StartPay"
+)
+
+// HTTPClient is HTTP client.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// ZarinpalPaymentAPI for rest
+type ZarinpalPaymentAPI struct {
+	httpClient  HTTPClient
+	merchantID  string
+	baseURL     string
+	gatewayURL  string
+	callbackURL string
+}
+
+// ZarinpalAPI creates a new ZarinpalPaymentAPI instance, empty URLs fall back
+// to the production gateway.
+func ZarinpalAPI(merchantID string, baseURL string, gatewayURL string, callbackURL string) *ZarinpalPaymentAPI {
+	return ZarinpalAPIClient(merchantID, baseURL, gatewayURL, callbackURL, &http.Client{})
+}
+
+// ZarinpalAPIClient creates a new ZarinpalPaymentAPI instance
+// and allows you to pass a http.Client.
+func ZarinpalAPIClient(merchantID string, baseURL string, gatewayURL string, callbackURL string, httpClient HTTPClient) *ZarinpalPaymentAPI {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+
+	if gatewayURL == "" {
+		gatewayURL = DefaultGatewayURL
+	}
+
+	return &ZarinpalPaymentAPI{
+		httpClient:  httpClient,
+		merchantID:  merchantID,
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		gatewayURL:  strings.TrimRight(gatewayURL, "/"),
+		callbackURL: callbackURL,
+	}
+}
+
+func (m *ZarinpalPaymentAPI) makeRequest(ctx context.Context, path string, body interface{}, resp interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	r, err := m.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	respData, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	var env envelope
+	err = json.Unmarshal(respData, &env)
+	if err != nil {
+		return err
+	}
+
+	// Errors is an empty array on success and an object on failure.
+	if len(env.Errors) > 0 && env.Errors[0] == '{' {
+		var errRes ErrorResponse
+		err = json.Unmarshal(env.Errors, &errRes)
+		if err != nil {
+			return err
+		}
+		return errRes
+	}
+
+	if len(env.Data) == 0 || env.Data[0] != '{' {
+		return ErrorResponse{Message: "empty response, status: " + r.Status}
+	}
+
+	return json.Unmarshal(env.Data, resp)
+}
+
+// Redirect requests a new transaction and returns the payment URL along
+// with the authority of the transaction, callbackQuery is appended to the
+// callback URL.
+func (m *ZarinpalPaymentAPI) Redirect(ctx context.Context, amount int64, description string, metadata Metadata, callbackQuery url.Values) (string, string, error) {
+	callbackURL := m.callbackURL
+	if len(callbackQuery) > 0 {
+		separator := "?"
+		if strings.Contains(callbackURL, "?") {
+			separator = "&"
+		}
+		callbackURL += separator + callbackQuery.Encode()
+	}
+
+	requestBody := PaymentRequest{
+		MerchantID:  m.merchantID,
+		Amount:      amount,
+		CallbackURL: callbackURL,
+		Description: description,
+		Metadata:    metadata,
+	}
+
+	var resp PaymentResponse
+	err := m.makeRequest(ctx, PATH_REQUEST, requestBody, &resp)
+	if err != nil {
+		return "", "", err
+	}
+
+	if resp.Code != CodeSuccess {
+		return "", "", ErrorResponse{Code: resp.Code, Message: resp.Message}
+	}
+
+	return m.gatewayURL + "/" + resp.Authority, resp.Authority, nil
+}
+
+// Verify method, a transaction verified before is reported as successful.
+func (m *ZarinpalPaymentAPI) Verify(ctx context.Context, amount int64, authority string) (*VerifyResponse, error) {
+	requestBody := VerifyRequest{
+		MerchantID: m.merchantID,
+		Amount:     amount,
+		Authority:  authority,
+	}
+
+	var resp VerifyResponse
+	err := m.makeRequest(ctx, PATH_VERIFY, requestBody, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Code != CodeSuccess && resp.Code != CodeAlreadyVerified {
+		return nil, ErrorResponse{Code: resp.Code, Message: resp.Message}
+	}
+
+	return &resp, nil
+}
+
+// Inquiry method
+func (m *ZarinpalPaymentAPI) Inquiry(ctx context.Context, authority string) (*InquiryResponse, error) {
+	requestBody := InquiryRequest{
+		MerchantID: m.merchantID,
+		Authority:  authority,
+	}
+
+	var resp InquiryResponse
+	err := m.makeRequest(ctx, PATH_INQUIRY, requestBody, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Code != CodeSuccess {
+		return nil, ErrorResponse{Code: resp.Code, Message: resp.Message}
+	}
+
+	return &resp, nil
+}
+
+// Refund method, a zero amount refunds the whole transaction.
+func (m *ZarinpalPaymentAPI) Refund(ctx context.Context, authority string, amount int64) (*RefundResponse, error) {
+	requestBody := RefundRequest{
+		MerchantID: m.merchantID,
+		Authority:  authority,
+		Amount:     amount,
+	}
+
+	var resp RefundResponse
+	err := m.makeRequest(ctx, PATH_REFUND, requestBody, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Code != CodeSuccess {
+		return nil, ErrorResponse{Code: resp.Code, Message: resp.Message}
+	}
+
+	return &resp, nil
+}
@@ -0,0 +1,72 @@
+package zarinpal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ZarinpalTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ZarinpalTestSuite) server(path string, response string, request interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Require().Equal(path, r.URL.Path)
+		if request != nil {
+			suite.Require().NoError(json.NewDecoder(r.Body).Decode(request))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+}
+
+func (suite *ZarinpalTestSuite) TestRedirect_Success() {
+	require := suite.Require()
+
+	var request PaymentRequest
+	server := suite.server(PATH_REQUEST, `{"data":{"code":100,"message":"Success","authority":"A0001"},"errors":[]}`, &request)
+	defer server.Close()
+
+	api := ZarinpalAPI("merchant", server.URL, "https://gateway.test/StartPay", "http://example.com/callback")
+	address, authority, err := api.Redirect(context.Background(), 2000, "invoice 4", Metadata{Mobile: "09120000000"}, url.Values{"pid": {"4"}})
+	require.NoError(err)
+	require.Equal("https://gateway.test/StartPay/A0001", address)
+	require.Equal("A0001", authority)
+	require.Equal("merchant", request.MerchantID)
+	require.Equal(int64(2000), request.Amount)
+	require.Equal("http://example.com/callback?pid=4", request.CallbackURL)
+}
+
+func (suite *ZarinpalTestSuite) TestVerify_AlreadyVerified() {
+	require := suite.Require()
+
+	server := suite.server(PATH_VERIFY, `{"data":{"code":101,"message":"Verified","ref_id":201,"card_pan":"502229******5995"},"errors":[]}`, nil)
+	defer server.Close()
+
+	api := ZarinpalAPI("merchant", server.URL, "", "")
+	response, err := api.Verify(context.Background(), 2000, "A0001")
+	require.NoError(err)
+	require.Equal(int64(201), response.RefID)
+}
+
+func (suite *ZarinpalTestSuite) TestVerify_Error() {
+	require := suite.Require()
+
+	server := suite.server(PATH_VERIFY, `{"data":[],"errors":{"code":-51,"message":"Session is not valid"}}`, nil)
+	defer server.Close()
+
+	api := ZarinpalAPI("merchant", server.URL, "", "")
+	_, err := api.Verify(context.Background(), 2000, "A0001")
+	require.Equal(ErrorResponse{Code: -51, Message: "Session is not valid"}, err)
+}
+
+func TestZarinpal(t *testing.T) {
+	suite.Run(t, new(ZarinpalTestSuite))
+}
@@ -0,0 +1,81 @@
+// Package sms sends the text messages of the server. Only the console sender
+// is here for now, a provider implements Sender when we sign with one.
+package sms
+
+import (
+	"context"
+	"fmt"
+	"on-air/config"
+	"on-air/logging"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Drivers of the sender.
+const (
+	DriverConsole = "console"
+)
+
+// Message is a text message to the mobile number To.
+type Message struct {
+	To   string
+	Text string
+}
+
+type Sender interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// New returns the sender of the configured driver, the console sender when
+// none is configured.
+func New(cfg *config.SMS) (Sender, error) {
+	switch strings.ToLower(cfg.Driver) {
+	case DriverConsole, "":
+		return &ConsoleSender{}, nil
+	default:
+		return nil, fmt.Errorf("unknown sms driver %q", cfg.Driver)
+	}
+}
+
+// ConsoleSender prints the messages to the log instead of sending them.
+type ConsoleSender struct{}
+
+func (ConsoleSender) Send(ctx context.Context, message Message) error {
+	logging.FromContext(ctx).WithFields(logrus.Fields{
+		"to": message.To,
+	}).Info(message.Text)
+
+	return nil
+}
+
+// FakeSender keeps the messages, so tests can read back what was sent.
+type FakeSender struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (f *FakeSender) Send(_ context.Context, message Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.messages = append(f.messages, message)
+	return nil
+}
+
+// Messages returns the messages sent so far.
+func (f *FakeSender) Messages() []Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Message(nil), f.messages...)
+}
+
+// Reset forgets the messages sent so far.
+func (f *FakeSender) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.messages = nil
+}
@@ -0,0 +1,48 @@
+package sms
+
+import (
+	"context"
+	"on-air/config"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SMSTestSuite struct {
+	suite.Suite
+}
+
+func (suite *SMSTestSuite) TestNew() {
+	require := suite.Require()
+
+	sender, err := New(&config.SMS{})
+	require.NoError(err)
+	require.IsType(&ConsoleSender{}, sender)
+	require.NoError(sender.Send(context.Background(), Message{To: "09121234567", Text: "code"}))
+
+	sender, err = New(&config.SMS{Driver: "Console"})
+	require.NoError(err)
+	require.IsType(&ConsoleSender{}, sender)
+
+	_, err = New(&config.SMS{Driver: "pigeon"})
+	require.Error(err)
+}
+
+func (suite *SMSTestSuite) TestFakeSender() {
+	require := suite.Require()
+
+	sender := &FakeSender{}
+	require.NoError(sender.Send(context.Background(), Message{To: "09121234567", Text: "first"}))
+	require.NoError(sender.Send(context.Background(), Message{To: "09121234568", Text: "second"}))
+	require.Equal([]Message{
+		{To: "09121234567", Text: "first"},
+		{To: "09121234568", Text: "second"},
+	}, sender.Messages())
+
+	sender.Reset()
+	require.Empty(sender.Messages())
+}
+
+func TestSMS(t *testing.T) {
+	suite.Run(t, new(SMSTestSuite))
+}
@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"log"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type user struct {
+	ID   uint
+	Name string
+}
+
+type GormPluginTestSuite struct {
+	suite.Suite
+	sqlMock  sqlmock.Sqlmock
+	dbMock   *gorm.DB
+	recorder *tracetest.SpanRecorder
+}
+
+func (suite *GormPluginTestSuite) SetupTest() {
+	mockDB, sqlMock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.dbMock, err = gorm.Open(postgres.New(postgres.Config{
+		Conn: mockDB,
+	}))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = suite.dbMock.Use(&GormPlugin{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	suite.sqlMock = sqlMock
+	suite.recorder = tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(suite.recorder)))
+}
+
+func (suite *GormPluginTestSuite) TestQuery_ChildOfContext() {
+	require := suite.Require()
+
+	ctx, parent := Tracer().Start(context.Background(), "request")
+
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "Ali"))
+
+	var found user
+	require.NoError(suite.dbMock.WithContext(ctx).First(&found).Error)
+	parent.End()
+
+	spans := suite.recorder.Ended()
+	require.Len(spans, 2)
+	require.Equal("gorm.query", spans[0].Name())
+	require.Equal(parent.SpanContext().TraceID(), spans[0].SpanContext().TraceID())
+	require.Equal(parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	require.Equal(codes.Unset, spans[0].Status().Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *GormPluginTestSuite) TestQuery_RecordsError() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "users"`).
+		WillReturnError(errors.New("connection reset"))
+
+	var found user
+	require.Error(suite.dbMock.WithContext(context.Background()).First(&found).Error)
+
+	spans := suite.recorder.Ended()
+	require.Len(spans, 1)
+	require.Equal(codes.Error, spans[0].Status().Code)
+}
+
+func (suite *GormPluginTestSuite) TestQuery_NotFoundIsNotAnError() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(`SELECT (.+) FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+	var found user
+	require.ErrorIs(suite.dbMock.First(&found).Error, gorm.ErrRecordNotFound)
+
+	spans := suite.recorder.Ended()
+	require.Len(spans, 1)
+	require.Equal(codes.Unset, spans[0].Status().Code)
+}
+
+func TestGormPlugin(t *testing.T) {
+	suite.Run(t, new(GormPluginTestSuite))
+}
@@ -1,23 +1,110 @@
 package utils
 
 import (
-	"net/http"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
-	"github.com/labstack/echo/v4"
 )
 
 type CustomValidator struct {
 	Validator *validator.Validate
 }
 
+// FieldError is a field of a request that failed a validation rule, the
+// field is named the way the client sends it.
+type FieldError struct {
+	Field string
+	Rule  string
+	Param string
+}
+
+// ValidationError lists the fields of a request that failed validation.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		fields = append(fields, fmt.Sprintf("%s failed on the %s rule", field.Field, field.Rule))
+	}
+
+	return "validation failed: " + strings.Join(fields, ", ")
+}
+
 func (cv *CustomValidator) Validate(i interface{}) error {
-	if err := cv.Validator.Struct(i); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	err := cv.Validator.Struct(i)
+	if err == nil {
+		return nil
+	}
+
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return err
+	}
+
+	fields := make([]FieldError, 0, len(validationErrors))
+	for _, fieldErr := range validationErrors {
+		fields = append(fields, FieldError{
+			Field: requestFieldName(reflect.TypeOf(i), fieldErr.StructNamespace()),
+			Rule:  fieldErr.Tag(),
+			Param: fieldErr.Param(),
+		})
+	}
+
+	return &ValidationError{Fields: fields}
+}
+
+// requestFieldName turns the struct namespace of a field, e.g.
+// ReserveRequest.PassengerIDs[0], into the name the client sends it by, e.g.
+// passengers[0], following the json, query and param tags.
+func requestFieldName(t reflect.Type, namespace string) string {
+	parts := strings.Split(namespace, ".")
+	names := make([]string, 0, len(parts))
+
+	for _, part := range parts[1:] {
+		name, index := part, ""
+		if i := strings.IndexByte(part, '['); i >= 0 {
+			name, index = part[:i], part[i:]
+		}
+
+		for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map) {
+			t = t.Elem()
+		}
+
+		if t == nil || t.Kind() != reflect.Struct {
+			names = append(names, part)
+			t = nil
+			continue
+		}
+
+		field, ok := t.FieldByName(name)
+		if !ok {
+			names = append(names, part)
+			t = nil
+			continue
+		}
+
+		names = append(names, tagName(field)+index)
+		t = field.Type
+	}
+
+	return strings.Join(names, ".")
+}
+
+func tagName(field reflect.StructField) string {
+	for _, key := range []string{"json", "query", "param", "form"} {
+		name := strings.Split(field.Tag.Get(key), ",")[0]
+		if name != "" && name != "-" {
+			return name
+		}
 	}
 
-	return nil
+	return field.Name
 }
 
 func CustomTimeValidator(fl validator.FieldLevel) bool {
@@ -63,6 +63,35 @@ func (suite *CustomeValidatorTestSuite) TestCustomeValidator_NotRequired_Failure
 	require.Error(err)
 }
 
+type TestRequest struct {
+	Email      string          `json:"email" validate:"required,email"`
+	Origin     string          `query:"origin" validate:"required"`
+	Passengers []TestPassenger `json:"passengers" validate:"required,dive"`
+}
+
+type TestPassenger struct {
+	FirstName string `json:"first_name" validate:"required"`
+}
+
+func (suite *CustomeValidatorTestSuite) TestCustomeValidator_FieldErrors() {
+	require := suite.Require()
+	validator := &CustomValidator{Validator: validator.New()}
+
+	mockStruct := TestRequest{
+		Email:      "not-an-email",
+		Passengers: []TestPassenger{{}},
+	}
+	err := validator.Validate(&mockStruct)
+
+	var validationErr *ValidationError
+	require.ErrorAs(err, &validationErr)
+	require.Equal([]FieldError{
+		{Field: "email", Rule: "email"},
+		{Field: "origin", Rule: "required"},
+		{Field: "passengers[0].first_name", Rule: "required"},
+	}, validationErr.Fields)
+}
+
 func TestCustomeValidator(t *testing.T) {
 	suite.Run(t, new(CustomeValidatorTestSuite))
 }
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	iranMobileNumberLen = 11
+)
+
+// NormalizeMobileNumber turns an Iranian mobile number the way users type it,
+// e.g. +98 912 123 4567, 00989121234567, 9121234567 or with Persian digits,
+// into the 09121234567 form the users are stored with. ok is false when it is
+// not an Iranian mobile number.
+func NormalizeMobileNumber(number string) (string, bool) {
+	var digits strings.Builder
+	for i, r := range strings.TrimSpace(number) {
+		switch {
+		case r >= '۰' && r <= '۹':
+			digits.WriteRune('0' + r - '۰')
+		case r >= '٠' && r <= '٩':
+			digits.WriteRune('0' + r - '٠')
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+		case r == '-' || r == '(' || r == ')' || unicode.IsSpace(r):
+		default:
+			return "", false
+		}
+	}
+
+	normalized := digits.String()
+	switch {
+	case strings.HasPrefix(normalized, "0098"):
+		normalized = "0" + normalized[4:]
+	case strings.HasPrefix(normalized, "98") && len(normalized) == iranMobileNumberLen+1:
+		normalized = "0" + normalized[2:]
+	case strings.HasPrefix(normalized, "9") && len(normalized) == iranMobileNumberLen-1:
+		normalized = "0" + normalized
+	}
+
+	if len(normalized) != iranMobileNumberLen || !strings.HasPrefix(normalized, "09") {
+		return "", false
+	}
+
+	return normalized, true
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MobileNumberTestSuite struct {
+	suite.Suite
+}
+
+func (suite *MobileNumberTestSuite) TestNormalizeMobileNumber() {
+	require := suite.Require()
+	testCases := []struct {
+		desc           string
+		number         string
+		expectedResult string
+		expectedOK     bool
+	}{
+		{"Local number", "09121234567", "09121234567", true},
+		{"Without the leading zero", "9121234567", "09121234567", true},
+		{"Country code", "+989121234567", "09121234567", true},
+		{"Country code without plus", "989121234567", "09121234567", true},
+		{"International prefix", "00989121234567", "09121234567", true},
+		{"Spaces and dashes", " +98 912-123 4567 ", "09121234567", true},
+		{"Persian digits", "۰۹۱۲۱۲۳۴۵۶۷", "09121234567", true},
+		{"Arabic digits", "٠٩١٢١٢٣٤٥٦٧", "09121234567", true},
+		{"Landline", "02112345678", "", false},
+		{"Too short", "0912123456", "", false},
+		{"Too long", "091212345678", "", false},
+		{"Other country", "+449121234567", "", false},
+		{"Letters", "0912123456a", "", false},
+		{"Plus in the middle", "0912+1234567", "", false},
+		{"Empty", "", "", false},
+	}
+
+	for _, t := range testCases {
+		res, ok := NormalizeMobileNumber(t.number)
+		require.Equal(t.expectedOK, ok, t.desc)
+		require.Equal(t.expectedResult, res, t.desc)
+	}
+}
+
+func TestMobileNumber(t *testing.T) {
+	suite.Run(t, new(MobileNumberTestSuite))
+}